@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Balance summary scopes
+const (
+	scopeUser  = "user"
+	scopeTrust = "trust"
+)
+
+// BalanceLine is one account's balance in a balance summary. Rate and
+// RateDate are the exchange rate the balance was converted at and are left
+// out for balances already in the reporting currency or at zero.
+type BalanceLine struct {
+	AccountID       string           `json:"account_id"`
+	Name            string           `json:"name"`
+	Type            string           `json:"type"`
+	InstitutionName string           `json:"institution_name,omitempty"`
+	Balance         money.Money      `json:"balance"`
+	Rate            *decimal.Decimal `json:"rate,omitempty"`
+	RateDate        *time.Time       `json:"rate_date,omitempty"`
+	Converted       money.Money      `json:"converted"`
+}
+
+// BalanceSummary totals the active accounts of a user or trust in its
+// reporting currency
+type BalanceSummary struct {
+	ScopeType string        `json:"scope_type"`
+	ScopeID   string        `json:"scope_id"`
+	Currency  string        `json:"currency"`
+	AsOf      time.Time     `json:"as_of"`
+	Accounts  []BalanceLine `json:"accounts"`
+	Total     money.Money   `json:"total"`
+}
+
+// loadFXTable loads the rates stored by investment-service that convert
+// amounts valued on date: the last rate of each currency pair on or before it
+func loadFXTable(ctx context.Context, date time.Time) (*fx.Table, error) {
+	rows, err := db.Query(ctx, `
+		SELECT r.base, r.quote, r.rate_date, r.rate
+		FROM investments.fx_rates r
+		JOIN (
+			SELECT base, quote, MAX(rate_date) AS rate_date
+			FROM investments.fx_rates
+			WHERE rate_date <= $1
+			GROUP BY base, quote
+		) latest USING (base, quote, rate_date)
+	`, fx.Day(date))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []fx.Rate
+	for rows.Next() {
+		var r fx.Rate
+		if err := rows.Scan(&r.Base, &r.Quote, &r.Date, &r.Rate); err != nil {
+			return nil, fmt.Errorf("failed to scan exchange rate: %w", err)
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve exchange rates: %w", err)
+	}
+	return fx.NewTable(rates), nil
+}
+
+// getBalanceSummary returns a handler totalling the balances of a user's or
+// trust's active accounts, with the scope ID taken from the named route
+// parameter. Balances are converted at the latest rate on or before today
+// into the scope's reporting currency, or into ?currency= when given.
+func getBalanceSummary(scopeType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scopeID := c.Param(param)
+
+		var currency string
+		if value := c.Query("currency"); value != "" {
+			currency = fx.NormalizeCurrency(value)
+			if !fx.ValidCurrency(currency) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three letter currency code"})
+				return
+			}
+		} else {
+			query, notFound := `SELECT reporting_currency FROM users.users WHERE id = $1`, "User not found"
+			if scopeType == scopeTrust {
+				query, notFound = `SELECT reporting_currency FROM trusts.trusts WHERE id = $1`, "Trust not found"
+			}
+			err := db.QueryRow(ctx, query, scopeID).Scan(&currency)
+			if err == pgx.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reporting currency: " + err.Error()})
+				return
+			}
+			currency = fx.NormalizeCurrency(currency)
+		}
+
+		summary := BalanceSummary{
+			ScopeType: scopeType,
+			ScopeID:   scopeID,
+			Currency:  currency,
+			AsOf:      fx.Day(time.Now().UTC()),
+			Accounts:  []BalanceLine{},
+			Total:     money.Zero(currency),
+		}
+
+		rates, err := loadFXTable(ctx, summary.AsOf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		column := "user_id"
+		if scopeType == scopeTrust {
+			column = "trust_id"
+		}
+		rows, err := db.Query(ctx, `
+			SELECT id, name, type, COALESCE(institution_name, ''), balance_amount, balance_currency
+			FROM accounts.accounts
+			WHERE `+column+` = $1 AND is_active = true
+			ORDER BY name, id
+		`, scopeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve accounts"})
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var line BalanceLine
+			var amount decimal.Decimal
+			var accountCurrency string
+			err := rows.Scan(&line.AccountID, &line.Name, &line.Type, &line.InstitutionName, &amount, &accountCurrency)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan account data"})
+				return
+			}
+			line.Balance = money.New(amount, accountCurrency)
+			line.Converted = money.Zero(currency)
+
+			if !line.Balance.IsZero() {
+				rate, rateDate, err := rates.Lookup(line.Balance.Currency, currency, summary.AsOf)
+				var missing *fx.MissingRateError
+				if errors.As(err, &missing) {
+					c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Cannot convert balance of account " + line.AccountID + ": " + missing.Error()})
+					return
+				}
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert balances: " + err.Error()})
+					return
+				}
+				line.Converted = money.New(line.Balance.Amount.Mul(rate), currency)
+				if line.Balance.Currency != currency {
+					line.Rate, line.RateDate = &rate, &rateDate
+				}
+			}
+
+			summary.Total, _ = summary.Total.Add(line.Converted)
+			summary.Accounts = append(summary.Accounts, line)
+		}
+		if err := rows.Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve accounts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/beneficiaries"
+)
+
+// Beneficiary represents a beneficiary designated on an account
+type Beneficiary struct {
+	ID           string     `json:"id"`
+	AccountID    string     `json:"account_id"`
+	Name         string     `json:"name"`
+	Relationship string     `json:"relationship,omitempty"`
+	Percentage   int        `json:"percentage"`
+	DateOfBirth  *time.Time `json:"date_of_birth,omitempty"`
+	IsActive     bool       `json:"is_active"`
+}
+
+func getBeneficiaries(c *gin.Context) {
+	accountID := c.Param("id")
+	var designated []Beneficiary
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, account_id, name, relationship, percentage, date_of_birth, is_active
+		FROM accounts.beneficiaries
+		WHERE account_id = $1 AND is_active = true
+		ORDER BY created_at
+	`, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve beneficiaries"})
+		return
+	}
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var beneficiary Beneficiary
+		err := rows.Scan(
+			&beneficiary.ID, &beneficiary.AccountID, &beneficiary.Name, &beneficiary.Relationship,
+			&beneficiary.Percentage, &beneficiary.DateOfBirth, &beneficiary.IsActive,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan beneficiary data"})
+			return
+		}
+		total += beneficiary.Percentage
+		designated = append(designated, beneficiary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"beneficiaries":    designated,
+		"total_percentage": total,
+		"complete":         beneficiaries.Complete(total),
+	})
+}
+
+func addBeneficiary(c *gin.Context) {
+	accountID := c.Param("id")
+
+	var input struct {
+		Name         string `json:"name" binding:"required"`
+		Relationship string `json:"relationship"`
+		Percentage   int    `json:"percentage" binding:"required,min=1,max=100"`
+		DateOfBirth  string `json:"date_of_birth"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dateOfBirth, err := beneficiaries.ParseDateOfBirth(input.DateOfBirth)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date_of_birth must be in YYYY-MM-DD format"})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add beneficiary: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the account so concurrent changes cannot over-allocate the split
+	if err := lockAccount(ctx, tx, accountID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Account not found"})
+		return
+	}
+
+	if err := checkBeneficiaryAllocation(ctx, tx, accountID, "", input.Percentage); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := uuid.New().String()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO accounts.beneficiaries (
+			id, account_id, name, relationship, percentage, date_of_birth, is_active
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		)
+	`, id, accountID, input.Name, input.Relationship, input.Percentage, dateOfBirth, true)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add beneficiary: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add beneficiary: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      id,
+		"message": "Beneficiary added successfully",
+	})
+}
+
+func updateBeneficiary(c *gin.Context) {
+	accountID := c.Param("id")
+	beneficiaryID := c.Param("beneficiaryId")
+
+	var input struct {
+		Name         string `json:"name"`
+		Relationship string `json:"relationship"`
+		Percentage   int    `json:"percentage" binding:"min=0,max=100"`
+		DateOfBirth  string `json:"date_of_birth"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dateOfBirth, err := beneficiaries.ParseDateOfBirth(input.DateOfBirth)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date_of_birth must be in YYYY-MM-DD format"})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update beneficiary: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockAccount(ctx, tx, accountID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	// Check if beneficiary exists
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM accounts.beneficiaries WHERE id = $1 AND account_id = $2 AND is_active = true)
+	`, beneficiaryID, accountID).Scan(&exists)
+
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Beneficiary not found"})
+		return
+	}
+
+	if input.Percentage > 0 {
+		if err := checkBeneficiaryAllocation(ctx, tx, accountID, beneficiaryID, input.Percentage); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE accounts.beneficiaries
+		SET
+			name = COALESCE(NULLIF($1, ''), name),
+			relationship = COALESCE(NULLIF($2, ''), relationship),
+			percentage = CASE WHEN $3 > 0 THEN $3 ELSE percentage END,
+			date_of_birth = COALESCE($4, date_of_birth),
+			updated_at = NOW()
+		WHERE id = $5 AND account_id = $6 AND is_active = true
+	`, input.Name, input.Relationship, input.Percentage, dateOfBirth, beneficiaryID, accountID)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update beneficiary: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update beneficiary: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Beneficiary updated successfully"})
+}
+
+func removeBeneficiary(c *gin.Context) {
+	accountID := c.Param("id")
+	beneficiaryID := c.Param("beneficiaryId")
+
+	result, err := db.Exec(context.Background(), `
+		UPDATE accounts.beneficiaries
+		SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND account_id = $2 AND is_active = true
+	`, beneficiaryID, accountID)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove beneficiary: " + err.Error()})
+		return
+	}
+
+	rowsAffected := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Beneficiary not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// lockAccount takes a row lock on an existing account for the rest of the transaction
+func lockAccount(ctx context.Context, tx pgx.Tx, accountID string) error {
+	var id string
+	return tx.QueryRow(ctx, `
+		SELECT id FROM accounts.accounts WHERE id = $1 AND is_active = true FOR UPDATE
+	`, accountID).Scan(&id)
+}
+
+// checkBeneficiaryAllocation verifies that giving percentage to a beneficiary
+// keeps the account's total split at or below 100. excludeID is the beneficiary
+// being updated, whose current share is replaced rather than added to.
+func checkBeneficiaryAllocation(ctx context.Context, tx pgx.Tx, accountID, excludeID string, percentage int) error {
+	var allocated int
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(percentage), 0)
+		FROM accounts.beneficiaries
+		WHERE account_id = $1 AND is_active = true AND ($2 = '' OR id::text != $2)
+	`, accountID, excludeID).Scan(&allocated)
+	if err != nil {
+		return err
+	}
+
+	return beneficiaries.CheckAllocation(allocated, percentage)
+}
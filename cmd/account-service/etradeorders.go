@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	etrademodels "github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/models"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/orders"
+)
+
+// etradeBrokerName identifies orders routed to E-Trade
+const etradeBrokerName = "etrade"
+
+// etradeOrderTerms maps order durations to E-Trade order terms
+var etradeOrderTerms = map[string]string{
+	orders.DurationDay: "GOOD_FOR_DAY",
+	orders.DurationGTC: "GOOD_UNTIL_CANCEL",
+}
+
+// etradeOrderStatuses maps E-Trade order statuses to order statuses. Anything
+// not listed is treated as still open.
+var etradeOrderStatuses = map[string]string{
+	"OPEN":             orders.StatusOpen,
+	"EXECUTED":         orders.StatusFilled,
+	"PARTIAL":          orders.StatusPartiallyFilled,
+	"INDIVIDUAL_FILLS": orders.StatusPartiallyFilled,
+	"CANCEL_REQUESTED": orders.StatusCancelRequested,
+	"CANCELLED":        orders.StatusCancelled,
+	"EXPIRED":          orders.StatusExpired,
+	"REJECTED":         orders.StatusRejected,
+}
+
+// etradeBroker routes orders to a linked E-Trade account through etrade-service,
+// which holds the user's E-Trade credentials
+type etradeBroker struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newETradeBroker() *etradeBroker {
+	return &etradeBroker{
+		baseURL: getEnv("ETRADE_SERVICE_URL", "http://etrade-service:8080"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name implements orders.Broker
+func (b *etradeBroker) Name() string {
+	return etradeBrokerName
+}
+
+// etradeOrder converts an order request to the etrade-service form
+func etradeOrder(account orders.Account, req orders.Request) etrademodels.ETradeOrderRequest {
+	return etrademodels.ETradeOrderRequest{
+		AccountID:     account.ExternalID,
+		ClientOrderID: req.ClientOrderID,
+		Symbol:        req.Symbol,
+		OrderAction:   req.Side,
+		Quantity:      req.Quantity,
+		PriceType:     req.Type,
+		LimitPrice:    etradePrice(req.LimitPrice),
+		StopPrice:     etradePrice(req.StopPrice),
+		OrderTerm:     etradeOrderTerms[req.Duration],
+	}
+}
+
+// etradePrice converts an optional price to the etrade-service form, where
+// no price is zero
+func etradePrice(price *decimal.Decimal) float64 {
+	if price == nil {
+		return 0
+	}
+	f, _ := price.Float64()
+	return f
+}
+
+// Preview implements orders.Broker
+func (b *etradeBroker) Preview(ctx context.Context, account orders.Account, req orders.Request) (*orders.Preview, error) {
+	body := gin.H{"user_id": account.UserID, "order": etradeOrder(account, req)}
+
+	var preview etrademodels.ETradeOrderPreview
+	if err := b.call(ctx, http.MethodPost, "/api/v1/etrade/orders/preview", body, &preview); err != nil {
+		return nil, err
+	}
+
+	return &orders.Preview{
+		PreviewID:           preview.PreviewID,
+		EstimatedTotal:      decimal.NewFromFloat(preview.EstimatedTotalAmount),
+		EstimatedCommission: decimal.NewFromFloat(preview.EstimatedCommission),
+		Messages:            preview.Messages,
+	}, nil
+}
+
+// Place implements orders.Broker
+func (b *etradeBroker) Place(ctx context.Context, account orders.Account, req orders.Request, previewID string) (*orders.Execution, error) {
+	body := gin.H{"user_id": account.UserID, "preview_id": previewID, "order": etradeOrder(account, req)}
+
+	var placement etrademodels.ETradeOrderPlacement
+	if err := b.call(ctx, http.MethodPost, "/api/v1/etrade/orders/place", body, &placement); err != nil {
+		return nil, err
+	}
+
+	return &orders.Execution{
+		BrokerOrderID: placement.OrderID,
+		Status:        orders.StatusOpen,
+		PlacedAt:      placement.PlacedTime,
+		Messages:      placement.Messages,
+	}, nil
+}
+
+// Cancel implements orders.Broker
+func (b *etradeBroker) Cancel(ctx context.Context, account orders.Account, brokerOrderID string) error {
+	body := gin.H{"user_id": account.UserID, "account_id": account.ExternalID, "order_id": brokerOrderID}
+	return b.call(ctx, http.MethodPost, "/api/v1/etrade/orders/cancel", body, nil)
+}
+
+// Status implements orders.Broker
+func (b *etradeBroker) Status(ctx context.Context, account orders.Account, brokerOrderID string) (*orders.Execution, error) {
+	query := url.Values{"user_id": {account.UserID}, "account_id": {account.ExternalID}}
+	path := "/api/v1/etrade/orders/" + url.PathEscape(brokerOrderID) + "?" + query.Encode()
+
+	var status etrademodels.ETradeOrderStatus
+	if err := b.call(ctx, http.MethodGet, path, nil, &status); err != nil {
+		return nil, err
+	}
+	status.OrderID = brokerOrderID
+	return etradeExecution(status), nil
+}
+
+// Lookup implements orders.Broker
+func (b *etradeBroker) Lookup(ctx context.Context, account orders.Account, clientOrderID string) (*orders.Execution, error) {
+	query := url.Values{"user_id": {account.UserID}, "account_id": {account.ExternalID}, "client_order_id": {clientOrderID}}
+
+	var status etrademodels.ETradeOrderStatus
+	if err := b.call(ctx, http.MethodGet, "/api/v1/etrade/orders?"+query.Encode(), nil, &status); err != nil {
+		return nil, err
+	}
+	return etradeExecution(status), nil
+}
+
+// etradeExecution converts an etrade-service order status to an execution
+func etradeExecution(status etrademodels.ETradeOrderStatus) *orders.Execution {
+	mapped, ok := etradeOrderStatuses[status.Status]
+	if !ok {
+		mapped = orders.StatusOpen
+	}
+
+	return &orders.Execution{
+		BrokerOrderID:  status.OrderID,
+		Status:         mapped,
+		FilledQuantity: status.FilledQuantity,
+		AveragePrice:   decimal.NewFromFloat(status.AverageExecutionPrice),
+		PlacedAt:       status.PlacedTime,
+	}
+}
+
+// call sends a request to etrade-service and decodes the response into out
+// when it is not nil. Failures are returned as *orders.BrokerError, marked
+// rejected when etrade-service or E-Trade refused the request; a request for
+// an order E-Trade does not have fails with orders.ErrUnknownOrder.
+func (b *etradeBroker) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return &orders.BrokerError{Broker: b.Name(), Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &orders.BrokerError{Broker: b.Name(), Err: err}
+	}
+
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return &orders.BrokerError{Broker: b.Name(), Err: orders.ErrUnknownOrder}
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &failure) != nil || failure.Error == "" {
+			failure.Error = fmt.Sprintf("status %d: %s", resp.StatusCode, string(respBody))
+		}
+		return &orders.BrokerError{
+			Broker:   b.Name(),
+			Err:      errors.New(failure.Error),
+			Rejected: resp.StatusCode >= 400 && resp.StatusCode < 500,
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return &orders.BrokerError{Broker: b.Name(), Err: fmt.Errorf("failed to parse response: %w", err)}
+	}
+	return nil
+}
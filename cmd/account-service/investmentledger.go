@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/ofx"
+)
+
+// Ledger transaction types recorded by investment-service
+const (
+	ledgerBuy      = "BUY"
+	ledgerSell     = "SELL"
+	ledgerDividend = "DIVIDEND"
+)
+
+// ledgerPriceDecimals is the precision of prices derived from a total, as
+// the ledger stores them
+const ledgerPriceDecimals = 6
+
+// ledgerTransaction is a transaction to record in investment-service's ledger.
+// The asset is named by symbol; ExternalID keeps it from being recorded twice.
+type ledgerTransaction struct {
+	AccountID  string          `json:"account_id"`
+	Symbol     string          `json:"symbol"`
+	Name       string          `json:"name,omitempty"`
+	Type       string          `json:"type"`
+	Quantity   float64         `json:"quantity,omitempty"`
+	Price      decimal.Decimal `json:"price"`
+	Fees       decimal.Decimal `json:"fees"`
+	Amount     decimal.Decimal `json:"amount"`
+	TradeDate  time.Time       `json:"trade_date"`
+	ExternalID string          `json:"external_id"`
+	Notes      string          `json:"notes,omitempty"`
+}
+
+// ledgerRejectionError is a transaction investment-service refused to record
+type ledgerRejectionError struct {
+	Status int
+	Reason string
+}
+
+func (e *ledgerRejectionError) Error() string {
+	return e.Reason
+}
+
+// investmentLedger records investment transactions through investment-service,
+// which keeps the tax lots of the positions they trade
+type investmentLedger struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newInvestmentLedger() *investmentLedger {
+	return &investmentLedger{
+		baseURL: getEnv("INVESTMENT_SERVICE_URL", "http://investment-service:8080"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// record records a transaction and reports whether its external ID had
+// already been recorded. Transactions the ledger refuses are returned as
+// *ledgerRejectionError.
+func (l *investmentLedger) record(ctx context.Context, t ledgerTransaction) (bool, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/api/v1/transactions", bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("investment-service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("investment-service: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusCreated:
+		return false, nil
+	case resp.StatusCode == http.StatusOK:
+		return true, nil
+	}
+
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(respBody, &failure) != nil || failure.Error == "" {
+		failure.Error = fmt.Sprintf("status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return false, &ledgerRejectionError{Status: resp.StatusCode, Reason: failure.Error}
+	}
+	return false, fmt.Errorf("investment-service: %s", failure.Error)
+}
+
+// statementLedgerTransactions returns the ledger transactions an investment
+// statement transaction is recorded as: buys and sells trade shares, income
+// is a dividend, and reinvested income is a dividend spent on a buy. It
+// returns nil for anything else, such as transfers and cash transactions,
+// which are kept with the account's transactions.
+func statementLedgerTransactions(accountID string, t ofx.Transaction) []ledgerTransaction {
+	if t.Symbol == "" {
+		return nil
+	}
+
+	base := ledgerTransaction{
+		AccountID:  accountID,
+		Symbol:     t.Symbol,
+		Name:       t.Name,
+		TradeDate:  t.Date,
+		ExternalID: t.FITID,
+		Notes:      t.Memo,
+	}
+	trade := func(side string) ledgerTransaction {
+		lt := base
+		lt.Type = side
+		lt.Quantity = math.Abs(t.Units)
+		lt.Fees = t.Fees.Abs()
+		lt.Price = t.UnitPrice.Abs()
+		if lt.Price.IsZero() && lt.Quantity != 0 {
+			// Buys cost the price plus fees and sells pay the price less fees
+			gross := t.Amount.Abs().Sub(lt.Fees)
+			if side == ledgerSell {
+				gross = t.Amount.Abs().Add(lt.Fees)
+			}
+			lt.Price = gross.DivRound(decimal.NewFromFloat(lt.Quantity), ledgerPriceDecimals)
+		}
+		return lt
+	}
+	dividend := func() ledgerTransaction {
+		lt := base
+		lt.Type = ledgerDividend
+		lt.Amount = t.Amount.Abs()
+		return lt
+	}
+
+	switch {
+	case strings.HasPrefix(t.Type, "BUY"):
+		return []ledgerTransaction{trade(ledgerBuy)}
+	case strings.HasPrefix(t.Type, "SELL"):
+		return []ledgerTransaction{trade(ledgerSell)}
+	case strings.HasPrefix(t.Type, "INCOME"):
+		return []ledgerTransaction{dividend()}
+	case strings.HasPrefix(t.Type, "REINVEST"):
+		buy := trade(ledgerBuy)
+		buy.ExternalID = t.FITID + ":" + ledgerBuy
+		return []ledgerTransaction{dividend(), buy}
+	default:
+		return nil
+	}
+}
@@ -15,22 +15,28 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/aggregation"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/orders"
 )
 
 // Account represents a financial account
 type Account struct {
-	ID              string    `json:"id"`
-	UserID          string    `json:"user_id"`
-	Type            string    `json:"type"`
-	Name            string    `json:"name"`
-	Description     string    `json:"description,omitempty"`
-	InstitutionName string    `json:"institution_name,omitempty"`
-	Balance         float64   `json:"balance"`
-	Currency        string    `json:"currency"`
-	IsActive        bool      `json:"is_active"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
-	TrustID         *string   `json:"trust_id,omitempty"`
+	ID              string          `json:"id"`
+	UserID          string          `json:"user_id"`
+	Type            string          `json:"type"`
+	Name            string          `json:"name"`
+	Description     string          `json:"description,omitempty"`
+	InstitutionName string          `json:"institution_name,omitempty"`
+	Balance         decimal.Decimal `json:"balance"`
+	Currency        string          `json:"currency"`
+	IsActive        bool            `json:"is_active"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	TrustID         *string         `json:"trust_id,omitempty"`
 }
 
 var db *pgxpool.Pool
@@ -53,6 +59,43 @@ func main() {
 	}
 	log.Println("Connected to database")
 
+	// Ensure the account-service tables exist
+	if err := ensureAccountSchema(db); err != nil {
+		log.Fatalf("Failed to ensure account schema: %v", err)
+	}
+
+	// Ensure the tables linked account syncs write exist
+	if err := accountsync.EnsureSchema(context.Background(), db); err != nil {
+		log.Fatalf("Failed to ensure account sync schema: %v", err)
+	}
+
+	// Route orders to the simulated broker instead of E-Trade when testing offline
+	if getEnv("ORDER_BROKER", etradeBrokerName) == orders.SimulatedBrokerName {
+		simulatedBroker = newSimulatedBroker()
+		log.Println("Routing orders to the simulated broker")
+	}
+
+	// Keep working orders in step with their brokers
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	go syncOrdersPeriodically(syncCtx)
+
+	// Serve the in-process fake institution when testing aggregation offline
+	var fakeInstitution *aggregation.Handler
+	if getEnv("FAKE_INSTITUTION", "false") == "true" {
+		registry, err := aggregation.NewRegistry(aggregation.NewFakeProvider())
+		if err != nil {
+			log.Fatalf("Failed to register fake institution: %v", err)
+		}
+		fakeInstitution = aggregation.NewHandler(
+			db, registry,
+			durationEnv("ACCOUNT_SYNC_INTERVAL", time.Hour),
+			durationEnv("ACCOUNT_SYNC_MAX_BACKOFF", 24*time.Hour),
+		)
+		go fakeInstitution.RunSync(syncCtx)
+		log.Println("Serving the fake institution at /api/v1/" + aggregation.FakeInstitutionID)
+	}
+
 	// Set up Gin router
 	router := gin.Default()
 
@@ -74,13 +117,36 @@ func main() {
 			accounts.POST("", createAccount)
 			accounts.PUT("/:id", updateAccount)
 			accounts.DELETE("/:id", deleteAccount)
+
+			// Beneficiaries
+			accounts.GET("/:id/beneficiaries", getBeneficiaries)
+			accounts.POST("/:id/beneficiaries", addBeneficiary)
+			accounts.PUT("/:id/beneficiaries/:beneficiaryId", updateBeneficiary)
+			accounts.DELETE("/:id/beneficiaries/:beneficiaryId", removeBeneficiary)
+
+			// Orders
+			accounts.GET("/:id/orders", listOrders)
+			accounts.POST("/:id/orders", placeOrder)
+			accounts.POST("/:id/orders/preview", previewOrder)
+			accounts.GET("/:id/orders/:orderId", getOrder)
+			accounts.POST("/:id/orders/:orderId/cancel", cancelOrder)
+
+			// Transactions
+			accounts.GET("/:id/transactions", listTransactions)
 		}
 
 		userAccounts := v1.Group("/users/:userId/accounts")
 		{
 			userAccounts.GET("", getUserAccounts)
+			userAccounts.GET("/balance", getBalanceSummary(scopeUser, "userId"))
+
+			// Statement imports
+			userAccounts.POST("/import/ofx", importStatement)
 		}
 
+		// Trust balance summaries
+		v1.GET("/trusts/:id/accounts/balance", getBalanceSummary(scopeTrust, "id"))
+
 		// E-Trade integration routes
 		etrade := v1.Group("/etrade")
 		{
@@ -99,6 +165,11 @@ func main() {
 			capitalone.POST("/accounts/link", linkCapitalOneAccount)
 			capitalone.POST("/products/:productId/search", searchCapitalOneBankProducts)
 		}
+
+		// Fake institution routes
+		if fakeInstitution != nil {
+			fakeInstitution.RegisterRoutes(v1)
+		}
 	}
 
 	// Start server
@@ -119,6 +190,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down account-service...")
+	stopSync()
 
 	// Give the server 5 seconds to finish ongoing requests
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -227,14 +299,14 @@ func getUserAccounts(c *gin.Context) {
 
 func createAccount(c *gin.Context) {
 	var input struct {
-		UserID          string  `json:"user_id" binding:"required"`
-		Type            string  `json:"type" binding:"required"`
-		Name            string  `json:"name" binding:"required"`
-		Description     string  `json:"description"`
-		InstitutionName string  `json:"institution_name"`
-		Balance         float64 `json:"balance"`
-		Currency        string  `json:"currency"`
-		TrustID         string  `json:"trust_id"`
+		UserID          string          `json:"user_id" binding:"required"`
+		Type            string          `json:"type" binding:"required"`
+		Name            string          `json:"name" binding:"required"`
+		Description     string          `json:"description"`
+		InstitutionName string          `json:"institution_name"`
+		Balance         decimal.Decimal `json:"balance"`
+		Currency        string          `json:"currency"`
+		TrustID         string          `json:"trust_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -272,7 +344,7 @@ func createAccount(c *gin.Context) {
 			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 		)
 	`, id, input.UserID, input.Type, input.Name, input.Description,
-		input.InstitutionName, input.Balance, currency, true, trustID)
+		input.InstitutionName, money.Round(input.Balance, currency), currency, true, trustID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account: " + err.Error()})
@@ -289,13 +361,13 @@ func updateAccount(c *gin.Context) {
 	id := c.Param("id")
 
 	var input struct {
-		Type            string  `json:"type"`
-		Name            string  `json:"name"`
-		Description     string  `json:"description"`
-		InstitutionName string  `json:"institution_name"`
-		Balance         float64 `json:"balance"`
-		Currency        string  `json:"currency"`
-		TrustID         string  `json:"trust_id"`
+		Type            string          `json:"type"`
+		Name            string          `json:"name"`
+		Description     string          `json:"description"`
+		InstitutionName string          `json:"institution_name"`
+		Balance         decimal.Decimal `json:"balance"`
+		Currency        string          `json:"currency"`
+		TrustID         string          `json:"trust_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -327,13 +399,13 @@ func updateAccount(c *gin.Context) {
 			name = COALESCE(NULLIF($2, ''), name),
 			description = COALESCE(NULLIF($3, ''), description),
 			institution_name = COALESCE(NULLIF($4, ''), institution_name),
-			balance_amount = CASE WHEN $5 <> 0 THEN $5 ELSE balance_amount END,
+			balance_amount = COALESCE(NULLIF($5::numeric, 0), balance_amount),
 			balance_currency = COALESCE(NULLIF($6, ''), balance_currency),
 			trust_id = $7,
 			updated_at = NOW()
 		WHERE id = $8 AND is_active = true
 	`, input.Type, input.Name, input.Description, input.InstitutionName,
-		input.Balance, input.Currency, trustID, id)
+		money.Round(input.Balance, input.Currency), input.Currency, trustID, id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account: " + err.Error()})
@@ -427,10 +499,7 @@ func initiateETradeAuth(c *gin.Context) {
 	}
 
 	// Parse the response
-	var authResp struct {
-		RequestToken string `json:"request_token"`
-		AuthURL      string `json:"auth_url"`
-	}
+	var authResp aggregation.AuthStart
 	if err := json.Unmarshal(respBody, &authResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
 		return
@@ -500,8 +569,8 @@ func etradeAuthCallback(c *gin.Context) {
 
 	// Parse the response
 	var authResp struct {
-		Success     bool   `json:"success"`
-		AccessToken string `json:"access_token"`
+		Success bool   `json:"success"`
+		Message string `json:"message,omitempty"`
 	}
 	if err := json.Unmarshal(respBody, &authResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
@@ -559,27 +628,7 @@ func getETradeAccounts(c *gin.Context) {
 
 	// Parse the response
 	var accountsResp struct {
-		Accounts []struct {
-			AccountID        string    `json:"account_id"`
-			AccountName      string    `json:"account_name"`
-			AccountType      string    `json:"account_type"`
-			InstitutionID    string    `json:"institution_id"`
-			InstitutionName  string    `json:"institution_name"`
-			Balance          float64   `json:"balance"`
-			Currency         string    `json:"currency"`
-			LastUpdated      time.Time `json:"last_updated"`
-			Status           string    `json:"status"`
-			AccountPositions []struct {
-				Symbol        string    `json:"symbol"`
-				Quantity      float64   `json:"quantity"`
-				CostBasis     float64   `json:"cost_basis"`
-				MarketValue   float64   `json:"market_value"`
-				GainLoss      float64   `json:"gain_loss"`
-				GainLossPerc  float64   `json:"gain_loss_perc"`
-				LastPrice     float64   `json:"last_price"`
-				LastPriceTime time.Time `json:"last_price_time"`
-			} `json:"account_positions,omitempty"`
-		} `json:"accounts"`
+		Accounts []aggregation.Account `json:"accounts"`
 	}
 	if err := json.Unmarshal(respBody, &accountsResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
@@ -649,12 +698,7 @@ func linkETradeAccount(c *gin.Context) {
 	}
 
 	// Parse the response
-	var linkResp struct {
-		Success    bool   `json:"success"`
-		Message    string `json:"message,omitempty"`
-		AccountID  string `json:"account_id,omitempty"`
-		InternalID string `json:"internal_id,omitempty"`
-	}
+	var linkResp aggregation.LinkResult
 	if err := json.Unmarshal(respBody, &linkResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
 		return
@@ -725,10 +769,7 @@ func initiateCapitalOneAuth(c *gin.Context) {
 	}
 
 	// Parse the response
-	var authResp struct {
-		AuthURL string `json:"auth_url"`
-		State   string `json:"state"`
-	}
+	var authResp aggregation.AuthStart
 	if err := json.Unmarshal(respBody, &authResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
 		return
@@ -799,8 +840,8 @@ func capitalOneAuthCallback(c *gin.Context) {
 
 	// Parse the response
 	var authResp struct {
-		Success     bool   `json:"success"`
-		AccessToken string `json:"access_token"`
+		Success bool   `json:"success"`
+		Message string `json:"message,omitempty"`
 	}
 	if err := json.Unmarshal(respBody, &authResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
@@ -858,37 +899,7 @@ func getCapitalOneAccounts(c *gin.Context) {
 
 	// Parse the response
 	var accountsResp struct {
-		Accounts []struct {
-			AccountID        string    `json:"account_id"`
-			AccountName      string    `json:"account_name"`
-			AccountType      string    `json:"account_type"`
-			InstitutionID    string    `json:"institution_id"`
-			InstitutionName  string    `json:"institution_name"`
-			Balance          float64   `json:"balance"`
-			Currency         string    `json:"currency"`
-			LastUpdated      time.Time `json:"last_updated"`
-			Status           string    `json:"status"`
-			AccountPositions []struct {
-				Symbol        string    `json:"symbol"`
-				Quantity      float64   `json:"quantity"`
-				CostBasis     float64   `json:"cost_basis"`
-				MarketValue   float64   `json:"market_value"`
-				GainLoss      float64   `json:"gain_loss"`
-				GainLossPerc  float64   `json:"gain_loss_perc"`
-				LastPrice     float64   `json:"last_price"`
-				LastPriceTime time.Time `json:"last_price_time"`
-			} `json:"account_positions,omitempty"`
-			Transactions []struct {
-				TransactionID   string    `json:"transaction_id"`
-				TransactionDate time.Time `json:"transaction_date"`
-				PostDate        time.Time `json:"post_date"`
-				Description     string    `json:"description"`
-				Category        string    `json:"category"`
-				Amount          float64   `json:"amount"`
-				Type            string    `json:"type"`
-				Status          string    `json:"status"`
-			} `json:"transactions,omitempty"`
-		} `json:"accounts"`
+		Accounts []aggregation.Account `json:"accounts"`
 	}
 	if err := json.Unmarshal(respBody, &accountsResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
@@ -958,12 +969,7 @@ func linkCapitalOneAccount(c *gin.Context) {
 	}
 
 	// Parse the response
-	var linkResp struct {
-		Success    bool   `json:"success"`
-		Message    string `json:"message,omitempty"`
-		AccountID  string `json:"account_id,omitempty"`
-		InternalID string `json:"internal_id,omitempty"`
-	}
+	var linkResp aggregation.LinkResult
 	if err := json.Unmarshal(respBody, &linkResp); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse response: " + err.Error()})
 		return
@@ -1073,3 +1079,120 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// ensureAccountSchema ensures the tables owned by account-service exist
+func ensureAccountSchema(db *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	// Create the beneficiaries table if it doesn't exist
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS accounts.beneficiaries (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			name VARCHAR(255) NOT NULL,
+			relationship VARCHAR(100),
+			percentage INTEGER NOT NULL DEFAULT 0,
+			date_of_birth DATE,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the orders tables if they don't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS accounts.orders (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			broker VARCHAR(50) NOT NULL,
+			broker_order_id VARCHAR(100),
+			client_order_id VARCHAR(20) NOT NULL,
+			symbol VARCHAR(20) NOT NULL,
+			side VARCHAR(10) NOT NULL,
+			order_type VARCHAR(20) NOT NULL,
+			quantity DECIMAL(19, 6) NOT NULL,
+			limit_price DECIMAL(19, 4),
+			stop_price DECIMAL(19, 4),
+			duration VARCHAR(10) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			filled_quantity DECIMAL(19, 6) NOT NULL DEFAULT 0,
+			average_price DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			estimated_total DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			estimated_commission DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			messages JSONB,
+			reject_reason TEXT,
+			placed_at TIMESTAMP WITH TIME ZONE,
+			closed_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS accounts.order_events (
+			id UUID PRIMARY KEY,
+			order_id UUID NOT NULL REFERENCES accounts.orders(id),
+			from_status VARCHAR(20),
+			to_status VARCHAR(20) NOT NULL,
+			filled_quantity DECIMAL(19, 6) NOT NULL DEFAULT 0,
+			note TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the statement imports table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS accounts.statement_imports (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			user_id UUID NOT NULL,
+			file_name VARCHAR(255),
+			statement_date TIMESTAMP WITH TIME ZONE,
+			period_start TIMESTAMP WITH TIME ZONE,
+			period_end TIMESTAMP WITH TIME ZONE,
+			transactions INTEGER NOT NULL DEFAULT 0,
+			transactions_imported INTEGER NOT NULL DEFAULT 0,
+			imported_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create indexes
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_account_beneficiaries_account_id ON accounts.beneficiaries(account_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_account_orders_account_id ON accounts.orders(account_id, created_at)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_account_orders_client_order_id ON accounts.orders(broker, client_order_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_account_order_events_order_id ON accounts.order_events(order_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_account_statement_imports_account_id ON accounts.statement_imports(account_id, statement_date)")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,784 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/orders"
+)
+
+// Order is an order placed through account-service
+type Order struct {
+	ID        string `json:"id"`
+	AccountID string `json:"account_id"`
+	orders.Request
+	Broker              string          `json:"broker"`
+	BrokerOrderID       string          `json:"broker_order_id,omitempty"`
+	Status              string          `json:"status"`
+	FilledQuantity      float64         `json:"filled_quantity"`
+	AveragePrice        decimal.Decimal `json:"average_price"`
+	EstimatedTotal      decimal.Decimal `json:"estimated_total"`
+	EstimatedCommission decimal.Decimal `json:"estimated_commission"`
+	Messages            []string        `json:"messages,omitempty"`
+	RejectReason        string          `json:"reject_reason,omitempty"`
+	PlacedAt            *time.Time      `json:"placed_at,omitempty"`
+	ClosedAt            *time.Time      `json:"closed_at,omitempty"`
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
+}
+
+// OrderEvent is an entry in an order's status history
+type OrderEvent struct {
+	ID             string    `json:"id"`
+	OrderID        string    `json:"order_id"`
+	FromStatus     string    `json:"from_status,omitempty"`
+	ToStatus       string    `json:"to_status"`
+	FilledQuantity float64   `json:"filled_quantity"`
+	Note           string    `json:"note,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// brokerageAccount is an account orders can be routed to
+type brokerageAccount struct {
+	orders.Account
+	InstitutionID string
+}
+
+var (
+	errAccountNotFound  = errors.New("account not found")
+	errAccountNotLinked = errors.New("account is not linked to a brokerage that accepts orders")
+	errOrderNotFound    = errors.New("order not found")
+)
+
+// orderReconcileDelay is how long an order whose placement was not confirmed
+// stays PENDING before its broker is asked for it by client order ID. It
+// outlasts any placement still in flight.
+const orderReconcileDelay = 2 * time.Minute
+
+// Order brokers. simulatedBroker is set when ORDER_BROKER=simulated and then
+// receives every order instead of the account's brokerage.
+var (
+	etradeOrders    = newETradeBroker()
+	simulatedBroker *orders.SimulatedBroker
+)
+
+// newSimulatedBroker creates the simulated broker, priced from investment-service asset prices
+func newSimulatedBroker() *orders.SimulatedBroker {
+	return orders.NewSimulatedBroker(func(ctx context.Context, symbol string) (decimal.Decimal, error) {
+		var price decimal.Decimal
+		err := db.QueryRow(ctx, `
+			SELECT current_price_amount FROM investments.assets WHERE UPPER(symbol) = $1 AND current_price_amount > 0
+		`, symbol).Scan(&price)
+		if err == pgx.ErrNoRows {
+			return decimal.Zero, fmt.Errorf("no price for %s", symbol)
+		}
+		return price, err
+	}, decimal.Zero)
+}
+
+// brokerFor returns the broker new orders for an account are routed to
+func brokerFor(account *brokerageAccount) (orders.Broker, error) {
+	if simulatedBroker != nil {
+		return simulatedBroker, nil
+	}
+	if account.InstitutionID == etradeBrokerName && account.ExternalID != "" {
+		return etradeOrders, nil
+	}
+	return nil, errAccountNotLinked
+}
+
+// brokerNamed returns the broker an existing order was routed to
+func brokerNamed(name string) (orders.Broker, error) {
+	switch {
+	case name == orders.SimulatedBrokerName && simulatedBroker != nil:
+		return simulatedBroker, nil
+	case name == etradeBrokerName:
+		return etradeOrders, nil
+	}
+	return nil, fmt.Errorf("broker %s is not available", name)
+}
+
+// loadBrokerageAccount loads an active account for order routing
+func loadBrokerageAccount(ctx context.Context, accountID string) (*brokerageAccount, error) {
+	var account brokerageAccount
+	err := db.QueryRow(ctx, `
+		SELECT id, user_id, COALESCE(external_account_id, ''), COALESCE(institution_id, '')
+		FROM accounts.accounts
+		WHERE id = $1 AND is_active = true
+	`, accountID).Scan(&account.ID, &account.UserID, &account.ExternalID, &account.InstitutionID)
+	if err == pgx.ErrNoRows {
+		return nil, errAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// respondToOrderError writes the response for a failed order operation
+func respondToOrderError(c *gin.Context, err error, order *Order) {
+	var validationErr *orders.ValidationError
+	var brokerErr *orders.BrokerError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order", "problems": validationErr.Problems})
+	case errors.Is(err, errAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+	case errors.Is(err, errOrderNotFound), errors.Is(err, orders.ErrUnknownOrder):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, errAccountNotLinked):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Orders can only be placed in accounts linked to E-Trade"})
+	case errors.As(err, &brokerErr):
+		response := gin.H{"error": "Broker error: " + brokerErr.Error()}
+		if order != nil {
+			response["order"] = order
+		}
+		c.JSON(http.StatusBadGateway, response)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process order: " + err.Error()})
+	}
+}
+
+// bindOrderRequest reads and validates an order request body
+func bindOrderRequest(c *gin.Context) (orders.Request, string, bool) {
+	var input struct {
+		orders.Request
+		PreviewID string `json:"preview_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return orders.Request{}, "", false
+	}
+
+	input.Request.Normalize()
+	if err := input.Request.Validate(); err != nil {
+		respondToOrderError(c, err, nil)
+		return orders.Request{}, "", false
+	}
+	return input.Request, input.PreviewID, true
+}
+
+// newClientOrderID returns an order reference brokers accept: at most 20
+// letters and digits
+func newClientOrderID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")[:20]
+}
+
+// previewOrder estimates an order's cost without placing it. The same order,
+// with the returned client_order_id and preview_id, may then be placed.
+func previewOrder(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	req, _, ok := bindOrderRequest(c)
+	if !ok {
+		return
+	}
+
+	account, err := loadBrokerageAccount(ctx, c.Param("id"))
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+	broker, err := brokerFor(account)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	req.ClientOrderID = newClientOrderID()
+	preview, err := broker.Preview(ctx, account.Account, req)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"broker":  broker.Name(),
+		"order":   req,
+		"preview": preview,
+	})
+}
+
+// placeOrder routes an order to the account's brokerage. Without a preview_id
+// the order is previewed first. The order is recorded before it is submitted:
+// an order the broker refuses is left REJECTED with its reason, while one
+// whose submission failed otherwise stays PENDING until the broker is asked
+// for it by its client order ID.
+func placeOrder(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	req, previewID, ok := bindOrderRequest(c)
+	if !ok {
+		return
+	}
+
+	account, err := loadBrokerageAccount(ctx, c.Param("id"))
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+	broker, err := brokerFor(account)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	// A previewed order is placed with the client order ID it was previewed with
+	if previewID != "" && req.ClientOrderID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_order_id from the preview is required with preview_id"})
+		return
+	}
+
+	var preview *orders.Preview
+	if previewID == "" {
+		req.ClientOrderID = newClientOrderID()
+		preview, err = broker.Preview(ctx, account.Account, req)
+		if err != nil {
+			respondToOrderError(c, err, nil)
+			return
+		}
+		previewID = preview.PreviewID
+	}
+
+	order := &Order{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Request:   req,
+		Broker:    broker.Name(),
+		Status:    orders.StatusPending,
+	}
+	if preview != nil {
+		order.EstimatedTotal = preview.EstimatedTotal
+		order.EstimatedCommission = preview.EstimatedCommission
+		order.Messages = preview.Messages
+	}
+
+	// Each client order ID, and so each preview, can only be placed once
+	var placed bool
+	err = db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM accounts.orders WHERE broker = $1 AND client_order_id = $2)
+	`, order.Broker, order.ClientOrderID).Scan(&placed)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+	if placed {
+		c.JSON(http.StatusConflict, gin.H{"error": "An order with this client_order_id has already been placed"})
+		return
+	}
+
+	if err := insertOrder(ctx, order); err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	execution, err := broker.Place(ctx, account.Account, req, previewID)
+	if err != nil {
+		var brokerErr *orders.BrokerError
+		if errors.As(err, &brokerErr) && brokerErr.Rejected {
+			if rejectErr := rejectOrder(ctx, order.ID, err.Error()); rejectErr != nil {
+				log.Printf("Failed to record rejection of order %s: %v", order.ID, rejectErr)
+			}
+		} else if noteErr := noteOrder(ctx, order.ID, "Placement unconfirmed: "+err.Error()); noteErr != nil {
+			log.Printf("Failed to record unconfirmed placement of order %s: %v", order.ID, noteErr)
+		}
+		order, _ = loadOrder(ctx, account.ID, order.ID)
+		respondToOrderError(c, err, order)
+		return
+	}
+
+	if err := applyExecution(ctx, order.ID, execution, "Order placed"); err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	order, err = loadOrder(ctx, account.ID, order.ID)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      order.ID,
+		"message": "Order placed successfully",
+		"order":   order,
+	})
+}
+
+// insertOrder records a new PENDING order and its first history entry
+func insertOrder(ctx context.Context, o *Order) error {
+	messages, err := json.Marshal(o.Messages)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO accounts.orders (
+			id, account_id, broker, client_order_id, symbol, side, order_type, quantity,
+			limit_price, stop_price, duration, status, estimated_total, estimated_commission, messages
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+		)
+	`, o.ID, o.AccountID, o.Broker, o.ClientOrderID, o.Symbol, o.Side, o.Type, o.Quantity,
+		o.LimitPrice, o.StopPrice, o.Duration, o.Status, o.EstimatedTotal, o.EstimatedCommission, messages)
+	if err != nil {
+		return err
+	}
+
+	if err := recordOrderEvent(ctx, tx, o.ID, "", o.Status, 0, "Order created"); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// rejectOrder marks an order the broker would not accept as REJECTED
+func rejectOrder(ctx context.Context, orderID, reason string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var from string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM accounts.orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&from)
+	if err != nil {
+		return err
+	}
+	if !orders.CanTransition(from, orders.StatusRejected) {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE accounts.orders
+		SET status = $1, reject_reason = $2, closed_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`, orders.StatusRejected, reason, orderID)
+	if err != nil {
+		return err
+	}
+
+	if err := recordOrderEvent(ctx, tx, orderID, from, orders.StatusRejected, 0, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// noteOrder adds an entry to an order's history without changing its status
+func noteOrder(ctx context.Context, orderID, note string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var filled float64
+	err = tx.QueryRow(ctx, `
+		SELECT status, filled_quantity FROM accounts.orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&status, &filled)
+	if err != nil {
+		return err
+	}
+
+	if err := recordOrderEvent(ctx, tx, orderID, status, status, filled, note); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// applyExecution brings an order up to date with the broker's view of it.
+// Status changes the order state machine does not allow are logged and
+// skipped; fill details are always recorded.
+func applyExecution(ctx context.Context, orderID string, e *orders.Execution, note string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var from string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM accounts.orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&from)
+	if err == pgx.ErrNoRows {
+		return errOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	to := from
+	if e.Status != from {
+		if orders.CanTransition(from, e.Status) {
+			to = e.Status
+		} else {
+			log.Printf("Ignoring %s -> %s for order %s", from, e.Status, orderID)
+		}
+	}
+
+	var placedAt *time.Time
+	if !e.PlacedAt.IsZero() {
+		placedAt = &e.PlacedAt
+	}
+	messages, err := json.Marshal(e.Messages)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE accounts.orders
+		SET status = $1,
+		    broker_order_id = COALESCE(NULLIF($2, ''), broker_order_id),
+		    filled_quantity = $3,
+		    average_price = $4,
+		    placed_at = COALESCE(placed_at, $5),
+		    messages = CASE WHEN $6::jsonb = 'null'::jsonb THEN messages ELSE $6::jsonb END,
+		    closed_at = CASE WHEN $7 THEN COALESCE(closed_at, NOW()) ELSE closed_at END,
+		    updated_at = NOW()
+		WHERE id = $8
+	`, to, e.BrokerOrderID, e.FilledQuantity, e.AveragePrice, placedAt, messages, orders.IsFinal(to), orderID)
+	if err != nil {
+		return err
+	}
+
+	if to != from {
+		if err := recordOrderEvent(ctx, tx, orderID, from, to, e.FilledQuantity, note); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// recordOrderEvent appends an entry to accounts.order_events
+func recordOrderEvent(ctx context.Context, tx pgx.Tx, orderID, from, to string, filled float64, note string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO accounts.order_events (
+			id, order_id, from_status, to_status, filled_quantity, note
+		) VALUES (
+			$1, $2, NULLIF($3, ''), $4, $5, $6
+		)
+	`, uuid.New().String(), orderID, from, to, filled, note)
+	return err
+}
+
+const orderColumns = `
+	id, account_id, broker, COALESCE(broker_order_id, ''), client_order_id, symbol, side,
+	order_type, quantity, limit_price, stop_price, duration, status,
+	filled_quantity, average_price, estimated_total, estimated_commission, messages,
+	COALESCE(reject_reason, ''), placed_at, closed_at, created_at, updated_at
+`
+
+// scanOrder scans a row selected with orderColumns
+func scanOrder(row pgx.Row) (*Order, error) {
+	var o Order
+	var messages []byte
+	err := row.Scan(
+		&o.ID, &o.AccountID, &o.Broker, &o.BrokerOrderID, &o.ClientOrderID, &o.Symbol, &o.Side,
+		&o.Type, &o.Quantity, &o.LimitPrice, &o.StopPrice, &o.Duration, &o.Status,
+		&o.FilledQuantity, &o.AveragePrice, &o.EstimatedTotal, &o.EstimatedCommission, &messages,
+		&o.RejectReason, &o.PlacedAt, &o.ClosedAt, &o.CreatedAt, &o.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) > 0 {
+		if err := json.Unmarshal(messages, &o.Messages); err != nil {
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+// loadOrder loads one of an account's orders
+func loadOrder(ctx context.Context, accountID, orderID string) (*Order, error) {
+	order, err := scanOrder(db.QueryRow(ctx, `
+		SELECT `+orderColumns+`
+		FROM accounts.orders
+		WHERE id = $1 AND account_id = $2
+	`, orderID, accountID))
+	if err == pgx.ErrNoRows {
+		return nil, errOrderNotFound
+	}
+	return order, err
+}
+
+// refreshOrder asks the order's broker for its status and records any change.
+// An order whose placement was not confirmed is looked up by its client order
+// ID, and rejected if the broker has no record of it.
+func refreshOrder(ctx context.Context, order *Order) error {
+	if orders.IsFinal(order.Status) {
+		return nil
+	}
+	if order.BrokerOrderID == "" &&
+		(order.Status != orders.StatusPending || time.Since(order.CreatedAt) < orderReconcileDelay) {
+		return nil
+	}
+
+	broker, err := brokerNamed(order.Broker)
+	if err != nil {
+		return err
+	}
+	account, err := loadBrokerageAccount(ctx, order.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if order.BrokerOrderID == "" {
+		execution, err := broker.Lookup(ctx, account.Account, order.ClientOrderID)
+		if errors.Is(err, orders.ErrUnknownOrder) {
+			return rejectOrder(ctx, order.ID, broker.Name()+" has no record of the order")
+		}
+		if err != nil {
+			return err
+		}
+		return applyExecution(ctx, order.ID, execution, "Found at "+broker.Name())
+	}
+
+	execution, err := broker.Status(ctx, account.Account, order.BrokerOrderID)
+	if err != nil {
+		return err
+	}
+	return applyExecution(ctx, order.ID, execution, "Status from "+broker.Name())
+}
+
+// listOrders returns an account's orders, newest first, optionally filtered by ?status=
+func listOrders(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID := c.Param("id")
+	status := strings.ToUpper(c.Query("status"))
+
+	rows, err := db.Query(ctx, `
+		SELECT `+orderColumns+`
+		FROM accounts.orders
+		WHERE account_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT 200
+	`, accountID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
+		return
+	}
+	defer rows.Close()
+
+	result := []*Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan order data"})
+			return
+		}
+		result = append(result, order)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"orders": result})
+}
+
+// getOrder returns an order with its status history, refreshing it from the
+// broker first when it is still working
+func getOrder(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID := c.Param("id")
+	orderID := c.Param("orderId")
+
+	order, err := loadOrder(ctx, accountID, orderID)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	response := gin.H{}
+	if err := refreshOrder(ctx, order); err != nil {
+		// Serve the last known state when the broker cannot be reached
+		response["refresh_error"] = err.Error()
+	} else if order, err = loadOrder(ctx, accountID, orderID); err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, order_id, COALESCE(from_status, ''), to_status, filled_quantity, COALESCE(note, ''), created_at
+		FROM accounts.order_events
+		WHERE order_id = $1
+		ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve order history"})
+		return
+	}
+	defer rows.Close()
+
+	events := []OrderEvent{}
+	for rows.Next() {
+		var event OrderEvent
+		err := rows.Scan(
+			&event.ID, &event.OrderID, &event.FromStatus, &event.ToStatus,
+			&event.FilledQuantity, &event.Note, &event.CreatedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan order history data"})
+			return
+		}
+		events = append(events, event)
+	}
+
+	response["order"] = order
+	response["events"] = events
+	c.JSON(http.StatusOK, response)
+}
+
+// cancelOrder asks the broker to cancel a working order
+func cancelOrder(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID := c.Param("id")
+	orderID := c.Param("orderId")
+
+	order, err := loadOrder(ctx, accountID, orderID)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	if !orders.Cancelable(order.Status) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  fmt.Sprintf("cannot cancel an order that is %s", order.Status),
+			"status": order.Status,
+		})
+		return
+	}
+
+	broker, err := brokerNamed(order.Broker)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+	account, err := loadBrokerageAccount(ctx, accountID)
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	if err := broker.Cancel(ctx, account.Account, order.BrokerOrderID); err != nil {
+		respondToOrderError(c, err, order)
+		return
+	}
+
+	requested := &orders.Execution{
+		Status:         orders.StatusCancelRequested,
+		FilledQuantity: order.FilledQuantity,
+		AveragePrice:   order.AveragePrice,
+	}
+	if err := applyExecution(ctx, order.ID, requested, "Cancel requested"); err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	// Brokers often confirm the cancel straight away
+	order, err = loadOrder(ctx, accountID, orderID)
+	if err == nil {
+		if refreshErr := refreshOrder(ctx, order); refreshErr != nil {
+			log.Printf("Failed to refresh order %s after cancel: %v", orderID, refreshErr)
+		}
+		order, err = loadOrder(ctx, accountID, orderID)
+	}
+	if err != nil {
+		respondToOrderError(c, err, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Order cancel requested successfully",
+		"order":   order,
+	})
+}
+
+// syncOrdersPeriodically refreshes working orders from their brokers until ctx is cancelled
+func syncOrdersPeriodically(ctx context.Context) {
+	interval := durationEnv("ORDER_SYNC_INTERVAL", time.Minute)
+	log.Printf("Syncing open orders every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncOpenOrders(ctx)
+		}
+	}
+}
+
+// syncOpenOrders refreshes every order that is not yet final, including
+// pending orders whose placement was not confirmed
+func syncOpenOrders(ctx context.Context) {
+	rows, err := db.Query(ctx, `
+		SELECT `+orderColumns+`
+		FROM accounts.orders
+		WHERE (status IN ($1, $2, $3) AND broker_order_id IS NOT NULL)
+		   OR (status = $4 AND broker_order_id IS NULL AND created_at < $5)
+	`, orders.StatusOpen, orders.StatusPartiallyFilled, orders.StatusCancelRequested,
+		orders.StatusPending, time.Now().Add(-orderReconcileDelay))
+	if err != nil {
+		log.Printf("Failed to load open orders: %v", err)
+		return
+	}
+
+	var open []*Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			log.Printf("Failed to scan open order: %v", err)
+			continue
+		}
+		open = append(open, order)
+	}
+	rows.Close()
+
+	for _, order := range open {
+		if err := refreshOrder(ctx, order); err != nil {
+			log.Printf("Failed to refresh order %s: %v", order.ID, err)
+		}
+	}
+}
+
+// durationEnv reads a duration such as "30s" from the environment
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/ofx"
+)
+
+// statementInstitutionID prefixes the institution_id of accounts created from
+// imported statements
+const statementInstitutionID = "ofx"
+
+// maxStatementFileBytes caps the size of a statement upload
+const maxStatementFileBytes = 10 << 20
+
+// statementAccountTypes maps OFX account types to account types
+var statementAccountTypes = map[string]string{
+	"CHECKING":            "CHECKING",
+	"SAVINGS":             "SAVINGS",
+	"MONEYMRKT":           "MONEY_MARKET",
+	"CD":                  "CD",
+	"CREDITLINE":          "CREDIT_LINE",
+	ofx.AccountCreditCard: "CREDIT_CARD",
+	ofx.AccountInvestment: "BROKERAGE",
+}
+
+// investmentTransactionVerbs describe the investment transactions kept with
+// an account's transactions by the start of their OFX kind; buys, sells and
+// income go to the investment ledger
+var investmentTransactionVerbs = []struct{ prefix, verb string }{
+	{"TRANSFER", "Transfer"},
+}
+
+// StatementImport is the outcome of importing one account's statement.
+// TransactionsImported counts transactions added or changed; transactions
+// already imported unchanged are TransactionsSkipped. Buys, sells and income
+// are recorded in the investment ledger and counted separately; those the
+// ledger refused are listed by FITID in InvestmentTransactionErrors.
+type StatementImport struct {
+	AccountID                      string     `json:"account_id"`
+	ExternalAccountID              string     `json:"external_account_id"`
+	AccountCreated                 bool       `json:"account_created"`
+	StatementDate                  *time.Time `json:"statement_date,omitempty"`
+	BalanceUpdated                 bool       `json:"balance_updated"`
+	Positions                      int        `json:"positions"`
+	ClosedPositions                int64      `json:"closed_positions"`
+	TransactionsImported           int64      `json:"transactions_imported"`
+	TransactionsSkipped            int64      `json:"transactions_skipped"`
+	InvestmentTransactionsImported int64      `json:"investment_transactions_imported"`
+	InvestmentTransactionsSkipped  int64      `json:"investment_transactions_skipped"`
+	InvestmentTransactionErrors    []string   `json:"investment_transaction_errors,omitempty"`
+
+	currency    string
+	periodStart time.Time
+	ledger      []ledgerTransaction
+	positions   []accountsync.Position
+}
+
+// importStatement imports an OFX or QFX file, sent as the "file" field of a
+// multipart upload or as the request body, into the user's accounts. Each
+// account on the file is matched to the account created by its first import,
+// by the institution and its account ID, or created. Transactions are keyed
+// by their FITID, so importing overlapping statements stores each transaction
+// once. Balances and positions are only replaced by a statement at least as
+// recent as any imported before; an older statement adds its transactions.
+//
+// Accounts, balances and cash transactions are imported in full or not at
+// all. Investment transactions are then recorded in investment-service's
+// ledger, which opens and relieves their tax lots, after an opening buy for
+// any units a current statement holds beyond what its trades account for.
+// Positions are synced last so holdings the ledger manages are left to it.
+// Should the ledger be unreachable, importing the file again records what is
+// missing.
+func importStatement(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("userId")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxStatementFileBytes)
+
+	var file io.Reader = c.Request.Body
+	fileName := ""
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An OFX or QFX file is required in the file field"})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer f.Close()
+		file, fileName = f, header.Filename
+	}
+
+	statement, err := ofx.Parse(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement file: " + err.Error()})
+		return
+	}
+
+	// Validate if user exists
+	var userExists bool
+	err = db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM users.users WHERE id = $1 AND is_active = true)
+	`, userID).Scan(&userExists)
+	if err != nil || !userExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	imports := make([]StatementImport, 0, len(statement.Statements))
+	for _, s := range statement.Statements {
+		result, err := importAccountStatement(ctx, tx, userID, statement.Institution, fileName, s, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to import statement of account %s: %v", s.AccountID, err)})
+			return
+		}
+		imports = append(imports, *result)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit statement import"})
+		return
+	}
+
+	ledger := newInvestmentLedger()
+	for i := range imports {
+		if err := reconcileStatementUnits(ctx, &imports[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to reconcile holdings of account %s: %v", imports[i].ExternalAccountID, err)})
+			return
+		}
+		if err := recordStatementLedger(ctx, ledger, &imports[i]); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":      fmt.Sprintf("Failed to record investment transactions of account %s, import the file again to retry: %v", imports[i].ExternalAccountID, err),
+				"statements": imports,
+			})
+			return
+		}
+	}
+
+	if err := syncStatementPositions(ctx, imports, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update positions: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    fmt.Sprintf("%d statements imported successfully", len(imports)),
+		"statements": imports,
+	})
+}
+
+// importAccountStatement stores one account's statement within tx. Its
+// investment transactions and, for a current statement, its positions are
+// kept on the result to be recorded once tx commits.
+func importAccountStatement(ctx context.Context, tx pgx.Tx, userID, institution, fileName string, s ofx.Statement, now time.Time) (*StatementImport, error) {
+	result := &StatementImport{ExternalAccountID: s.AccountID}
+	currency := s.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	result.currency = currency
+	result.periodStart = s.Start
+
+	// Accounts imported before they were keyed by institution are claimed by
+	// the institution they were named after
+	institutionKey := statementInstitutionKey(institution, s)
+	institutionName := statementInstitutionName(institution, s)
+	var legacy bool
+	err := tx.QueryRow(ctx, `
+		SELECT id, institution_id <> $1
+		FROM accounts.accounts
+		WHERE user_id = $2 AND external_account_id = $3 AND is_active = true
+		  AND (institution_id = $1 OR (institution_id = $4 AND institution_name = $5))
+		ORDER BY institution_id = $1 DESC
+		LIMIT 1
+		FOR UPDATE
+	`, institutionKey, userID, s.AccountID, statementInstitutionID, institutionName).Scan(&result.AccountID, &legacy)
+	if err == pgx.ErrNoRows {
+		result.AccountID, err = createStatementAccount(ctx, tx, userID, institutionKey, institutionName, s, currency)
+		result.AccountCreated = true
+	} else if err == nil && legacy {
+		_, err = tx.Exec(ctx, `
+			UPDATE accounts.accounts SET institution_id = $1, updated_at = $2 WHERE id = $3
+		`, institutionKey, now, result.AccountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Balances and holdings only move forward
+	var latest *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT MAX(statement_date) FROM accounts.statement_imports WHERE account_id = $1
+	`, result.AccountID).Scan(&latest)
+	if err != nil {
+		return nil, err
+	}
+	if !s.BalanceAsOf.IsZero() {
+		statementDate := s.BalanceAsOf
+		result.StatementDate = &statementDate
+	}
+	if result.StatementDate != nil && (latest == nil || !result.StatementDate.Before(*latest)) {
+		_, err = tx.Exec(ctx, `
+			UPDATE accounts.accounts
+			SET balance_amount = $1, balance_currency = $2, updated_at = $3
+			WHERE id = $4
+		`, money.Round(s.Balance, currency), currency, now, result.AccountID)
+		if err != nil {
+			return nil, err
+		}
+		result.BalanceUpdated = true
+
+		if s.Positions != nil {
+			// OFX positions carry no cost basis
+			result.positions = make([]accountsync.Position, 0, len(s.Positions))
+			for _, p := range s.Positions {
+				result.positions = append(result.positions, accountsync.Position{
+					Symbol:      p.Symbol,
+					Quantity:    p.Units,
+					MarketValue: p.MarketValue,
+				})
+			}
+		}
+	}
+
+	transactions := make([]accountsync.Transaction, 0, len(s.Transactions))
+	for _, t := range s.Transactions {
+		if ledger := statementLedgerTransactions(result.AccountID, t); ledger != nil {
+			result.ledger = append(result.ledger, ledger...)
+			continue
+		}
+		posted := t.Posted
+		transactions = append(transactions, accountsync.Transaction{
+			ExternalID:  t.FITID,
+			Date:        t.Date,
+			PostDate:    &posted,
+			Description: statementTransactionDescription(t),
+			Category:    t.Category,
+			Amount:      t.Amount,
+			Type:        t.Type,
+		})
+	}
+	// A statement covers a period but not the pending transactions in it, so
+	// no range is given and nothing stored is deleted
+	result.TransactionsImported, err = accountsync.SyncTransactions(ctx, tx, result.AccountID, currency, transactions, time.Time{}, now)
+	if err != nil {
+		return nil, err
+	}
+	result.TransactionsSkipped = int64(len(transactions)) - result.TransactionsImported
+
+	var periodStart, periodEnd *time.Time
+	if !s.Start.IsZero() {
+		periodStart = &s.Start
+	}
+	if !s.End.IsZero() {
+		periodEnd = &s.End
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO accounts.statement_imports (
+			id, account_id, user_id, file_name, statement_date, period_start, period_end,
+			transactions, transactions_imported, imported_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		)
+	`, uuid.New().String(), result.AccountID, userID, fileName, result.StatementDate,
+		periodStart, periodEnd, len(transactions), result.TransactionsImported, now)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reconcileStatementUnits adds an opening buy ahead of a current statement's
+// trades for the units it reports beyond those the ledger holds and the
+// trades add, such as shares bought before the first imported statement.
+// Positions the ledger manages are not synced, so without it the trades
+// alone would make up the position. OFX carries no cost basis, so the
+// opening lot costs the statement's price.
+func reconcileStatementUnits(ctx context.Context, result *StatementImport) error {
+	if result.positions == nil || len(result.ledger) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(result.ledger))
+	for _, t := range result.ledger {
+		ids = append(ids, t.ExternalID)
+	}
+	rows, err := db.Query(ctx, `
+		SELECT external_id FROM investments.transactions
+		WHERE account_id = $1 AND external_id = ANY($2)
+	`, result.AccountID, ids)
+	if err != nil {
+		return err
+	}
+	recorded := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		recorded[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Units the statement's trades add once recorded, and each symbol's
+	// first trade
+	traded := make(map[string]float64)
+	firstTrade := make(map[string]ledgerTransaction)
+	for _, t := range result.ledger {
+		symbol := strings.ToUpper(strings.TrimSpace(t.Symbol))
+		if first, ok := firstTrade[symbol]; !ok || t.TradeDate.Before(first.TradeDate) {
+			firstTrade[symbol] = t
+		}
+		if recorded[t.ExternalID] {
+			continue
+		}
+		switch t.Type {
+		case ledgerBuy:
+			traded[symbol] += t.Quantity
+		case ledgerSell:
+			traded[symbol] -= t.Quantity
+		}
+	}
+
+	for _, p := range result.positions {
+		symbol := strings.ToUpper(strings.TrimSpace(p.Symbol))
+		first, ok := firstTrade[symbol]
+		if !ok {
+			continue
+		}
+
+		var held float64
+		err := db.QueryRow(ctx, `
+			SELECT COALESCE(SUM(p.quantity), 0)
+			FROM investments.positions p
+			JOIN investments.assets a ON a.id = p.asset_id
+			WHERE p.account_id = $1 AND a.symbol = $2 AND p.is_open = true
+		`, result.AccountID, symbol).Scan(&held)
+		if err != nil {
+			return err
+		}
+
+		missing := p.Quantity - held - traded[symbol]
+		if missing < 0.000001 {
+			continue
+		}
+		openedAt := first.TradeDate
+		if !result.periodStart.IsZero() && result.periodStart.Before(openedAt) {
+			openedAt = result.periodStart
+		}
+		// A position sold out reports no price, so the first trade's is used
+		price := first.Price
+		if p.Quantity != 0 {
+			price = p.MarketValue.Abs().DivRound(decimal.NewFromFloat(math.Abs(p.Quantity)), ledgerPriceDecimals)
+		}
+		opening := ledgerTransaction{
+			AccountID:  result.AccountID,
+			Symbol:     symbol,
+			Type:       ledgerBuy,
+			Quantity:   missing,
+			Price:      price,
+			TradeDate:  openedAt,
+			ExternalID: "OPENING:" + symbol + ":" + openedAt.Format("20060102"),
+			Notes:      "Opening balance",
+		}
+		// Recorded first, as trades on the same date sort after it
+		result.ledger = append([]ledgerTransaction{opening}, result.ledger...)
+	}
+	return nil
+}
+
+// recordStatementLedger records a statement's investment transactions in the
+// ledger in trade date order. Transactions the ledger refuses, such as a sale
+// of shares bought before the first imported statement, are listed on the
+// result; any other failure stops the import.
+func recordStatementLedger(ctx context.Context, ledger *investmentLedger, result *StatementImport) error {
+	sort.SliceStable(result.ledger, func(i, j int) bool {
+		return result.ledger[i].TradeDate.Before(result.ledger[j].TradeDate)
+	})
+	for _, t := range result.ledger {
+		duplicate, err := ledger.record(ctx, t)
+		var rejection *ledgerRejectionError
+		if errors.As(err, &rejection) {
+			result.InvestmentTransactionErrors = append(result.InvestmentTransactionErrors, t.ExternalID+": "+rejection.Reason)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			result.InvestmentTransactionsSkipped++
+		} else {
+			result.InvestmentTransactionsImported++
+		}
+	}
+	return nil
+}
+
+// syncStatementPositions makes the positions of each account with a current
+// statement match its holdings, once the ledger has recorded its trades
+func syncStatementPositions(ctx context.Context, imports []StatementImport, now time.Time) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range imports {
+		result := &imports[i]
+		if result.positions == nil {
+			continue
+		}
+		result.Positions, result.ClosedPositions, err = accountsync.SyncPositions(ctx, tx, result.AccountID, result.currency, result.positions, now)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", result.ExternalAccountID, err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// statementInstitutionKey is the institution_id of an account imported from
+// a statement, which scopes its account ID to the institution: the bank's
+// routing number or the broker's ID, or the institution's name when the
+// statement has neither
+func statementInstitutionKey(institution string, s ofx.Statement) string {
+	id := s.InstitutionID
+	if id == "" {
+		id = institution
+	}
+	if id == "" {
+		return statementInstitutionID
+	}
+	return statementInstitutionID + ":" + id
+}
+
+// statementInstitutionName names the institution of an imported account
+func statementInstitutionName(institution string, s ofx.Statement) string {
+	if institution == "" {
+		institution = s.InstitutionID
+	}
+	if institution == "" {
+		institution = "Imported"
+	}
+	return institution
+}
+
+// createStatementAccount creates the account for a statement's first import,
+// named after the institution, the account type and the last digits of the
+// account ID
+func createStatementAccount(ctx context.Context, tx pgx.Tx, userID, institutionKey, institution string, s ofx.Statement, currency string) (string, error) {
+	accountType, ok := statementAccountTypes[s.Type]
+	if !ok {
+		accountType = "CHECKING"
+	}
+
+	last := s.AccountID
+	if len(last) > 4 {
+		last = last[len(last)-4:]
+	}
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(accountType, "_", " ")))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	name := fmt.Sprintf("%s %s ...%s", institution, strings.Join(words, " "), last)
+
+	id := uuid.New().String()
+	_, err := tx.Exec(ctx, `
+		INSERT INTO accounts.accounts (
+			id, user_id, type, name, description, institution_id, institution_name,
+			external_account_id, balance_amount, balance_currency, is_active
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, 0, $9, true
+		)
+	`, id, userID, accountType, name, "Imported from an OFX statement", institutionKey,
+		institution, s.AccountID, currency)
+	return id, err
+}
+
+// statementTransactionDescription describes a statement transaction by its
+// payee or memo, and an investment transaction by what it did with which
+// security
+func statementTransactionDescription(t ofx.Transaction) string {
+	if t.Symbol != "" {
+		verb := t.Type
+		for _, v := range investmentTransactionVerbs {
+			if strings.HasPrefix(t.Type, v.prefix) {
+				verb = v.verb
+				break
+			}
+		}
+		if t.Units != 0 {
+			return fmt.Sprintf("%s %g %s", verb, math.Abs(t.Units), t.Symbol)
+		}
+		return verb + " " + t.Symbol
+	}
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Memo
+}
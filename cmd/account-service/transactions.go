@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// dateLayout is the layout of dates in query parameters
+const dateLayout = "2006-01-02"
+
+// Transaction is a transaction of a linked account, stored by its
+// institution's sync
+type Transaction struct {
+	ID              string          `json:"id"`
+	AccountID       string          `json:"account_id"`
+	ExternalID      string          `json:"external_transaction_id"`
+	TransactionDate time.Time       `json:"transaction_date"`
+	PostDate        *time.Time      `json:"post_date,omitempty"`
+	Description     string          `json:"description,omitempty"`
+	Category        string          `json:"category,omitempty"`
+	Amount          decimal.Decimal `json:"amount"`
+	Currency        string          `json:"currency"`
+	Type            string          `json:"type,omitempty"`
+	Status          string          `json:"status"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// listTransactions returns an account's transactions, newest first. They can
+// be filtered by transaction date with ?from= and ?to=, by ?category=, by
+// ?status= (PENDING or POSTED), and by signed amount with ?min_amount= and
+// ?max_amount=. Pages are selected with ?limit= (at most 1000, 100 by
+// default) and ?offset=; has_more reports whether later pages remain.
+func listTransactions(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID := c.Param("id")
+
+	var exists bool
+	err := db.QueryRow(ctx, `SELECT true FROM accounts.accounts WHERE id = $1 AND is_active = true`, accountID).Scan(&exists)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve account"})
+		return
+	}
+
+	conditions := []string{"account_id = $1"}
+	args := []interface{}{accountID}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	for _, bound := range []struct{ param, condition string }{
+		{"from", "transaction_date >= $%d"},
+		{"to", "transaction_date <= $%d"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param + " date, expected YYYY-MM-DD"})
+			return
+		}
+		addCondition(bound.condition, date)
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"min_amount", "amount >= $%d"},
+		{"max_amount", "amount <= $%d"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		amount, err := decimal.NewFromString(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param})
+			return
+		}
+		addCondition(bound.condition, amount)
+	}
+	if value := c.Query("category"); value != "" {
+		addCondition("LOWER(category) = LOWER($%d)", value)
+	}
+	if value := c.Query("status"); value != "" {
+		addCondition("status = $%d", strings.ToUpper(value))
+	}
+
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if value := c.Query("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+			return
+		}
+		offset = n
+	}
+	// One row past the page tells whether another page follows
+	args = append(args, limit+1, offset)
+
+	rows, err := db.Query(ctx, `
+		SELECT id, account_id, external_transaction_id, transaction_date, post_date,
+		       COALESCE(description, ''), COALESCE(category, ''), amount, currency,
+		       COALESCE(type, ''), status, created_at, updated_at
+		FROM accounts.transactions
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY transaction_date DESC, created_at DESC
+		LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args))+`
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(
+			&t.ID, &t.AccountID, &t.ExternalID, &t.TransactionDate, &t.PostDate,
+			&t.Description, &t.Category, &t.Amount, &t.Currency,
+			&t.Type, &t.Status, &t.CreatedAt, &t.UpdatedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan transaction data"})
+			return
+		}
+		transactions = append(transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
+	}
+
+	hasMore := len(transactions) > limit
+	if hasMore {
+		transactions = transactions[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"limit":        limit,
+		"offset":       offset,
+		"has_more":     hasMore,
+	})
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
 
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/aggregation"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/capitalone/handlers"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/capitalone/service"
 )
@@ -61,6 +63,27 @@ func main() {
 		log.Fatalf("Failed to ensure capitalone schema: %v", err)
 	}
 
+	// Ensure the account sync status table exists
+	if err := accountsync.EnsureSchema(context.Background(), db); err != nil {
+		log.Fatalf("Failed to ensure account sync schema: %v", err)
+	}
+
+	// Serve Capital One through the aggregation provider interface
+	registry, err := aggregation.NewRegistry(capitalOneService)
+	if err != nil {
+		log.Fatalf("Failed to register Capital One provider: %v", err)
+	}
+	aggregationHandler := aggregation.NewHandler(
+		db, registry,
+		durationEnv("ACCOUNT_SYNC_INTERVAL", time.Hour),
+		durationEnv("ACCOUNT_SYNC_MAX_BACKOFF", 24*time.Hour),
+	)
+
+	// Keep linked Capital One accounts in sync in the background
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	go aggregationHandler.RunSync(syncCtx)
+
 	// Create the Capital One handler
 	capitalOneHandler := handlers.NewCapitalOneHandler(capitalOneService)
 
@@ -77,6 +100,7 @@ func main() {
 
 	// API routes
 	v1 := router.Group("/api/v1")
+	aggregationHandler.RegisterRoutes(v1)
 	capitalOneHandler.RegisterRoutes(v1)
 
 	// Start server
@@ -97,6 +121,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down capitalone-service...")
+	stopSync()
 
 	// Give the server 5 seconds to finish ongoing requests
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -116,3 +141,17 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// durationEnv reads a duration environment variable such as "1h"
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/evidence"
+)
+
+// signer identifies who is signing and how they authenticated
+type signer struct {
+	UserID     string
+	SessionID  string
+	AuthMethod string
+}
+
+// SignerAuthError is returned when a signer cannot be authenticated
+type SignerAuthError struct {
+	Status int
+	Reason string
+}
+
+func (e *SignerAuthError) Error() string {
+	return e.Reason
+}
+
+// signatoryCredentials are what a signer is checked against: the signatory's
+// account or email, and the hash and expiry of their latest invite token
+type signatoryCredentials struct {
+	UserID          string
+	Email           string
+	InviteTokenHash string
+	InviteExpiresAt *time.Time
+}
+
+// authenticateSigner identifies the signer from their auth session (the
+// X-Session-ID header) or, for signatories without an account, from the
+// invite token they were emailed, until it expires. A session only stands in
+// for a signatory without an account when it belongs to a user with the
+// signatory's email.
+func authenticateSigner(c *gin.Context, ctx context.Context, signatory signatoryCredentials, userID, inviteToken string) (*signer, error) {
+	if sessionID := c.GetHeader("X-Session-ID"); sessionID != "" {
+		if _, err := uuid.Parse(sessionID); err != nil {
+			return nil, &SignerAuthError{Status: http.StatusUnauthorized, Reason: "Invalid or expired session"}
+		}
+
+		session, err := sessionService.GetActiveSession(ctx, sessionID)
+		if err == pgx.ErrNoRows {
+			return nil, &SignerAuthError{Status: http.StatusUnauthorized, Reason: "Invalid or expired session"}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if userID != "" && userID != session.UserID {
+			return nil, &SignerAuthError{Status: http.StatusForbidden, Reason: "Session does not belong to the signing user"}
+		}
+		if signatory.UserID != "" && signatory.UserID != session.UserID {
+			return nil, &SignerAuthError{Status: http.StatusForbidden, Reason: "Signatory is assigned to a different user"}
+		}
+		if signatory.UserID == "" {
+			var matches bool
+			err := db.QueryRow(ctx, `
+				SELECT EXISTS(SELECT 1 FROM users.users WHERE id = $1 AND is_active = true AND LOWER(email) = LOWER($2))
+			`, session.UserID, signatory.Email).Scan(&matches)
+			if err != nil {
+				return nil, err
+			}
+			if !matches {
+				return nil, &SignerAuthError{Status: http.StatusForbidden, Reason: "Session does not belong to the signatory's email"}
+			}
+		}
+
+		if err := sessionService.UpdateSessionActivity(ctx, sessionID); err != nil {
+			// Activity tracking must not block signing
+			log.Printf("Warning: Failed to update session activity: %v", err)
+		}
+
+		return &signer{UserID: session.UserID, SessionID: session.ID, AuthMethod: evidence.AuthSession}, nil
+	}
+
+	if inviteToken != "" && signatory.InviteTokenHash != "" &&
+		subtle.ConstantTimeCompare([]byte(hashInviteToken(inviteToken)), []byte(signatory.InviteTokenHash)) == 1 {
+		if signatory.InviteExpiresAt == nil || !time.Now().Before(*signatory.InviteExpiresAt) {
+			return nil, &SignerAuthError{Status: http.StatusUnauthorized, Reason: "Invite token has expired"}
+		}
+		return &signer{UserID: signatory.UserID, AuthMethod: evidence.AuthInviteToken}, nil
+	}
+
+	return nil, &SignerAuthError{Status: http.StatusUnauthorized, Reason: "An auth session or a valid invite token is required to sign"}
+}
+
+// querier is satisfied by both the connection pool and transactions
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// documentContent hashes the document's content files. Derived files such as
+// PDFs, which change as signatures are added, are not part of the content.
+func documentContent(ctx context.Context, q querier, documentID string) ([]evidence.ContentFile, string, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, file_name, file_key
+		FROM documents.files
+		WHERE document_id = $1 AND source_hash IS NULL
+		ORDER BY id
+	`, documentID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	type contentRow struct {
+		file evidence.ContentFile
+		key  string
+	}
+	var found []contentRow
+	for rows.Next() {
+		var r contentRow
+		if err := rows.Scan(&r.file.ID, &r.file.Name, &r.key); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		found = append(found, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	files := make([]evidence.ContentFile, 0, len(found))
+	for _, r := range found {
+		data, err := s3Client.DownloadFile(ctx, r.key)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read file %s: %w", r.file.ID, err)
+		}
+		r.file.SHA256 = evidence.HashBytes(data)
+		files = append(files, r.file)
+	}
+
+	return files, evidence.ContentHash(files), nil
+}
+
+// recordSignatureEvent appends a sealed event to the document's signature
+// chain. The caller must hold a lock on the document row.
+func recordSignatureEvent(ctx context.Context, tx pgx.Tx, event *evidence.Event) error {
+	var sequence int
+	var previousHash string
+	err := tx.QueryRow(ctx, `
+		SELECT sequence, event_hash
+		FROM documents.signature_events
+		WHERE document_id = $1
+		ORDER BY sequence DESC
+		LIMIT 1
+	`, event.DocumentID).Scan(&sequence, &previousHash)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+
+	event.Seal(previousHash)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO documents.signature_events (
+			id, document_id, sequence, signatory_id, event_type, content_hash,
+			signer_name, signer_email, user_id, session_id, auth_method,
+			ip_address, user_agent, occurred_at, previous_hash, event_hash
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11,
+			$12, $13, $14, $15, $16
+		)
+	`, event.ID, event.DocumentID, sequence+1, event.SignatoryID, event.Type, event.ContentHash,
+		event.SignerName, event.SignerEmail, event.UserID, event.SessionID, event.AuthMethod,
+		event.IPAddress, event.UserAgent, event.OccurredAt, event.PreviousHash, event.Hash)
+	return err
+}
+
+// loadSignatureEvents returns a document's signature chain in order
+func loadSignatureEvents(ctx context.Context, documentID string) ([]evidence.Event, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, document_id, signatory_id, event_type, content_hash,
+		       signer_name, signer_email, COALESCE(user_id, ''), COALESCE(session_id, ''),
+		       auth_method, ip_address, user_agent, occurred_at, previous_hash, event_hash
+		FROM documents.signature_events
+		WHERE document_id = $1
+		ORDER BY sequence
+	`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []evidence.Event
+	for rows.Next() {
+		var e evidence.Event
+		err := rows.Scan(
+			&e.ID, &e.DocumentID, &e.SignatoryID, &e.Type, &e.ContentHash,
+			&e.SignerName, &e.SignerEmail, &e.UserID, &e.SessionID,
+			&e.AuthMethod, &e.IPAddress, &e.UserAgent, &e.OccurredAt, &e.PreviousHash, &e.Hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// getDocumentCertificate returns the document's completion certificate: its
+// signature chain, the content hash each signer signed and whether the chain
+// and the current content still match that evidence
+func getDocumentCertificate(c *gin.Context) {
+	documentID := c.Param("id")
+	ctx := context.Background()
+
+	var document struct {
+		Title  string
+		Status string
+	}
+	err := db.QueryRow(ctx, `
+		SELECT title, status FROM documents.documents WHERE id = $1 AND status != 'DELETED'
+	`, documentID).Scan(&document.Title, &document.Status)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	events, err := loadSignatureEvents(ctx, documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve signature events: " + err.Error()})
+		return
+	}
+
+	files, contentHash, err := documentContent(ctx, db, documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash document content: " + err.Error()})
+		return
+	}
+
+	var pending []string
+	rows, err := db.Query(ctx, `
+		SELECT name FROM documents.signatories
+		WHERE document_id = $1 AND required = true AND signed_at IS NULL
+		ORDER BY "order"
+	`, documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve signatories: " + err.Error()})
+		return
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan signatory data"})
+			return
+		}
+		pending = append(pending, name)
+	}
+	rows.Close()
+
+	verification := evidence.Verify(events, contentHash)
+	completed := document.Status == DocumentStatusSigned && len(pending) == 0
+
+	c.JSON(http.StatusOK, gin.H{
+		"document_id":           documentID,
+		"title":                 document.Title,
+		"status":                document.Status,
+		"completed":             completed,
+		"pending_signatories":   pending,
+		"content_hash":          contentHash,
+		"content_files":         files,
+		"events":                events,
+		"verification":          verification,
+		"verified":              len(events) > 0 && verification.ChainValid && verification.ContentUnchanged,
+		"content_changed":       !verification.ContentUnchanged,
+		"certificate_issued_at": time.Now().UTC(),
+	})
+}
+
+// respondToSignerAuthError writes the response for a failed signer check
+func respondToSignerAuthError(c *gin.Context, err error) {
+	var authErr *SignerAuthError
+	if errors.As(err, &authErr) {
+		c.JSON(authErr.Status, gin.H{"error": authErr.Reason})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate signer: " + err.Error()})
+}
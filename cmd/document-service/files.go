@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMaxUploadBytes is the upload size limit when DOCUMENT_MAX_UPLOAD_BYTES is unset
+const defaultMaxUploadBytes = 25 << 20
+
+// multipartOverheadBytes allows for multipart headers around the file itself
+const multipartOverheadBytes = 1 << 20
+
+// sniffLength is the number of bytes inspected to detect a file's type
+const sniffLength = 512
+
+// allowedContentTypes are the file types accepted for upload, keyed by the
+// type detected from the file's magic bytes
+var allowedContentTypes = map[string]bool{
+	"application/pdf":           true,
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"text/plain; charset=utf-8": true,
+	// Office Open XML documents (.docx, .xlsx) are zip archives
+	"application/zip": true,
+}
+
+// errFileTooLarge is returned when an upload exceeds the size limit
+var errFileTooLarge = errors.New("file exceeds the upload size limit")
+
+// UnsupportedTypeError is returned when an upload's detected type is not allowed
+type UnsupportedTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported file type %s", e.ContentType)
+}
+
+// uploadResult describes a file stored in S3
+type uploadResult struct {
+	ContentType string
+	SizeBytes   int64
+	SHA256      string
+}
+
+// limitedReader fails once more than limit bytes have been read
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// uploadFile detects the file's type from its first bytes, then streams it to
+// S3 while computing its SHA-256 and enforcing the size limit
+func uploadFile(ctx context.Context, key string, src io.Reader, maxBytes int64) (*uploadResult, error) {
+	buffered := bufio.NewReaderSize(src, sniffLength)
+	head, err := buffered.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(head) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	contentType := http.DetectContentType(head)
+	if !allowedContentTypes[contentType] {
+		return nil, &UnsupportedTypeError{ContentType: contentType}
+	}
+
+	hash := sha256.New()
+	limited := &limitedReader{r: buffered, limit: maxBytes}
+	body := io.TeeReader(limited, hash)
+
+	if err := s3Client.UploadFile(ctx, key, body, contentType); err != nil {
+		if errors.Is(err, errFileTooLarge) || limited.n > maxBytes {
+			return nil, errFileTooLarge
+		}
+		return nil, err
+	}
+
+	return &uploadResult{
+		ContentType: contentType,
+		SizeBytes:   limited.n,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// multipartFile returns the named file part of a multipart request without
+// reading the parts that follow it. Parts before it are skipped.
+func multipartFile(r *http.Request, field string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// respondToUploadError writes the response for a failed upload
+func respondToUploadError(c *gin.Context, err error) {
+	var typeErr *UnsupportedTypeError
+	switch {
+	case errors.Is(err, errFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the %d byte limit", maxUploadBytes())})
+	case errors.As(err, &typeErr):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported file type: " + typeErr.ContentType})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file: " + err.Error()})
+	}
+}
+
+// uploadedFileKey returns the S3 key for an uploaded document file
+func uploadedFileKey(documentID, fileID, fileName string) string {
+	return fmt.Sprintf("documents/%s/files/%s/%s", documentID, fileID, fileName)
+}
+
+// sanitizeFileName keeps the base name of an uploaded file, limited to
+// characters that are safe in S3 keys and download headers
+func sanitizeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Trim(unsafeFileNameChars.ReplaceAllString(name, "-"), "-.")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// maxUploadBytes returns the upload size limit from DOCUMENT_MAX_UPLOAD_BYTES
+func maxUploadBytes() int64 {
+	value := getEnv("DOCUMENT_MAX_UPLOAD_BYTES", "")
+	if value == "" {
+		return defaultMaxUploadBytes
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 {
+		log.Printf("Invalid DOCUMENT_MAX_UPLOAD_BYTES %q, using %d", value, defaultMaxUploadBytes)
+		return defaultMaxUploadBytes
+	}
+	return limit
+}
+
+// downloadURLTTL returns how long presigned download URLs stay valid
+func downloadURLTTL() time.Duration {
+	return durationEnv("DOCUMENT_DOWNLOAD_URL_TTL", 5*time.Minute)
+}
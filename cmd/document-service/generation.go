@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/evidence"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/render"
+)
+
+// renderedContentType is the content type of rendered template output
+const renderedContentType = "text/plain; charset=utf-8"
+
+// checkTemplateDefinition verifies a template's variable schema and content and
+// returns the variable names to store alongside it. When a schema is supplied
+// its names take precedence over the plain variables list.
+func checkTemplateDefinition(content string, variables []string, schema render.Schema) ([]string, error) {
+	if schema != nil {
+		if err := schema.Check(); err != nil {
+			return nil, err
+		}
+		variables = schema.Names()
+	}
+
+	if _, err := render.Parse(content); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+// respondToTemplateError writes the response for an invalid template or variables
+func respondToTemplateError(c *gin.Context, err error) {
+	var validationErr *render.ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Invalid template variables",
+			"problems": validationErr.Problems,
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// generatedFileKeyPrefix returns the S3 key prefix of a document's rendered
+// content, which tells it apart from uploaded files of the same type
+func generatedFileKeyPrefix(documentID string) string {
+	return fmt.Sprintf("documents/%s/generated/", documentID)
+}
+
+// generatedFileKey returns the S3 key for a document's rendered content
+func generatedFileKey(documentID, fileID string) string {
+	return generatedFileKeyPrefix(documentID) + fileID + ".txt"
+}
+
+func generateDocument(c *gin.Context) {
+	templateID := c.Param("id")
+
+	var input struct {
+		Title     string                 `json:"title" binding:"required"`
+		CreatorID string                 `json:"creator_id" binding:"required"`
+		Variables map[string]interface{} `json:"variables"`
+		// Version pins a published template version; the current version is used when omitted
+		Version *int `json:"version"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	// Get template
+	var template struct {
+		ID      string
+		Name    string
+		Type    string
+		Version int
+	}
+
+	err := db.QueryRow(ctx, `
+		SELECT id, name, type, version
+		FROM documents.templates
+		WHERE id = $1 AND is_active = true
+	`, templateID).Scan(&template.ID, &template.Name, &template.Type, &template.Version)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+
+	// Documents are always generated from a published version, never the draft
+	version := template.Version
+	if input.Version != nil {
+		version = *input.Version
+	}
+
+	published, err := loadTemplateVersion(ctx, db, templateID, version)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	// Templates created before variable schemas existed only list names
+	schema := published.VariableSchema
+	if schema == nil {
+		schema = render.SchemaFromNames(published.Variables)
+	}
+
+	values, err := schema.Validate(input.Variables)
+	if err != nil {
+		respondToTemplateError(c, err)
+		return
+	}
+
+	content, err := render.Render(published.Content, values)
+	if err != nil {
+		respondToTemplateError(c, err)
+		return
+	}
+
+	documentID := uuid.New().String()
+	fileID := uuid.New().String()
+	fileKey := generatedFileKey(documentID, fileID)
+	body := []byte(content)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate document: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+
+	// Create document from template
+	_, err = tx.Exec(ctx, `
+		INSERT INTO documents.documents (
+			id, type, title, status, creator_id, template_id, template_version, version,
+			variables, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10
+		)
+	`, documentID, template.Type, input.Title, "DRAFT",
+		input.CreatorID, templateID, published.Version, 1, values, now)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate document: " + err.Error()})
+		return
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO documents.files (
+			id, document_id, file_key, file_name, content_type, size_bytes, sha256, uploaded_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		)
+	`, fileID, documentID, fileKey, fmt.Sprintf("%s-v%d.txt", template.Name, published.Version), renderedContentType,
+		int64(len(body)), evidence.HashBytes(body), now)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record generated document file: " + err.Error()})
+		return
+	}
+
+	// Store the rendered content once it is recorded; a failed upload rolls
+	// the records back
+	if err := s3Client.UploadFile(ctx, fileKey, bytes.NewReader(body), renderedContentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store generated document: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		// Don't leave an orphaned object behind
+		if delErr := s3Client.DeleteFile(context.Background(), fileKey); delErr != nil {
+			log.Printf("Warning: Failed to delete orphaned file %s: %v", fileKey, delErr)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate document: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":               documentID,
+		"file_id":          fileID,
+		"template_version": published.Version,
+		"message":          "Document generated successfully",
+	})
+}
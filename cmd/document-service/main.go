@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -11,7 +12,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/auth"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/aws"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/evidence"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/render"
 )
 
 // Document represents a document in the system
@@ -26,7 +33,9 @@ type Document struct {
 	UpdatedAt   time.Time  `json:"updated_at"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 	TemplateID  *string    `json:"template_id,omitempty"`
-	Version     int        `json:"version"`
+	// TemplateVersion is the published template version the document was generated from
+	TemplateVersion *int `json:"template_version,omitempty"`
+	Version         int  `json:"version"`
 }
 
 // File represents a file related to a document
@@ -37,6 +46,7 @@ type File struct {
 	FileName    string    `json:"file_name"`
 	ContentType string    `json:"content_type"`
 	SizeBytes   int64     `json:"size_bytes"`
+	SHA256      string    `json:"sha256,omitempty"`
 	UploadedAt  time.Time `json:"uploaded_at"`
 }
 
@@ -56,6 +66,12 @@ type Signatory struct {
 
 var db *pgxpool.Pool
 
+// s3Client stores document files
+var s3Client *aws.S3Client
+
+// sessionService validates the auth sessions of signers
+var sessionService *auth.SessionService
+
 func main() {
 	log.Println("Starting document-service...")
 
@@ -74,6 +90,25 @@ func main() {
 	}
 	log.Println("Connected to database")
 
+	// Ensure the document-service columns exist
+	if err := ensureDocumentSchema(db); err != nil {
+		log.Fatalf("Failed to ensure document schema: %v", err)
+	}
+
+	sessionService = auth.NewSessionService(db)
+
+	// Connect to S3 for document file storage
+	sess, err := aws.NewSession(getEnv("AWS_REGION", "us-east-1"), getEnv("AWS_ENDPOINT", ""))
+	if err != nil {
+		log.Fatalf("Unable to create AWS session: %v", err)
+	}
+	s3Client = aws.NewS3Client(sess, getEnv("DOCUMENTS_BUCKET", "trustainvest-documents"))
+
+	// Deliver signing invitations and reminders in the background
+	reminderCtx, stopReminders := context.WithCancel(context.Background())
+	defer stopReminders()
+	go remindPendingSignatures(reminderCtx)
+
 	// Set up Gin router
 	router := gin.Default()
 
@@ -100,8 +135,12 @@ func main() {
 			// Files
 			documents.GET("/:id/files", getDocumentFiles)
 			documents.POST("/:id/files", addDocumentFile)
+			documents.GET("/:id/files/:fileId/download", downloadDocumentFile)
 			documents.DELETE("/:id/files/:fileId", removeDocumentFile)
 
+			// PDF output
+			documents.GET("/:id/pdf", getDocumentPDF)
+
 			// Signatories
 			documents.GET("/:id/signatories", getDocumentSignatories)
 			documents.POST("/:id/signatories", addSignatory)
@@ -110,6 +149,8 @@ func main() {
 
 			// Signing
 			documents.POST("/:id/sign", signDocument)
+			documents.POST("/:id/send-for-signature", sendForSignature)
+			documents.GET("/:id/certificate", getDocumentCertificate)
 		}
 
 		// User documents
@@ -126,6 +167,13 @@ func main() {
 			templates.POST("", createTemplate)
 			templates.PUT("/:id", updateTemplate)
 
+			// Versions
+			templates.POST("/:id/publish", publishTemplate)
+			templates.GET("/:id/versions", getTemplateVersions)
+			templates.GET("/:id/versions/:version", getTemplateVersion)
+			templates.GET("/:id/diff", diffTemplate)
+			templates.POST("/:id/rollback", rollbackTemplate)
+
 			// Generate document from template
 			templates.POST("/:id/generate", generateDocument)
 		}
@@ -149,6 +197,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down document-service...")
+	stopReminders()
 
 	// Give the server 5 seconds to finish ongoing requests
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -165,7 +214,7 @@ func listDocuments(c *gin.Context) {
 	var documents []Document
 	rows, err := db.Query(context.Background(), `
 		SELECT id, type, title, description, status, creator_id,
-		       created_at, updated_at, expires_at, template_id, version
+		       created_at, updated_at, expires_at, template_id, template_version, version
 		FROM documents.documents
 		WHERE status != 'DELETED'
 		LIMIT 100
@@ -182,7 +231,7 @@ func listDocuments(c *gin.Context) {
 			&document.ID, &document.Type, &document.Title, &document.Description,
 			&document.Status, &document.CreatorID, &document.CreatedAt,
 			&document.UpdatedAt, &document.ExpiresAt, &document.TemplateID,
-			&document.Version,
+			&document.TemplateVersion, &document.Version,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan document data"})
@@ -200,14 +249,14 @@ func getDocumentByID(c *gin.Context) {
 
 	err := db.QueryRow(context.Background(), `
 		SELECT id, type, title, description, status, creator_id,
-		       created_at, updated_at, expires_at, template_id, version
+		       created_at, updated_at, expires_at, template_id, template_version, version
 		FROM documents.documents
 		WHERE id = $1 AND status != 'DELETED'
 	`, id).Scan(
 		&document.ID, &document.Type, &document.Title, &document.Description,
 		&document.Status, &document.CreatorID, &document.CreatedAt,
 		&document.UpdatedAt, &document.ExpiresAt, &document.TemplateID,
-		&document.Version,
+		&document.TemplateVersion, &document.Version,
 	)
 
 	if err != nil {
@@ -224,7 +273,7 @@ func getUserDocuments(c *gin.Context) {
 
 	rows, err := db.Query(context.Background(), `
 		SELECT id, type, title, description, status, creator_id,
-		       created_at, updated_at, expires_at, template_id, version
+		       created_at, updated_at, expires_at, template_id, template_version, version
 		FROM documents.documents
 		WHERE creator_id = $1 AND status != 'DELETED'
 	`, userID)
@@ -240,7 +289,7 @@ func getUserDocuments(c *gin.Context) {
 			&document.ID, &document.Type, &document.Title, &document.Description,
 			&document.Status, &document.CreatorID, &document.CreatedAt,
 			&document.UpdatedAt, &document.ExpiresAt, &document.TemplateID,
-			&document.Version,
+			&document.TemplateVersion, &document.Version,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan document data"})
@@ -379,7 +428,8 @@ func getDocumentFiles(c *gin.Context) {
 	var files []File
 
 	rows, err := db.Query(context.Background(), `
-		SELECT id, document_id, file_key, file_name, content_type, size_bytes, uploaded_at
+		SELECT id, document_id, file_key, file_name, content_type, size_bytes,
+		       COALESCE(sha256, ''), uploaded_at
 		FROM documents.files
 		WHERE document_id = $1
 	`, documentID)
@@ -393,7 +443,7 @@ func getDocumentFiles(c *gin.Context) {
 		var file File
 		err := rows.Scan(
 			&file.ID, &file.DocumentID, &file.FileKey, &file.FileName,
-			&file.ContentType, &file.SizeBytes, &file.UploadedAt,
+			&file.ContentType, &file.SizeBytes, &file.SHA256, &file.UploadedAt,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan file data"})
@@ -405,94 +455,205 @@ func getDocumentFiles(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"files": files})
 }
 
+// addDocumentFile accepts a multipart upload in the "file" field and streams
+// it to S3 as it is received. The content type is taken from the file's magic bytes, not from
+// the client. Files can only be added while the document is a draft; the
+// document is locked to record the file only once the upload is stored, and
+// the stored object is deleted if it cannot be recorded.
 func addDocumentFile(c *gin.Context) {
 	documentID := c.Param("id")
+	ctx := context.Background()
 
-	var input struct {
-		FileKey     string `json:"file_key" binding:"required"`
-		FileName    string `json:"file_name" binding:"required"`
-		ContentType string `json:"content_type" binding:"required"`
-		SizeBytes   int64  `json:"size_bytes" binding:"required"`
+	maxBytes := maxUploadBytes()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverheadBytes)
+
+	// Read the file part straight off the request so it streams to S3
+	// rather than being buffered by the multipart form parser
+	src, err := multipartFile(c.Request, "file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A file must be uploaded in the \"file\" field: " + err.Error()})
+		return
 	}
+	defer src.Close()
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	id := uuid.New().String()
+	fileName := sanitizeFileName(src.FileName())
+	fileKey := uploadedFileKey(documentID, id, fileName)
+
+	upload, err := uploadFile(ctx, fileKey, src, maxBytes)
+	if err != nil {
+		respondToUploadError(c, err)
 		return
 	}
 
-	// Check if document exists
-	var documentExists bool
-	err := db.QueryRow(context.Background(), `
-		SELECT EXISTS(SELECT 1 FROM documents.documents WHERE id = $1 AND status != 'DELETED')
-	`, documentID).Scan(&documentExists)
+	// Don't leave an orphaned object behind if the file is not recorded
+	recorded := false
+	defer func() {
+		if recorded {
+			return
+		}
+		if delErr := s3Client.DeleteFile(ctx, fileKey); delErr != nil {
+			log.Printf("Warning: Failed to delete orphaned file %s: %v", fileKey, delErr)
+		}
+	}()
 
-	if err != nil || !documentExists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Document not found"})
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add document file: " + err.Error()})
 		return
 	}
+	defer tx.Rollback(ctx)
 
-	id := uuid.New().String()
+	// Lock the document so it cannot be sent for signature while the file is recorded
+	if !lockDraftDocument(c, ctx, tx, documentID) {
+		return
+	}
 
-	_, err = db.Exec(context.Background(), `
+	now := time.Now()
+	_, err = tx.Exec(ctx, `
 		INSERT INTO documents.files (
-			id, document_id, file_key, file_name, content_type, size_bytes, uploaded_at
+			id, document_id, file_key, file_name, content_type, size_bytes, sha256, uploaded_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7
+			$1, $2, $3, $4, $5, $6, $7, $8
 		)
-	`, id, documentID, input.FileKey, input.FileName, input.ContentType,
-		input.SizeBytes, time.Now())
+	`, id, documentID, fileKey, fileName, upload.ContentType,
+		upload.SizeBytes, upload.SHA256, now)
+
+	if err == nil {
+		// Update document version and timestamp
+		_, err = tx.Exec(ctx, `
+			UPDATE documents.documents
+			SET updated_at = $1, version = version + 1
+			WHERE id = $2
+		`, now, documentID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add document file: " + err.Error()})
 		return
 	}
+	recorded = true
 
-	// Update document version and timestamp
-	_, err = db.Exec(context.Background(), `
-		UPDATE documents.documents
-		SET updated_at = $1, version = version + 1
-		WHERE id = $2
-	`, time.Now(), documentID)
+	c.JSON(http.StatusCreated, gin.H{
+		"id":           id,
+		"file_key":     fileKey,
+		"content_type": upload.ContentType,
+		"size_bytes":   upload.SizeBytes,
+		"sha256":       upload.SHA256,
+		"message":      "File added successfully",
+	})
+}
+
+// lockDraftDocument locks the document row within tx and writes an error
+// response unless the document is a draft. Files are signed as they stand
+// when the document leaves draft, so they cannot change afterwards.
+func lockDraftDocument(c *gin.Context, ctx context.Context, tx pgx.Tx, documentID string) bool {
+	var status string
+	err := tx.QueryRow(ctx, `
+		SELECT status FROM documents.documents WHERE id = $1 AND status != 'DELETED' FOR UPDATE
+	`, documentID).Scan(&status)
 
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return false
+	}
 	if err != nil {
-		log.Printf("Warning: Failed to update document version: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document: " + err.Error()})
+		return false
 	}
+	if status != DocumentStatusDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Files can only be changed while the document is a draft", "status": status})
+		return false
+	}
+	return true
+}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":      id,
-		"message": "File added successfully",
+// downloadDocumentFile returns a short-lived presigned URL for a file
+func downloadDocumentFile(c *gin.Context) {
+	documentID := c.Param("id")
+	fileID := c.Param("fileId")
+
+	var file File
+	err := db.QueryRow(context.Background(), `
+		SELECT f.file_key, f.file_name
+		FROM documents.files f
+		JOIN documents.documents d ON d.id = f.document_id
+		WHERE f.id = $1 AND f.document_id = $2 AND d.status != 'DELETED'
+	`, fileID, documentID).Scan(&file.FileKey, &file.FileName)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	ttl := downloadURLTTL()
+	url, err := s3Client.PresignDownloadURL(file.FileKey, file.FileName, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download URL: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"expires_at": time.Now().Add(ttl).UTC(),
 	})
 }
 
 func removeDocumentFile(c *gin.Context) {
 	documentID := c.Param("id")
 	fileID := c.Param("fileId")
+	ctx := context.Background()
 
-	result, err := db.Exec(context.Background(), `
-		DELETE FROM documents.files
-		WHERE id = $1 AND document_id = $2
-	`, fileID, documentID)
-
+	tx, err := db.Begin(ctx)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove file: " + err.Error()})
 		return
 	}
+	defer tx.Rollback(ctx)
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
+	if !lockDraftDocument(c, ctx, tx, documentID) {
+		return
+	}
+
+	var fileKey string
+	err = tx.QueryRow(ctx, `
+		DELETE FROM documents.files
+		WHERE id = $1 AND document_id = $2
+		RETURNING file_key
+	`, fileID, documentID).Scan(&fileKey)
+
+	if err == pgx.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove file: " + err.Error()})
+		return
+	}
 
 	// Update document version and timestamp
-	_, err = db.Exec(context.Background(), `
+	_, err = tx.Exec(ctx, `
 		UPDATE documents.documents
 		SET updated_at = $1, version = version + 1
 		WHERE id = $2
 	`, time.Now(), documentID)
-
 	if err != nil {
-		log.Printf("Warning: Failed to update document version: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document version: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove file: " + err.Error()})
+		return
+	}
+
+	// Delete the stored object only once the record is gone, so a file that
+	// is still listed is never missing. A failed delete leaves an orphan.
+	if err := s3Client.DeleteFile(ctx, fileKey); err != nil {
+		log.Printf("Warning: Failed to delete orphaned file %s: %v", fileKey, err)
 	}
 
 	c.Status(http.StatusNoContent)
@@ -676,8 +837,10 @@ func signDocument(c *gin.Context) {
 
 	var input struct {
 		SignatoryID string `json:"signatory_id" binding:"required"`
-		UserID      string `json:"user_id" binding:"required"`
+		UserID      string `json:"user_id"`
 		SignatureID string `json:"signature_id"`
+		// InviteToken authenticates signatories without an auth session
+		InviteToken string `json:"invite_token"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -685,27 +848,105 @@ func signDocument(c *gin.Context) {
 		return
 	}
 
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign document: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the document so concurrent signatures are checked in turn
+	var status string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM documents.documents WHERE id = $1 AND status != 'DELETED' FOR UPDATE
+	`, documentID).Scan(&status)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	// Only documents sent for signature can be signed
+	if status != DocumentStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Document is not pending signature", "status": status})
+		return
+	}
+
+	// Hash the content being signed under the lock, so it cannot change
+	// before the signature is recorded
+	_, contentHash, err := documentContent(ctx, tx, documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash document content: " + err.Error()})
+		return
+	}
+
 	// Verify the signatory exists and is associated with this document
-	var signatoryExists bool
-	err := db.QueryRow(context.Background(), `
-		SELECT EXISTS(
-			SELECT 1 FROM documents.signatories 
-			WHERE id = $1 AND document_id = $2 AND signed_at IS NULL
-		)
-	`, input.SignatoryID, documentID).Scan(&signatoryExists)
+	var name string
+	var signatory signatoryCredentials
+	err = tx.QueryRow(ctx, `
+		SELECT name, email, COALESCE(user_id::text, ''), COALESCE(invite_token_hash, ''), invite_expires_at
+		FROM documents.signatories 
+		WHERE id = $1 AND document_id = $2 AND signed_at IS NULL
+	`, input.SignatoryID, documentID).Scan(
+		&name, &signatory.Email, &signatory.UserID, &signatory.InviteTokenHash, &signatory.InviteExpiresAt,
+	)
 
-	if err != nil || !signatoryExists {
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Signatory not found or already signed"})
 		return
 	}
 
-	// Record the signature
+	signer, err := authenticateSigner(c, ctx, signatory, input.UserID, input.InviteToken)
+	if err != nil {
+		respondToSignerAuthError(c, err)
+		return
+	}
+
+	// Signatories sign in order
+	var orderErr *SigningOrderError
+	err = checkSigningTurn(ctx, tx, documentID, input.SignatoryID)
+	if errors.As(err, &orderErr) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "It is not yet this signatory's turn to sign",
+			"waiting": orderErr.Waiting,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check signing order: " + err.Error()})
+		return
+	}
+
+	// Record the signature evidence in the document's signature chain
 	now := time.Now()
-	_, err = db.Exec(context.Background(), `
+	event := &evidence.Event{
+		ID:          uuid.New().String(),
+		DocumentID:  documentID,
+		SignatoryID: input.SignatoryID,
+		Type:        evidence.EventSigned,
+		ContentHash: contentHash,
+		SignerName:  name,
+		SignerEmail: signatory.Email,
+		UserID:      signer.UserID,
+		SessionID:   signer.SessionID,
+		AuthMethod:  signer.AuthMethod,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		OccurredAt:  now.UTC().Truncate(time.Microsecond),
+	}
+	if err := recordSignatureEvent(ctx, tx, event); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record signature evidence: " + err.Error()})
+		return
+	}
+
+	// The signature ID defaults to the evidence event
+	_, err = tx.Exec(ctx, `
 		UPDATE documents.signatories
-		SET signed_at = $1, signature_id = $2
-		WHERE id = $3 AND document_id = $4 AND signed_at IS NULL
-	`, now, input.SignatureID, input.SignatoryID, documentID)
+		SET signed_at = $1, signature_id = COALESCE(NULLIF($2, ''), $3)
+		WHERE id = $4 AND document_id = $5 AND signed_at IS NULL
+	`, event.OccurredAt, input.SignatureID, event.ID, input.SignatoryID, documentID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign document: " + err.Error()})
@@ -714,7 +955,7 @@ func signDocument(c *gin.Context) {
 
 	// Check if all required signatories have signed
 	var allSigned bool
-	err = db.QueryRow(context.Background(), `
+	err = tx.QueryRow(ctx, `
 		SELECT NOT EXISTS(
 			SELECT 1 FROM documents.signatories
 			WHERE document_id = $1 AND required = true AND signed_at IS NULL
@@ -725,18 +966,35 @@ func signDocument(c *gin.Context) {
 		log.Printf("Error checking if all signatories have signed: %v", err)
 	} else if allSigned {
 		// If all required signatories have signed, update the document status
-		_, err := db.Exec(context.Background(), `
+		_, err := tx.Exec(ctx, `
 			UPDATE documents.documents
-			SET status = 'SIGNED', updated_at = $1
-			WHERE id = $2 AND status = 'PENDING'
-		`, now, documentID)
+			SET status = $1, updated_at = $2
+			WHERE id = $3 AND status = $4
+		`, DocumentStatusSigned, now, documentID, DocumentStatusPending)
 
 		if err != nil {
 			log.Printf("Error updating document status to SIGNED: %v", err)
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Document signed successfully"})
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign document: " + err.Error()})
+		return
+	}
+
+	// Invite whoever signs next
+	invited := 0
+	if !allSigned {
+		invited = inviteNextSignatories(ctx, documentID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Document signed successfully",
+		"event_id":     event.ID,
+		"event_hash":   event.Hash,
+		"content_hash": contentHash,
+		"invited":      invited,
+	})
 }
 
 // Templates handlers
@@ -793,27 +1051,28 @@ func getTemplateByID(c *gin.Context) {
 	id := c.Param("id")
 
 	var template struct {
-		ID          string    `json:"id"`
-		Name        string    `json:"name"`
-		Description string    `json:"description,omitempty"`
-		Type        string    `json:"type"`
-		Content     string    `json:"content"`
-		Variables   []string  `json:"variables"`
-		CreatedAt   time.Time `json:"created_at"`
-		UpdatedAt   time.Time `json:"updated_at"`
-		Version     int       `json:"version"`
-		IsActive    bool      `json:"is_active"`
+		ID             string        `json:"id"`
+		Name           string        `json:"name"`
+		Description    string        `json:"description,omitempty"`
+		Type           string        `json:"type"`
+		Content        string        `json:"content"`
+		Variables      []string      `json:"variables"`
+		VariableSchema render.Schema `json:"variable_schema,omitempty"`
+		CreatedAt      time.Time     `json:"created_at"`
+		UpdatedAt      time.Time     `json:"updated_at"`
+		Version        int           `json:"version"`
+		IsActive       bool          `json:"is_active"`
 	}
 
 	err := db.QueryRow(context.Background(), `
-		SELECT id, name, description, type, content, variables, 
+		SELECT id, name, description, type, content, variables, variable_schema,
 		       created_at, updated_at, version, is_active
 		FROM documents.templates
 		WHERE id = $1 AND is_active = true
 	`, id).Scan(
 		&template.ID, &template.Name, &template.Description, &template.Type,
-		&template.Content, &template.Variables, &template.CreatedAt,
-		&template.UpdatedAt, &template.Version, &template.IsActive,
+		&template.Content, &template.Variables, &template.VariableSchema,
+		&template.CreatedAt, &template.UpdatedAt, &template.Version, &template.IsActive,
 	)
 
 	if err != nil {
@@ -826,11 +1085,13 @@ func getTemplateByID(c *gin.Context) {
 
 func createTemplate(c *gin.Context) {
 	var input struct {
-		Name        string   `json:"name" binding:"required"`
-		Description string   `json:"description"`
-		Type        string   `json:"type" binding:"required"`
-		Content     string   `json:"content" binding:"required"`
-		Variables   []string `json:"variables"`
+		Name           string        `json:"name" binding:"required"`
+		Description    string        `json:"description"`
+		Type           string        `json:"type" binding:"required"`
+		Content        string        `json:"content" binding:"required"`
+		Variables      []string      `json:"variables"`
+		VariableSchema render.Schema `json:"variable_schema"`
+		CreatedBy      string        `json:"created_by"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -838,22 +1099,56 @@ func createTemplate(c *gin.Context) {
 		return
 	}
 
+	variables, err := checkTemplateDefinition(input.Content, input.Variables, input.VariableSchema)
+	if err != nil {
+		respondToTemplateError(c, err)
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
 	id := uuid.New().String()
 
-	_, err := db.Exec(context.Background(), `
+	_, err = tx.Exec(ctx, `
 		INSERT INTO documents.templates (
-			id, name, description, type, content, variables, version, is_active
+			id, name, description, type, content, variables, variable_schema, version, is_active
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9
 		)
 	`, id, input.Name, input.Description, input.Type, input.Content,
-		input.Variables, 1, true)
+		variables, input.VariableSchema, 0, true)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template: " + err.Error()})
 		return
 	}
 
+	// The initial content is published as version 1
+	err = insertTemplateVersion(ctx, tx, &TemplateVersion{
+		TemplateID:     id,
+		Version:        1,
+		Content:        input.Content,
+		Variables:      variables,
+		VariableSchema: input.VariableSchema,
+		Notes:          "Initial version",
+		PublishedBy:    input.CreatedBy,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish template: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template: " + err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"id":      id,
 		"message": "Template created successfully",
@@ -864,11 +1159,12 @@ func updateTemplate(c *gin.Context) {
 	id := c.Param("id")
 
 	var input struct {
-		Name        string   `json:"name"`
-		Description string   `json:"description"`
-		Content     string   `json:"content"`
-		Variables   []string `json:"variables"`
-		IsActive    *bool    `json:"is_active"`
+		Name           string        `json:"name"`
+		Description    string        `json:"description"`
+		Content        string        `json:"content"`
+		Variables      []string      `json:"variables"`
+		VariableSchema render.Schema `json:"variable_schema"`
+		IsActive       *bool         `json:"is_active"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -876,18 +1172,38 @@ func updateTemplate(c *gin.Context) {
 		return
 	}
 
-	// Check if template exists
-	var exists bool
+	// Load the current definition so the updated template can be checked as a whole
+	var current struct {
+		Content        string
+		Variables      []string
+		VariableSchema render.Schema
+	}
 	err := db.QueryRow(context.Background(), `
-		SELECT EXISTS(SELECT 1 FROM documents.templates WHERE id = $1)
-	`, id).Scan(&exists)
+		SELECT content, variables, variable_schema FROM documents.templates WHERE id = $1
+	`, id).Scan(&current.Content, &current.Variables, &current.VariableSchema)
 
-	if err != nil || !exists {
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 		return
 	}
 
-	// Update template
+	if input.Content == "" {
+		input.Content = current.Content
+	}
+	if input.VariableSchema == nil {
+		input.VariableSchema = current.VariableSchema
+		if input.Variables == nil {
+			input.Variables = current.Variables
+		}
+	}
+
+	input.Variables, err = checkTemplateDefinition(input.Content, input.Variables, input.VariableSchema)
+	if err != nil {
+		respondToTemplateError(c, err)
+		return
+	}
+
+	// Update the template's draft; the draft is published separately
 	query := `
 		UPDATE documents.templates
 		SET 
@@ -895,22 +1211,22 @@ func updateTemplate(c *gin.Context) {
 			description = COALESCE(NULLIF($2, ''), description),
 			content = COALESCE(NULLIF($3, ''), content),
 			variables = COALESCE($4, variables),
-			updated_at = $5,
-			version = version + 1
+			variable_schema = $5,
+			updated_at = $6
 	`
 
 	args := []interface{}{
 		input.Name, input.Description, input.Content,
-		input.Variables, time.Now(),
+		input.Variables, input.VariableSchema, time.Now(),
 	}
 
 	if input.IsActive != nil {
-		query += `, is_active = $6
-			WHERE id = $7`
+		query += `, is_active = $7
+			WHERE id = $8`
 		args = append(args, *input.IsActive, id)
 	} else {
 		query += `
-			WHERE id = $6`
+			WHERE id = $7`
 		args = append(args, id)
 	}
 
@@ -921,60 +1237,219 @@ func updateTemplate(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Template updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Template draft updated successfully"})
 }
 
-func generateDocument(c *gin.Context) {
-	templateID := c.Param("id")
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
 
-	var input struct {
-		Title     string                 `json:"title" binding:"required"`
-		CreatorID string                 `json:"creator_id" binding:"required"`
-		Variables map[string]interface{} `json:"variables"`
+// ensureDocumentSchema ensures the columns added by document-service exist
+func ensureDocumentSchema(db *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	// Templates declare the variables they accept
+	_, err := db.Exec(ctx, `
+		ALTER TABLE IF EXISTS documents.templates
+			ADD COLUMN IF NOT EXISTS variable_schema JSONB
+	`)
+	if err != nil {
+		return err
 	}
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	// Generated documents keep the variables they were rendered with
+	_, err = db.Exec(ctx, `
+		ALTER TABLE IF EXISTS documents.documents
+			ADD COLUMN IF NOT EXISTS variables JSONB
+	`)
+	if err != nil {
+		return err
 	}
 
-	// Get template
-	var template struct {
-		ID      string
-		Type    string
-		Content string
+	// Documents record the template version they were generated from
+	_, err = db.Exec(ctx, `
+		ALTER TABLE IF EXISTS documents.documents
+			ADD COLUMN IF NOT EXISTS template_version INTEGER
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the template_versions table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS documents.template_versions (
+			id UUID PRIMARY KEY,
+			template_id UUID NOT NULL,
+			version INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			variables TEXT[],
+			variable_schema JSONB,
+			notes TEXT,
+			published_by VARCHAR(255),
+			published_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			UNIQUE (template_id, version)
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	err := db.QueryRow(context.Background(), `
-		SELECT id, type, content
-		FROM documents.templates
-		WHERE id = $1 AND is_active = true
-	`, templateID).Scan(&template.ID, &template.Type, &template.Content)
+	// Published versions are immutable
+	_, err = db.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION documents.reject_template_version_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION 'documents.template_versions is append-only';
+		END;
+		$$ LANGUAGE plpgsql
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'template_versions_append_only'
+			) THEN
+				CREATE TRIGGER template_versions_append_only
+				BEFORE UPDATE OR DELETE ON documents.template_versions
+				FOR EACH ROW EXECUTE FUNCTION documents.reject_template_version_change();
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return err
+	}
 
+	// Templates created before versioning keep their content as their current version
+	_, err = db.Exec(ctx, `
+		INSERT INTO documents.template_versions (
+			id, template_id, version, content, variables, variable_schema, notes, published_at
+		)
+		SELECT gen_random_uuid(), t.id, t.version, t.content, t.variables,
+		       t.variable_schema, 'Imported from unversioned template', t.updated_at
+		FROM documents.templates t
+		WHERE t.version > 0 AND NOT EXISTS (
+			SELECT 1 FROM documents.template_versions v WHERE v.template_id = t.id
+		)
+	`)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
-		return
+		return err
 	}
 
-	// Create document from template
-	documentID := uuid.New().String()
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_template_versions_template_id ON documents.template_versions(template_id)")
+	if err != nil {
+		return err
+	}
 
-	_, err = db.Exec(context.Background(), `
-		INSERT INTO documents.documents (
-			id, type, title, status, creator_id, template_id, version
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7
+	// Signatories track their invitation and reminders
+	_, err = db.Exec(ctx, `
+		ALTER TABLE IF EXISTS documents.signatories
+			ADD COLUMN IF NOT EXISTS invite_token VARCHAR(64),
+			ADD COLUMN IF NOT EXISTS invite_token_hash VARCHAR(64),
+			ADD COLUMN IF NOT EXISTS invite_expires_at TIMESTAMP WITH TIME ZONE,
+			ADD COLUMN IF NOT EXISTS invited_at TIMESTAMP WITH TIME ZONE,
+			ADD COLUMN IF NOT EXISTS last_reminded_at TIMESTAMP WITH TIME ZONE
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Invite tokens are kept only as hashes. Tokens stored before then are
+	// hashed and expire with their document, or 30 days after they were sent.
+	_, err = db.Exec(ctx, `
+		UPDATE documents.signatories s
+		SET invite_token_hash = encode(sha256(convert_to(s.invite_token, 'UTF8')), 'hex'),
+		    invite_expires_at = COALESCE(d.expires_at, s.invited_at + INTERVAL '30 days', NOW()),
+		    invite_token = NULL
+		FROM documents.documents d
+		WHERE d.id = s.document_id AND s.invite_token IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the signature_events table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS documents.signature_events (
+			id UUID PRIMARY KEY,
+			document_id UUID NOT NULL,
+			sequence INTEGER NOT NULL,
+			signatory_id UUID NOT NULL,
+			event_type VARCHAR(50) NOT NULL,
+			content_hash VARCHAR(64) NOT NULL,
+			signer_name VARCHAR(255) NOT NULL,
+			signer_email VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255),
+			session_id VARCHAR(255),
+			auth_method VARCHAR(50) NOT NULL,
+			ip_address VARCHAR(45) NOT NULL,
+			user_agent TEXT NOT NULL,
+			occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			previous_hash VARCHAR(64) NOT NULL,
+			event_hash VARCHAR(64) NOT NULL,
+			UNIQUE (document_id, sequence)
 		)
-	`, documentID, template.Type, input.Title, "DRAFT",
-		input.CreatorID, templateID, 1)
+	`)
+	if err != nil {
+		return err
+	}
 
+	// Signature evidence is append-only
+	_, err = db.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION documents.reject_signature_event_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION 'documents.signature_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql
+	`)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate document: " + err.Error()})
-		return
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'signature_events_append_only'
+			) THEN
+				CREATE TRIGGER signature_events_append_only
+				BEFORE UPDATE OR DELETE ON documents.signature_events
+				FOR EACH ROW EXECUTE FUNCTION documents.reject_signature_event_change();
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return err
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":      documentID,
-		"message": "Document generated successfully",
-	})
+	// Files record the SHA-256 of their stored content
+	_, err = db.Exec(ctx, `
+		ALTER TABLE IF EXISTS documents.files
+			ADD COLUMN IF NOT EXISTS sha256 VARCHAR(64)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Derived files such as PDFs record a hash of the inputs they were rendered from
+	_, err = db.Exec(ctx, `
+		ALTER TABLE IF EXISTS documents.files
+			ADD COLUMN IF NOT EXISTS source_hash TEXT
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/evidence"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/pdf"
+)
+
+// pdfContentType is the content type of PDF document files
+const pdfContentType = "application/pdf"
+
+// unsafeFileNameChars matches characters replaced in generated file names
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// pdfFileKey returns the S3 key for a document's PDF
+func pdfFileKey(documentID, fileID string) string {
+	return fmt.Sprintf("documents/%s/pdf/%s.pdf", documentID, fileID)
+}
+
+// pdfFileName returns the download name of a document's PDF
+func pdfFileName(title string, version int) string {
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(title, "-"), "-")
+	if name == "" {
+		name = "document"
+	}
+	return fmt.Sprintf("%s-v%d.pdf", name, version)
+}
+
+// pdfSourceHash identifies the inputs a PDF is rendered from, so an existing
+// PDF is reused until the content, title or signatures change
+func pdfSourceHash(doc pdf.Document, contentFileID string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", contentFileID, doc.Title, doc.Subtitle)
+	for _, s := range doc.Signatories {
+		signedAt := ""
+		if s.SignedAt != nil {
+			signedAt = s.SignedAt.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%t\x00%s\x00", s.Name, s.Email, s.Role, s.Required, signedAt)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadPDFSignatories returns the document's signatories in signing order
+func loadPDFSignatories(ctx context.Context, documentID string) ([]pdf.Signatory, error) {
+	rows, err := db.Query(ctx, `
+		SELECT name, email, role, required, signed_at
+		FROM documents.signatories
+		WHERE document_id = $1
+		ORDER BY "order" ASC
+	`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var signatories []pdf.Signatory
+	for rows.Next() {
+		var s pdf.Signatory
+		if err := rows.Scan(&s.Name, &s.Email, &s.Role, &s.Required, &s.SignedAt); err != nil {
+			return nil, err
+		}
+		signatories = append(signatories, s)
+	}
+	return signatories, rows.Err()
+}
+
+// getDocumentPDF returns the document as a PDF. The PDF is rendered from the
+// latest rendered content and stored as a document file; it is re-rendered
+// only when the content, title or signatures have changed since.
+func getDocumentPDF(c *gin.Context) {
+	documentID := c.Param("id")
+	ctx := context.Background()
+
+	var document struct {
+		Title   string
+		Type    string
+		Status  string
+		Version int
+	}
+	err := db.QueryRow(ctx, `
+		SELECT title, type, status, version
+		FROM documents.documents
+		WHERE id = $1 AND status != 'DELETED'
+	`, documentID).Scan(&document.Title, &document.Type, &document.Status, &document.Version)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	// The PDF is laid out from the most recent rendered template output,
+	// which is stored under its own key prefix unlike uploaded text files
+	var content File
+	err = db.QueryRow(ctx, `
+		SELECT id, file_key
+		FROM documents.files
+		WHERE document_id = $1 AND content_type = $2 AND file_key LIKE $3 || '%'
+		ORDER BY uploaded_at DESC
+		LIMIT 1
+	`, documentID, renderedContentType, generatedFileKeyPrefix(documentID)).Scan(&content.ID, &content.FileKey)
+
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document has no rendered content"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document content: " + err.Error()})
+		return
+	}
+
+	signatories, err := loadPDFSignatories(ctx, documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve signatories: " + err.Error()})
+		return
+	}
+
+	doc := pdf.Document{
+		Title:       document.Title,
+		Subtitle:    fmt.Sprintf("%s - version %d - %s", document.Type, document.Version, document.Status),
+		Signatories: signatories,
+	}
+	sourceHash := pdfSourceHash(doc, content.ID)
+	fileName := pdfFileName(document.Title, document.Version)
+
+	// Reuse the stored PDF when nothing it depends on has changed
+	var existingKey string
+	err = db.QueryRow(ctx, `
+		SELECT file_key
+		FROM documents.files
+		WHERE document_id = $1 AND content_type = $2 AND source_hash = $3
+		ORDER BY uploaded_at DESC
+		LIMIT 1
+	`, documentID, pdfContentType, sourceHash).Scan(&existingKey)
+
+	if err == nil {
+		data, err := s3Client.DownloadFile(ctx, existingKey)
+		if err == nil {
+			servePDF(c, fileName, data)
+			return
+		}
+		// Fall through and render a replacement if the stored object is unavailable
+	} else if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document files: " + err.Error()})
+		return
+	}
+
+	body, err := s3Client.DownloadFile(ctx, content.FileKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve document content: " + err.Error()})
+		return
+	}
+	doc.Body = string(body)
+
+	data, err := pdf.Render(doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render PDF: " + err.Error()})
+		return
+	}
+
+	fileID := uuid.New().String()
+	fileKey := pdfFileKey(documentID, fileID)
+
+	if err := s3Client.UploadFile(ctx, fileKey, bytes.NewReader(data), pdfContentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store PDF: " + err.Error()})
+		return
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO documents.files (
+			id, document_id, file_key, file_name, content_type, size_bytes, sha256, uploaded_at, source_hash
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9
+		)
+	`, fileID, documentID, fileKey, fileName, pdfContentType, int64(len(data)),
+		evidence.HashBytes(data), time.Now(), sourceHash)
+
+	if err != nil {
+		// Don't leave an orphaned object behind
+		if delErr := s3Client.DeleteFile(context.Background(), fileKey); delErr != nil {
+			log.Printf("Warning: Failed to delete orphaned file %s: %v", fileKey, delErr)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record PDF file: " + err.Error()})
+		return
+	}
+
+	servePDF(c, fileName, data)
+}
+
+// servePDF writes PDF bytes as an inline download
+func servePDF(c *gin.Context, fileName string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, fileName))
+	c.Data(http.StatusOK, pdfContentType, data)
+}
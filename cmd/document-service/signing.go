@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+)
+
+// Document statuses used by the signing workflow
+const (
+	DocumentStatusDraft   = "DRAFT"
+	DocumentStatusPending = "PENDING"
+	DocumentStatusSigned  = "SIGNED"
+)
+
+// Email types sent through notification-service
+const (
+	emailTypeSigningInvite   = "SIGNING_INVITE"
+	emailTypeSigningReminder = "SIGNING_REMINDER"
+)
+
+// signingLinkPlaceholder stands in for the signing link in email bodies.
+// notification-service fills it in when sending and stores the body with the
+// placeholder, so invite tokens are never kept with sent emails.
+const signingLinkPlaceholder = "signing_link"
+
+// notificationClient posts to the notification-service email channel
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// pendingSignatory is a signatory waiting for an invitation or reminder
+type pendingSignatory struct {
+	ID            string
+	DocumentID    string
+	DocumentTitle string
+	UserID        string
+	Name          string
+	Email         string
+	ExpiresAt     *time.Time
+}
+
+// SigningOrderError is returned when a signatory signs before their turn
+type SigningOrderError struct {
+	Waiting []string
+}
+
+func (e *SigningOrderError) Error() string {
+	return fmt.Sprintf("waiting for earlier signatories: %v", e.Waiting)
+}
+
+// checkSigningTurn verifies that every required signatory ordered before the
+// given one has signed. Signatories sharing an order may sign in any order.
+func checkSigningTurn(ctx context.Context, tx pgx.Tx, documentID, signatoryID string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT s.name
+		FROM documents.signatories s, documents.signatories me
+		WHERE me.id = $1 AND me.document_id = $2
+		  AND s.document_id = me.document_id
+		  AND s.required = true
+		  AND s.signed_at IS NULL
+		  AND s."order" < me."order"
+		ORDER BY s."order"
+	`, signatoryID, documentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var waiting []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		waiting = append(waiting, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(waiting) > 0 {
+		return &SigningOrderError{Waiting: waiting}
+	}
+	return nil
+}
+
+// nextSignatories returns the uninvited signatories whose turn it is: the
+// unsigned signatories with the lowest order, once every required signatory
+// before them has signed.
+func nextSignatories(ctx context.Context, documentID string) ([]pendingSignatory, error) {
+	rows, err := db.Query(ctx, `
+		SELECT s.id, s.document_id, d.title, COALESCE(s.user_id::text, ''), s.name, s.email,
+		       d.expires_at
+		FROM documents.signatories s
+		JOIN documents.documents d ON d.id = s.document_id
+		WHERE s.document_id = $1
+		  AND d.status = 'PENDING'
+		  AND s.signed_at IS NULL
+		  AND s.invited_at IS NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM documents.signatories earlier
+			WHERE earlier.document_id = s.document_id
+			  AND earlier.required = true
+			  AND earlier.signed_at IS NULL
+			  AND earlier."order" < s."order"
+		  )
+		ORDER BY s."order"
+	`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPendingSignatories(rows)
+}
+
+func scanPendingSignatories(rows pgx.Rows) ([]pendingSignatory, error) {
+	var signatories []pendingSignatory
+	for rows.Next() {
+		var s pendingSignatory
+		err := rows.Scan(
+			&s.ID, &s.DocumentID, &s.DocumentTitle, &s.UserID, &s.Name, &s.Email, &s.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		signatories = append(signatories, s)
+	}
+	return signatories, rows.Err()
+}
+
+// inviteNextSignatories emails an invitation to each signatory whose turn it
+// is. Failures are logged; the reminder loop retries uninvited signatories.
+func inviteNextSignatories(ctx context.Context, documentID string) int {
+	signatories, err := nextSignatories(ctx, documentID)
+	if err != nil {
+		log.Printf("Error finding next signatories for document %s: %v", documentID, err)
+		return 0
+	}
+
+	invited := 0
+	for _, s := range signatories {
+		if err := inviteSignatory(ctx, s); err != nil {
+			log.Printf("Error inviting signatory %s to document %s: %v", s.ID, documentID, err)
+			continue
+		}
+		invited++
+	}
+	return invited
+}
+
+// inviteSignatory sends a signing invitation and records when it was sent
+func inviteSignatory(ctx context.Context, s pendingSignatory) error {
+	token, err := newInviteToken()
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hello %s,\n\nYou have been asked to sign \"%s\". It is now your turn to sign.\n\nReview and sign the document here:\n{{%s}}\n",
+		s.Name, s.DocumentTitle, signingLinkPlaceholder,
+	)
+	if s.ExpiresAt != nil {
+		body += fmt.Sprintf("\nPlease sign before %s.\n", s.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"))
+	}
+
+	subject := fmt.Sprintf("Signature requested: %s", s.DocumentTitle)
+	if err := sendNotificationEmail(ctx, s, emailTypeSigningInvite, subject, body, signingLink(s, token)); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = db.Exec(ctx, `
+		UPDATE documents.signatories
+		SET invite_token_hash = $1, invite_expires_at = $2, invited_at = $3
+		WHERE id = $4
+	`, hashInviteToken(token), inviteExpiry(s, now), now, s.ID)
+	return err
+}
+
+// remindSignatory sends a reminder for a pending signature. Only a hash of
+// the invite token is kept, so the reminder carries a new token that replaces
+// the one sent before.
+func remindSignatory(ctx context.Context, s pendingSignatory) error {
+	token, err := newInviteToken()
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hello %s,\n\nThis is a reminder that \"%s\" is still waiting for your signature.\n\nReview and sign the document here:\n{{%s}}\n",
+		s.Name, s.DocumentTitle, signingLinkPlaceholder,
+	)
+	if s.ExpiresAt != nil {
+		body += fmt.Sprintf("\nThe signing request expires on %s.\n", s.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"))
+	}
+
+	subject := fmt.Sprintf("Reminder: signature requested for %s", s.DocumentTitle)
+	if err := sendNotificationEmail(ctx, s, emailTypeSigningReminder, subject, body, signingLink(s, token)); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = db.Exec(ctx, `
+		UPDATE documents.signatories
+		SET invite_token_hash = $1, invite_expires_at = $2, last_reminded_at = $3
+		WHERE id = $4
+	`, hashInviteToken(token), inviteExpiry(s, now), now, s.ID)
+	return err
+}
+
+// inviteExpiry returns when an invite token sent at now stops being accepted:
+// when the document expires, or after SIGNING_INVITE_TTL for documents that
+// do not expire
+func inviteExpiry(s pendingSignatory, now time.Time) time.Time {
+	if s.ExpiresAt != nil {
+		return *s.ExpiresAt
+	}
+	return now.Add(durationEnv("SIGNING_INVITE_TTL", 30*24*time.Hour))
+}
+
+// signingLink builds the invite link a signatory follows to sign
+func signingLink(s pendingSignatory, token string) string {
+	query := url.Values{}
+	query.Set("document", s.DocumentID)
+	query.Set("signatory", s.ID)
+	query.Set("token", token)
+	return getEnv("SIGNING_URL_BASE", "http://localhost:3000/sign") + "?" + query.Encode()
+}
+
+// newInviteToken returns a random token identifying a signing invitation
+func newInviteToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate invite token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashInviteToken returns the hash an invite token is stored and checked as
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendNotificationEmail sends an email through the notification-service email
+// channel. The body refers to the signing link by its placeholder.
+func sendNotificationEmail(ctx context.Context, s pendingSignatory, emailType, subject, body, link string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"to":            s.Email,
+		"user_id":       s.UserID,
+		"type":          emailType,
+		"subject":       subject,
+		"body":          body,
+		"secrets":       map[string]string{signingLinkPlaceholder: link},
+		"transactional": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := getEnv("NOTIFICATION_SERVICE_URL", "http://notification-service:8080") + "/api/v1/emails"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach notification-service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification-service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendForSignature moves a draft document to PENDING and invites the first signatories
+func sendForSignature(c *gin.Context) {
+	documentID := c.Param("id")
+	ctx := context.Background()
+
+	var signatoryCount int
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM documents.signatories WHERE document_id = $1
+	`, documentID).Scan(&signatoryCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve signatories: " + err.Error()})
+		return
+	}
+	if signatoryCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Document has no signatories"})
+		return
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE documents.documents
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`, DocumentStatusPending, time.Now(), documentID, DocumentStatusDraft)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send document for signature: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft documents can be sent for signature"})
+		return
+	}
+
+	invited := inviteNextSignatories(ctx, documentID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document sent for signature",
+		"invited": invited,
+	})
+}
+
+// remindPendingSignatures periodically delivers outstanding invitations and
+// reminds invited signatories whose document expires soon. The loop interval,
+// the window before expiry and the minimum gap between reminders are set by
+// SIGNING_REMINDER_INTERVAL, SIGNING_REMINDER_WINDOW and SIGNING_REMINDER_FREQUENCY.
+func remindPendingSignatures(ctx context.Context) {
+	interval := durationEnv("SIGNING_REMINDER_INTERVAL", time.Hour)
+	window := durationEnv("SIGNING_REMINDER_WINDOW", 72*time.Hour)
+	frequency := durationEnv("SIGNING_REMINDER_FREQUENCY", 24*time.Hour)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Signing reminders running every %s", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processSigningReminders(ctx, window, frequency)
+		}
+	}
+}
+
+// processSigningReminders runs one pass of the reminder loop
+func processSigningReminders(ctx context.Context, window, frequency time.Duration) {
+	// Retry invitations that could not be delivered when the turn started
+	rows, err := db.Query(ctx, `
+		SELECT DISTINCT s.document_id
+		FROM documents.signatories s
+		JOIN documents.documents d ON d.id = s.document_id
+		WHERE d.status = 'PENDING' AND s.signed_at IS NULL AND s.invited_at IS NULL
+		  AND (d.expires_at IS NULL OR d.expires_at > NOW())
+	`)
+	if err != nil {
+		log.Printf("Error finding documents awaiting invitations: %v", err)
+		return
+	}
+	var documentIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("Error scanning document awaiting invitations: %v", err)
+			return
+		}
+		documentIDs = append(documentIDs, id)
+	}
+	rows.Close()
+
+	for _, id := range documentIDs {
+		inviteNextSignatories(ctx, id)
+	}
+
+	// Remind invited signatories whose document expires within the window
+	now := time.Now()
+	rows, err = db.Query(ctx, `
+		SELECT s.id, s.document_id, d.title, COALESCE(s.user_id::text, ''), s.name, s.email,
+		       d.expires_at
+		FROM documents.signatories s
+		JOIN documents.documents d ON d.id = s.document_id
+		WHERE d.status = 'PENDING'
+		  AND d.expires_at > $1 AND d.expires_at <= $2
+		  AND s.signed_at IS NULL
+		  AND s.invited_at IS NOT NULL
+		  AND (s.last_reminded_at IS NULL OR s.last_reminded_at <= $3)
+		ORDER BY d.expires_at, s."order"
+	`, now, now.Add(window), now.Add(-frequency))
+	if err != nil {
+		log.Printf("Error finding signatories to remind: %v", err)
+		return
+	}
+	signatories, err := scanPendingSignatories(rows)
+	rows.Close()
+	if err != nil {
+		log.Printf("Error scanning signatories to remind: %v", err)
+		return
+	}
+
+	for _, s := range signatories {
+		if err := remindSignatory(ctx, s); err != nil {
+			log.Printf("Error reminding signatory %s for document %s: %v", s.ID, s.DocumentID, err)
+		}
+	}
+}
+
+// durationEnv reads a duration environment variable such as "30m"
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
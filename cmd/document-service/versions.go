@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/diff"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/documents/render"
+)
+
+// draftVersion names a template's working draft in diff requests
+const draftVersion = "draft"
+
+// diffContextLines is the number of unchanged lines around each diff hunk
+const diffContextLines = 3
+
+// TemplateVersion is an immutable published version of a template.
+// documents.templates holds the editable draft and the latest published
+// version number; every publish appends a row to documents.template_versions.
+type TemplateVersion struct {
+	ID             string        `json:"id"`
+	TemplateID     string        `json:"template_id"`
+	Version        int           `json:"version"`
+	Content        string        `json:"content,omitempty"`
+	Variables      []string      `json:"variables,omitempty"`
+	VariableSchema render.Schema `json:"variable_schema,omitempty"`
+	Notes          string        `json:"notes,omitempty"`
+	PublishedBy    string        `json:"published_by,omitempty"`
+	PublishedAt    time.Time     `json:"published_at"`
+}
+
+// errTemplateNotFound is returned when a template does not exist or is inactive
+var errTemplateNotFound = errors.New("template not found")
+
+// errTemplateVersionNotFound is returned when a requested version was never published
+var errTemplateVersionNotFound = errors.New("template version not found")
+
+// errNoTemplateChanges is returned when publishing a draft identical to the latest version
+var errNoTemplateChanges = errors.New("draft has no unpublished changes")
+
+// queryRower is satisfied by both the connection pool and transactions
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// loadTemplateVersion reads one published version of a template
+func loadTemplateVersion(ctx context.Context, q queryRower, templateID string, version int) (*TemplateVersion, error) {
+	var v TemplateVersion
+	err := q.QueryRow(ctx, `
+		SELECT id, template_id, version, content, variables, variable_schema,
+		       COALESCE(notes, ''), COALESCE(published_by, ''), published_at
+		FROM documents.template_versions
+		WHERE template_id = $1 AND version = $2
+	`, templateID, version).Scan(
+		&v.ID, &v.TemplateID, &v.Version, &v.Content, &v.Variables,
+		&v.VariableSchema, &v.Notes, &v.PublishedBy, &v.PublishedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, errTemplateVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// loadTemplateDraft reads a template's working draft as an unpublished version
+func loadTemplateDraft(ctx context.Context, q queryRower, templateID string) (*TemplateVersion, error) {
+	var v TemplateVersion
+	err := q.QueryRow(ctx, `
+		SELECT id, version, content, variables, variable_schema, updated_at
+		FROM documents.templates
+		WHERE id = $1 AND is_active = true
+	`, templateID).Scan(&v.TemplateID, &v.Version, &v.Content, &v.Variables, &v.VariableSchema, &v.PublishedAt)
+	if err == pgx.ErrNoRows {
+		return nil, errTemplateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// insertTemplateVersion appends a published version and makes it the
+// template's current version
+func insertTemplateVersion(ctx context.Context, tx pgx.Tx, v *TemplateVersion) error {
+	v.ID = uuid.New().String()
+	v.PublishedAt = time.Now()
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO documents.template_versions (
+			id, template_id, version, content, variables, variable_schema,
+			notes, published_by, published_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9
+		)
+	`, v.ID, v.TemplateID, v.Version, v.Content, v.Variables, v.VariableSchema,
+		v.Notes, v.PublishedBy, v.PublishedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE documents.templates
+		SET version = $1, updated_at = $2
+		WHERE id = $3
+	`, v.Version, v.PublishedAt, v.TemplateID)
+	return err
+}
+
+// sameDefinition reports whether two template versions have identical
+// content and variables
+func sameDefinition(a, b *TemplateVersion) bool {
+	if a.Content != b.Content {
+		return false
+	}
+	schemaA, _ := json.Marshal(a.VariableSchema)
+	schemaB, _ := json.Marshal(b.VariableSchema)
+	variablesA, _ := json.Marshal(a.Variables)
+	variablesB, _ := json.Marshal(b.Variables)
+	return string(schemaA) == string(schemaB) && string(variablesA) == string(variablesB)
+}
+
+// respondToVersionError writes the response for a failed versioning operation
+func respondToVersionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+	case errors.Is(err, errTemplateVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template version not found"})
+	case errors.Is(err, errNoTemplateChanges):
+		c.JSON(http.StatusConflict, gin.H{"error": "Draft has no unpublished changes"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template versions: " + err.Error()})
+	}
+}
+
+// publishTemplate publishes the template's draft as a new immutable version
+func publishTemplate(c *gin.Context) {
+	templateID := c.Param("id")
+
+	var input struct {
+		PublishedBy string `json:"published_by" binding:"required"`
+		Notes       string `json:"notes"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the template so concurrent publishes get consecutive versions
+	var locked string
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM documents.templates WHERE id = $1 AND is_active = true FOR UPDATE
+	`, templateID).Scan(&locked)
+	if err == pgx.ErrNoRows {
+		respondToVersionError(c, errTemplateNotFound)
+		return
+	}
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	draft, err := loadTemplateDraft(ctx, tx, templateID)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	latest, err := loadTemplateVersion(ctx, tx, templateID, draft.Version)
+	if err != nil && !errors.Is(err, errTemplateVersionNotFound) {
+		respondToVersionError(c, err)
+		return
+	}
+	if latest != nil && sameDefinition(draft, latest) {
+		respondToVersionError(c, errNoTemplateChanges)
+		return
+	}
+
+	version := &TemplateVersion{
+		TemplateID:     templateID,
+		Version:        draft.Version + 1,
+		Content:        draft.Content,
+		Variables:      draft.Variables,
+		VariableSchema: draft.VariableSchema,
+		Notes:          input.Notes,
+		PublishedBy:    input.PublishedBy,
+	}
+	if err := insertTemplateVersion(ctx, tx, version); err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      version.ID,
+		"version": version.Version,
+		"message": "Template published successfully",
+	})
+}
+
+// rollbackTemplate republishes an earlier version as the newest version and
+// resets the draft to it. Published versions are never modified.
+func rollbackTemplate(c *gin.Context) {
+	templateID := c.Param("id")
+
+	var input struct {
+		Version     int    `json:"version" binding:"required,min=1"`
+		PublishedBy string `json:"published_by" binding:"required"`
+		Notes       string `json:"notes"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var current int
+	err = tx.QueryRow(ctx, `
+		SELECT version FROM documents.templates WHERE id = $1 AND is_active = true FOR UPDATE
+	`, templateID).Scan(&current)
+	if err == pgx.ErrNoRows {
+		respondToVersionError(c, errTemplateNotFound)
+		return
+	}
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	target, err := loadTemplateVersion(ctx, tx, templateID, input.Version)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	if input.Version == current {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Version %d is already the current version", current)})
+		return
+	}
+
+	notes := input.Notes
+	if notes == "" {
+		notes = fmt.Sprintf("Rollback to version %d", input.Version)
+	}
+
+	version := &TemplateVersion{
+		TemplateID:     templateID,
+		Version:        current + 1,
+		Content:        target.Content,
+		Variables:      target.Variables,
+		VariableSchema: target.VariableSchema,
+		Notes:          notes,
+		PublishedBy:    input.PublishedBy,
+	}
+	if err := insertTemplateVersion(ctx, tx, version); err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	// The draft starts again from the restored version
+	_, err = tx.Exec(ctx, `
+		UPDATE documents.templates
+		SET content = $1, variables = $2, variable_schema = $3
+		WHERE id = $4
+	`, target.Content, target.Variables, target.VariableSchema, templateID)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":             version.ID,
+		"version":        version.Version,
+		"rolled_back_to": input.Version,
+		"message":        "Template rolled back successfully",
+	})
+}
+
+func getTemplateVersions(c *gin.Context) {
+	templateID := c.Param("id")
+	var versions []TemplateVersion
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, template_id, version, COALESCE(notes, ''), COALESCE(published_by, ''), published_at
+		FROM documents.template_versions
+		WHERE template_id = $1
+		ORDER BY version DESC
+	`, templateID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve template versions"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v TemplateVersion
+		err := rows.Scan(&v.ID, &v.TemplateID, &v.Version, &v.Notes, &v.PublishedBy, &v.PublishedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan template version data"})
+			return
+		}
+		versions = append(versions, v)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+func getTemplateVersion(c *gin.Context) {
+	templateID := c.Param("id")
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template version"})
+		return
+	}
+
+	v, err := loadTemplateVersion(context.Background(), db, templateID, version)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, v)
+}
+
+// resolveDiffSide loads the published version named by a diff query parameter
+func resolveDiffSide(ctx context.Context, templateID, name string) (*TemplateVersion, error) {
+	version, err := strconv.Atoi(name)
+	if err != nil || version < 1 {
+		return nil, fmt.Errorf("invalid template version %q", name)
+	}
+	return loadTemplateVersion(ctx, db, templateID, version)
+}
+
+// diffTemplate compares two versions of a template. Both sides accept a
+// version number or "draft"; by default the current published version is
+// compared with the draft.
+func diffTemplate(c *gin.Context) {
+	templateID := c.Param("id")
+	ctx := context.Background()
+
+	draft, err := loadTemplateDraft(ctx, db, templateID)
+	if err != nil {
+		respondToVersionError(c, err)
+		return
+	}
+
+	fromName := c.DefaultQuery("from", strconv.Itoa(draft.Version))
+	toName := c.DefaultQuery("to", draftVersion)
+
+	sides := make([]*TemplateVersion, 2)
+	for i, name := range []string{fromName, toName} {
+		if name == draftVersion {
+			sides[i] = draft
+			continue
+		}
+		sides[i], err = resolveDiffSide(ctx, templateID, name)
+		if errors.Is(err, errTemplateVersionNotFound) {
+			respondToVersionError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	from, to := sides[0], sides[1]
+
+	lines := diff.Lines(from.Content, to.Content)
+	added, removed := diffVariables(from.Variables, to.Variables)
+
+	c.JSON(http.StatusOK, gin.H{
+		"template_id":       templateID,
+		"from":              fromName,
+		"to":                toName,
+		"changed":           diff.Changed(lines) || !sameDefinition(from, to),
+		"lines":             lines,
+		"unified":           diff.Unified(lines, "version "+fromName, "version "+toName, diffContextLines),
+		"variables_added":   added,
+		"variables_removed": removed,
+	})
+}
+
+// diffVariables returns the variable names only in to and only in from
+func diffVariables(from, to []string) ([]string, []string) {
+	inFrom := make(map[string]bool, len(from))
+	for _, name := range from {
+		inFrom[name] = true
+	}
+	inTo := make(map[string]bool, len(to))
+	for _, name := range to {
+		inTo[name] = true
+	}
+
+	added, removed := []string{}, []string{}
+	for _, name := range to {
+		if !inFrom[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range from {
+		if !inTo[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
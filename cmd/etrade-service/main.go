@@ -12,6 +12,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
 
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/aggregation"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/handlers"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/service"
 )
@@ -54,9 +56,30 @@ func main() {
 		log.Fatalf("Failed to ensure etrade schema: %v", err)
 	}
 
+	// Ensure the account sync status table exists
+	if err := accountsync.EnsureSchema(context.Background(), db); err != nil {
+		log.Fatalf("Failed to ensure account sync schema: %v", err)
+	}
+
 	// Create the E-Trade service
 	etradeService := service.NewETradeService(db, etradeConsumerKey, etradeConsumerSecret, etradeCallbackURL, etradeSandbox)
 
+	// Serve E-Trade through the aggregation provider interface
+	registry, err := aggregation.NewRegistry(etradeService)
+	if err != nil {
+		log.Fatalf("Failed to register E-Trade provider: %v", err)
+	}
+	aggregationHandler := aggregation.NewHandler(
+		db, registry,
+		durationEnv("ACCOUNT_SYNC_INTERVAL", time.Hour),
+		durationEnv("ACCOUNT_SYNC_MAX_BACKOFF", 24*time.Hour),
+	)
+
+	// Keep linked E-Trade accounts in sync in the background
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	go aggregationHandler.RunSync(syncCtx)
+
 	// Create the E-Trade handler
 	etradeHandler := handlers.NewETradeHandler(etradeService)
 
@@ -73,6 +96,7 @@ func main() {
 
 	// API routes
 	v1 := router.Group("/api/v1")
+	aggregationHandler.RegisterRoutes(v1)
 	etradeHandler.RegisterRoutes(v1)
 
 	// Start server
@@ -93,6 +117,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down etrade-service...")
+	stopSync()
 
 	// Give the server 5 seconds to finish ongoing requests
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -113,6 +138,20 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// durationEnv reads a duration environment variable such as "1h"
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
 // ensureEtradeSchema ensures the etrade schema and tables exist
 func ensureEtradeSchema(db *pgxpool.Pool) error {
 	ctx := context.Background()
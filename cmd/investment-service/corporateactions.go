@@ -0,0 +1,908 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/corpactions"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/lots"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Sources of corporate actions
+const (
+	corporateActionSourceAPI = "API"
+	corporateActionSourceCSV = "CSV"
+)
+
+// maxCorporateActionFileBytes caps the size of a corporate actions CSV upload
+const maxCorporateActionFileBytes = 5 << 20
+
+// CorporateAction is a corporate action that has been applied to holdings
+type CorporateAction struct {
+	ID            string                      `json:"id"`
+	Type          string                      `json:"type"`
+	AssetID       string                      `json:"asset_id"`
+	Symbol        string                      `json:"symbol"`
+	NewAssetID    string                      `json:"new_asset_id,omitempty"`
+	NewSymbol     string                      `json:"new_symbol,omitempty"`
+	Ratio         *float64                    `json:"ratio,omitempty"`
+	CashPerShare  *decimal.Decimal            `json:"cash_per_share,omitempty"`
+	NewPrice      *decimal.Decimal            `json:"new_price,omitempty"`
+	EffectiveDate time.Time                   `json:"effective_date"`
+	PayDate       *time.Time                  `json:"pay_date,omitempty"`
+	Description   string                      `json:"description"`
+	Source        string                      `json:"source"`
+	AppliedAt     time.Time                   `json:"applied_at"`
+	Adjustments   []CorporateActionAdjustment `json:"adjustments"`
+}
+
+// CorporateActionAdjustment records how a corporate action changed one lot,
+// or one position for actions that do not touch lots. Cost basis is not
+// recorded for cash dividends, which leave it unchanged.
+type CorporateActionAdjustment struct {
+	ID              string           `json:"id"`
+	AccountID       string           `json:"account_id"`
+	PositionID      string           `json:"position_id"`
+	LotID           string           `json:"lot_id,omitempty"`
+	NewPositionID   string           `json:"new_position_id,omitempty"`
+	NewLotID        string           `json:"new_lot_id,omitempty"`
+	TransactionID   string           `json:"transaction_id,omitempty"`
+	QuantityBefore  float64          `json:"quantity_before"`
+	QuantityAfter   float64          `json:"quantity_after"`
+	CostBasisBefore *decimal.Decimal `json:"cost_basis_before,omitempty"`
+	CostBasisAfter  *decimal.Decimal `json:"cost_basis_after,omitempty"`
+	CashAmount      decimal.Decimal  `json:"cash_amount"`
+	CreatedAt       time.Time        `json:"created_at"`
+}
+
+// corporateActionInput is the body of a corporate action request. Dates are
+// YYYY-MM-DD.
+type corporateActionInput struct {
+	Type          string          `json:"type" binding:"required"`
+	Symbol        string          `json:"symbol" binding:"required"`
+	EffectiveDate string          `json:"effective_date" binding:"required"`
+	PayDate       string          `json:"pay_date"`
+	Ratio         float64         `json:"ratio"`
+	CashPerShare  decimal.Decimal `json:"cash_per_share"`
+	NewSymbol     string          `json:"new_symbol"`
+	NewName       string          `json:"new_name"`
+	NewPrice      decimal.Decimal `json:"new_price"`
+	Description   string          `json:"description"`
+}
+
+// action converts the input to a normalized corporate action
+func (in corporateActionInput) action() (corpactions.Action, error) {
+	a := corpactions.Action{
+		Type:         in.Type,
+		Symbol:       in.Symbol,
+		Ratio:        in.Ratio,
+		CashPerShare: in.CashPerShare,
+		NewSymbol:    in.NewSymbol,
+		NewName:      in.NewName,
+		NewPrice:     in.NewPrice,
+		Description:  in.Description,
+	}
+
+	effective, err := time.Parse("2006-01-02", in.EffectiveDate)
+	if err != nil {
+		return a, badTransaction("Invalid effective_date, expected YYYY-MM-DD")
+	}
+	a.EffectiveDate = effective
+	if in.PayDate != "" {
+		pay, err := time.Parse("2006-01-02", in.PayDate)
+		if err != nil {
+			return a, badTransaction("Invalid pay_date, expected YYYY-MM-DD")
+		}
+		a.PayDate = &pay
+	}
+
+	a.Normalize()
+	return a, nil
+}
+
+// heldLot is an open tax lot affected by a corporate action
+type heldLot struct {
+	ID            string
+	AccountID     string
+	PositionID    string
+	TransactionID string
+	AcquiredAt    time.Time
+	corpactions.Lot
+}
+
+// costBasis returns the basis of the lot's remaining shares
+func (l heldLot) costBasis(currency string) decimal.Decimal {
+	return money.Round(l.UnitCost.Mul(decimal.NewFromFloat(l.RemainingQuantity)), currency)
+}
+
+// applyCorporateAction records a validated corporate action and adjusts the
+// holdings it affects. An action may be applied once per symbol, type and
+// effective date.
+func applyCorporateAction(ctx context.Context, tx pgx.Tx, a corpactions.Action, source string) (*CorporateAction, error) {
+	ca := &CorporateAction{
+		ID:            uuid.New().String(),
+		Type:          a.Type,
+		Symbol:        a.Symbol,
+		EffectiveDate: a.EffectiveDate,
+		PayDate:       a.PayDate,
+		Description:   a.Description,
+		Source:        source,
+		AppliedAt:     time.Now(),
+		Adjustments:   []CorporateActionAdjustment{},
+	}
+	if ca.Description == "" {
+		ca.Description = a.Summary()
+	}
+	if a.Ratio != 0 {
+		ratio := a.Ratio
+		ca.Ratio = &ratio
+	}
+	for _, term := range []struct {
+		value decimal.Decimal
+		dest  **decimal.Decimal
+	}{
+		{a.CashPerShare, &ca.CashPerShare},
+		{a.NewPrice, &ca.NewPrice},
+	} {
+		if !term.value.IsZero() {
+			value := term.value
+			*term.dest = &value
+		}
+	}
+
+	var assetClass, currency string
+	err := tx.QueryRow(ctx, `
+		SELECT id, asset_class, current_price_currency
+		FROM investments.assets
+		WHERE symbol = $1
+		FOR UPDATE
+	`, a.Symbol).Scan(&ca.AssetID, &assetClass, &currency)
+	if err == pgx.ErrNoRows {
+		return nil, &LedgerError{Status: http.StatusNotFound, Reason: fmt.Sprintf("Asset %s not found", a.Symbol)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var applied bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM investments.corporate_actions
+			WHERE asset_id = $1 AND type = $2 AND effective_date = $3
+		)
+	`, ca.AssetID, a.Type, a.EffectiveDate).Scan(&applied)
+	if err != nil {
+		return nil, err
+	}
+	if applied {
+		return nil, &LedgerError{
+			Status: http.StatusConflict,
+			Reason: fmt.Sprintf("A %s for %s effective %s has already been applied", a.Type, a.Symbol, a.EffectiveDate.Format("2006-01-02")),
+		}
+	}
+
+	if a.Type == corpactions.TypeMerger && a.Ratio > 0 {
+		ca.NewSymbol = a.NewSymbol
+		ca.NewAssetID, err = acquirerAsset(ctx, tx, a, assetClass, currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if a.Type == corpactions.TypeSymbolChange {
+		ca.NewSymbol = a.NewSymbol
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO investments.corporate_actions (
+			id, type, asset_id, symbol, new_asset_id, new_symbol, ratio, cash_per_share,
+			new_price, effective_date, pay_date, description, source, applied_at
+		) VALUES (
+			$1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, ''), $7, $8,
+			$9, $10, $11, $12, $13, $14
+		)
+	`, ca.ID, ca.Type, ca.AssetID, ca.Symbol, ca.NewAssetID, ca.NewSymbol, ca.Ratio, ca.CashPerShare,
+		ca.NewPrice, ca.EffectiveDate, ca.PayDate, ca.Description, ca.Source, ca.AppliedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record corporate action: %w", err)
+	}
+
+	switch a.Type {
+	case corpactions.TypeSplit, corpactions.TypeStockDividend:
+		err = scaleLots(ctx, tx, ca, a, currency)
+	case corpactions.TypeCashDividend:
+		err = payDividend(ctx, tx, ca, a, currency)
+	case corpactions.TypeSymbolChange:
+		err = changeSymbol(ctx, tx, ca, a)
+	case corpactions.TypeMerger:
+		err = mergeLots(ctx, tx, ca, a, currency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// acquirerAsset returns the asset a merger converts shares into, creating it
+// in the target's asset class when it is not tracked yet
+func acquirerAsset(ctx context.Context, tx pgx.Tx, a corpactions.Action, assetClass, currency string) (string, error) {
+	id, _, err := findOrCreateAsset(ctx, tx, a.NewSymbol, a.NewName, assetClass, a.NewPrice, currency)
+	return id, err
+}
+
+// lockHeldLots returns the open lots in an asset, locked for update. Lots
+// acquired on or after before are left out when before is not zero, and
+// lots in closed accounts are left out when activeOnly is set.
+func lockHeldLots(ctx context.Context, tx pgx.Tx, assetID string, before time.Time, activeOnly bool) ([]heldLot, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT l.id, l.account_id, l.position_id, l.transaction_id, l.acquired_at,
+		       l.quantity, l.remaining_quantity, l.unit_cost
+		FROM investments.tax_lots l
+		JOIN accounts.accounts a ON a.id = l.account_id
+		WHERE l.asset_id = $1 AND l.remaining_quantity > 0
+		  AND ($2::timestamptz IS NULL OR l.acquired_at < $2)
+		  AND (NOT $3 OR a.is_active = true)
+		ORDER BY l.account_id, l.position_id, l.acquired_at, l.id
+		FOR UPDATE OF l
+	`, assetID, nullTime(before), activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var held []heldLot
+	for rows.Next() {
+		var l heldLot
+		err := rows.Scan(
+			&l.ID, &l.AccountID, &l.PositionID, &l.TransactionID, &l.AcquiredAt,
+			&l.Quantity, &l.RemainingQuantity, &l.UnitCost,
+		)
+		if err != nil {
+			return nil, err
+		}
+		held = append(held, l)
+	}
+	return held, rows.Err()
+}
+
+// nullTime returns nil for the zero time so it is passed to SQL as NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// scaleLots applies a split or stock dividend to the lots held before its
+// effective date. Disposals already recorded stay in the shares they sold;
+// the lot's split factor converts them to the shares it holds now.
+func scaleLots(ctx context.Context, tx pgx.Tx, ca *CorporateAction, a corpactions.Action, currency string) error {
+	held, err := lockHeldLots(ctx, tx, ca.AssetID, a.EffectiveDate, false)
+	if err != nil {
+		return err
+	}
+
+	factor := a.ShareFactor()
+	positions := make(map[string]bool)
+	for _, l := range held {
+		scaled := l.Scale(factor)
+		_, err := tx.Exec(ctx, `
+			UPDATE investments.tax_lots
+			SET quantity = $1, remaining_quantity = $2, unit_cost = $3, split_factor = split_factor * $4
+			WHERE id = $5
+		`, scaled.Quantity, scaled.RemainingQuantity, scaled.UnitCost, factor, l.ID)
+		if err != nil {
+			return fmt.Errorf("failed to adjust lot %s: %w", l.ID, err)
+		}
+
+		basis := l.costBasis(currency)
+		err = recordAdjustment(ctx, tx, ca, CorporateActionAdjustment{
+			AccountID:       l.AccountID,
+			PositionID:      l.PositionID,
+			LotID:           l.ID,
+			QuantityBefore:  l.RemainingQuantity,
+			QuantityAfter:   scaled.RemainingQuantity,
+			CostBasisBefore: &basis,
+			CostBasisAfter:  &basis,
+		})
+		if err != nil {
+			return err
+		}
+		positions[l.PositionID] = true
+	}
+
+	for positionID := range positions {
+		if err := syncPosition(ctx, tx, positionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payDividend records a dividend for each position that held shares on the
+// ex-date, counting shares sold since then
+func payDividend(ctx context.Context, tx pgx.Tx, ca *CorporateAction, a corpactions.Action, currency string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT l.account_id, l.position_id,
+		       SUM(l.remaining_quantity + COALESCE(d.quantity, 0))
+		FROM investments.tax_lots l
+		JOIN accounts.accounts a ON a.id = l.account_id
+		LEFT JOIN (
+			SELECT lot_id, SUM(quantity) AS quantity
+			FROM investments.lot_disposals
+			WHERE disposed_at >= $2
+			GROUP BY lot_id
+		) d ON d.lot_id = l.id
+		WHERE l.asset_id = $1 AND l.acquired_at < $2 AND a.is_active = true
+		GROUP BY l.account_id, l.position_id
+		HAVING SUM(l.remaining_quantity + COALESCE(d.quantity, 0)) > 0
+		ORDER BY l.account_id, l.position_id
+	`, ca.AssetID, a.EffectiveDate)
+	if err != nil {
+		return err
+	}
+
+	var holders []CorporateActionAdjustment
+	for rows.Next() {
+		var h CorporateActionAdjustment
+		if err := rows.Scan(&h.AccountID, &h.PositionID, &h.QuantityBefore); err != nil {
+			rows.Close()
+			return err
+		}
+		h.QuantityAfter = h.QuantityBefore
+		h.CashAmount = money.Round(a.CashPerShare.Mul(decimal.NewFromFloat(h.QuantityBefore)), currency)
+		holders = append(holders, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	payDate := a.PaymentDate()
+	for _, h := range holders {
+		if !h.CashAmount.IsPositive() {
+			continue
+		}
+		t, err := recordTransaction(ctx, tx, transactionInput{
+			AccountID: h.AccountID,
+			AssetID:   ca.AssetID,
+			Type:      TransactionDividend,
+			Amount:    h.CashAmount,
+			TradeDate: &payDate,
+			Notes:     ca.Description,
+		})
+		if err != nil {
+			return err
+		}
+		h.TransactionID = t.ID
+		if err := recordAdjustment(ctx, tx, ca, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeSymbol renames an asset and the model allocations that name it.
+// Positions keep their lots; each open position is recorded for the audit
+// trail.
+func changeSymbol(ctx context.Context, tx pgx.Tx, ca *CorporateAction, a corpactions.Action) error {
+	var taken bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM investments.assets WHERE symbol = $1)
+	`, a.NewSymbol).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return &LedgerError{
+			Status: http.StatusConflict,
+			Reason: fmt.Sprintf("Asset %s already exists; record a merger to move holdings into it", a.NewSymbol),
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE investments.assets
+		SET symbol = $1, name = COALESCE(NULLIF($2, ''), name), last_updated = $3
+		WHERE id = $4
+	`, a.NewSymbol, a.NewName, time.Now(), ca.AssetID)
+	if err != nil {
+		return fmt.Errorf("failed to rename asset: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE investments.model_allocations
+		SET target = CASE WHEN target_type = 'SYMBOL' AND UPPER(target) = $1 THEN $2 ELSE target END,
+		    buy_symbol = CASE WHEN buy_symbol = $1 THEN $2 ELSE buy_symbol END
+		WHERE (target_type = 'SYMBOL' AND UPPER(target) = $1) OR buy_symbol = $1
+	`, a.Symbol, a.NewSymbol)
+	if err != nil {
+		return fmt.Errorf("failed to update model allocations: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, account_id, quantity, cost_basis
+		FROM investments.positions
+		WHERE asset_id = $1 AND is_open = true
+		ORDER BY account_id, id
+	`, ca.AssetID)
+	if err != nil {
+		return err
+	}
+
+	var held []CorporateActionAdjustment
+	for rows.Next() {
+		var h CorporateActionAdjustment
+		var basis decimal.Decimal
+		if err := rows.Scan(&h.PositionID, &h.AccountID, &h.QuantityBefore, &basis); err != nil {
+			rows.Close()
+			return err
+		}
+		h.QuantityAfter = h.QuantityBefore
+		h.CostBasisBefore, h.CostBasisAfter = &basis, &basis
+		held = append(held, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, h := range held {
+		if err := recordAdjustment(ctx, tx, ca, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLots converts each holder's lots acquired before the effective date
+// into the acquirer. Cash paid in the merger is recorded as a sale of the
+// share of each lot it pays for, and the rest of each lot moves into a new
+// lot in the acquirer that keeps its acquisition date and basis. The old lot is closed and the new one held
+// from the effective date, so the shares are counted once on any date.
+func mergeLots(ctx context.Context, tx pgx.Tx, ca *CorporateAction, a corpactions.Action, currency string) error {
+	held, err := lockHeldLots(ctx, tx, ca.AssetID, a.EffectiveDate, true)
+	if err != nil {
+		return err
+	}
+
+	byPosition := make(map[string][]heldLot)
+	var positions []string
+	for _, l := range held {
+		if _, ok := byPosition[l.PositionID]; !ok {
+			positions = append(positions, l.PositionID)
+		}
+		byPosition[l.PositionID] = append(byPosition[l.PositionID], l)
+	}
+
+	stock := a.StockFraction()
+	for _, positionID := range positions {
+		positionLots := byPosition[positionID]
+		accountID := positionLots[0].AccountID
+
+		var transactionID string
+		if stock < 1 {
+			t, err := sellForCash(ctx, tx, ca, a, stock, positionLots)
+			if err != nil {
+				return err
+			}
+			transactionID = t.ID
+		}
+
+		var newPositionID string
+		if stock > 0 {
+			newPositionID, err = openPosition(ctx, tx, accountID, ca.NewAssetID, a.EffectiveDate)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, l := range positionLots {
+			basis := l.costBasis(currency)
+			adjustment := CorporateActionAdjustment{
+				AccountID:       l.AccountID,
+				PositionID:      l.PositionID,
+				LotID:           l.ID,
+				TransactionID:   transactionID,
+				QuantityBefore:  l.RemainingQuantity,
+				CostBasisBefore: &basis,
+				CashAmount:      money.Round(a.CashPerShare.Mul(decimal.NewFromFloat(l.RemainingQuantity)), currency),
+			}
+
+			basisAfter := decimal.Zero
+			if stock > 0 {
+				kept := corpactions.Lot{
+					Quantity:          l.RemainingQuantity * stock,
+					RemainingQuantity: l.RemainingQuantity * stock,
+					UnitCost:          l.UnitCost,
+				}
+				converted := kept.Scale(a.Ratio / stock)
+
+				adjustment.NewPositionID = newPositionID
+				adjustment.NewLotID = uuid.New().String()
+				adjustment.QuantityAfter = converted.RemainingQuantity
+				basisAfter = money.Round(converted.UnitCost.Mul(decimal.NewFromFloat(converted.RemainingQuantity)), currency)
+
+				_, err := tx.Exec(ctx, `
+					UPDATE investments.tax_lots
+					SET remaining_quantity = 0, closed_at = $1
+					WHERE id = $2
+				`, a.EffectiveDate, l.ID)
+				if err != nil {
+					return fmt.Errorf("failed to close lot %s: %w", l.ID, err)
+				}
+
+				_, err = tx.Exec(ctx, `
+					INSERT INTO investments.tax_lots (
+						id, account_id, asset_id, position_id, transaction_id,
+						acquired_at, quantity, remaining_quantity, unit_cost, converted_at
+					) VALUES (
+						$1, $2, $3, $4, $5, $6, $7, $7, $8, $9
+					)
+				`, adjustment.NewLotID, l.AccountID, ca.NewAssetID, newPositionID, l.TransactionID,
+					l.AcquiredAt, converted.RemainingQuantity, converted.UnitCost, a.EffectiveDate)
+				if err != nil {
+					return fmt.Errorf("failed to open converted lot: %w", err)
+				}
+			}
+			adjustment.CostBasisAfter = &basisAfter
+
+			if err := recordAdjustment(ctx, tx, ca, adjustment); err != nil {
+				return err
+			}
+		}
+
+		if err := syncPosition(ctx, tx, positionID); err != nil {
+			return err
+		}
+		if newPositionID != "" {
+			if err := syncPosition(ctx, tx, newPositionID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sellForCash records the cash part of a merger as a sale of the fraction
+// of each lot not converted to stock, realizing a gain against the cash
+func sellForCash(ctx context.Context, tx pgx.Tx, ca *CorporateAction, a corpactions.Action, stock float64, positionLots []heldLot) (*Transaction, error) {
+	var selections []lots.Selection
+	var quantity float64
+	for _, l := range positionLots {
+		sold := l.RemainingQuantity * (1 - stock)
+		if stock == 0 {
+			sold = l.RemainingQuantity
+		}
+		selections = append(selections, lots.Selection{LotID: l.ID, Quantity: sold})
+		quantity += sold
+	}
+
+	effective := a.EffectiveDate
+	return recordTransaction(ctx, tx, transactionInput{
+		AccountID: positionLots[0].AccountID,
+		AssetID:   ca.AssetID,
+		Type:      TransactionSell,
+		Quantity:  quantity,
+		Price:     a.CashPerShare.Div(decimal.NewFromFloat(1 - stock)),
+		TradeDate: &effective,
+		LotMethod: string(lots.Specific),
+		Lots:      selections,
+		Notes:     ca.Description,
+	})
+}
+
+// openPosition returns an account's open position in an asset, opening an
+// empty one if there is none
+func openPosition(ctx context.Context, tx pgx.Tx, accountID, assetID string, openedAt time.Time) (string, error) {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1::text || ':' || $2::text))`, accountID, assetID)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = tx.QueryRow(ctx, `
+		SELECT id
+		FROM investments.positions
+		WHERE account_id = $1 AND asset_id = $2 AND is_open = true
+		ORDER BY purchase_date
+		LIMIT 1
+		FOR UPDATE
+	`, accountID, assetID).Scan(&id)
+	if err == nil || err != pgx.ErrNoRows {
+		return id, err
+	}
+
+	id = uuid.New().String()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO investments.positions (
+			id, account_id, asset_id, quantity, cost_basis,
+			current_value, purchase_date, last_updated, is_open
+		) VALUES (
+			$1, $2, $3, 0, 0, 0, $4, $5, true
+		)
+	`, id, accountID, assetID, openedAt, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to open position: %w", err)
+	}
+	return id, nil
+}
+
+// recordAdjustment writes an adjustment to the corporate action's audit trail
+func recordAdjustment(ctx context.Context, tx pgx.Tx, ca *CorporateAction, adj CorporateActionAdjustment) error {
+	adj.ID = uuid.New().String()
+	adj.CreatedAt = ca.AppliedAt
+	_, err := tx.Exec(ctx, `
+		INSERT INTO investments.corporate_action_adjustments (
+			id, action_id, account_id, position_id, lot_id, new_position_id, new_lot_id,
+			transaction_id, quantity_before, quantity_after, cost_basis_before,
+			cost_basis_after, cash_amount, created_at
+		) VALUES (
+			$1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, '')::uuid, NULLIF($7, '')::uuid,
+			NULLIF($8, '')::uuid, $9, $10, $11, $12, $13, $14
+		)
+	`, adj.ID, ca.ID, adj.AccountID, adj.PositionID, adj.LotID, adj.NewPositionID, adj.NewLotID,
+		adj.TransactionID, adj.QuantityBefore, adj.QuantityAfter, adj.CostBasisBefore,
+		adj.CostBasisAfter, adj.CashAmount, adj.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record corporate action adjustment: %w", err)
+	}
+	ca.Adjustments = append(ca.Adjustments, adj)
+	return nil
+}
+
+// respondToCorporateActionError writes the response for a corporate action
+// that could not be applied
+func respondToCorporateActionError(c *gin.Context, err error) {
+	var invalid *corpactions.ValidationError
+	var invalidRows corpactions.ValidationErrors
+	var ledgerErr *LedgerError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error(), "problems": invalid.Problems})
+	case errors.As(err, &invalidRows):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Corporate actions file has invalid rows", "rows": invalidRows})
+	case errors.As(err, &ledgerErr):
+		c.JSON(ledgerErr.Status, gin.H{"error": ledgerErr.Reason})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply corporate action: " + err.Error()})
+	}
+}
+
+// createCorporateAction applies a corporate action to the holdings it affects
+func createCorporateAction(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var input corporateActionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	action, err := input.action()
+	if err == nil {
+		err = action.Validate(time.Now())
+	}
+	if err != nil {
+		respondToCorporateActionError(c, err)
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	ca, err := applyCorporateAction(ctx, tx, action, corporateActionSourceAPI)
+	if err != nil {
+		respondToCorporateActionError(c, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit corporate action"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":               ca.ID,
+		"message":          "Corporate action applied successfully",
+		"corporate_action": ca,
+	})
+}
+
+// importCorporateActions applies the corporate actions in a CSV file, sent
+// as the "file" field of a multipart upload or as the request body, in
+// effective date order. The file is applied in full or not at all.
+func importCorporateActions(c *gin.Context) {
+	ctx := c.Request.Context()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCorporateActionFileBytes)
+
+	var file io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A CSV file is required in the file field"})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer f.Close()
+		file = f
+	}
+
+	actions, err := corpactions.ParseCSV(file, time.Now())
+	if err != nil {
+		var invalidRows corpactions.ValidationErrors
+		if errors.As(err, &invalidRows) {
+			respondToCorporateActionError(c, err)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid corporate actions file: " + err.Error()})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	applied := make([]*CorporateAction, 0, len(actions))
+	for _, action := range actions {
+		ca, err := applyCorporateAction(ctx, tx, action, corporateActionSourceCSV)
+		var ledgerErr *LedgerError
+		if errors.As(err, &ledgerErr) {
+			err = &LedgerError{Status: ledgerErr.Status, Reason: action.Summary() + ": " + ledgerErr.Reason}
+		}
+		if err != nil {
+			respondToCorporateActionError(c, err)
+			return
+		}
+		applied = append(applied, ca)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit corporate actions"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":           fmt.Sprintf("%d corporate actions applied successfully", len(applied)),
+		"corporate_actions": applied,
+	})
+}
+
+// corporateActionColumns are the columns scanned by scanCorporateAction
+const corporateActionColumns = `
+	id, type, asset_id, symbol, COALESCE(new_asset_id::text, ''), COALESCE(new_symbol, ''),
+	ratio, cash_per_share, new_price, effective_date, pay_date, description, source, applied_at
+`
+
+// scanCorporateAction scans a row selected with corporateActionColumns
+func scanCorporateAction(row pgx.Row) (CorporateAction, error) {
+	var ca CorporateAction
+	err := row.Scan(
+		&ca.ID, &ca.Type, &ca.AssetID, &ca.Symbol, &ca.NewAssetID, &ca.NewSymbol,
+		&ca.Ratio, &ca.CashPerShare, &ca.NewPrice, &ca.EffectiveDate, &ca.PayDate,
+		&ca.Description, &ca.Source, &ca.AppliedAt,
+	)
+	return ca, err
+}
+
+// listCorporateActions returns applied corporate actions, latest effective
+// first, filtered by symbol (old or new), type and a from/to effective date
+// range
+func listCorporateActions(c *gin.Context) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if symbol := c.Query("symbol"); symbol != "" {
+		add("$%d IN (symbol, new_symbol)", strings.ToUpper(symbol))
+	}
+	if actionType := c.Query("type"); actionType != "" {
+		add("type = $%d", strings.ToUpper(actionType))
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"from", "effective_date >= $%d"},
+		{"to", "effective_date <= $%d"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s date, expected YYYY-MM-DD", bound.param)})
+			return
+		}
+		add(bound.condition, date)
+	}
+
+	query := "SELECT " + corporateActionColumns + " FROM investments.corporate_actions"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY effective_date DESC, applied_at DESC"
+
+	rows, err := db.Query(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve corporate actions"})
+		return
+	}
+	defer rows.Close()
+
+	actions := []CorporateAction{}
+	for rows.Next() {
+		ca, err := scanCorporateAction(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan corporate action data"})
+			return
+		}
+		actions = append(actions, ca)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"corporate_actions": actions})
+}
+
+// getCorporateAction returns a corporate action with its adjustments
+func getCorporateAction(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	ca, err := scanCorporateAction(db.QueryRow(ctx,
+		"SELECT "+corporateActionColumns+" FROM investments.corporate_actions WHERE id = $1", c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Corporate action not found"})
+		return
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, account_id, position_id, COALESCE(lot_id::text, ''),
+		       COALESCE(new_position_id::text, ''), COALESCE(new_lot_id::text, ''),
+		       COALESCE(transaction_id::text, ''), quantity_before, quantity_after,
+		       cost_basis_before, cost_basis_after, cash_amount, created_at
+		FROM investments.corporate_action_adjustments
+		WHERE action_id = $1
+		ORDER BY account_id, position_id, lot_id
+	`, ca.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve corporate action adjustments"})
+		return
+	}
+	defer rows.Close()
+
+	ca.Adjustments = []CorporateActionAdjustment{}
+	for rows.Next() {
+		var adj CorporateActionAdjustment
+		err := rows.Scan(
+			&adj.ID, &adj.AccountID, &adj.PositionID, &adj.LotID,
+			&adj.NewPositionID, &adj.NewLotID, &adj.TransactionID,
+			&adj.QuantityBefore, &adj.QuantityAfter, &adj.CostBasisBefore,
+			&adj.CostBasisAfter, &adj.CashAmount, &adj.CreatedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan corporate action adjustment data"})
+			return
+		}
+		ca.Adjustments = append(ca.Adjustments, adj)
+	}
+
+	c.JSON(http.StatusOK, ca)
+}
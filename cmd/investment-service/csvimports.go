@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/csvimport"
+)
+
+// maxImportFileBytes caps the size of a CSV import upload
+const maxImportFileBytes = 5 << 20
+
+// importedAssetClass is the asset class of assets created by an import that
+// does not give one
+const importedAssetClass = "UNCLASSIFIED"
+
+// ImportProfile is a saved column mapping for the CSV files of one broker
+type ImportProfile struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Mapping     csvimport.Mapping `json:"mapping"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// importProfileInput is the body of import profile create and update requests
+type importProfileInput struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Mapping     *csvimport.Mapping `json:"mapping"`
+}
+
+// ImportedRow is a row of an import file with the transaction it records
+type ImportedRow struct {
+	Line         int          `json:"line"`
+	AssetCreated bool         `json:"asset_created"`
+	Transaction  *Transaction `json:"transaction"`
+}
+
+// CSVImport is the outcome of importing a CSV file into an account. The IDs
+// of a dry run's transactions are not kept.
+type CSVImport struct {
+	AccountID     string              `json:"account_id"`
+	Kind          string              `json:"kind"`
+	ProfileID     string              `json:"profile_id,omitempty"`
+	DryRun        bool                `json:"dry_run"`
+	Rows          []ImportedRow       `json:"rows"`
+	AssetsCreated []string            `json:"assets_created"`
+	Errors        csvimport.RowErrors `json:"errors"`
+}
+
+var (
+	errImportProfileNotFound  = errors.New("import profile not found")
+	errImportProfileNameTaken = errors.New("another import profile already has this name")
+)
+
+// validateImportMapping normalizes and validates a mapping
+func validateImportMapping(m *csvimport.Mapping) error {
+	m.Normalize()
+	if err := m.Validate(); err != nil {
+		return badTransaction("Invalid mapping: %s", err.Error())
+	}
+	return nil
+}
+
+// checkImportProfileName fails when a profile other than profileID has the name
+func checkImportProfileName(ctx context.Context, tx pgx.Tx, name, profileID string) error {
+	var taken bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM investments.import_profiles WHERE lower(name) = lower($1) AND id::text <> $2)
+	`, name, profileID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errImportProfileNameTaken
+	}
+	return nil
+}
+
+// loadImportProfile returns an import profile
+func loadImportProfile(ctx context.Context, profileID string) (*ImportProfile, error) {
+	if _, err := uuid.Parse(profileID); err != nil {
+		return nil, errImportProfileNotFound
+	}
+
+	var p ImportProfile
+	var mapping []byte
+	err := db.QueryRow(ctx, `
+		SELECT id, name, COALESCE(description, ''), mapping, created_at, updated_at
+		FROM investments.import_profiles
+		WHERE id = $1
+	`, profileID).Scan(&p.ID, &p.Name, &p.Description, &mapping, &p.CreatedAt, &p.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, errImportProfileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mapping, &p.Mapping); err != nil {
+		return nil, fmt.Errorf("failed to decode mapping of import profile %s: %w", p.ID, err)
+	}
+	return &p, nil
+}
+
+// respondToImportProfileError writes the response for a failed import
+// profile request
+func respondToImportProfileError(c *gin.Context, err error) {
+	var ledgerErr *LedgerError
+	switch {
+	case errors.Is(err, errImportProfileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import profile not found"})
+	case errors.Is(err, errImportProfileNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Another import profile already has this name"})
+	case errors.As(err, &ledgerErr):
+		c.JSON(ledgerErr.Status, gin.H{"error": ledgerErr.Reason})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save import profile: " + err.Error()})
+	}
+}
+
+// listImportProfiles returns import profiles by name, optionally of one kind
+func listImportProfiles(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := db.Query(ctx, `
+		SELECT id
+		FROM investments.import_profiles
+		WHERE $1 = '' OR kind = $1
+		ORDER BY name
+	`, strings.ToUpper(c.Query("kind")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve import profiles"})
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan import profile data"})
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	profiles := []ImportProfile{}
+	for _, id := range ids {
+		p, err := loadImportProfile(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve import profiles"})
+			return
+		}
+		profiles = append(profiles, *p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"import_profiles": profiles})
+}
+
+// getImportProfile returns one import profile
+func getImportProfile(c *gin.Context) {
+	p, err := loadImportProfile(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// createImportProfile saves a column mapping under a name
+func createImportProfile(c *gin.Context) {
+	var input importProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if input.Mapping == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mapping is required"})
+		return
+	}
+	if err := validateImportMapping(input.Mapping); err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+	mapping, err := json.Marshal(input.Mapping)
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	id := uuid.New().String()
+	err = checkImportProfileName(ctx, tx, input.Name, "")
+	if err == nil {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO investments.import_profiles (
+				id, name, description, kind, mapping, created_at, updated_at
+			) VALUES (
+				$1, $2, NULLIF($3, ''), $4, $5, $6, $6
+			)
+		`, id, input.Name, input.Description, input.Mapping.Kind, mapping, time.Now())
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      id,
+		"message": "Import profile created successfully",
+	})
+}
+
+// updateImportProfile updates an import profile. A mapping, when given,
+// replaces the existing one.
+func updateImportProfile(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		respondToImportProfileError(c, errImportProfileNotFound)
+		return
+	}
+
+	var input importProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+
+	var kind string
+	var mapping []byte
+	if input.Mapping != nil {
+		if err := validateImportMapping(input.Mapping); err != nil {
+			respondToImportProfileError(c, err)
+			return
+		}
+		var err error
+		if mapping, err = json.Marshal(input.Mapping); err != nil {
+			respondToImportProfileError(c, err)
+			return
+		}
+		kind = input.Mapping.Kind
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if input.Name != "" {
+		if err := checkImportProfileName(ctx, tx, input.Name, id); err != nil {
+			respondToImportProfileError(c, err)
+			return
+		}
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE investments.import_profiles
+		SET
+			name = COALESCE(NULLIF($1, ''), name),
+			description = COALESCE(NULLIF($2, ''), description),
+			kind = COALESCE(NULLIF($3, ''), kind),
+			mapping = COALESCE($4::jsonb, mapping),
+			updated_at = $5
+		WHERE id = $6
+	`, input.Name, input.Description, kind, mapping, time.Now(), id)
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		respondToImportProfileError(c, errImportProfileNotFound)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Import profile updated successfully"})
+}
+
+// deleteImportProfile deletes an import profile
+func deleteImportProfile(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		respondToImportProfileError(c, errImportProfileNotFound)
+		return
+	}
+
+	result, err := db.Exec(c.Request.Context(), `DELETE FROM investments.import_profiles WHERE id = $1`, id)
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		respondToImportProfileError(c, errImportProfileNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// importMapping returns the mapping of an import: the saved profile named by
+// profileID, else the mapping sent with the file as JSON, else the default
+// mapping for a kind of file, whose columns are named after their fields
+func importMapping(ctx context.Context, profileID, mappingJSON, kind string) (csvimport.Mapping, error) {
+	var m csvimport.Mapping
+	switch {
+	case profileID != "":
+		p, err := loadImportProfile(ctx, profileID)
+		if err != nil {
+			return m, err
+		}
+		m = p.Mapping
+	case mappingJSON != "":
+		if err := json.Unmarshal([]byte(mappingJSON), &m); err != nil {
+			return m, badTransaction("Invalid mapping: %s", err.Error())
+		}
+	default:
+		m.Kind = kind
+	}
+	return m, validateImportMapping(&m)
+}
+
+// importCSV imports positions or transactions from a CSV file, sent as the
+// "file" field of a multipart upload or as the request body, into an
+// account.
+//
+// The file is laid out as the saved import profile named by profile_id
+// describes, or as the mapping sent as JSON in the "mapping" field of a
+// multipart upload, or else has columns named after the fields of the kind
+// of file given by kind. Assets not in the asset master yet are created.
+//
+// With dry_run=true nothing is saved; the response previews the
+// transactions each row would record and lists the problem of every row
+// that cannot be imported, including sales of more shares than the account
+// would hold. Otherwise the rows are recorded in date order, all of them or,
+// when any row has a problem, none.
+func importCSV(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID := c.Param("accountId")
+	dryRun := c.Query("dry_run") == "true"
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileBytes)
+
+	var file io.Reader = c.Request.Body
+	mappingJSON := ""
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A CSV file is required in the file field"})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer f.Close()
+		file = f
+		mappingJSON = c.PostForm("mapping")
+	}
+
+	profileID := c.Query("profile_id")
+	mapping, err := importMapping(ctx, profileID, mappingJSON, c.Query("kind"))
+	if err != nil {
+		respondToImportProfileError(c, err)
+		return
+	}
+
+	rows, invalid, err := csvimport.Parse(file, mapping, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import file: " + err.Error()})
+		return
+	}
+	if len(invalid) > 0 && !dryRun {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import file has invalid rows", "rows": invalid})
+		return
+	}
+
+	var accountExists bool
+	err = db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM accounts.accounts WHERE id::text = $1 AND is_active = true)
+	`, accountID).Scan(&accountExists)
+	if err != nil || !accountExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	result := &CSVImport{
+		AccountID:     accountID,
+		Kind:          mapping.Kind,
+		ProfileID:     profileID,
+		DryRun:        dryRun,
+		Rows:          []ImportedRow{},
+		AssetsCreated: []string{},
+		Errors:        append(csvimport.RowErrors{}, invalid...),
+	}
+	if err := importRows(ctx, tx, result, rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import rows: " + err.Error()})
+		return
+	}
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Line < result.Errors[j].Line })
+
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("%d of %d rows can be imported", len(result.Rows), len(result.Rows)+len(result.Errors)),
+			"import":  result,
+		})
+		return
+	}
+	if len(result.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import file has invalid rows", "rows": result.Errors})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit import"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": fmt.Sprintf("%d rows imported successfully", len(result.Rows)),
+		"import":  result,
+	})
+}
+
+// importRows records rows within tx, each in a savepoint so a row the ledger
+// rejects is reported as a row problem and leaves the others in place
+func importRows(ctx context.Context, tx pgx.Tx, result *CSVImport, rows []csvimport.Row) error {
+	for _, row := range rows {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		imported, err := importRow(ctx, savepoint, result.AccountID, row)
+		var ledgerErr *LedgerError
+		if errors.As(err, &ledgerErr) {
+			if err := savepoint.Rollback(ctx); err != nil {
+				return err
+			}
+			result.Errors = append(result.Errors, &csvimport.RowError{Line: row.Line, Problems: []string{ledgerErr.Reason}})
+			continue
+		}
+		if err == nil {
+			err = savepoint.Commit(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		result.Rows = append(result.Rows, *imported)
+		if imported.AssetCreated {
+			result.AssetsCreated = append(result.AssetsCreated, row.Symbol)
+		}
+	}
+	return nil
+}
+
+// importRow records the transaction of one row, finding or creating the
+// asset it trades
+func importRow(ctx context.Context, tx pgx.Tx, accountID string, row csvimport.Row) (*ImportedRow, error) {
+	imported := &ImportedRow{Line: row.Line}
+	in := transactionInput{
+		AccountID: accountID,
+		Type:      row.Type,
+		Quantity:  row.Quantity,
+		Price:     row.Price,
+		Fees:      row.Fees,
+		Amount:    row.Amount,
+		TradeDate: row.Date,
+		Notes:     row.Notes,
+	}
+
+	if row.Symbol != "" {
+		assetClass := row.AssetClass
+		if assetClass == "" {
+			assetClass = importedAssetClass
+		}
+		currency := row.Currency
+		if currency == "" {
+			currency = "USD"
+		}
+		var err error
+		in.AssetID, imported.AssetCreated, err = findOrCreateAsset(ctx, tx, row.Symbol, row.Name, assetClass, row.Price, currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := recordTransaction(ctx, tx, in)
+	if err != nil {
+		return nil, err
+	}
+	if row.Symbol != "" && row.Currency != "" && t.Currency != row.Currency {
+		return nil, badTransaction("%s is traded in %s, not %s", row.Symbol, t.Currency, row.Currency)
+	}
+	imported.Transaction = t
+	return imported, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
+)
+
+// fxSource supplies the daily exchange rates stored in investments.fx_rates
+var fxSource fx.Source
+
+// fxRefreshMu keeps scheduled and requested rate refreshes from overlapping
+var fxRefreshMu sync.Mutex
+
+// FXRefresh summarizes one exchange rate refresh
+type FXRefresh struct {
+	Source      string    `json:"source"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Received    int       `json:"received"`
+	Stored      int64     `json:"stored"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// newFXSource creates the source named by FX_RATE_PROVIDER
+func newFXSource() (fx.Source, error) {
+	switch kind := getEnv("FX_RATE_PROVIDER", "file"); kind {
+	case "file":
+		return fx.NewFileSource(getEnv("FX_RATE_FILE", "/app/data/fx-rates.csv")), nil
+	default:
+		return nil, fmt.Errorf("unknown exchange rate provider %q", kind)
+	}
+}
+
+// refreshFXRatesPeriodically loads new rates at startup and then every
+// FX_REFRESH_INTERVAL until ctx is cancelled
+func refreshFXRatesPeriodically(ctx context.Context) {
+	interval := durationEnv("FX_REFRESH_INTERVAL", 6*time.Hour)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Refreshing exchange rates from %s every %s", fxSource.Name(), interval)
+
+	for {
+		if result, err := refreshFXRates(ctx, nil); err != nil {
+			log.Printf("Exchange rate refresh failed: %v", err)
+		} else {
+			log.Printf("Exchange rate refresh stored %d of %d rates", result.Stored, result.Received)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshFXRates stores the source's rates dated from from through today.
+// Without a from date, rates are loaded from the latest stored day onwards,
+// or in full when none are stored. Rates already stored are replaced, so a
+// source may correct a published rate.
+func refreshFXRates(ctx context.Context, from *time.Time) (*FXRefresh, error) {
+	fxRefreshMu.Lock()
+	defer fxRefreshMu.Unlock()
+
+	result := &FXRefresh{
+		Source:      fxSource.Name(),
+		To:          fx.Day(time.Now().UTC()),
+		RefreshedAt: time.Now(),
+	}
+	if from != nil {
+		result.From = fx.Day(*from)
+	} else {
+		var latest *time.Time
+		err := db.QueryRow(ctx, `SELECT MAX(rate_date) FROM investments.fx_rates`).Scan(&latest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve latest rate date: %w", err)
+		}
+		if latest != nil {
+			result.From = *latest
+		}
+	}
+
+	rates, err := fxSource.Rates(ctx, result.From, result.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rates from %s: %w", fxSource.Name(), err)
+	}
+	result.Received = len(rates)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, r := range rates {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO investments.fx_rates (base, quote, rate_date, rate, source)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (base, quote, rate_date) DO UPDATE
+			SET rate = EXCLUDED.rate, source = EXCLUDED.source, updated_at = NOW()
+			WHERE investments.fx_rates.rate IS DISTINCT FROM EXCLUDED.rate
+		`, r.Base, r.Quote, r.Date, r.Rate, fxSource.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to store %s/%s rate for %s: %w", r.Base, r.Quote, r.Date.Format(dateLayout), err)
+		}
+		result.Stored += tag.RowsAffected()
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// loadFXTable loads the rates needed to convert amounts valued between from
+// and to: every rate in the period and the last rate of each pair before it
+func loadFXTable(ctx context.Context, from, to time.Time) (*fx.Table, error) {
+	rows, err := db.Query(ctx, `
+		SELECT base, quote, rate_date, rate
+		FROM investments.fx_rates
+		WHERE rate_date <= $2
+		  AND (rate_date >= $1 OR (base, quote, rate_date) IN (
+		      SELECT base, quote, MAX(rate_date)
+		      FROM investments.fx_rates
+		      WHERE rate_date < $1
+		      GROUP BY base, quote
+		  ))
+	`, fx.Day(from), fx.Day(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []fx.Rate
+	for rows.Next() {
+		var r fx.Rate
+		if err := rows.Scan(&r.Base, &r.Quote, &r.Date, &r.Rate); err != nil {
+			return nil, fmt.Errorf("failed to scan exchange rate: %w", err)
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve exchange rates: %w", err)
+	}
+	return fx.NewTable(rates), nil
+}
+
+// convertPrice converts a per-share price or cost into currency to at the
+// rate as of date. Unlike Convert, which rounds amounts to the minor unit,
+// it keeps the six decimal places the ledger stores prices with.
+func convertPrice(rates *fx.Table, price decimal.Decimal, from, to string, date time.Time) (decimal.Decimal, error) {
+	rate, _, err := rates.Lookup(from, to, date)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return price.Mul(rate).Round(6), nil
+}
+
+// reportingCurrency returns the currency a scope's aggregated views are
+// reported in: the ?currency= query parameter when given, otherwise the
+// user's or trust's preference or the account's own currency
+func reportingCurrency(c *gin.Context, scopeType, scopeID string) (string, error) {
+	if value := c.Query("currency"); value != "" {
+		currency := fx.NormalizeCurrency(value)
+		if !fx.ValidCurrency(currency) {
+			return "", badFXRequest("currency must be a three letter currency code")
+		}
+		return currency, nil
+	}
+
+	var query, notFound string
+	switch scopeType {
+	case scopeAccount:
+		query, notFound = `SELECT balance_currency FROM accounts.accounts WHERE id = $1`, "Account not found"
+	case scopeTrust:
+		query, notFound = `SELECT reporting_currency FROM trusts.trusts WHERE id = $1`, "Trust not found"
+	case scopeUser:
+		query, notFound = `SELECT reporting_currency FROM users.users WHERE id = $1`, "User not found"
+	default:
+		return "", fmt.Errorf("unknown report scope %q", scopeType)
+	}
+
+	var currency string
+	err := db.QueryRow(c.Request.Context(), query, scopeID).Scan(&currency)
+	if err == pgx.ErrNoRows {
+		return "", &FXError{Status: http.StatusNotFound, Reason: notFound}
+	}
+	if err != nil {
+		return "", err
+	}
+	return fx.NormalizeCurrency(currency), nil
+}
+
+// FXError is an exchange rate request that cannot be served
+type FXError struct {
+	Status int
+	Reason string
+}
+
+func (e *FXError) Error() string {
+	return e.Reason
+}
+
+func badFXRequest(reason string) error {
+	return &FXError{Status: http.StatusBadRequest, Reason: reason}
+}
+
+// respondToFXError writes the response for an error from currency conversion
+func respondToFXError(c *gin.Context, err error) {
+	var fxErr *FXError
+	if errors.As(err, &fxErr) {
+		c.JSON(fxErr.Status, gin.H{"error": fxErr.Reason})
+		return
+	}
+	var missing *fx.MissingRateError
+	if errors.As(err, &missing) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Cannot convert currencies: " + missing.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert currencies: " + err.Error()})
+}
+
+// listFXRates lists stored rates, filtered by ?base=, ?quote=, ?from= and
+// ?to= (YYYY-MM-DD)
+func listFXRates(c *gin.Context) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if value := c.Query("base"); value != "" {
+		addCondition("base = $%d", fx.NormalizeCurrency(value))
+	}
+	if value := c.Query("quote"); value != "" {
+		addCondition("quote = $%d", fx.NormalizeCurrency(value))
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"from", "rate_date >= $%d"},
+		{"to", "rate_date <= $%d"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param + " date, expected YYYY-MM-DD"})
+			return
+		}
+		addCondition(bound.condition, date)
+	}
+
+	query := `SELECT base, quote, rate_date, rate FROM investments.fx_rates`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY rate_date DESC, base, quote LIMIT 1000"
+
+	rows, err := db.Query(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve exchange rates"})
+		return
+	}
+	defer rows.Close()
+
+	rates := []fx.Rate{}
+	for rows.Next() {
+		var r fx.Rate
+		if err := rows.Scan(&r.Base, &r.Quote, &r.Date, &r.Rate); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan exchange rate data"})
+			return
+		}
+		rates = append(rates, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rates": rates})
+}
+
+// createFXRate records a rate by hand, replacing any stored rate for the
+// same pair and day
+func createFXRate(c *gin.Context) {
+	var input struct {
+		Base  string          `json:"base" binding:"required"`
+		Quote string          `json:"quote" binding:"required"`
+		Date  string          `json:"date" binding:"required"`
+		Rate  decimal.Decimal `json:"rate"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	base, quote := fx.NormalizeCurrency(input.Base), fx.NormalizeCurrency(input.Quote)
+	if !fx.ValidCurrency(base) || !fx.ValidCurrency(quote) || base == quote {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base and quote must be two different three letter currency codes"})
+		return
+	}
+	date, err := time.Parse(dateLayout, input.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+	if !input.Rate.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rate must be positive"})
+		return
+	}
+
+	_, err = db.Exec(c.Request.Context(), `
+		INSERT INTO investments.fx_rates (base, quote, rate_date, rate, source)
+		VALUES ($1, $2, $3, $4, 'manual')
+		ON CONFLICT (base, quote, rate_date) DO UPDATE
+		SET rate = EXCLUDED.rate, source = EXCLUDED.source, updated_at = NOW()
+	`, base, quote, date, input.Rate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record exchange rate: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"rate":    fx.Rate{Base: base, Quote: quote, Date: date, Rate: input.Rate},
+		"message": "Exchange rate recorded successfully",
+	})
+}
+
+// refreshFXRatesNow loads rates from the source on demand. ?from= reloads
+// history from that date.
+func refreshFXRatesNow(c *gin.Context) {
+	var from *time.Time
+	if value := c.Query("from"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		from = &date
+	}
+
+	result, err := refreshFXRates(c.Request.Context(), from)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refresh exchange rates: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/lots"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/marketdata"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Transaction types recorded in the ledger
+const (
+	TransactionBuy      = "BUY"
+	TransactionSell     = "SELL"
+	TransactionDividend = "DIVIDEND"
+	TransactionFee      = "FEE"
+)
+
+// openingBalanceNote marks the buys that open lots for shares held before
+// the ledger recorded them
+const openingBalanceNote = "Opening balance"
+
+// Transaction is one entry in the investment ledger
+type Transaction struct {
+	ID           string           `json:"id"`
+	AccountID    string           `json:"account_id"`
+	AssetID      string           `json:"asset_id,omitempty"`
+	PositionID   string           `json:"position_id,omitempty"`
+	Type         string           `json:"type"`
+	Quantity     float64          `json:"quantity"`
+	Price        decimal.Decimal  `json:"price"`
+	Fees         decimal.Decimal  `json:"fees"`
+	Amount       decimal.Decimal  `json:"amount"`
+	Currency     string           `json:"currency"`
+	LotMethod    string           `json:"lot_method,omitempty"`
+	RealizedGain *decimal.Decimal `json:"realized_gain,omitempty"`
+	TradeDate    time.Time        `json:"trade_date"`
+	Notes        string           `json:"notes,omitempty"`
+	ExternalID   string           `json:"external_id,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	Disposals    []lots.Disposal  `json:"disposals,omitempty"`
+	// Duplicate is set when the external ID was already recorded and the
+	// earlier transaction is returned instead
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// TaxLot is a purchase lot held in a position
+type TaxLot struct {
+	ID                string          `json:"id"`
+	AccountID         string          `json:"account_id"`
+	AssetID           string          `json:"asset_id"`
+	PositionID        string          `json:"position_id"`
+	TransactionID     string          `json:"transaction_id"`
+	AcquiredAt        time.Time       `json:"acquired_at"`
+	Quantity          float64         `json:"quantity"`
+	RemainingQuantity float64         `json:"remaining_quantity"`
+	UnitCost          decimal.Decimal `json:"unit_cost"`
+	CostBasis         decimal.Decimal `json:"cost_basis"`
+	ClosedAt          *time.Time      `json:"closed_at,omitempty"`
+}
+
+// transactionInput is a transaction to record. The asset may be named by
+// symbol instead of ID; buys of an untracked symbol create the asset in the
+// account's currency. An external ID, such as an OFX FITID, is recorded once
+// per account.
+type transactionInput struct {
+	AccountID  string           `json:"account_id" binding:"required"`
+	AssetID    string           `json:"asset_id"`
+	Symbol     string           `json:"symbol"`
+	Name       string           `json:"name"`
+	ExternalID string           `json:"external_id"`
+	Type       string           `json:"type" binding:"required"`
+	Quantity   float64          `json:"quantity"`
+	Price      decimal.Decimal  `json:"price"`
+	Fees       decimal.Decimal  `json:"fees"`
+	Amount     decimal.Decimal  `json:"amount"`
+	Currency   string           `json:"currency"`
+	TradeDate  *time.Time       `json:"trade_date"`
+	LotMethod  string           `json:"lot_method"`
+	Lots       []lots.Selection `json:"lots"`
+	Notes      string           `json:"notes"`
+}
+
+// LedgerError is returned when a transaction cannot be recorded
+type LedgerError struct {
+	Status int
+	Reason string
+}
+
+func (e *LedgerError) Error() string {
+	return e.Reason
+}
+
+func badTransaction(format string, args ...interface{}) *LedgerError {
+	return &LedgerError{Status: http.StatusBadRequest, Reason: fmt.Sprintf(format, args...)}
+}
+
+// recordTransaction validates and records a transaction, updating the tax
+// lots and the position it affects. Buys open a lot, sells relieve lots by
+// the requested method and realize a gain, and dividends and fees are cash
+// entries that leave lots unchanged.
+func recordTransaction(ctx context.Context, tx pgx.Tx, in transactionInput) (*Transaction, error) {
+	t := &Transaction{
+		ID:         uuid.New().String(),
+		AccountID:  in.AccountID,
+		AssetID:    in.AssetID,
+		Type:       strings.ToUpper(strings.TrimSpace(in.Type)),
+		Notes:      in.Notes,
+		ExternalID: in.ExternalID,
+		TradeDate:  time.Now(),
+		CreatedAt:  time.Now(),
+	}
+	if in.TradeDate != nil {
+		t.TradeDate = *in.TradeDate
+	}
+	if in.Fees.IsNegative() {
+		return nil, badTransaction("fees cannot be negative")
+	}
+
+	switch t.Type {
+	case TransactionBuy, TransactionSell:
+		if in.AssetID == "" && in.Symbol == "" {
+			return nil, badTransaction("asset_id or symbol is required for %s transactions", t.Type)
+		}
+		if in.Quantity <= 0 {
+			return nil, badTransaction("quantity must be positive")
+		}
+		if in.Price.IsNegative() {
+			return nil, badTransaction("price cannot be negative")
+		}
+		t.Quantity, t.Price, t.Fees = in.Quantity, in.Price, in.Fees
+	case TransactionDividend, TransactionFee:
+		if t.Type == TransactionDividend && in.AssetID == "" && in.Symbol == "" {
+			return nil, badTransaction("asset_id or symbol is required for DIVIDEND transactions")
+		}
+		if !in.Amount.IsPositive() {
+			return nil, badTransaction("amount must be positive")
+		}
+		t.Amount = in.Amount
+	default:
+		return nil, badTransaction("unknown transaction type %q", in.Type)
+	}
+
+	var accountCurrency string
+	err := tx.QueryRow(ctx, `
+		SELECT balance_currency FROM accounts.accounts WHERE id = $1 AND is_active = true
+	`, in.AccountID).Scan(&accountCurrency)
+	if err == pgx.ErrNoRows {
+		return nil, badTransaction("Account not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if in.ExternalID != "" {
+		existing, err := scanTransaction(tx.QueryRow(ctx,
+			"SELECT "+transactionColumns+" FROM investments.transactions WHERE account_id = $1 AND external_id = $2",
+			in.AccountID, in.ExternalID))
+		if err == nil {
+			existing.Duplicate = true
+			return &existing, nil
+		}
+		if err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	if in.AssetID == "" && in.Symbol != "" {
+		if t.Type == TransactionBuy {
+			in.AssetID, _, err = findOrCreateAsset(ctx, tx, in.Symbol, in.Name, importedAssetClass, in.Price, accountCurrency)
+		} else {
+			err = tx.QueryRow(ctx, `
+				SELECT id FROM investments.assets WHERE symbol = $1
+			`, marketdata.NormalizeSymbol(in.Symbol)).Scan(&in.AssetID)
+		}
+		if err == pgx.ErrNoRows {
+			return nil, badTransaction("Asset %s not found", in.Symbol)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.AssetID = in.AssetID
+	}
+
+	if in.AssetID == "" {
+		t.Currency = accountCurrency
+		if in.Currency != "" && !strings.EqualFold(in.Currency, t.Currency) {
+			return nil, badTransaction("currency %s does not match the account's currency %s", in.Currency, t.Currency)
+		}
+		t.Amount = money.Round(t.Amount, t.Currency)
+		if err := insertTransaction(ctx, tx, t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT current_price_currency FROM investments.assets WHERE id = $1
+	`, in.AssetID).Scan(&t.Currency)
+	if err == pgx.ErrNoRows {
+		return nil, badTransaction("Asset not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if in.Currency != "" && !strings.EqualFold(in.Currency, t.Currency) {
+		return nil, badTransaction("currency %s does not match the asset's currency %s", in.Currency, t.Currency)
+	}
+	t.Fees = money.Round(t.Fees, t.Currency)
+	t.Amount = money.Round(t.Amount, t.Currency)
+
+	// Serialize transactions for the same holding so lots are relieved once
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1::text || ':' || $2::text))`, in.AccountID, in.AssetID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT id
+		FROM investments.positions
+		WHERE account_id = $1 AND asset_id = $2 AND is_open = true
+		ORDER BY purchase_date
+		LIMIT 1
+		FOR UPDATE
+	`, in.AccountID, in.AssetID).Scan(&t.PositionID)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	switch t.Type {
+	case TransactionBuy:
+		err = recordBuy(ctx, tx, t)
+	case TransactionSell:
+		err = recordSell(ctx, tx, t, in)
+	default:
+		err = insertTransaction(ctx, tx, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// recordBuy opens a lot for a purchase, opening a position if the account
+// does not hold the asset yet. Fees are part of the lot's cost.
+func recordBuy(ctx context.Context, tx pgx.Tx, t *Transaction) error {
+	if t.PositionID == "" {
+		t.PositionID = uuid.New().String()
+		_, err := tx.Exec(ctx, `
+			INSERT INTO investments.positions (
+				id, account_id, asset_id, quantity, cost_basis,
+				current_value, purchase_date, last_updated, is_open
+			) VALUES (
+				$1, $2, $3, 0, 0, 0, $4, $5, true
+			)
+		`, t.PositionID, t.AccountID, t.AssetID, t.TradeDate, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to open position: %w", err)
+		}
+	} else if err := openBalanceLot(ctx, tx, t); err != nil {
+		return err
+	}
+
+	t.Amount = money.Round(t.Price.Mul(decimal.NewFromFloat(t.Quantity)).Add(t.Fees), t.Currency)
+	if err := insertTransaction(ctx, tx, t); err != nil {
+		return err
+	}
+	if err := openLot(ctx, tx, t); err != nil {
+		return err
+	}
+
+	return syncPosition(ctx, tx, t.PositionID)
+}
+
+// openLot opens a tax lot for the shares a buy acquired, at the buy's cost
+func openLot(ctx context.Context, tx pgx.Tx, t *Transaction) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO investments.tax_lots (
+			id, account_id, asset_id, position_id, transaction_id,
+			acquired_at, quantity, remaining_quantity, unit_cost
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $7, $8
+		)
+	`, uuid.New().String(), t.AccountID, t.AssetID, t.PositionID, t.ID,
+		t.TradeDate, t.Quantity, t.Amount.DivRound(decimal.NewFromFloat(t.Quantity), 6))
+	if err != nil {
+		return fmt.Errorf("failed to open tax lot: %w", err)
+	}
+	return nil
+}
+
+// openBalanceLot records an opening buy and lot for the shares of a position
+// that has no lots yet, such as one synced from an aggregator, so that the
+// first transaction the ledger records adds to its shares rather than
+// replacing them
+func openBalanceLot(ctx context.Context, tx pgx.Tx, t *Transaction) error {
+	var quantity float64
+	var costBasis decimal.Decimal
+	var purchaseDate time.Time
+	var hasLots bool
+	err := tx.QueryRow(ctx, `
+		SELECT p.quantity, p.cost_basis, p.purchase_date,
+		       EXISTS(SELECT 1 FROM investments.tax_lots l WHERE l.position_id = p.id)
+		FROM investments.positions p
+		WHERE p.id = $1
+	`, t.PositionID).Scan(&quantity, &costBasis, &purchaseDate, &hasLots)
+	if err != nil {
+		return err
+	}
+	if hasLots || quantity <= 0 {
+		return nil
+	}
+
+	opening := &Transaction{
+		ID:         uuid.New().String(),
+		AccountID:  t.AccountID,
+		AssetID:    t.AssetID,
+		PositionID: t.PositionID,
+		Type:       TransactionBuy,
+		Quantity:   quantity,
+		Price:      costBasis.DivRound(decimal.NewFromFloat(quantity), 6),
+		Amount:     money.Round(costBasis, t.Currency),
+		Currency:   t.Currency,
+		TradeDate:  purchaseDate,
+		Notes:      openingBalanceNote,
+		CreatedAt:  time.Now(),
+	}
+	if err := insertTransaction(ctx, tx, opening); err != nil {
+		return err
+	}
+	return openLot(ctx, tx, opening)
+}
+
+// recordSell relieves lots for a sale and records the realized gain. Fees
+// reduce the proceeds.
+func recordSell(ctx context.Context, tx pgx.Tx, t *Transaction, in transactionInput) error {
+	if t.PositionID == "" {
+		return &LedgerError{Status: http.StatusConflict, Reason: "Account holds no open position in this asset"}
+	}
+	if err := openBalanceLot(ctx, tx, t); err != nil {
+		return err
+	}
+
+	method, err := lots.ParseMethod(in.LotMethod)
+	if err != nil {
+		return badTransaction("%s", err.Error())
+	}
+	t.LotMethod = string(method)
+
+	open, err := loadOpenLots(ctx, tx, t.PositionID)
+	if err != nil {
+		return err
+	}
+
+	t.Amount = money.Round(t.Price.Mul(decimal.NewFromFloat(t.Quantity)).Sub(t.Fees), t.Currency)
+	disposals, err := lots.Relieve(open, method, t.Quantity, money.New(t.Amount, t.Currency), in.Lots)
+	if errors.Is(err, lots.ErrInsufficientQuantity) {
+		return &LedgerError{Status: http.StatusConflict, Reason: "Position holds fewer shares than the quantity sold"}
+	}
+	if err != nil {
+		return badTransaction("%s", err.Error())
+	}
+
+	gain := lots.RealizedGain(disposals)
+	t.RealizedGain = &gain
+	t.Disposals = disposals
+	if err := insertTransaction(ctx, tx, t); err != nil {
+		return err
+	}
+
+	for _, d := range disposals {
+		_, err := tx.Exec(ctx, `
+			UPDATE investments.tax_lots
+			SET remaining_quantity = CASE WHEN remaining_quantity - $1 <= 0.000001 THEN 0 ELSE remaining_quantity - $1 END,
+			    closed_at = CASE WHEN remaining_quantity - $1 <= 0.000001 THEN $2 ELSE closed_at END
+			WHERE id = $3
+		`, d.Quantity, t.TradeDate, d.LotID)
+		if err != nil {
+			return fmt.Errorf("failed to relieve lot %s: %w", d.LotID, err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO investments.lot_disposals (
+				id, transaction_id, lot_id, quantity, proceeds, cost_basis,
+				realized_gain, acquired_at, disposed_at, split_factor
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9,
+				(SELECT split_factor FROM investments.tax_lots WHERE id = $3)
+			)
+		`, uuid.New().String(), t.ID, d.LotID, d.Quantity, d.Proceeds, d.CostBasis,
+			d.RealizedGain, d.AcquiredAt, t.TradeDate)
+		if err != nil {
+			return fmt.Errorf("failed to record lot disposal: %w", err)
+		}
+	}
+
+	return syncPosition(ctx, tx, t.PositionID)
+}
+
+// insertTransaction writes a transaction to the ledger
+func insertTransaction(ctx context.Context, tx pgx.Tx, t *Transaction) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO investments.transactions (
+			id, account_id, asset_id, position_id, type, quantity, price, fees,
+			amount, currency, lot_method, realized_gain, trade_date, notes, external_id, created_at
+		) VALUES (
+			$1, $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5, $6, $7, $8,
+			$9, $10, NULLIF($11, ''), $12, $13, $14, NULLIF($15, ''), $16
+		)
+	`, t.ID, t.AccountID, t.AssetID, t.PositionID, t.Type, t.Quantity, t.Price, t.Fees,
+		t.Amount, t.Currency, t.LotMethod, t.RealizedGain, t.TradeDate, t.Notes, t.ExternalID, t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record transaction: %w", err)
+	}
+	return nil
+}
+
+// loadOpenLots returns a position's lots that still hold shares, locked for update
+func loadOpenLots(ctx context.Context, tx pgx.Tx, positionID string) ([]lots.Lot, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, acquired_at, remaining_quantity, unit_cost
+		FROM investments.tax_lots
+		WHERE position_id = $1 AND remaining_quantity > 0
+		ORDER BY acquired_at, id
+		FOR UPDATE
+	`, positionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var open []lots.Lot
+	for rows.Next() {
+		var lot lots.Lot
+		if err := rows.Scan(&lot.ID, &lot.AcquiredAt, &lot.Quantity, &lot.UnitCost); err != nil {
+			return nil, err
+		}
+		open = append(open, lot)
+	}
+	return open, rows.Err()
+}
+
+// syncPosition derives a position's quantity and cost basis from its lots,
+// values it at the asset's current price and closes it once no shares remain.
+// Amounts are rounded to the minor unit of the asset's currency.
+func syncPosition(ctx context.Context, tx pgx.Tx, positionID string) error {
+	var currency string
+	err := tx.QueryRow(ctx, `
+		SELECT a.current_price_currency
+		FROM investments.positions p
+		JOIN investments.assets a ON a.id = p.asset_id
+		WHERE p.id = $1
+	`, positionID).Scan(&currency)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve position: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE investments.positions p
+		SET quantity = l.quantity,
+		    cost_basis = ROUND(l.cost_basis, $3),
+		    current_value = ROUND(l.quantity * a.current_price_amount, $3),
+		    is_open = l.quantity > 0,
+		    last_updated = $2
+		FROM (
+			SELECT COALESCE(SUM(remaining_quantity), 0) AS quantity,
+			       COALESCE(SUM(remaining_quantity * unit_cost), 0) AS cost_basis
+			FROM investments.tax_lots
+			WHERE position_id = $1
+		) l, investments.assets a
+		WHERE p.id = $1 AND a.id = p.asset_id
+	`, positionID, time.Now(), money.Places(currency))
+	if err != nil {
+		return fmt.Errorf("failed to update position: %w", err)
+	}
+	return nil
+}
+
+// respondToLedgerError writes the response for a transaction that could not be recorded
+func respondToLedgerError(c *gin.Context, err error) {
+	var ledgerErr *LedgerError
+	if errors.As(err, &ledgerErr) {
+		c.JSON(ledgerErr.Status, gin.H{"error": ledgerErr.Reason})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record transaction: " + err.Error()})
+}
+
+// commitTransaction records a transaction in its own database transaction
+func commitTransaction(ctx context.Context, in transactionInput) (*Transaction, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := recordTransaction(ctx, tx, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// createTransaction records a buy, sell, dividend or fee. A transaction whose
+// external ID is already recorded is returned unchanged with status 200.
+func createTransaction(c *gin.Context) {
+	var input transactionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := commitTransaction(c.Request.Context(), input)
+	if err != nil {
+		respondToLedgerError(c, err)
+		return
+	}
+	if t.Duplicate {
+		c.JSON(http.StatusOK, gin.H{
+			"id":          t.ID,
+			"message":     "Transaction already recorded",
+			"transaction": t,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":          t.ID,
+		"message":     "Transaction recorded successfully",
+		"transaction": t,
+	})
+}
+
+// transactionColumns are the columns scanned by scanTransaction
+const transactionColumns = `
+	id, account_id, COALESCE(asset_id::text, ''), COALESCE(position_id::text, ''), type,
+	quantity, price, fees, amount, currency, COALESCE(lot_method, ''), realized_gain,
+	trade_date, COALESCE(notes, ''), COALESCE(external_id, ''), created_at
+`
+
+// scanTransaction scans a row selected with transactionColumns
+func scanTransaction(row pgx.Row) (Transaction, error) {
+	var t Transaction
+	err := row.Scan(
+		&t.ID, &t.AccountID, &t.AssetID, &t.PositionID, &t.Type,
+		&t.Quantity, &t.Price, &t.Fees, &t.Amount, &t.Currency, &t.LotMethod, &t.RealizedGain,
+		&t.TradeDate, &t.Notes, &t.ExternalID, &t.CreatedAt,
+	)
+	return t, err
+}
+
+// listTransactions returns ledger entries, newest first, filtered by
+// account_id, asset_id, type and a from/to trade date range
+func listTransactions(c *gin.Context) {
+	queryTransactions(c, c.Query("account_id"))
+}
+
+// getAccountTransactions returns an account's ledger entries
+func getAccountTransactions(c *gin.Context) {
+	queryTransactions(c, c.Param("accountId"))
+}
+
+func queryTransactions(c *gin.Context, accountID string) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if accountID != "" {
+		add("account_id = $%d", accountID)
+	}
+	if assetID := c.Query("asset_id"); assetID != "" {
+		add("asset_id = $%d", assetID)
+	}
+	if txType := c.Query("type"); txType != "" {
+		add("type = $%d", strings.ToUpper(txType))
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"from", "trade_date >= $%d"},
+		{"to", "trade_date < $%d::date + 1"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s date, expected YYYY-MM-DD", bound.param)})
+			return
+		}
+		add(bound.condition, date)
+	}
+
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	query := "SELECT " + transactionColumns + " FROM investments.transactions"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY trade_date DESC, created_at DESC LIMIT %d", limit)
+
+	rows, err := db.Query(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan transaction data"})
+			return
+		}
+		transactions = append(transactions, t)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transactions": transactions})
+}
+
+// getTransactionByID returns a transaction with the lots a sale relieved
+func getTransactionByID(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	t, err := scanTransaction(db.QueryRow(ctx,
+		"SELECT "+transactionColumns+" FROM investments.transactions WHERE id = $1", c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT lot_id, acquired_at, quantity, cost_basis, proceeds, realized_gain
+		FROM investments.lot_disposals
+		WHERE transaction_id = $1
+		ORDER BY acquired_at, lot_id
+	`, t.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lot disposals"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d lots.Disposal
+		if err := rows.Scan(&d.LotID, &d.AcquiredAt, &d.Quantity, &d.CostBasis, &d.Proceeds, &d.RealizedGain); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan lot disposal data"})
+			return
+		}
+		t.Disposals = append(t.Disposals, d)
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+// getPositionLots returns a position's tax lots, open lots only unless all=true.
+// Cost basis is rounded to the minor unit of the asset's currency.
+func getPositionLots(c *gin.Context) {
+	query := `
+		SELECT l.id, l.account_id, l.asset_id, l.position_id, l.transaction_id, l.acquired_at,
+		       l.quantity, l.remaining_quantity, l.unit_cost,
+		       l.remaining_quantity * l.unit_cost, l.closed_at, a.current_price_currency
+		FROM investments.tax_lots l
+		JOIN investments.assets a ON a.id = l.asset_id
+		WHERE l.position_id = $1`
+	if c.Query("all") != "true" {
+		query += " AND l.remaining_quantity > 0"
+	}
+	query += " ORDER BY l.acquired_at, l.id"
+
+	rows, err := db.Query(c.Request.Context(), query, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tax lots"})
+		return
+	}
+	defer rows.Close()
+
+	taxLots := []TaxLot{}
+	for rows.Next() {
+		var lot TaxLot
+		var currency string
+		err := rows.Scan(
+			&lot.ID, &lot.AccountID, &lot.AssetID, &lot.PositionID, &lot.TransactionID, &lot.AcquiredAt,
+			&lot.Quantity, &lot.RemainingQuantity, &lot.UnitCost, &lot.CostBasis, &lot.ClosedAt, &currency,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan tax lot data"})
+			return
+		}
+		lot.CostBasis = money.Round(lot.CostBasis, currency)
+		taxLots = append(taxLots, lot)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lots": taxLots})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,38 +12,48 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/lots"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/marketdata"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
 )
 
 // Asset represents an investment asset
 type Asset struct {
-	ID           string    `json:"id"`
-	Symbol       string    `json:"symbol"`
-	Name         string    `json:"name"`
-	AssetClass   string    `json:"asset_class"`
-	CurrentPrice float64   `json:"current_price"`
-	Currency     string    `json:"currency"`
-	LastUpdated  time.Time `json:"last_updated"`
+	ID           string          `json:"id"`
+	Symbol       string          `json:"symbol"`
+	Name         string          `json:"name"`
+	AssetClass   string          `json:"asset_class"`
+	CurrentPrice decimal.Decimal `json:"current_price"`
+	Currency     string          `json:"currency"`
+	LastUpdated  time.Time       `json:"last_updated"`
 }
 
-// Position represents a user's investment position
+// Position represents a user's investment position. Money amounts are exact
+// decimals so gains reconcile with cost basis and value to the penny.
 type Position struct {
-	ID           string    `json:"id"`
-	AccountID    string    `json:"account_id"`
-	AssetID      string    `json:"asset_id"`
-	Quantity     float64   `json:"quantity"`
-	CostBasis    float64   `json:"cost_basis"`
-	Currency     string    `json:"currency"`
-	CurrentValue float64   `json:"current_value"`
-	PurchaseDate time.Time `json:"purchase_date"`
-	LastUpdated  time.Time `json:"last_updated"`
-	IsOpen       bool      `json:"is_open"`
-	Gains        float64   `json:"gains"`
-	GainPercent  float64   `json:"gain_percent"`
+	ID           string          `json:"id"`
+	AccountID    string          `json:"account_id"`
+	AssetID      string          `json:"asset_id"`
+	Quantity     float64         `json:"quantity"`
+	CostBasis    decimal.Decimal `json:"cost_basis"`
+	Currency     string          `json:"currency"`
+	CurrentValue decimal.Decimal `json:"current_value"`
+	PurchaseDate time.Time       `json:"purchase_date"`
+	LastUpdated  time.Time       `json:"last_updated"`
+	IsOpen       bool            `json:"is_open"`
+	Gains        decimal.Decimal `json:"gains"`
+	GainPercent  float64         `json:"gain_percent"`
 }
 
 var db *pgxpool.Pool
 
+// marketData supplies the prices assets are refreshed from
+var marketData marketdata.Provider
+
 func main() {
 	log.Println("Starting investment-service...")
 
@@ -61,6 +72,27 @@ func main() {
 	}
 	log.Println("Connected to database")
 
+	// Ensure the investments tables exist
+	if err := ensureInvestmentSchema(db); err != nil {
+		log.Fatalf("Failed to ensure investment schema: %v", err)
+	}
+
+	// Keep asset prices, position values and exchange rates current and
+	// record daily portfolio snapshots in the background
+	marketData, err = newMarketDataProvider()
+	if err != nil {
+		log.Fatalf("Unable to create market data provider: %v", err)
+	}
+	fxSource, err = newFXSource()
+	if err != nil {
+		log.Fatalf("Unable to create exchange rate source: %v", err)
+	}
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	go refreshPricesPeriodically(refreshCtx)
+	go refreshFXRatesPeriodically(refreshCtx)
+	go snapshotPortfoliosPeriodically(refreshCtx)
+
 	// Set up Gin router
 	router := gin.Default()
 
@@ -83,6 +115,7 @@ func main() {
 			assets.GET("/symbol/:symbol", getAssetBySymbol)
 			assets.POST("", createAsset)
 			assets.PUT("/:id", updateAsset)
+			assets.POST("/refresh-prices", refreshPrices)
 		}
 
 		// Positions
@@ -93,6 +126,15 @@ func main() {
 			positions.POST("", createPosition)
 			positions.PUT("/:id", updatePosition)
 			positions.DELETE("/:id", closePosition)
+			positions.GET("/:id/lots", getPositionLots)
+		}
+
+		// Transactions
+		transactions := v1.Group("/transactions")
+		{
+			transactions.GET("", listTransactions)
+			transactions.GET("/:id", getTransactionByID)
+			transactions.POST("", createTransaction)
 		}
 
 		// Account positions
@@ -100,6 +142,69 @@ func main() {
 		{
 			accountPositions.GET("", getAccountPositions)
 		}
+
+		// Account transactions
+		accountTransactions := v1.Group("/accounts/:accountId/transactions")
+		{
+			accountTransactions.GET("", getAccountTransactions)
+		}
+
+		// Gains tax reports
+		v1.GET("/accounts/:accountId/tax-report", getTaxReport(scopeAccount, "accountId"))
+		v1.GET("/trusts/:id/tax-report", getTaxReport(scopeTrust, "id"))
+		v1.GET("/users/:userId/tax-report", getTaxReport(scopeUser, "userId"))
+
+		// Performance
+		v1.GET("/accounts/:accountId/performance", getPerformance(scopeAccount, "accountId"))
+		v1.GET("/trusts/:id/performance", getPerformance(scopeTrust, "id"))
+		v1.GET("/users/:userId/performance", getPerformance(scopeUser, "userId"))
+		v1.POST("/performance/snapshots", createSnapshots)
+
+		// Exchange rate routes
+		fxRates := v1.Group("/fx-rates")
+		{
+			fxRates.GET("", listFXRates)
+			fxRates.POST("", createFXRate)
+			fxRates.POST("/refresh", refreshFXRatesNow)
+		}
+
+		// Model portfolios
+		modelPortfolios := v1.Group("/model-portfolios")
+		{
+			modelPortfolios.GET("", listModelPortfolios)
+			modelPortfolios.GET("/:id", getModelPortfolio)
+			modelPortfolios.POST("", createModelPortfolio)
+			modelPortfolios.PUT("/:id", updateModelPortfolio)
+			modelPortfolios.DELETE("/:id", deleteModelPortfolio)
+		}
+
+		// Model assignments and rebalancing
+		v1.GET("/users/:userId/model-portfolio", getModelAssignment(scopeUser, "userId"))
+		v1.PUT("/users/:userId/model-portfolio", assignModelPortfolio(scopeUser, "userId"))
+		v1.GET("/users/:userId/rebalance", getRebalanceProposal(scopeUser, "userId"))
+		v1.GET("/trusts/:id/model-portfolio", getModelAssignment(scopeTrust, "id"))
+		v1.PUT("/trusts/:id/model-portfolio", assignModelPortfolio(scopeTrust, "id"))
+		v1.GET("/trusts/:id/rebalance", getRebalanceProposal(scopeTrust, "id"))
+
+		// Corporate action routes
+		corporateActions := v1.Group("/corporate-actions")
+		{
+			corporateActions.GET("", listCorporateActions)
+			corporateActions.GET("/:id", getCorporateAction)
+			corporateActions.POST("", createCorporateAction)
+			corporateActions.POST("/import", importCorporateActions)
+		}
+
+		// CSV imports
+		importProfiles := v1.Group("/import-profiles")
+		{
+			importProfiles.GET("", listImportProfiles)
+			importProfiles.GET("/:id", getImportProfile)
+			importProfiles.POST("", createImportProfile)
+			importProfiles.PUT("/:id", updateImportProfile)
+			importProfiles.DELETE("/:id", deleteImportProfile)
+		}
+		v1.POST("/accounts/:accountId/imports/csv", importCSV)
 	}
 
 	// Start server
@@ -120,6 +225,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down investment-service...")
+	stopRefresh()
 
 	// Give the server 5 seconds to finish ongoing requests
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -208,17 +314,21 @@ func getAssetBySymbol(c *gin.Context) {
 
 func createAsset(c *gin.Context) {
 	var input struct {
-		Symbol     string  `json:"symbol" binding:"required"`
-		Name       string  `json:"name" binding:"required"`
-		AssetClass string  `json:"asset_class" binding:"required"`
-		Price      float64 `json:"price" binding:"required"`
-		Currency   string  `json:"currency"`
+		Symbol     string           `json:"symbol" binding:"required"`
+		Name       string           `json:"name" binding:"required"`
+		AssetClass string           `json:"asset_class" binding:"required"`
+		Price      *decimal.Decimal `json:"price" binding:"required"`
+		Currency   string           `json:"currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.Price.IsNegative() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price must not be negative"})
+		return
+	}
 
 	// Check if asset with symbol already exists
 	var exists bool
@@ -244,7 +354,7 @@ func createAsset(c *gin.Context) {
 		) VALUES (
 			$1, $2, $3, $4, $5, $6, $7
 		)
-	`, id, input.Symbol, input.Name, input.AssetClass, input.Price, currency, time.Now())
+	`, id, input.Symbol, input.Name, input.AssetClass, *input.Price, currency, time.Now())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create asset: " + err.Error()})
@@ -261,16 +371,20 @@ func updateAsset(c *gin.Context) {
 	id := c.Param("id")
 
 	var input struct {
-		Name       string  `json:"name"`
-		AssetClass string  `json:"asset_class"`
-		Price      float64 `json:"price"`
-		Currency   string  `json:"currency"`
+		Name       string           `json:"name"`
+		AssetClass string           `json:"asset_class"`
+		Price      *decimal.Decimal `json:"price"`
+		Currency   string           `json:"currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.Price != nil && !input.Price.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price must be positive"})
+		return
+	}
 
 	// Check if asset exists
 	var exists bool
@@ -289,7 +403,7 @@ func updateAsset(c *gin.Context) {
 		SET 
 			name = COALESCE(NULLIF($1, ''), name),
 			asset_class = COALESCE(NULLIF($2, ''), asset_class),
-			current_price_amount = CASE WHEN $3 > 0 THEN $3 ELSE current_price_amount END,
+			current_price_amount = COALESCE($3, current_price_amount),
 			current_price_currency = COALESCE(NULLIF($4, ''), current_price_currency),
 			last_updated = $5
 		WHERE id = $6
@@ -303,6 +417,37 @@ func updateAsset(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Asset updated successfully"})
 }
 
+// findOrCreateAsset returns the ID of the asset with a symbol, adding it to
+// the asset master when it is not tracked yet. New assets are named after
+// their symbol unless a name is given. It also reports whether the asset was
+// created.
+func findOrCreateAsset(ctx context.Context, tx pgx.Tx, symbol, name, assetClass string, price decimal.Decimal, currency string) (string, bool, error) {
+	symbol = marketdata.NormalizeSymbol(symbol)
+
+	var id string
+	err := tx.QueryRow(ctx, `SELECT id FROM investments.assets WHERE symbol = $1`, symbol).Scan(&id)
+	if err != pgx.ErrNoRows {
+		return id, false, err
+	}
+
+	if name == "" {
+		name = symbol
+	}
+	id = uuid.New().String()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO investments.assets (
+			id, symbol, name, asset_class, current_price_amount,
+			current_price_currency, last_updated
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		)
+	`, id, symbol, name, assetClass, price, currency, time.Now())
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create asset %s: %w", symbol, err)
+	}
+	return id, true, nil
+}
+
 // Positions handlers
 func listPositions(c *gin.Context) {
 	var positions []Position
@@ -398,13 +543,17 @@ func getAccountPositions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"positions": positions})
 }
 
+// createPosition records a purchase. The shares are added to the account's
+// open position in the asset, which is opened if the account holds none.
 func createPosition(c *gin.Context) {
 	var input struct {
-		AccountID string  `json:"account_id" binding:"required"`
-		AssetID   string  `json:"asset_id" binding:"required"`
-		Quantity  float64 `json:"quantity" binding:"required"`
-		Price     float64 `json:"price" binding:"required"`
-		Currency  string  `json:"currency"`
+		AccountID string           `json:"account_id" binding:"required"`
+		AssetID   string           `json:"asset_id" binding:"required"`
+		Quantity  float64          `json:"quantity" binding:"required"`
+		Price     *decimal.Decimal `json:"price" binding:"required"`
+		Fees      decimal.Decimal  `json:"fees"`
+		TradeDate *time.Time       `json:"trade_date"`
+		Currency  string           `json:"currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -412,64 +561,39 @@ func createPosition(c *gin.Context) {
 		return
 	}
 
-	// Check if account exists
-	var accountExists bool
-	err := db.QueryRow(context.Background(), `
-		SELECT EXISTS(SELECT 1 FROM accounts.accounts WHERE id = $1 AND is_active = true)
-	`, input.AccountID).Scan(&accountExists)
-
-	if err != nil || !accountExists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Account not found"})
-		return
-	}
-
-	// Check if asset exists
-	var assetExists bool
-	err = db.QueryRow(context.Background(), `
-		SELECT EXISTS(SELECT 1 FROM investments.assets WHERE id = $1)
-	`, input.AssetID).Scan(&assetExists)
-
-	if err != nil || !assetExists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Asset not found"})
-		return
-	}
-
-	id := uuid.New().String()
-	currency := input.Currency
-	if currency == "" {
-		currency = "USD"
-	}
-
-	// Calculate total cost
-	costBasis := input.Quantity * input.Price
-
-	// Create position
-	_, err = db.Exec(context.Background(), `
-		INSERT INTO investments.positions (
-			id, account_id, asset_id, quantity, cost_basis,
-			current_value, purchase_date, last_updated, is_open
-		) VALUES (
-			$1, $2, $3, $4, $5, $5, $6, $6, $7
-		)
-	`, id, input.AccountID, input.AssetID, input.Quantity, costBasis, time.Now(), true)
-
+	t, err := commitTransaction(c.Request.Context(), transactionInput{
+		AccountID: input.AccountID,
+		AssetID:   input.AssetID,
+		Type:      TransactionBuy,
+		Quantity:  input.Quantity,
+		Price:     *input.Price,
+		Fees:      input.Fees,
+		Currency:  input.Currency,
+		TradeDate: input.TradeDate,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create position: " + err.Error()})
+		respondToLedgerError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"id":      id,
-		"message": "Position created successfully",
+		"id":             t.PositionID,
+		"transaction_id": t.ID,
+		"message":        "Position created successfully",
 	})
 }
 
+// updatePosition overrides the current value of a position without tax lots,
+// such as one synced from an aggregator. Positions kept by the ledger are
+// valued at the asset's current price, and their quantities change only
+// through transactions.
 func updatePosition(c *gin.Context) {
 	id := c.Param("id")
+	ctx := c.Request.Context()
 
 	var input struct {
-		Quantity     float64 `json:"quantity"`
-		CurrentValue float64 `json:"current_value"`
+		Quantity     float64          `json:"quantity"`
+		CurrentValue *decimal.Decimal `json:"current_value"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -477,26 +601,39 @@ func updatePosition(c *gin.Context) {
 		return
 	}
 
-	// Check if position exists
-	var exists bool
-	err := db.QueryRow(context.Background(), `
-		SELECT EXISTS(SELECT 1 FROM investments.positions WHERE id = $1 AND is_open = true)
-	`, id).Scan(&exists)
+	if input.Quantity != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Position quantity is derived from its tax lots; record a BUY or SELL transaction instead"})
+		return
+	}
+	if input.CurrentValue == nil || !input.CurrentValue.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "current_value must be positive"})
+		return
+	}
 
-	if err != nil || !exists {
+	var currency string
+	var hasLots bool
+	err := db.QueryRow(ctx, `
+		SELECT a.current_price_currency,
+		       EXISTS(SELECT 1 FROM investments.tax_lots l WHERE l.position_id = p.id)
+		FROM investments.positions p
+		JOIN investments.assets a ON a.id = p.asset_id
+		WHERE p.id = $1 AND p.is_open = true
+	`, id).Scan(&currency, &hasLots)
+
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Position not found"})
 		return
 	}
+	if hasLots {
+		c.JSON(http.StatusConflict, gin.H{"error": "Position is valued from its tax lots at the asset's current price; update the asset's price instead"})
+		return
+	}
 
-	// Update position
-	_, err = db.Exec(context.Background(), `
+	_, err = db.Exec(ctx, `
 		UPDATE investments.positions
-		SET 
-			quantity = CASE WHEN $1 > 0 THEN $1 ELSE quantity END,
-			current_value = CASE WHEN $2 > 0 THEN $2 ELSE current_value END,
-			last_updated = $3
-		WHERE id = $4 AND is_open = true
-	`, input.Quantity, input.CurrentValue, time.Now(), id)
+		SET current_value = $1, last_updated = $2
+		WHERE id = $3 AND is_open = true
+	`, money.Round(*input.CurrentValue, currency), time.Now(), id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update position: " + err.Error()})
@@ -506,25 +643,560 @@ func updatePosition(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Position updated successfully"})
 }
 
+// closePosition sells every remaining share in a position and returns the
+// sale with its realized gain. The optional body sets the sale price (the
+// asset's current price by default), fees, trade date and lot method.
 func closePosition(c *gin.Context) {
 	id := c.Param("id")
+	ctx := c.Request.Context()
 
-	result, err := db.Exec(context.Background(), `
-		UPDATE investments.positions
-		SET is_open = false, last_updated = $1
-		WHERE id = $2 AND is_open = true
-	`, time.Now(), id)
+	var input struct {
+		Price     *decimal.Decimal `json:"price"`
+		Fees      decimal.Decimal  `json:"fees"`
+		TradeDate *time.Time       `json:"trade_date"`
+		LotMethod string           `json:"lot_method"`
+		Lots      []lots.Selection `json:"lots"`
+		Notes     string           `json:"notes"`
+	}
+
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var position struct {
+		AccountID    string
+		AssetID      string
+		Quantity     float64
+		CurrentPrice decimal.Decimal
+	}
+	err := db.QueryRow(ctx, `
+		SELECT p.account_id, p.asset_id, p.quantity, a.current_price_amount
+		FROM investments.positions p
+		JOIN investments.assets a ON a.id = p.asset_id
+		WHERE p.id = $1 AND p.is_open = true
+	`, id).Scan(&position.AccountID, &position.AssetID, &position.Quantity, &position.CurrentPrice)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close position: " + err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Position not found"})
 		return
 	}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Position not found"})
+	price := position.CurrentPrice
+	if input.Price != nil {
+		price = *input.Price
+	}
+	notes := input.Notes
+	if notes == "" {
+		notes = "Position closed"
+	}
+
+	t, err := commitTransaction(ctx, transactionInput{
+		AccountID: position.AccountID,
+		AssetID:   position.AssetID,
+		Type:      TransactionSell,
+		Quantity:  position.Quantity,
+		Price:     price,
+		Fees:      input.Fees,
+		TradeDate: input.TradeDate,
+		LotMethod: input.LotMethod,
+		Lots:      input.Lots,
+		Notes:     notes,
+	})
+	if err != nil {
+		respondToLedgerError(c, err)
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Position closed successfully",
+		"transaction": t,
+	})
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// ensureInvestmentSchema ensures the tables owned by investment-service exist
+func ensureInvestmentSchema(db *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	// Create the assets table if it doesn't exist
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.assets (
+			id UUID PRIMARY KEY,
+			symbol VARCHAR(20) NOT NULL UNIQUE,
+			name VARCHAR(255) NOT NULL,
+			asset_class VARCHAR(50) NOT NULL,
+			current_price_amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			current_price_currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			last_updated TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the positions table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.positions (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			asset_id UUID NOT NULL REFERENCES investments.assets(id),
+			quantity DECIMAL(19, 6) NOT NULL,
+			cost_basis DECIMAL(19, 4) NOT NULL,
+			current_value DECIMAL(19, 4) NOT NULL,
+			purchase_date TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			last_updated TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			is_open BOOLEAN NOT NULL DEFAULT TRUE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Price refreshes look up the assets held in open positions
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_investment_positions_open_asset_id ON investments.positions(asset_id) WHERE is_open = true")
+	if err != nil {
+		return err
+	}
+
+	// Create the transactions ledger if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.transactions (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			asset_id UUID REFERENCES investments.assets(id),
+			position_id UUID REFERENCES investments.positions(id),
+			type VARCHAR(20) NOT NULL,
+			quantity DECIMAL(19, 6) NOT NULL DEFAULT 0,
+			price DECIMAL(19, 6) NOT NULL DEFAULT 0,
+			fees DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			lot_method VARCHAR(20),
+			realized_gain DECIMAL(19, 4),
+			trade_date TIMESTAMP WITH TIME ZONE NOT NULL,
+			notes TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the tax lots table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.tax_lots (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			asset_id UUID NOT NULL REFERENCES investments.assets(id),
+			position_id UUID NOT NULL REFERENCES investments.positions(id),
+			transaction_id UUID NOT NULL REFERENCES investments.transactions(id),
+			acquired_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			quantity DECIMAL(19, 6) NOT NULL,
+			remaining_quantity DECIMAL(19, 6) NOT NULL,
+			unit_cost DECIMAL(19, 6) NOT NULL,
+			closed_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the lot disposals table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.lot_disposals (
+			id UUID PRIMARY KEY,
+			transaction_id UUID NOT NULL REFERENCES investments.transactions(id),
+			lot_id UUID NOT NULL REFERENCES investments.tax_lots(id),
+			quantity DECIMAL(19, 6) NOT NULL,
+			proceeds DECIMAL(19, 4) NOT NULL,
+			cost_basis DECIMAL(19, 4) NOT NULL,
+			realized_gain DECIMAL(19, 4) NOT NULL,
+			acquired_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			disposed_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Ledger entries and disposals are never edited once recorded
+	_, err = db.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION investments.reject_ledger_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION '% is append-only', TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME;
+		END;
+		$$ LANGUAGE plpgsql
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'transactions_append_only'
+			) THEN
+				CREATE TRIGGER transactions_append_only
+				BEFORE UPDATE OR DELETE ON investments.transactions
+				FOR EACH ROW EXECUTE FUNCTION investments.reject_ledger_change();
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'lot_disposals_append_only'
+			) THEN
+				CREATE TRIGGER lot_disposals_append_only
+				BEFORE UPDATE OR DELETE ON investments.lot_disposals
+				FOR EACH ROW EXECUTE FUNCTION investments.reject_ledger_change();
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return err
+	}
+
+	// One-time schema migrations are recorded so they run once
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.schema_migrations (
+			name VARCHAR(100) PRIMARY KEY,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Positions opened before the ledger existed get an opening buy and a
+	// single lot for their current quantity and cost basis. This runs once:
+	// positions opened since are either kept by the ledger or synced and
+	// imported without lots until the ledger first trades them.
+	_, err = db.Exec(ctx, `
+		WITH migrated AS (
+			INSERT INTO investments.schema_migrations (name)
+			VALUES ('opening_balance_lots')
+			ON CONFLICT (name) DO NOTHING
+			RETURNING name
+		), opened AS (
+			INSERT INTO investments.transactions (
+				id, account_id, asset_id, position_id, type, quantity, price,
+				amount, currency, trade_date, notes
+			)
+			SELECT gen_random_uuid(), p.account_id, p.asset_id, p.id, 'BUY', p.quantity,
+			       p.cost_basis / p.quantity, p.cost_basis, a.current_price_currency,
+			       p.purchase_date, 'Opening balance'
+			FROM investments.positions p
+			JOIN investments.assets a ON a.id = p.asset_id
+			WHERE EXISTS (SELECT 1 FROM migrated)
+			  AND p.is_open = true AND p.quantity > 0
+			  AND NOT EXISTS (SELECT 1 FROM investments.tax_lots l WHERE l.position_id = p.id)
+			RETURNING id, account_id, asset_id, position_id, trade_date, quantity, price
+		)
+		INSERT INTO investments.tax_lots (
+			id, account_id, asset_id, position_id, transaction_id,
+			acquired_at, quantity, remaining_quantity, unit_cost
+		)
+		SELECT gen_random_uuid(), account_id, asset_id, position_id, id,
+		       trade_date, quantity, quantity, price
+		FROM opened
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the daily snapshot tables used for performance if they don't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.portfolio_snapshots (
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			snapshot_date DATE NOT NULL,
+			market_value DECIMAL(19, 4) NOT NULL,
+			cost_basis DECIMAL(19, 4) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (account_id, snapshot_date)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.asset_prices (
+			asset_id UUID NOT NULL REFERENCES investments.assets(id),
+			price_date DATE NOT NULL,
+			price DECIMAL(19, 6) NOT NULL,
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			PRIMARY KEY (asset_id, price_date)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Snapshots are valued in their account's currency
+	_, err = db.Exec(ctx, "ALTER TABLE investments.portfolio_snapshots ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD'")
+	if err != nil {
+		return err
+	}
+
+	// Create the daily exchange rate table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.fx_rates (
+			base VARCHAR(3) NOT NULL,
+			quote VARCHAR(3) NOT NULL,
+			rate_date DATE NOT NULL,
+			rate DECIMAL(19, 10) NOT NULL,
+			source VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (base, quote, rate_date)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the model portfolio tables if they don't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.model_portfolios (
+			id UUID PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			description TEXT,
+			risk_profile VARCHAR(50),
+			drift_tolerance DECIMAL(5, 2) NOT NULL DEFAULT 5,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.model_allocations (
+			id UUID PRIMARY KEY,
+			model_id UUID NOT NULL REFERENCES investments.model_portfolios(id),
+			target_type VARCHAR(20) NOT NULL,
+			target VARCHAR(100) NOT NULL,
+			weight DECIMAL(7, 4) NOT NULL,
+			tolerance DECIMAL(5, 2),
+			buy_symbol VARCHAR(20)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.model_assignments (
+			scope_type VARCHAR(20) NOT NULL,
+			scope_id UUID NOT NULL,
+			model_id UUID NOT NULL REFERENCES investments.model_portfolios(id),
+			assigned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (scope_type, scope_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Each risk profile maps to at most one active model
+	_, err = db.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_investment_model_portfolios_risk_profile ON investments.model_portfolios(risk_profile) WHERE is_active = true AND risk_profile IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	// Start with a stock and bond model for each risk profile
+	_, err = db.Exec(ctx, `
+		WITH defaults (risk_profile, name, equity) AS (
+			VALUES
+				('conservative', 'Conservative', 30),
+				('moderately_conservative', 'Moderately Conservative', 45),
+				('moderate', 'Moderate', 60),
+				('moderately_aggressive', 'Moderately Aggressive', 75),
+				('aggressive', 'Aggressive', 90)
+		), models AS (
+			INSERT INTO investments.model_portfolios (id, name, description, risk_profile)
+			SELECT gen_random_uuid(), d.name,
+			       d.equity || '% US stocks, ' || (100 - d.equity) || '% US bonds', d.risk_profile
+			FROM defaults d
+			WHERE NOT EXISTS (SELECT 1 FROM investments.model_portfolios)
+			RETURNING id, risk_profile
+		)
+		INSERT INTO investments.model_allocations (id, model_id, target_type, target, weight, buy_symbol)
+		SELECT gen_random_uuid(), m.id, 'ASSET_CLASS', a.target, a.weight, a.buy_symbol
+		FROM models m
+		JOIN defaults d ON d.risk_profile = m.risk_profile
+		CROSS JOIN LATERAL (
+			VALUES ('EQUITY', d.equity, 'VTI'), ('FIXED_INCOME', 100 - d.equity, 'BND')
+		) a (target, weight, buy_symbol)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the corporate action tables if they don't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.corporate_actions (
+			id UUID PRIMARY KEY,
+			type VARCHAR(20) NOT NULL,
+			asset_id UUID NOT NULL REFERENCES investments.assets(id),
+			symbol VARCHAR(20) NOT NULL,
+			new_asset_id UUID REFERENCES investments.assets(id),
+			new_symbol VARCHAR(20),
+			ratio DECIMAL(19, 8),
+			cash_per_share DECIMAL(19, 6),
+			new_price DECIMAL(19, 6),
+			effective_date DATE NOT NULL,
+			pay_date DATE,
+			description TEXT NOT NULL,
+			source VARCHAR(10) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.corporate_action_adjustments (
+			id UUID PRIMARY KEY,
+			action_id UUID NOT NULL REFERENCES investments.corporate_actions(id),
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			position_id UUID NOT NULL REFERENCES investments.positions(id),
+			lot_id UUID REFERENCES investments.tax_lots(id),
+			new_position_id UUID REFERENCES investments.positions(id),
+			new_lot_id UUID REFERENCES investments.tax_lots(id),
+			transaction_id UUID REFERENCES investments.transactions(id),
+			quantity_before DECIMAL(19, 6) NOT NULL,
+			quantity_after DECIMAL(19, 6) NOT NULL,
+			cost_basis_before DECIMAL(19, 4),
+			cost_basis_after DECIMAL(19, 4),
+			cash_amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Corporate actions and their adjustments are an audit trail
+	_, err = db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'corporate_actions_append_only'
+			) THEN
+				CREATE TRIGGER corporate_actions_append_only
+				BEFORE UPDATE OR DELETE ON investments.corporate_actions
+				FOR EACH ROW EXECUTE FUNCTION investments.reject_ledger_change();
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'corporate_action_adjustments_append_only'
+			) THEN
+				CREATE TRIGGER corporate_action_adjustments_append_only
+				BEFORE UPDATE OR DELETE ON investments.corporate_action_adjustments
+				FOR EACH ROW EXECUTE FUNCTION investments.reject_ledger_change();
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Splits scale a lot's shares but not the disposals recorded before them,
+	// so each lot keeps its cumulative split factor and each disposal the
+	// factor its lot had when it was recorded
+	_, err = db.Exec(ctx, "ALTER TABLE investments.tax_lots ADD COLUMN IF NOT EXISTS split_factor DECIMAL(19, 10) NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "ALTER TABLE investments.lot_disposals ADD COLUMN IF NOT EXISTS split_factor DECIMAL(19, 10) NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+
+	// Lots converted from another asset in a merger are held from the merger,
+	// though they keep the original acquisition date
+	_, err = db.Exec(ctx, "ALTER TABLE investments.tax_lots ADD COLUMN IF NOT EXISTS converted_at TIMESTAMP WITH TIME ZONE")
+	if err != nil {
+		return err
+	}
+
+	// Transactions imported from an institution keep its ID for them, such as
+	// an OFX FITID, so importing them again records them once
+	_, err = db.Exec(ctx, "ALTER TABLE investments.transactions ADD COLUMN IF NOT EXISTS external_id VARCHAR(255)")
+	if err != nil {
+		return err
+	}
+
+	// Create the import profiles table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS investments.import_profiles (
+			id UUID PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			description TEXT,
+			kind VARCHAR(20) NOT NULL,
+			mapping JSONB NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create indexes
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_investment_transactions_account_id ON investments.transactions(account_id, trade_date)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_investment_transactions_external_id ON investments.transactions(account_id, external_id) WHERE external_id IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_investment_tax_lots_position_id ON investments.tax_lots(position_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_investment_lot_disposals_transaction_id ON investments.lot_disposals(transaction_id)")
+	if err != nil {
+		return err
+	}
+
+	// An action is applied once per asset, type and effective date
+	_, err = db.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_investment_corporate_actions_asset_type_date ON investments.corporate_actions(asset_id, type, effective_date)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_investment_corporate_action_adjustments_action_id ON investments.corporate_action_adjustments(action_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS idx_investment_import_profiles_name ON investments.import_profiles(lower(name))")
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/rebalance"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/risk"
+)
+
+// defaultDriftTolerance is the tolerance band, in percentage points, of
+// models that do not set one
+const defaultDriftTolerance = 5.0
+
+// ModelPortfolio is a target allocation for investors with a risk profile
+type ModelPortfolio struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	RiskProfile    string            `json:"risk_profile,omitempty"`
+	DriftTolerance float64           `json:"drift_tolerance"`
+	IsActive       bool              `json:"is_active"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Allocations    []ModelAllocation `json:"allocations"`
+}
+
+// ModelAllocation is one target of a model portfolio. Weights and
+// tolerances are percentages.
+type ModelAllocation struct {
+	TargetType string   `json:"target_type" binding:"required"`
+	Target     string   `json:"target" binding:"required"`
+	Weight     float64  `json:"weight" binding:"required"`
+	Tolerance  *float64 `json:"tolerance,omitempty"`
+	BuySymbol  string   `json:"buy_symbol,omitempty"`
+}
+
+// modelPortfolioInput is the body of model portfolio create and update requests
+type modelPortfolioInput struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	RiskProfile    string            `json:"risk_profile"`
+	DriftTolerance float64           `json:"drift_tolerance"`
+	Allocations    []ModelAllocation `json:"allocations"`
+}
+
+var (
+	errModelNotFound      = errors.New("model portfolio not found")
+	errRiskProfileInUse   = errors.New("another active model portfolio already uses this risk profile")
+	errNoModelForInvestor = errors.New("no model portfolio applies")
+	errUnknownRiskProfile = errors.New("unknown risk profile")
+)
+
+// validateAllocations checks that allocations name known target types, have
+// positive weights and add up to 100%
+func validateAllocations(allocations []ModelAllocation) error {
+	if len(allocations) == 0 {
+		return errors.New("at least one allocation is required")
+	}
+
+	total := 0.0
+	seen := make(map[string]bool)
+	for i := range allocations {
+		a := &allocations[i]
+		a.TargetType = strings.ToUpper(strings.TrimSpace(a.TargetType))
+		a.Target = strings.TrimSpace(a.Target)
+		a.BuySymbol = strings.ToUpper(strings.TrimSpace(a.BuySymbol))
+		if a.TargetType != rebalance.KindAssetClass && a.TargetType != rebalance.KindSymbol {
+			return fmt.Errorf("target_type must be %s or %s", rebalance.KindAssetClass, rebalance.KindSymbol)
+		}
+		if a.TargetType == rebalance.KindSymbol {
+			a.Target = strings.ToUpper(a.Target)
+		}
+		if a.Target == "" {
+			return errors.New("allocation target is required")
+		}
+		if a.Weight <= 0 || a.Weight > 100 {
+			return fmt.Errorf("weight of %s must be between 0 and 100", a.Target)
+		}
+		if a.Tolerance != nil && (*a.Tolerance < 0 || *a.Tolerance > 100) {
+			return fmt.Errorf("tolerance of %s must be between 0 and 100", a.Target)
+		}
+		key := a.TargetType + ":" + strings.ToUpper(a.Target)
+		if seen[key] {
+			return fmt.Errorf("%s is allocated more than once", a.Target)
+		}
+		seen[key] = true
+		total += a.Weight
+	}
+	if math.Abs(total-100) > 0.01 {
+		return fmt.Errorf("allocation weights add up to %g%%, not 100%%", total)
+	}
+	return nil
+}
+
+// checkRiskProfile normalizes a model's risk profile and checks no other
+// active model uses it
+func checkRiskProfile(ctx context.Context, tx pgx.Tx, profile, modelID string) (string, error) {
+	if profile == "" {
+		return "", nil
+	}
+	normalized := risk.Normalize(profile)
+	if normalized == "" {
+		return "", fmt.Errorf("%w %q; expected one of %s", errUnknownRiskProfile, profile, strings.Join(risk.Profiles, ", "))
+	}
+
+	var taken bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM investments.model_portfolios
+			WHERE risk_profile = $1 AND is_active = true AND id::text != $2
+		)
+	`, normalized, modelID).Scan(&taken)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", errRiskProfileInUse
+	}
+	return normalized, nil
+}
+
+// replaceAllocations sets a model's allocations
+func replaceAllocations(ctx context.Context, tx pgx.Tx, modelID string, allocations []ModelAllocation) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM investments.model_allocations WHERE model_id = $1`, modelID); err != nil {
+		return err
+	}
+	for _, a := range allocations {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO investments.model_allocations (
+				id, model_id, target_type, target, weight, tolerance, buy_symbol
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, NULLIF($7, '')
+			)
+		`, uuid.New().String(), modelID, a.TargetType, a.Target, a.Weight, a.Tolerance, a.BuySymbol)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadModelPortfolio returns an active model portfolio with its allocations
+func loadModelPortfolio(ctx context.Context, modelID string) (*ModelPortfolio, error) {
+	var m ModelPortfolio
+	err := db.QueryRow(ctx, `
+		SELECT id, name, COALESCE(description, ''), COALESCE(risk_profile, ''),
+		       drift_tolerance, is_active, created_at, updated_at
+		FROM investments.model_portfolios
+		WHERE id = $1 AND is_active = true
+	`, modelID).Scan(
+		&m.ID, &m.Name, &m.Description, &m.RiskProfile,
+		&m.DriftTolerance, &m.IsActive, &m.CreatedAt, &m.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, errModelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT target_type, target, weight, tolerance, COALESCE(buy_symbol, '')
+		FROM investments.model_allocations
+		WHERE model_id = $1
+		ORDER BY weight DESC, target
+	`, modelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m.Allocations = []ModelAllocation{}
+	for rows.Next() {
+		var a ModelAllocation
+		if err := rows.Scan(&a.TargetType, &a.Target, &a.Weight, &a.Tolerance, &a.BuySymbol); err != nil {
+			return nil, err
+		}
+		m.Allocations = append(m.Allocations, a)
+	}
+	return &m, rows.Err()
+}
+
+// respondToModelError writes the response for a failed model portfolio request
+func respondToModelError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errModelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Model portfolio not found"})
+	case errors.Is(err, errUnknownRiskProfile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errRiskProfileInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Another active model portfolio already uses this risk profile"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save model portfolio: " + err.Error()})
+	}
+}
+
+// listModelPortfolios returns active model portfolios, optionally for one risk profile
+func listModelPortfolios(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := db.Query(ctx, `
+		SELECT id
+		FROM investments.model_portfolios
+		WHERE is_active = true AND ($1 = '' OR risk_profile = $1)
+		ORDER BY name
+	`, risk.Normalize(c.Query("risk_profile")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve model portfolios"})
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan model portfolio data"})
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	models := []ModelPortfolio{}
+	for _, id := range ids {
+		m, err := loadModelPortfolio(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve model portfolios"})
+			return
+		}
+		models = append(models, *m)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"model_portfolios": models})
+}
+
+// getModelPortfolio returns one model portfolio
+func getModelPortfolio(c *gin.Context) {
+	m, err := loadModelPortfolio(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
+// createModelPortfolio creates a model portfolio with its allocations
+func createModelPortfolio(c *gin.Context) {
+	var input modelPortfolioInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if err := validateAllocations(input.Allocations); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.DriftTolerance < 0 || input.DriftTolerance > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "drift_tolerance must be between 0 and 100"})
+		return
+	}
+	if input.DriftTolerance == 0 {
+		input.DriftTolerance = defaultDriftTolerance
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	profile, err := checkRiskProfile(ctx, tx, input.RiskProfile, "")
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+
+	id := uuid.New().String()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO investments.model_portfolios (
+			id, name, description, risk_profile, drift_tolerance, is_active, created_at, updated_at
+		) VALUES (
+			$1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, true, $6, $6
+		)
+	`, id, input.Name, input.Description, profile, input.DriftTolerance, time.Now())
+	if err == nil {
+		err = replaceAllocations(ctx, tx, id, input.Allocations)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      id,
+		"message": "Model portfolio created successfully",
+	})
+}
+
+// updateModelPortfolio updates a model portfolio. Allocations, when given,
+// replace the existing ones.
+func updateModelPortfolio(c *gin.Context) {
+	id := c.Param("id")
+
+	var input modelPortfolioInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Allocations != nil {
+		if err := validateAllocations(input.Allocations); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.DriftTolerance < 0 || input.DriftTolerance > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "drift_tolerance must be between 0 and 100"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	profile, err := checkRiskProfile(ctx, tx, input.RiskProfile, id)
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE investments.model_portfolios
+		SET
+			name = COALESCE(NULLIF($1, ''), name),
+			description = COALESCE(NULLIF($2, ''), description),
+			risk_profile = COALESCE(NULLIF($3, ''), risk_profile),
+			drift_tolerance = CASE WHEN $4 > 0 THEN $4 ELSE drift_tolerance END,
+			updated_at = $5
+		WHERE id = $6 AND is_active = true
+	`, input.Name, input.Description, profile, input.DriftTolerance, time.Now(), id)
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		respondToModelError(c, errModelNotFound)
+		return
+	}
+
+	if input.Allocations != nil {
+		err = replaceAllocations(ctx, tx, id, input.Allocations)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Model portfolio updated successfully"})
+}
+
+// deleteModelPortfolio deactivates a model portfolio
+func deleteModelPortfolio(c *gin.Context) {
+	result, err := db.Exec(c.Request.Context(), `
+		UPDATE investments.model_portfolios
+		SET is_active = false, updated_at = $1
+		WHERE id = $2 AND is_active = true
+	`, time.Now(), c.Param("id"))
+	if err != nil {
+		respondToModelError(c, err)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		respondToModelError(c, errModelNotFound)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// investorRiskProfile returns the risk profile of a user, or of the user who
+// created a trust
+func investorRiskProfile(ctx context.Context, scopeType, scopeID string) (string, error) {
+	query := `SELECT COALESCE(risk_profile, '') FROM users.users WHERE id = $1`
+	if scopeType == scopeTrust {
+		query = `
+			SELECT COALESCE(u.risk_profile, '')
+			FROM trusts.trusts t
+			JOIN users.users u ON u.id = t.creator_user_id
+			WHERE t.id = $1`
+	}
+
+	var profile string
+	err := db.QueryRow(ctx, query, scopeID).Scan(&profile)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return risk.Normalize(profile), err
+}
+
+// resolveModelPortfolio returns the model a user or trust is rebalanced to:
+// the model assigned to it, or else the active model for its risk profile.
+// It also reports which of the two applied.
+func resolveModelPortfolio(ctx context.Context, scopeType, scopeID string) (*ModelPortfolio, string, error) {
+	var modelID string
+	err := db.QueryRow(ctx, `
+		SELECT a.model_id
+		FROM investments.model_assignments a
+		JOIN investments.model_portfolios m ON m.id = a.model_id
+		WHERE a.scope_type = $1 AND a.scope_id = $2 AND m.is_active = true
+	`, scopeType, scopeID).Scan(&modelID)
+	if err == nil {
+		m, err := loadModelPortfolio(ctx, modelID)
+		return m, "assignment", err
+	}
+	if err != pgx.ErrNoRows {
+		return nil, "", err
+	}
+
+	profile, err := investorRiskProfile(ctx, scopeType, scopeID)
+	if err != nil {
+		return nil, "", err
+	}
+	if profile == "" {
+		return nil, "", fmt.Errorf("%w: the %s has no assigned model and no risk profile", errNoModelForInvestor, scopeType)
+	}
+
+	err = db.QueryRow(ctx, `
+		SELECT id FROM investments.model_portfolios WHERE risk_profile = $1 AND is_active = true
+	`, profile).Scan(&modelID)
+	if err == pgx.ErrNoRows {
+		return nil, "", fmt.Errorf("%w: no active model for risk profile %s", errNoModelForInvestor, profile)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	m, err := loadModelPortfolio(ctx, modelID)
+	return m, "risk_profile", err
+}
+
+// getModelAssignment returns a handler reporting the model a user or trust
+// is rebalanced to
+func getModelAssignment(scopeType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m, source, err := resolveModelPortfolio(c.Request.Context(), scopeType, c.Param(param))
+		if errors.Is(err, errNoModelForInvestor) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			respondToModelError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"source": source, "model_portfolio": m})
+	}
+}
+
+// assignModelPortfolio returns a handler assigning a model to a user or
+// trust, overriding the model for its risk profile. An empty
+// model_portfolio_id removes the assignment.
+func assignModelPortfolio(scopeType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scopeID := c.Param(param)
+
+		var input struct {
+			ModelPortfolioID string `json:"model_portfolio_id"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if input.ModelPortfolioID == "" {
+			_, err := db.Exec(ctx, `
+				DELETE FROM investments.model_assignments WHERE scope_type = $1 AND scope_id = $2
+			`, scopeType, scopeID)
+			if err != nil {
+				respondToModelError(c, err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		if _, err := loadModelPortfolio(ctx, input.ModelPortfolioID); err != nil {
+			respondToModelError(c, err)
+			return
+		}
+
+		_, err := db.Exec(ctx, `
+			INSERT INTO investments.model_assignments (scope_type, scope_id, model_id, assigned_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (scope_type, scope_id) DO UPDATE
+			SET model_id = EXCLUDED.model_id, assigned_at = EXCLUDED.assigned_at
+		`, scopeType, scopeID, input.ModelPortfolioID, time.Now())
+		if err != nil {
+			respondToModelError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Model portfolio assigned successfully"})
+	}
+}
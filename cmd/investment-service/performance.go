@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/performance"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// dateLayout is the format of date query parameters
+const dateLayout = "2006-01-02"
+
+// SnapshotResult summarizes one snapshot run
+type SnapshotResult struct {
+	Date     time.Time `json:"date"`
+	Accounts int64     `json:"accounts"`
+	Prices   int64     `json:"prices"`
+	// Unconverted lists accounts skipped for want of an exchange rate
+	Unconverted []string `json:"unconverted"`
+}
+
+// Benchmark compares a portfolio's return with holding one asset
+type Benchmark struct {
+	Symbol       string           `json:"symbol"`
+	StartDate    *time.Time       `json:"start_date,omitempty"`
+	EndDate      *time.Time       `json:"end_date,omitempty"`
+	StartPrice   *decimal.Decimal `json:"start_price,omitempty"`
+	EndPrice     *decimal.Decimal `json:"end_price,omitempty"`
+	Return       *float64         `json:"return"`
+	ExcessReturn *float64         `json:"excess_return"`
+	Note         string           `json:"note,omitempty"`
+}
+
+// snapshotPortfoliosPeriodically records today's snapshot at startup and then
+// every PERFORMANCE_SNAPSHOT_INTERVAL. Each run replaces today's snapshot, so
+// the last run of the day leaves its closing values.
+func snapshotPortfoliosPeriodically(ctx context.Context) {
+	interval := durationEnv("PERFORMANCE_SNAPSHOT_INTERVAL", time.Hour)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Snapshotting portfolio values every %s", interval)
+
+	for {
+		if _, err := snapshotPortfolios(ctx); err != nil {
+			log.Printf("Portfolio snapshot failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshotPortfolios records today's value of every account that has held a
+// position, and today's price of every asset for benchmark comparisons.
+// Accounts whose positions are all closed are recorded at zero so a final
+// sale shows as a withdrawal rather than a missing day. Snapshots are valued
+// in the account's currency, converting holdings priced in other currencies
+// at today's rate; accounts holding a currency without a rate are skipped
+// and listed in the result.
+func snapshotPortfolios(ctx context.Context) (*SnapshotResult, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	result := &SnapshotResult{Date: today, Unconverted: []string{}}
+
+	rates, err := loadFXTable(ctx, today, today)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO investments.asset_prices (asset_id, price_date, price, currency)
+		SELECT id, $1, current_price_amount, current_price_currency
+		FROM investments.assets
+		ON CONFLICT (asset_id, price_date) DO UPDATE
+		SET price = EXCLUDED.price, currency = EXCLUDED.currency
+	`, today)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record asset prices: %w", err)
+	}
+	result.Prices = tag.RowsAffected()
+
+	type accountValue struct {
+		ID          string
+		MarketValue money.Money
+		CostBasis   money.Money
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT p.account_id, ac.balance_currency, a.current_price_currency,
+		       COALESCE(SUM(p.current_value) FILTER (WHERE p.is_open), 0),
+		       COALESCE(SUM(p.cost_basis) FILTER (WHERE p.is_open), 0)
+		FROM investments.positions p
+		JOIN investments.assets a ON a.id = p.asset_id
+		JOIN accounts.accounts ac ON ac.id = p.account_id
+		GROUP BY p.account_id, ac.balance_currency, a.current_price_currency
+		ORDER BY p.account_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to value accounts: %w", err)
+	}
+	var values []*accountValue
+	unconverted := make(map[string]bool)
+	for rows.Next() {
+		var accountID, accountCurrency, assetCurrency string
+		var marketValue, costBasis decimal.Decimal
+		if err := rows.Scan(&accountID, &accountCurrency, &assetCurrency, &marketValue, &costBasis); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan account value: %w", err)
+		}
+
+		n := len(values)
+		if n == 0 || values[n-1].ID != accountID {
+			values = append(values, &accountValue{
+				ID:          accountID,
+				MarketValue: money.Zero(accountCurrency),
+				CostBasis:   money.Zero(accountCurrency),
+			})
+		}
+		v := values[len(values)-1]
+
+		market, errMarket := rates.Convert(money.New(marketValue, assetCurrency), v.MarketValue.Currency, today)
+		cost, errCost := rates.Convert(money.New(costBasis, assetCurrency), v.CostBasis.Currency, today)
+		if errMarket != nil || errCost != nil {
+			unconverted[accountID] = true
+			continue
+		}
+		v.MarketValue, _ = v.MarketValue.Add(market)
+		v.CostBasis, _ = v.CostBasis.Add(cost)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to value accounts: %w", err)
+	}
+
+	for _, v := range values {
+		if unconverted[v.ID] {
+			result.Unconverted = append(result.Unconverted, v.ID)
+			continue
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO investments.portfolio_snapshots (account_id, snapshot_date, market_value, cost_basis, currency, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (account_id, snapshot_date) DO UPDATE
+			SET market_value = EXCLUDED.market_value,
+			    cost_basis = EXCLUDED.cost_basis,
+			    currency = EXCLUDED.currency,
+			    updated_at = EXCLUDED.updated_at
+		`, v.ID, today, v.MarketValue.Amount, v.CostBasis.Amount, v.MarketValue.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record portfolio snapshot for account %s: %w", v.ID, err)
+		}
+		result.Accounts++
+	}
+	if len(result.Unconverted) > 0 {
+		log.Printf("Skipped snapshots of %d accounts holding assets without an exchange rate for %s",
+			len(result.Unconverted), today.Format(dateLayout))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// createSnapshots records today's snapshots on demand
+func createSnapshots(c *gin.Context) {
+	result, err := snapshotPortfolios(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to snapshot portfolios: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// loadValuations sums the accounts' daily snapshots between from and to in
+// currency, converting each account's value at the rate on the date. An
+// account without a snapshot on a date, because it was skipped for a missing
+// rate or the snapshot did not run, counts at its last snapshot value,
+// including one taken before from, so a gap is not read as a loss.
+func loadValuations(ctx context.Context, accountIDs []string, from, to time.Time, currency string, rates *fx.Table) ([]performance.Valuation, error) {
+	rows, err := db.Query(ctx, `
+		SELECT s.account_id, s.snapshot_date, s.currency, s.market_value
+		FROM investments.portfolio_snapshots s
+		WHERE s.account_id = ANY($1::uuid[]) AND s.snapshot_date <= $3
+		  AND (s.snapshot_date >= $2 OR s.snapshot_date = (
+		      SELECT MAX(p.snapshot_date) FROM investments.portfolio_snapshots p
+		      WHERE p.account_id = s.account_id AND p.snapshot_date < $2
+		  ))
+		ORDER BY s.snapshot_date, s.account_id
+	`, accountIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type snapshot struct {
+		AccountID string
+		Date      time.Time
+		Value     money.Money
+	}
+	var snapshots []snapshot
+	for rows.Next() {
+		var s snapshot
+		var snapshotCurrency string
+		var value decimal.Decimal
+		if err := rows.Scan(&s.AccountID, &s.Date, &snapshotCurrency, &value); err != nil {
+			return nil, err
+		}
+		s.Value = money.New(value, snapshotCurrency)
+		snapshots = append(snapshots, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]money.Money)
+	var valuations []performance.Valuation
+	for i, s := range snapshots {
+		latest[s.AccountID] = s.Value
+		if s.Date.Before(from) || (i+1 < len(snapshots) && snapshots[i+1].Date.Equal(s.Date)) {
+			continue
+		}
+
+		// Every snapshot on this date has been read
+		total := decimal.Zero
+		for _, value := range latest {
+			converted, err := rates.Convert(value, currency, s.Date)
+			if err != nil {
+				return nil, err
+			}
+			total = total.Add(converted.Amount)
+		}
+		valuations = append(valuations, performance.Valuation{Date: s.Date, Value: total})
+	}
+	return valuations, nil
+}
+
+// loadCashFlows totals the accounts' daily cash flows between from and to in
+// currency, converting each day's flows at the rate on that day. Positions
+// are valued without cash, so buys and fees are money added to the
+// portfolio, while sale proceeds and dividends are money taken out of it.
+func loadCashFlows(ctx context.Context, accountIDs []string, from, to time.Time, currency string, rates *fx.Table) ([]performance.CashFlow, error) {
+	rows, err := db.Query(ctx, `
+		SELECT (trade_date AT TIME ZONE 'UTC')::date AS flow_date, currency,
+		       SUM(CASE type
+		           WHEN 'BUY' THEN amount
+		           WHEN 'FEE' THEN amount
+		           WHEN 'SELL' THEN -amount
+		           WHEN 'DIVIDEND' THEN -amount
+		           ELSE 0
+		       END)
+		FROM investments.transactions
+		WHERE account_id = ANY($1::uuid[])
+		  AND (trade_date AT TIME ZONE 'UTC')::date >= $2
+		  AND (trade_date AT TIME ZONE 'UTC')::date <= $3
+		GROUP BY flow_date, currency
+		ORDER BY flow_date, currency
+	`, accountIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flows []performance.CashFlow
+	for rows.Next() {
+		var date time.Time
+		var flowCurrency string
+		var amount decimal.Decimal
+		if err := rows.Scan(&date, &flowCurrency, &amount); err != nil {
+			return nil, err
+		}
+		converted, err := rates.Convert(money.New(amount, flowCurrency), currency, date)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(flows); n > 0 && flows[n-1].Date.Equal(date) {
+			flows[n-1].Amount = flows[n-1].Amount.Add(converted.Amount)
+			continue
+		}
+		flows = append(flows, performance.CashFlow{Date: date, Amount: converted.Amount})
+	}
+	return flows, rows.Err()
+}
+
+// compareBenchmark returns the benchmark asset's price return over the
+// portfolio's period alongside the portfolio's excess return
+func compareBenchmark(ctx context.Context, symbol string, result *performance.Result) (*Benchmark, error) {
+	benchmark := &Benchmark{Symbol: strings.ToUpper(symbol)}
+
+	var assetID string
+	err := db.QueryRow(ctx, `
+		SELECT id FROM investments.assets WHERE UPPER(symbol) = $1
+	`, benchmark.Symbol).Scan(&assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT price_date, price
+		FROM investments.asset_prices
+		WHERE asset_id = $1 AND price_date >= $2 AND price_date <= $3
+		ORDER BY price_date
+	`, assetID, result.From, result.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []performance.Valuation
+	for rows.Next() {
+		var p performance.Valuation
+		if err := rows.Scan(&p.Date, &p.Value); err != nil {
+			return nil, err
+		}
+		prices = append(prices, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	benchmarkReturn, err := performance.PriceReturn(prices)
+	if err != nil {
+		benchmark.Note = "Not enough price history for the benchmark over this period"
+		return benchmark, nil
+	}
+
+	first, last := prices[0], prices[len(prices)-1]
+	excess := result.TimeWeightedReturn - benchmarkReturn
+	benchmark.StartDate, benchmark.EndDate = &first.Date, &last.Date
+	benchmark.StartPrice, benchmark.EndPrice = &first.Value, &last.Value
+	benchmark.Return = &benchmarkReturn
+	benchmark.ExcessReturn = &excess
+	return benchmark, nil
+}
+
+// getPerformance returns a handler for the performance of one scope, with the
+// scope ID taken from the named route parameter. The period defaults to the
+// last year and is set with ?from= and ?to= (YYYY-MM-DD); ?benchmark= names
+// an asset symbol to compare against. Values are reported in the scope's
+// reporting currency, or in ?currency= when given.
+func getPerformance(scopeType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scopeID := c.Param(param)
+
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		if value := c.Query("to"); value != "" {
+			date, err := time.Parse(dateLayout, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return
+			}
+			to = date
+		}
+		from := to.AddDate(-1, 0, 0)
+		if value := c.Query("from"); value != "" {
+			date, err := time.Parse(dateLayout, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+			from = date
+		}
+		if !from.Before(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+			return
+		}
+
+		accountIDs, err := scopeAccountIDs(ctx, scopeType, scopeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve accounts: " + err.Error()})
+			return
+		}
+		if len(accountIDs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No accounts found for " + scopeType})
+			return
+		}
+
+		currency, err := reportingCurrency(c, scopeType, scopeID)
+		if err != nil {
+			respondToFXError(c, err)
+			return
+		}
+		rates, err := loadFXTable(ctx, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		valuations, err := loadValuations(ctx, accountIDs, from, to, currency, rates)
+		var missing *fx.MissingRateError
+		if errors.As(err, &missing) {
+			respondToFXError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve portfolio snapshots: " + err.Error()})
+			return
+		}
+		flows, err := loadCashFlows(ctx, accountIDs, from, to, currency, rates)
+		if errors.As(err, &missing) {
+			respondToFXError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cash flows: " + err.Error()})
+			return
+		}
+
+		result, err := performance.Compute(valuations, flows)
+		if errors.Is(err, performance.ErrNotEnoughData) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Not enough portfolio snapshots in this period to compute returns"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute performance: " + err.Error()})
+			return
+		}
+
+		response := gin.H{
+			"scope_type":  scopeType,
+			"scope_id":    scopeID,
+			"account_ids": accountIDs,
+			"currency":    currency,
+			"performance": result,
+		}
+
+		if symbol := c.Query("benchmark"); symbol != "" {
+			benchmark, err := compareBenchmark(ctx, symbol, result)
+			if err == pgx.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Benchmark asset not found"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare benchmark: " + err.Error()})
+				return
+			}
+			response["benchmark"] = benchmark
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
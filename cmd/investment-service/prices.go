@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	etradeclient "github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/client"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/marketdata"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// refreshMu keeps scheduled and requested price refreshes from overlapping
+var refreshMu sync.Mutex
+
+// PriceRefresh summarizes one price refresh
+type PriceRefresh struct {
+	Provider         string    `json:"provider"`
+	Requested        int       `json:"requested"`
+	Updated          []string  `json:"updated"`
+	Unchanged        []string  `json:"unchanged"`
+	Missing          []string  `json:"missing"`
+	CurrencyMismatch []string  `json:"currency_mismatch"`
+	PositionsUpdated int64     `json:"positions_updated"`
+	RefreshedAt      time.Time `json:"refreshed_at"`
+}
+
+// newMarketDataProvider creates the provider named by MARKET_DATA_PROVIDER
+func newMarketDataProvider() (marketdata.Provider, error) {
+	switch kind := getEnv("MARKET_DATA_PROVIDER", "file"); kind {
+	case "file":
+		return marketdata.NewFileProvider(getEnv("MARKET_DATA_FILE", "/app/data/market-data.csv")), nil
+	case "etrade":
+		consumerKey := getEnv("ETRADE_CONSUMER_KEY", "")
+		consumerSecret := getEnv("ETRADE_CONSUMER_SECRET", "")
+		accessToken := getEnv("ETRADE_ACCESS_TOKEN", "")
+		tokenSecret := getEnv("ETRADE_TOKEN_SECRET", "")
+		if consumerKey == "" || consumerSecret == "" || accessToken == "" || tokenSecret == "" {
+			return nil, errors.New("ETRADE_CONSUMER_KEY, ETRADE_CONSUMER_SECRET, ETRADE_ACCESS_TOKEN and ETRADE_TOKEN_SECRET are required for E-Trade quotes")
+		}
+		client := etradeclient.NewETradeClient(consumerKey, consumerSecret, getEnv("ETRADE_SANDBOX", "true") == "true")
+		client.SetCredentials(accessToken, tokenSecret)
+		return marketdata.NewETradeProvider(client), nil
+	default:
+		return nil, fmt.Errorf("unknown market data provider %q", kind)
+	}
+}
+
+// refreshPricesPeriodically refreshes prices at startup and then every
+// MARKET_DATA_REFRESH_INTERVAL until ctx is cancelled
+func refreshPricesPeriodically(ctx context.Context) {
+	interval := durationEnv("MARKET_DATA_REFRESH_INTERVAL", 15*time.Minute)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Refreshing prices from %s every %s", marketData.Name(), interval)
+
+	for {
+		if result, err := refreshAssetPrices(ctx); err != nil {
+			log.Printf("Price refresh failed: %v", err)
+		} else {
+			log.Printf("Price refresh updated %d of %d assets and %d positions",
+				len(result.Updated), result.Requested, result.PositionsUpdated)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshAssetPrices updates the price of every asset held in an open
+// position and recomputes the value of those positions. A quote older than
+// the asset's last update, such as a price entered by hand since, is ignored.
+func refreshAssetPrices(ctx context.Context) (*PriceRefresh, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	type heldAsset struct {
+		ID       string
+		Symbol   string
+		Currency string
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT DISTINCT a.id, a.symbol, a.current_price_currency
+		FROM investments.assets a
+		JOIN investments.positions p ON p.asset_id = a.id
+		WHERE p.is_open = true
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve held assets: %w", err)
+	}
+	var held []heldAsset
+	for rows.Next() {
+		var a heldAsset
+		if err := rows.Scan(&a.ID, &a.Symbol, &a.Currency); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan asset data: %w", err)
+		}
+		held = append(held, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve held assets: %w", err)
+	}
+
+	result := &PriceRefresh{
+		Provider:         marketData.Name(),
+		Requested:        len(held),
+		Updated:          []string{},
+		Unchanged:        []string{},
+		Missing:          []string{},
+		CurrencyMismatch: []string{},
+		RefreshedAt:      time.Now(),
+	}
+	if len(held) == 0 {
+		return result, nil
+	}
+
+	symbols := make([]string, 0, len(held))
+	for _, a := range held {
+		symbols = append(symbols, a.Symbol)
+	}
+	quotes, err := marketData.Quotes(ctx, symbols)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quotes from %s: %w", marketData.Name(), err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var priced []heldAsset
+	for _, a := range held {
+		quote, ok := quotes[marketdata.NormalizeSymbol(a.Symbol)]
+		if !ok {
+			result.Missing = append(result.Missing, a.Symbol)
+			continue
+		}
+		if quote.Currency != a.Currency {
+			result.CurrencyMismatch = append(result.CurrencyMismatch, a.Symbol)
+			continue
+		}
+
+		tag, err := tx.Exec(ctx, `
+			UPDATE investments.assets
+			SET current_price_amount = $1, last_updated = $2
+			WHERE id = $3 AND (last_updated IS NULL OR last_updated <= $2)
+		`, quote.Price, quote.AsOf, a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update price of %s: %w", a.Symbol, err)
+		}
+		if tag.RowsAffected() == 0 {
+			result.Unchanged = append(result.Unchanged, a.Symbol)
+		} else {
+			result.Updated = append(result.Updated, a.Symbol)
+		}
+		priced = append(priced, a)
+	}
+
+	// Value positions at their asset's current price, including assets whose
+	// stored price is newer than the quote, rounded to the currency's minor unit
+	for _, a := range priced {
+		tag, err := tx.Exec(ctx, `
+			UPDATE investments.positions p
+			SET current_value = ROUND(p.quantity * a.current_price_amount, $3), last_updated = $2
+			FROM investments.assets a
+			WHERE a.id = p.asset_id AND p.asset_id = $1 AND p.is_open = true
+			  AND p.current_value IS DISTINCT FROM ROUND(p.quantity * a.current_price_amount, $3)
+		`, a.ID, result.RefreshedAt, money.Places(a.Currency))
+		if err != nil {
+			return nil, fmt.Errorf("failed to update position values of %s: %w", a.Symbol, err)
+		}
+		result.PositionsUpdated += tag.RowsAffected()
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// refreshPrices refreshes asset prices on demand
+func refreshPrices(c *gin.Context) {
+	result, err := refreshAssetPrices(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refresh prices: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// durationEnv reads a duration such as "15m" from the environment
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
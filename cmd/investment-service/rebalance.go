@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/lots"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/rebalance"
+)
+
+// taxAdvantagedStatuses are account tax statuses whose sales are not taxed
+var taxAdvantagedStatuses = map[string]bool{
+	"TAX_DEFERRED": true,
+	"TAX_EXEMPT":   true,
+	"TAX_FREE":     true,
+}
+
+// isTaxAdvantaged reports whether sales in an account are free of capital
+// gains tax, from its tax status or, when that is unset, its account type
+func isTaxAdvantaged(taxStatus, accountType string) bool {
+	if taxStatus != "" {
+		return taxAdvantagedStatuses[strings.ToUpper(taxStatus)]
+	}
+	t := strings.ToUpper(accountType)
+	for _, kind := range []string{"IRA", "401K", "403B", "HSA", "529"} {
+		if strings.Contains(t, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadHoldings returns the open lots across accounts, grouped by account and
+// asset. Open positions without lots, such as synced ones, are held as one
+// lot without an ID. Prices are converted into currency at the rate as of
+// asOf and unit costs at the rate on the day each lot was acquired.
+func loadHoldings(ctx context.Context, accountIDs []string, currency string, rates *fx.Table, asOf time.Time) ([]rebalance.Holding, error) {
+	rows, err := db.Query(ctx, `
+		SELECT l.account_id, COALESCE(ac.tax_status, ''), ac.type, l.asset_id, a.symbol,
+		       a.asset_class, a.current_price_amount, a.current_price_currency, l.id::text, l.acquired_at,
+		       l.remaining_quantity, l.unit_cost, t.currency
+		FROM investments.tax_lots l
+		JOIN investments.assets a ON a.id = l.asset_id
+		JOIN accounts.accounts ac ON ac.id = l.account_id
+		JOIN investments.transactions t ON t.id = l.transaction_id
+		WHERE l.account_id = ANY($1::uuid[]) AND l.remaining_quantity > 0 AND ac.is_active = true
+		UNION ALL
+		SELECT p.account_id, COALESCE(ac.tax_status, ''), ac.type, p.asset_id, a.symbol,
+		       a.asset_class, a.current_price_amount, a.current_price_currency, '', p.purchase_date,
+		       p.quantity, ROUND(p.cost_basis / p.quantity, 6), a.current_price_currency
+		FROM investments.positions p
+		JOIN investments.assets a ON a.id = p.asset_id
+		JOIN accounts.accounts ac ON ac.id = p.account_id
+		WHERE p.account_id = ANY($1::uuid[]) AND p.is_open = true AND p.quantity > 0 AND ac.is_active = true
+		  AND NOT EXISTS (SELECT 1 FROM investments.tax_lots l WHERE l.position_id = p.id)
+		ORDER BY 1, 4, 10, 9
+	`, accountIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holdings []rebalance.Holding
+	for rows.Next() {
+		var h rebalance.Holding
+		var taxStatus, accountType, priceCurrency, costCurrency string
+		var lot lots.Lot
+		err := rows.Scan(
+			&h.AccountID, &taxStatus, &accountType, &h.AssetID, &h.Symbol,
+			&h.AssetClass, &h.Price, &priceCurrency, &lot.ID, &lot.AcquiredAt,
+			&lot.Quantity, &lot.UnitCost, &costCurrency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if h.Price, err = convertPrice(rates, h.Price, priceCurrency, currency, asOf); err != nil {
+			return nil, err
+		}
+		if lot.UnitCost, err = convertPrice(rates, lot.UnitCost, costCurrency, currency, lot.AcquiredAt); err != nil {
+			return nil, err
+		}
+
+		if n := len(holdings); n > 0 && holdings[n-1].AccountID == h.AccountID && holdings[n-1].AssetID == h.AssetID {
+			holdings[n-1].Lots = append(holdings[n-1].Lots, lot)
+			continue
+		}
+		h.TaxAdvantaged = isTaxAdvantaged(taxStatus, accountType)
+		h.Lots = []lots.Lot{lot}
+		holdings = append(holdings, h)
+	}
+	return holdings, rows.Err()
+}
+
+// modelTargets converts a model's allocations into rebalancing targets,
+// looking up the asset each allocation buys and its price in currency
+func modelTargets(ctx context.Context, m *ModelPortfolio, currency string, rates *fx.Table, asOf time.Time) ([]rebalance.Target, error) {
+	targets := make([]rebalance.Target, 0, len(m.Allocations))
+	for _, a := range m.Allocations {
+		tolerance := m.DriftTolerance
+		if a.Tolerance != nil {
+			tolerance = *a.Tolerance
+		}
+		t := rebalance.Target{
+			Kind:      a.TargetType,
+			Key:       a.Target,
+			Weight:    a.Weight / 100,
+			Tolerance: tolerance / 100,
+		}
+
+		buySymbol := a.BuySymbol
+		if a.TargetType == rebalance.KindSymbol {
+			buySymbol = a.Target
+		}
+		if buySymbol != "" {
+			var priceCurrency string
+			err := db.QueryRow(ctx, `
+				SELECT id, symbol, current_price_amount, current_price_currency
+				FROM investments.assets WHERE UPPER(symbol) = $1
+			`, strings.ToUpper(buySymbol)).Scan(&t.BuyAssetID, &t.BuySymbol, &t.BuyPrice, &priceCurrency)
+			// An unknown buy symbol is reported as a warning in the proposal
+			if err != nil && err != pgx.ErrNoRows {
+				return nil, err
+			}
+			if err == nil {
+				if t.BuyPrice, err = convertPrice(rates, t.BuyPrice, priceCurrency, currency, asOf); err != nil {
+					return nil, err
+				}
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// getRebalanceProposal returns a handler proposing the trades that bring a
+// user's or trust's accounts back to its model portfolio. The model can be
+// overridden with ?model_portfolio_id=; ?fractional=true allows fractional
+// share quantities and ?min_trade_value= skips smaller trades. Values are in
+// the scope's reporting currency or ?currency=.
+func getRebalanceProposal(scopeType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scopeID := c.Param(param)
+
+		opts := rebalance.Options{
+			AsOf:             time.Now(),
+			DefaultTolerance: defaultDriftTolerance / 100,
+			FractionalShares: c.Query("fractional") == "true",
+		}
+		if value := c.Query("min_trade_value"); value != "" {
+			minimum, err := decimal.NewFromString(value)
+			if err != nil || minimum.IsNegative() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "min_trade_value must be a non-negative number"})
+				return
+			}
+			opts.MinTradeValue = minimum
+		}
+
+		var model *ModelPortfolio
+		source := "request"
+		var err error
+		if modelID := c.Query("model_portfolio_id"); modelID != "" {
+			model, err = loadModelPortfolio(ctx, modelID)
+		} else {
+			model, source, err = resolveModelPortfolio(ctx, scopeType, scopeID)
+		}
+		if errors.Is(err, errNoModelForInvestor) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			respondToModelError(c, err)
+			return
+		}
+		opts.DefaultTolerance = model.DriftTolerance / 100
+
+		accountIDs, err := scopeAccountIDs(ctx, scopeType, scopeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve accounts: " + err.Error()})
+			return
+		}
+		if len(accountIDs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No accounts found for " + scopeType})
+			return
+		}
+
+		opts.Currency, err = reportingCurrency(c, scopeType, scopeID)
+		if err != nil {
+			respondToFXError(c, err)
+			return
+		}
+		rates, err := loadFXTable(ctx, time.Time{}, opts.AsOf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		holdings, err := loadHoldings(ctx, accountIDs, opts.Currency, rates, opts.AsOf)
+		var missing *fx.MissingRateError
+		if errors.As(err, &missing) {
+			respondToFXError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve holdings: " + err.Error()})
+			return
+		}
+		targets, err := modelTargets(ctx, model, opts.Currency, rates, opts.AsOf)
+		if errors.As(err, &missing) {
+			respondToFXError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve model assets: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"scope_type":      scopeType,
+			"scope_id":        scopeID,
+			"account_ids":     accountIDs,
+			"model_portfolio": model,
+			"model_source":    source,
+			"proposal":        rebalance.Propose(targets, holdings, opts),
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/taxreport"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Tax report scopes
+const (
+	scopeAccount = "account"
+	scopeTrust   = "trust"
+	scopeUser    = "user"
+)
+
+// scopeAccountIDs returns the accounts a report covers. Closed accounts are
+// included since their sales still count toward the year.
+func scopeAccountIDs(ctx context.Context, scopeType, scopeID string) ([]string, error) {
+	var column string
+	switch scopeType {
+	case scopeAccount:
+		column = "id"
+	case scopeTrust:
+		column = "trust_id"
+	case scopeUser:
+		column = "user_id"
+	default:
+		return nil, fmt.Errorf("unknown report scope %q", scopeType)
+	}
+
+	rows, err := db.Query(ctx, `SELECT id FROM accounts.accounts WHERE `+column+` = $1 ORDER BY id`, scopeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// buildTaxReport loads the lot data for a tax year and builds the report in
+// currency. Wash sales are matched across all accounts in the report's
+// scope. Proceeds are converted at the rate on the sale date and cost basis
+// at the rate on the acquisition date; open lots are valued at the rate on
+// the price date.
+func buildTaxReport(ctx context.Context, scopeType, scopeID string, accountIDs []string, year int, currency string) (taxreport.Report, error) {
+	yearStart, yearEnd := taxreport.YearBounds(year)
+	asOf := time.Now()
+	if asOf.After(yearEnd) {
+		asOf = yearEnd
+	}
+
+	report := taxreport.Report{
+		ScopeType:   scopeType,
+		ScopeID:     scopeID,
+		TaxYear:     year,
+		Currency:    currency,
+		AccountIDs:  accountIDs,
+		AsOf:        asOf,
+		GeneratedAt: time.Now(),
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT d.transaction_id, t.account_id, t.asset_id, a.symbol, a.name, d.lot_id,
+		       d.quantity, d.acquired_at, d.disposed_at, d.proceeds, d.cost_basis, t.currency
+		FROM investments.lot_disposals d
+		JOIN investments.transactions t ON t.id = d.transaction_id
+		JOIN investments.assets a ON a.id = t.asset_id
+		WHERE t.account_id = ANY($1::uuid[]) AND d.disposed_at >= $2 AND d.disposed_at < $3
+		ORDER BY d.disposed_at, d.lot_id
+	`, accountIDs, yearStart, yearEnd)
+	if err != nil {
+		return report, fmt.Errorf("failed to retrieve sales: %w", err)
+	}
+	var sales []taxreport.Sale
+	var saleCurrencies []string
+	for rows.Next() {
+		var s taxreport.Sale
+		var saleCurrency string
+		err := rows.Scan(
+			&s.TransactionID, &s.AccountID, &s.AssetID, &s.Symbol, &s.Name, &s.LotID,
+			&s.Quantity, &s.AcquiredAt, &s.SoldAt, &s.Proceeds, &s.CostBasis, &saleCurrency,
+		)
+		if err != nil {
+			rows.Close()
+			return report, fmt.Errorf("failed to scan sale data: %w", err)
+		}
+		sales = append(sales, s)
+		saleCurrencies = append(saleCurrencies, saleCurrency)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to retrieve sales: %w", err)
+	}
+
+	rows, err = db.Query(ctx, `
+		SELECT l.id, l.account_id, l.asset_id, l.quantity, l.acquired_at
+		FROM investments.tax_lots l
+		JOIN investments.transactions t ON t.id = l.transaction_id
+		WHERE l.account_id = ANY($1::uuid[]) AND t.type = 'BUY'
+		  AND l.acquired_at >= $2 AND l.acquired_at < $3
+		ORDER BY l.acquired_at, l.id
+	`, accountIDs, yearStart.AddDate(0, 0, -taxreport.WashSaleDays), yearEnd.AddDate(0, 0, taxreport.WashSaleDays))
+	if err != nil {
+		return report, fmt.Errorf("failed to retrieve purchases: %w", err)
+	}
+	var purchases []taxreport.Purchase
+	for rows.Next() {
+		var p taxreport.Purchase
+		if err := rows.Scan(&p.LotID, &p.AccountID, &p.AssetID, &p.Quantity, &p.AcquiredAt); err != nil {
+			rows.Close()
+			return report, fmt.Errorf("failed to scan purchase data: %w", err)
+		}
+		purchases = append(purchases, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to retrieve purchases: %w", err)
+	}
+
+	// Lots open at the reporting date are valued at the asset's current price
+	// while the year is still open, and at its last price of the year once it
+	// has ended. Lots with no price for a past year are left out with a
+	// warning. Quantities are in shares after any later split, disposals
+	// converted by the splits since they were recorded. A lot converted in a
+	// merger is held from the merger, when the lot it replaced was closed.
+	current := asOf.Before(yearEnd)
+	rows, err = db.Query(ctx, `
+		SELECT id, account_id, asset_id, symbol, name, held, unit_cost, cost_currency,
+		       acquired_at, price, price_currency, price_as_of,
+		       close_price, close_currency, close_date
+		FROM (
+			SELECT l.id, l.account_id, l.asset_id, a.symbol, a.name,
+			       l.quantity - COALESCE((
+			           SELECT SUM(d.quantity * l.split_factor / d.split_factor) FROM investments.lot_disposals d
+			           WHERE d.lot_id = l.id AND d.disposed_at < $2
+			       ), 0) AS held,
+			       l.unit_cost, t.currency AS cost_currency, l.acquired_at,
+			       a.current_price_amount AS price, a.current_price_currency AS price_currency,
+			       a.last_updated AS price_as_of,
+			       p.price AS close_price, p.currency AS close_currency, p.price_date AS close_date
+			FROM investments.tax_lots l
+			JOIN investments.assets a ON a.id = l.asset_id
+			JOIN investments.transactions t ON t.id = l.transaction_id
+			LEFT JOIN LATERAL (
+				SELECT price, currency, price_date FROM investments.asset_prices
+				WHERE asset_id = l.asset_id AND price_date < $3::date
+				ORDER BY price_date DESC
+				LIMIT 1
+			) p ON true
+			WHERE l.account_id = ANY($1::uuid[]) AND COALESCE(l.converted_at, l.acquired_at) < $2
+			  AND (l.closed_at IS NULL OR l.closed_at >= $2)
+		) open_lots
+		WHERE held > 0
+		ORDER BY symbol, acquired_at, id
+	`, accountIDs, asOf, yearEnd)
+	if err != nil {
+		return report, fmt.Errorf("failed to retrieve open lots: %w", err)
+	}
+	defer rows.Close()
+	var open []taxreport.OpenLot
+	var lotCurrencies [][2]string
+	unpriced := map[string]bool{}
+	for rows.Next() {
+		var l taxreport.OpenLot
+		var costCurrency, priceCurrency string
+		var closePrice *decimal.Decimal
+		var closeCurrency *string
+		var closeDate *time.Time
+		err := rows.Scan(
+			&l.LotID, &l.AccountID, &l.AssetID, &l.Symbol, &l.Name, &l.Quantity,
+			&l.UnitCost, &costCurrency, &l.AcquiredAt, &l.Price, &priceCurrency, &l.PriceAsOf,
+			&closePrice, &closeCurrency, &closeDate,
+		)
+		if err != nil {
+			return report, fmt.Errorf("failed to scan lot data: %w", err)
+		}
+		if !current {
+			if closePrice == nil {
+				unpriced[l.Symbol] = true
+				continue
+			}
+			l.Price, priceCurrency, l.PriceAsOf = *closePrice, *closeCurrency, *closeDate
+		}
+		open = append(open, l)
+		lotCurrencies = append(lotCurrencies, [2]string{costCurrency, priceCurrency})
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to retrieve open lots: %w", err)
+	}
+	if len(unpriced) > 0 {
+		symbols := make([]string, 0, len(unpriced))
+		for symbol := range unpriced {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
+		report.Warnings = append(report.Warnings, fmt.Sprintf(
+			"Left out open lots of %s; no price recorded for %d", strings.Join(symbols, ", "), year))
+	}
+
+	earliest := asOf
+	for _, s := range sales {
+		if s.AcquiredAt.Before(earliest) {
+			earliest = s.AcquiredAt
+		}
+	}
+	for _, l := range open {
+		if l.AcquiredAt.Before(earliest) {
+			earliest = l.AcquiredAt
+		}
+	}
+	rates, err := loadFXTable(ctx, earliest, time.Now())
+	if err != nil {
+		return report, err
+	}
+
+	for i := range sales {
+		s := &sales[i]
+		proceeds, err := rates.Convert(money.New(s.Proceeds, saleCurrencies[i]), currency, s.SoldAt)
+		if err != nil {
+			return report, err
+		}
+		costBasis, err := rates.Convert(money.New(s.CostBasis, saleCurrencies[i]), currency, s.AcquiredAt)
+		if err != nil {
+			return report, err
+		}
+		s.Proceeds, s.CostBasis = proceeds.Amount, costBasis.Amount
+	}
+	for i := range open {
+		l := &open[i]
+		l.UnitCost, err = convertPrice(rates, l.UnitCost, lotCurrencies[i][0], currency, l.AcquiredAt)
+		if err != nil {
+			return report, err
+		}
+		l.Price, err = convertPrice(rates, l.Price, lotCurrencies[i][1], currency, l.PriceAsOf)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return taxreport.Build(report, sales, purchases, open), nil
+}
+
+// getTaxReport returns a handler for the gains report of one scope, with the
+// scope ID taken from the named route parameter. The report covers the year
+// given by ?year= (last year by default) and is returned as JSON, or as CSV
+// in 1099-B layout with ?format=csv. CSV exports realized sales unless
+// ?section=unrealized is given. Amounts are in the scope's reporting
+// currency or ?currency=.
+func getTaxReport(scopeType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scopeID := c.Param(param)
+
+		year := time.Now().Year() - 1
+		if value := c.Query("year"); value != "" {
+			y, err := strconv.Atoi(value)
+			if err != nil || y < 1900 || y > time.Now().Year() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax year"})
+				return
+			}
+			year = y
+		}
+
+		format := c.DefaultQuery("format", "json")
+		section := c.DefaultQuery("section", "realized")
+		if format != "json" && format != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+			return
+		}
+		if section != "realized" && section != "unrealized" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "section must be realized or unrealized"})
+			return
+		}
+
+		accountIDs, err := scopeAccountIDs(ctx, scopeType, scopeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve accounts: " + err.Error()})
+			return
+		}
+		if len(accountIDs) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No accounts found for " + scopeType})
+			return
+		}
+
+		currency, err := reportingCurrency(c, scopeType, scopeID)
+		if err != nil {
+			respondToFXError(c, err)
+			return
+		}
+
+		report, err := buildTaxReport(ctx, scopeType, scopeID, accountIDs, year, currency)
+		var missing *fx.MissingRateError
+		if errors.As(err, &missing) {
+			respondToFXError(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build tax report: " + err.Error()})
+			return
+		}
+
+		if format == "json" {
+			c.JSON(http.StatusOK, report)
+			return
+		}
+
+		var buf bytes.Buffer
+		write := taxreport.WriteRealizedCSV
+		if section == "unrealized" {
+			write = taxreport.WriteUnrealizedCSV
+		}
+		if err := write(&buf, report); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write tax report: " + err.Error()})
+			return
+		}
+
+		fileName := fmt.Sprintf("gains-%s-%s-%d-%s.csv", scopeType, scopeID, year, section)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Email delivery statuses
+const (
+	EmailStatusSent       = "SENT"
+	EmailStatusFailed     = "FAILED"
+	EmailStatusSuppressed = "SUPPRESSED"
+)
+
+// Email represents an email sent through the email channel
+type Email struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id,omitempty"`
+	ToAddress string     `json:"to"`
+	Type      string     `json:"type"`
+	Subject   string     `json:"subject"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+// EmailSender delivers email messages
+type EmailSender interface {
+	SendEmail(ctx context.Context, to, subject, body string) error
+}
+
+// sesEmailSender sends email through Amazon SES
+type sesEmailSender struct {
+	client *ses.SES
+	from   string
+}
+
+func (s *sesEmailSender) SendEmail(ctx context.Context, to, subject, body string) error {
+	_, err := s.client.SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source: aws.String(s.from),
+		Destination: &ses.Destination{
+			ToAddresses: []*string{aws.String(to)},
+		},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(subject), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(body), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// logEmailSender writes emails to the log instead of delivering them
+type logEmailSender struct{}
+
+func (logEmailSender) SendEmail(ctx context.Context, to, subject, body string) error {
+	log.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// emailSender is the sender used by the email channel
+var emailSender EmailSender
+
+// newEmailSender creates the sender selected by EMAIL_SENDER ("ses" or "log")
+func newEmailSender() (EmailSender, error) {
+	switch kind := getEnv("EMAIL_SENDER", "ses"); kind {
+	case "log":
+		return logEmailSender{}, nil
+	case "ses":
+		cfg := aws.NewConfig().WithRegion(getEnv("AWS_REGION", "us-east-1"))
+		if endpoint := getEnv("AWS_ENDPOINT", ""); endpoint != "" {
+			cfg = cfg.WithEndpoint(endpoint)
+		}
+		sess, err := session.NewSession(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		}
+		return &sesEmailSender{
+			client: ses.New(sess),
+			from:   getEnv("EMAIL_FROM_ADDRESS", "no-reply@trustainvest.com"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown email sender %q", kind)
+	}
+}
+
+// sendEmail delivers an email and records the outcome. When the email is for
+// a known user who has turned email off, only notices that must be delivered
+// regardless of preferences are sent.
+func sendEmail(c *gin.Context) {
+	var input struct {
+		To      string `json:"to" binding:"required,email"`
+		Subject string `json:"subject" binding:"required"`
+		Body    string `json:"body" binding:"required"`
+		Type    string `json:"type" binding:"required"`
+		UserID  string `json:"user_id"`
+		// Transactional emails such as signing invitations ignore preferences
+		Transactional bool `json:"transactional"`
+		// Secrets fill {{name}} placeholders in the body when it is sent. The
+		// body is recorded with the placeholders, so links carrying tokens
+		// are never stored.
+		Secrets map[string]string `json:"secrets"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	email := Email{
+		ID:        uuid.New().String(),
+		UserID:    input.UserID,
+		ToAddress: input.To,
+		Type:      input.Type,
+		Subject:   input.Subject,
+		CreatedAt: time.Now(),
+	}
+
+	enabled := true
+	if input.UserID != "" && !input.Transactional {
+		err := db.QueryRow(ctx, `
+			SELECT email_enabled FROM notifications.user_preferences WHERE user_id = $1
+		`, input.UserID).Scan(&enabled)
+		if err != nil {
+			// Users without saved preferences get the defaults
+			enabled = true
+		}
+	}
+
+	if !enabled {
+		email.Status = EmailStatusSuppressed
+	} else if err := emailSender.SendEmail(ctx, input.To, input.Subject, fillSecrets(input.Body, input.Secrets)); err != nil {
+		email.Status = EmailStatusFailed
+		email.Error = err.Error()
+	} else {
+		now := time.Now()
+		email.Status = EmailStatusSent
+		email.SentAt = &now
+	}
+
+	_, err := db.Exec(ctx, `
+		INSERT INTO notifications.emails (
+			id, user_id, to_address, type, subject, body, status, error, created_at, sent_at
+		) VALUES (
+			$1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10
+		)
+	`, email.ID, email.UserID, email.ToAddress, email.Type, email.Subject, input.Body,
+		email.Status, email.Error, email.CreatedAt, email.SentAt)
+	if err != nil {
+		log.Printf("Warning: Failed to record email %s: %v", email.ID, err)
+	}
+
+	if email.Status == EmailStatusFailed {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send email: " + email.Error, "id": email.ID})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":      email.ID,
+		"status":  email.Status,
+		"message": "Email processed successfully",
+	})
+}
+
+// fillSecrets replaces each {{name}} placeholder in body with its secret
+func fillSecrets(body string, secrets map[string]string) string {
+	if len(secrets) == 0 {
+		return body
+	}
+	pairs := make([]string, 0, 2*len(secrets))
+	for name, value := range secrets {
+		pairs = append(pairs, "{{"+name+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(body)
+}
@@ -59,6 +59,17 @@ func main() {
 	}
 	log.Println("Connected to database")
 
+	// Ensure the email log table exists
+	if err := ensureNotificationSchema(db); err != nil {
+		log.Fatalf("Failed to ensure notification schema: %v", err)
+	}
+
+	// Set up the email channel
+	emailSender, err = newEmailSender()
+	if err != nil {
+		log.Fatalf("Unable to create email sender: %v", err)
+	}
+
 	// Set up Gin router
 	router := gin.Default()
 
@@ -82,6 +93,9 @@ func main() {
 			notifications.PUT("/:id/read", markNotificationAsRead)
 		}
 
+		// Email channel
+		v1.POST("/emails", sendEmail)
+
 		// User notifications
 		userNotifications := v1.Group("/users/:userId/notifications")
 		{
@@ -577,3 +591,43 @@ func updateUserPreferences(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Preferences updated successfully"})
 }
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// ensureNotificationSchema ensures the tables added by notification-service exist
+func ensureNotificationSchema(db *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	// Create the emails table if it doesn't exist
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS notifications.emails (
+			id UUID PRIMARY KEY,
+			user_id UUID,
+			to_address VARCHAR(255) NOT NULL,
+			type VARCHAR(50) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			body TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			error TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			sent_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_emails_to_address ON notifications.emails(to_address)")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
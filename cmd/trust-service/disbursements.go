@@ -0,0 +1,709 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/models"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Disbursement rule trigger and amount types
+const (
+	TriggerTypeAge   = "age"
+	TriggerTypeDate  = "date"
+	TriggerTypeEvent = "event"
+
+	DisbursementTypePercentage = "percentage"
+	DisbursementTypeFixed      = "fixed"
+
+	DisbursementStatusPending = "PENDING"
+)
+
+// DisbursementRule represents a rule for disbursing trust funds to a beneficiary
+type DisbursementRule struct {
+	ID               string       `json:"id"`
+	TrustID          string       `json:"trust_id"`
+	Description      string       `json:"description"`
+	BeneficiaryID    string       `json:"beneficiary_id"`
+	TriggerType      string       `json:"trigger_type"`
+	TriggerValue     string       `json:"trigger_value"`
+	DisbursementType string       `json:"disbursement_type"`
+	Amount           models.Money `json:"amount,omitempty"`
+	Percentage       int          `json:"percentage,omitempty"`
+	IsActive         bool         `json:"is_active"`
+	TriggeredAt      *time.Time   `json:"triggered_at,omitempty"`
+	CreatedAt        time.Time    `json:"created_at"`
+}
+
+// Disbursement represents a disbursement produced by a triggered rule
+type Disbursement struct {
+	ID            string       `json:"id"`
+	TrustID       string       `json:"trust_id"`
+	RuleID        string       `json:"rule_id"`
+	BeneficiaryID string       `json:"beneficiary_id"`
+	AccountID     string       `json:"account_id"`
+	Amount        models.Money `json:"amount"`
+	Status        string       `json:"status"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// linkedAccount is a trust-linked account considered as a disbursement source
+type linkedAccount struct {
+	ID      string
+	Balance models.Money
+}
+
+func getDisbursementRules(c *gin.Context) {
+	trustID := c.Param("id")
+	var rules []DisbursementRule
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, trust_id, description, beneficiary_id, trigger_type, trigger_value,
+		       disbursement_type, amount, currency, percentage, is_active,
+		       triggered_at, created_at
+		FROM trusts.disbursement_rules
+		WHERE trust_id = $1 AND is_active = true
+		ORDER BY created_at
+	`, trustID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve disbursement rules"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule DisbursementRule
+		err := rows.Scan(
+			&rule.ID, &rule.TrustID, &rule.Description, &rule.BeneficiaryID, &rule.TriggerType,
+			&rule.TriggerValue, &rule.DisbursementType, &rule.Amount.Amount, &rule.Amount.Currency,
+			&rule.Percentage, &rule.IsActive, &rule.TriggeredAt, &rule.CreatedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan disbursement rule data"})
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"disbursement_rules": rules})
+}
+
+func getDisbursementRuleByID(c *gin.Context) {
+	trustID := c.Param("id")
+	ruleID := c.Param("ruleId")
+	var rule DisbursementRule
+
+	err := db.QueryRow(context.Background(), `
+		SELECT id, trust_id, description, beneficiary_id, trigger_type, trigger_value,
+		       disbursement_type, amount, currency, percentage, is_active,
+		       triggered_at, created_at
+		FROM trusts.disbursement_rules
+		WHERE id = $1 AND trust_id = $2 AND is_active = true
+	`, ruleID, trustID).Scan(
+		&rule.ID, &rule.TrustID, &rule.Description, &rule.BeneficiaryID, &rule.TriggerType,
+		&rule.TriggerValue, &rule.DisbursementType, &rule.Amount.Amount, &rule.Amount.Currency,
+		&rule.Percentage, &rule.IsActive, &rule.TriggeredAt, &rule.CreatedAt,
+	)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Disbursement rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func addDisbursementRule(c *gin.Context) {
+	trustID := c.Param("id")
+
+	var input struct {
+		Description      string       `json:"description"`
+		BeneficiaryID    string       `json:"beneficiary_id" binding:"required"`
+		TriggerType      string       `json:"trigger_type" binding:"required"`
+		TriggerValue     string       `json:"trigger_value"`
+		DisbursementType string       `json:"disbursement_type" binding:"required"`
+		Amount           models.Money `json:"amount"`
+		Percentage       int          `json:"percentage"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := DisbursementRule{
+		TriggerType:      input.TriggerType,
+		TriggerValue:     input.TriggerValue,
+		DisbursementType: input.DisbursementType,
+		Amount:           input.Amount,
+		Percentage:       input.Percentage,
+	}
+	if err := validateDisbursementRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if trust exists
+	var trustExists bool
+	err := db.QueryRow(context.Background(), `
+		SELECT EXISTS(SELECT 1 FROM trusts.trusts WHERE id = $1 AND status != 'INACTIVE')
+	`, trustID).Scan(&trustExists)
+
+	if err != nil || !trustExists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Trust not found"})
+		return
+	}
+
+	// Check if beneficiary belongs to the trust
+	var beneficiaryExists bool
+	err = db.QueryRow(context.Background(), `
+		SELECT EXISTS(SELECT 1 FROM trusts.beneficiaries WHERE id = $1 AND trust_id = $2 AND is_active = true)
+	`, input.BeneficiaryID, trustID).Scan(&beneficiaryExists)
+
+	if err != nil || !beneficiaryExists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Beneficiary not found"})
+		return
+	}
+
+	id := uuid.New().String()
+
+	_, err = db.Exec(context.Background(), `
+		INSERT INTO trusts.disbursement_rules (
+			id, trust_id, beneficiary_id, description, trigger_type, trigger_value,
+			disbursement_type, amount, currency, percentage, is_active
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		)
+	`, id, trustID, input.BeneficiaryID, input.Description, rule.TriggerType, rule.TriggerValue,
+		rule.DisbursementType, rule.Amount.Amount, rule.Amount.Currency, rule.Percentage, true)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add disbursement rule: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      id,
+		"message": "Disbursement rule added successfully",
+	})
+}
+
+func updateDisbursementRule(c *gin.Context) {
+	trustID := c.Param("id")
+	ruleID := c.Param("ruleId")
+
+	var input struct {
+		Description      *string       `json:"description"`
+		TriggerType      *string       `json:"trigger_type"`
+		TriggerValue     *string       `json:"trigger_value"`
+		DisbursementType *string       `json:"disbursement_type"`
+		Amount           *models.Money `json:"amount"`
+		Percentage       *int          `json:"percentage"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Load the existing rule so the merged result can be validated as a whole
+	var rule DisbursementRule
+	err := db.QueryRow(context.Background(), `
+		SELECT id, description, trigger_type, trigger_value, disbursement_type,
+		       amount, currency, percentage, triggered_at
+		FROM trusts.disbursement_rules
+		WHERE id = $1 AND trust_id = $2 AND is_active = true
+	`, ruleID, trustID).Scan(
+		&rule.ID, &rule.Description, &rule.TriggerType, &rule.TriggerValue, &rule.DisbursementType,
+		&rule.Amount.Amount, &rule.Amount.Currency, &rule.Percentage, &rule.TriggeredAt,
+	)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Disbursement rule not found"})
+		return
+	}
+
+	if rule.TriggeredAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot update a disbursement rule that has already been triggered"})
+		return
+	}
+
+	if input.Description != nil {
+		rule.Description = *input.Description
+	}
+	if input.TriggerType != nil {
+		rule.TriggerType = *input.TriggerType
+	}
+	if input.TriggerValue != nil {
+		rule.TriggerValue = *input.TriggerValue
+	}
+	if input.DisbursementType != nil {
+		rule.DisbursementType = *input.DisbursementType
+	}
+	if input.Amount != nil {
+		rule.Amount = *input.Amount
+	}
+	if input.Percentage != nil {
+		rule.Percentage = *input.Percentage
+	}
+
+	if err := validateDisbursementRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = db.Exec(context.Background(), `
+		UPDATE trusts.disbursement_rules
+		SET
+			description = $1,
+			trigger_type = $2,
+			trigger_value = $3,
+			disbursement_type = $4,
+			amount = $5,
+			currency = $6,
+			percentage = $7,
+			updated_at = NOW()
+		WHERE id = $8 AND trust_id = $9 AND is_active = true
+	`, rule.Description, rule.TriggerType, rule.TriggerValue, rule.DisbursementType,
+		rule.Amount.Amount, rule.Amount.Currency, rule.Percentage, ruleID, trustID)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update disbursement rule: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Disbursement rule updated successfully"})
+}
+
+func removeDisbursementRule(c *gin.Context) {
+	trustID := c.Param("id")
+	ruleID := c.Param("ruleId")
+
+	result, err := db.Exec(context.Background(), `
+		UPDATE trusts.disbursement_rules
+		SET is_active = false, updated_at = NOW()
+		WHERE id = $1 AND trust_id = $2 AND is_active = true
+	`, ruleID, trustID)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove disbursement rule: " + err.Error()})
+		return
+	}
+
+	rowsAffected := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Disbursement rule not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// triggerDisbursementRule fires an event rule on request; age and date rules
+// are fired by the scheduled evaluator.
+func triggerDisbursementRule(c *gin.Context) {
+	trustID := c.Param("id")
+	ruleID := c.Param("ruleId")
+
+	var triggerType, status string
+	var triggeredAt *time.Time
+	err := db.QueryRow(context.Background(), `
+		SELECT r.trigger_type, r.triggered_at, t.status
+		FROM trusts.disbursement_rules r
+		JOIN trusts.trusts t ON t.id = r.trust_id
+		WHERE r.id = $1 AND r.trust_id = $2 AND r.is_active = true
+	`, ruleID, trustID).Scan(&triggerType, &triggeredAt, &status)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Disbursement rule not found"})
+		return
+	}
+
+	if triggerType != TriggerTypeEvent {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only event rules can be triggered manually"})
+		return
+	}
+
+	if triggeredAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Disbursement rule has already been triggered"})
+		return
+	}
+
+	if status != TrustStatusActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Disbursements can only be made from an active trust"})
+		return
+	}
+
+	disbursements, err := fireDisbursementRule(context.Background(), ruleID, time.Now())
+	var shortfall *ShortfallError
+	if errors.As(err, &shortfall) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Linked accounts cannot cover the disbursement: " + shortfall.Error(), "shortfall": shortfall})
+		return
+	}
+	if errors.Is(err, errNothingToDisburse) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Linked accounts hold no funds to disburse"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trigger disbursement rule: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"disbursements": disbursements})
+}
+
+func getDisbursements(c *gin.Context) {
+	trustID := c.Param("id")
+	var disbursements []Disbursement
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, trust_id, rule_id, beneficiary_id, account_id, amount,
+		       currency, status, created_at
+		FROM trusts.disbursements
+		WHERE trust_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+	`, trustID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve disbursements"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var disbursement Disbursement
+		err := rows.Scan(
+			&disbursement.ID, &disbursement.TrustID, &disbursement.RuleID, &disbursement.BeneficiaryID,
+			&disbursement.AccountID, &disbursement.Amount.Amount, &disbursement.Amount.Currency,
+			&disbursement.Status, &disbursement.CreatedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan disbursement data"})
+			return
+		}
+		disbursements = append(disbursements, disbursement)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"disbursements": disbursements})
+}
+
+// validateDisbursementRule checks the trigger and amount settings of a rule
+// and fills in defaults.
+func validateDisbursementRule(rule *DisbursementRule) error {
+	switch rule.TriggerType {
+	case TriggerTypeAge:
+		age, err := strconv.Atoi(rule.TriggerValue)
+		if err != nil || age <= 0 || age > 150 {
+			return errors.New("trigger_value must be an age in years for age triggers")
+		}
+	case TriggerTypeDate:
+		if _, err := time.Parse("2006-01-02", rule.TriggerValue); err != nil {
+			return errors.New("trigger_value must be a date in YYYY-MM-DD format for date triggers")
+		}
+	case TriggerTypeEvent:
+		if rule.TriggerValue == "" {
+			return errors.New("trigger_value must describe the event for event triggers")
+		}
+	default:
+		return fmt.Errorf("invalid trigger_type %q", rule.TriggerType)
+	}
+
+	switch rule.DisbursementType {
+	case DisbursementTypePercentage:
+		if rule.Percentage <= 0 || rule.Percentage > 100 {
+			return errors.New("percentage must be between 1 and 100")
+		}
+		rule.Amount = models.Money{}
+	case DisbursementTypeFixed:
+		if !rule.Amount.IsPositive() {
+			return errors.New("amount must be greater than zero for fixed disbursements")
+		}
+		rule.Percentage = 0
+	default:
+		return fmt.Errorf("invalid disbursement_type %q", rule.DisbursementType)
+	}
+
+	if rule.Amount.Currency == "" {
+		rule.Amount.Currency = "USD"
+	}
+	rule.Amount = rule.Amount.Round()
+
+	return nil
+}
+
+// evaluateDisbursementRules periodically checks age and date rules on active trusts
+func evaluateDisbursementRules(ctx context.Context) {
+	interval, err := time.ParseDuration(getEnv("DISBURSEMENT_EVAL_INTERVAL", "1h"))
+	if err != nil {
+		log.Printf("Invalid DISBURSEMENT_EVAL_INTERVAL, using 1h: %v", err)
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := processDisbursementRules(ctx, time.Now()); err != nil {
+			log.Printf("Error evaluating disbursement rules: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processDisbursementRules fires every untriggered age or date rule whose trigger has been reached
+func processDisbursementRules(ctx context.Context, now time.Time) error {
+	rows, err := db.Query(ctx, `
+		SELECT r.id, r.trigger_type, r.trigger_value, b.date_of_birth
+		FROM trusts.disbursement_rules r
+		JOIN trusts.trusts t ON t.id = r.trust_id
+		JOIN trusts.beneficiaries b ON b.id = r.beneficiary_id AND b.is_active = true
+		WHERE r.is_active = true
+		  AND r.triggered_at IS NULL
+		  AND r.trigger_type IN ('age', 'date')
+		  AND t.status = 'ACTIVE'
+	`)
+	if err != nil {
+		return err
+	}
+
+	var due []string
+	for rows.Next() {
+		var id, triggerType, triggerValue string
+		var dateOfBirth *time.Time
+		if err := rows.Scan(&id, &triggerType, &triggerValue, &dateOfBirth); err != nil {
+			rows.Close()
+			return err
+		}
+		if ruleIsDue(triggerType, triggerValue, dateOfBirth, now) {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range due {
+		disbursements, err := fireDisbursementRule(ctx, id, now)
+		if err != nil {
+			log.Printf("Failed to fire disbursement rule %s: %v", id, err)
+			continue
+		}
+		log.Printf("Disbursement rule %s triggered %d pending disbursements", id, len(disbursements))
+	}
+
+	return nil
+}
+
+// ruleIsDue reports whether an age or date trigger has been reached at now
+func ruleIsDue(triggerType, triggerValue string, dateOfBirth *time.Time, now time.Time) bool {
+	switch triggerType {
+	case TriggerTypeAge:
+		if dateOfBirth == nil {
+			return false
+		}
+		age, err := strconv.Atoi(triggerValue)
+		if err != nil {
+			return false
+		}
+		return !now.Before(dateOfBirth.AddDate(age, 0, 0))
+	case TriggerTypeDate:
+		date, err := time.Parse("2006-01-02", triggerValue)
+		if err != nil {
+			return false
+		}
+		return !now.Before(date)
+	}
+	return false
+}
+
+// fireDisbursementRule marks a rule as triggered and records pending
+// disbursements against the trust's linked accounts in a single transaction.
+// A fixed rule the accounts cannot cover, or a percentage rule over accounts
+// holding nothing, is left untriggered.
+func fireDisbursementRule(ctx context.Context, ruleID string, now time.Time) ([]Disbursement, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var rule DisbursementRule
+	err = tx.QueryRow(ctx, `
+		SELECT id, trust_id, beneficiary_id, disbursement_type, amount, currency, percentage
+		FROM trusts.disbursement_rules
+		WHERE id = $1 AND is_active = true AND triggered_at IS NULL
+		FOR UPDATE
+	`, ruleID).Scan(
+		&rule.ID, &rule.TrustID, &rule.BeneficiaryID, &rule.DisbursementType,
+		&rule.Amount.Amount, &rule.Amount.Currency, &rule.Percentage,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, errors.New("rule is inactive or already triggered")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT a.id, a.balance_amount, a.balance_currency
+		FROM trusts.trust_accounts ta
+		JOIN accounts.accounts a ON a.id = ta.account_id
+		WHERE ta.trust_id = $1 AND a.is_active = true
+		ORDER BY a.balance_amount DESC
+	`, rule.TrustID)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []linkedAccount
+	for rows.Next() {
+		var account linkedAccount
+		if err := rows.Scan(&account.ID, &account.Balance.Amount, &account.Balance.Currency); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	disbursements, err := planDisbursements(&rule, accounts, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range disbursements {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO trusts.disbursements (
+				id, trust_id, rule_id, beneficiary_id, account_id, amount, currency, status, created_at
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9
+			)
+		`, d.ID, d.TrustID, d.RuleID, d.BeneficiaryID, d.AccountID, d.Amount.Amount,
+			d.Amount.Currency, d.Status, d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE trusts.disbursement_rules
+		SET triggered_at = $1, updated_at = $1
+		WHERE id = $2
+	`, now, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return disbursements, nil
+}
+
+// errNothingToDisburse is returned when a percentage rule's linked accounts
+// hold no funds. The rule stays pending so it fires once they are funded.
+var errNothingToDisburse = errors.New("linked accounts hold no funds to disburse")
+
+// ShortfallError is returned when a fixed rule's linked accounts hold less
+// than the rule's amount in its currency. The rule stays pending so it fires
+// once the accounts are funded. Accounts in other currencies are listed
+// because they cannot fund the rule.
+type ShortfallError struct {
+	Required              models.Money `json:"required"`
+	Available             models.Money `json:"available"`
+	OtherCurrencyAccounts []string     `json:"other_currency_accounts,omitempty"`
+}
+
+func (e *ShortfallError) Error() string {
+	msg := fmt.Sprintf("linked accounts hold %s of the %s required", e.Available, e.Required)
+	if len(e.OtherCurrencyAccounts) > 0 {
+		msg += fmt.Sprintf("; %d accounts in other currencies cannot be drawn on", len(e.OtherCurrencyAccounts))
+	}
+	return msg
+}
+
+// planDisbursements splits a rule's amount across the linked accounts.
+// Percentage rules take that share of every account balance and fail with
+// errNothingToDisburse when no balance yields an amount; fixed rules draw
+// from the largest balances in the rule's currency first and fail with a
+// ShortfallError rather than disburse less than the rule's amount.
+func planDisbursements(rule *DisbursementRule, accounts []linkedAccount, now time.Time) ([]Disbursement, error) {
+	var disbursements []Disbursement
+	add := func(account linkedAccount, amount models.Money) {
+		disbursements = append(disbursements, Disbursement{
+			ID:            uuid.New().String(),
+			TrustID:       rule.TrustID,
+			RuleID:        rule.ID,
+			BeneficiaryID: rule.BeneficiaryID,
+			AccountID:     account.ID,
+			Amount:        amount,
+			Status:        DisbursementStatusPending,
+			CreatedAt:     now,
+		})
+	}
+
+	switch rule.DisbursementType {
+	case DisbursementTypePercentage:
+		for _, account := range accounts {
+			amount := account.Balance.Round().Percent(decimal.NewFromInt(int64(rule.Percentage)))
+			if amount.IsPositive() {
+				add(account, amount)
+			}
+		}
+		if len(disbursements) == 0 {
+			return nil, errNothingToDisburse
+		}
+	case DisbursementTypeFixed:
+		required := rule.Amount.Round()
+		remaining := required
+		var otherCurrency []string
+		for _, account := range accounts {
+			if !remaining.IsPositive() {
+				break
+			}
+			if !account.Balance.IsPositive() {
+				continue
+			}
+			amount, err := remaining.Min(account.Balance.Round())
+			if errors.Is(err, money.ErrCurrencyMismatch) {
+				otherCurrency = append(otherCurrency, account.ID)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			add(account, amount)
+			if remaining, err = remaining.Sub(amount); err != nil {
+				return nil, err
+			}
+		}
+		if remaining.IsPositive() {
+			available, _ := required.Sub(remaining)
+			return nil, &ShortfallError{
+				Required:              required,
+				Available:             available,
+				OtherCurrencyAccounts: otherCurrency,
+			}
+		}
+	}
+
+	return disbursements, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/beneficiaries"
+)
+
+// Trust lifecycle statuses
+const (
+	TrustStatusDraft             = "DRAFT"
+	TrustStatusPendingSignatures = "PENDING_SIGNATURES"
+	TrustStatusActive            = "ACTIVE"
+	TrustStatusSuspended         = "SUSPENDED"
+	TrustStatusRevoked           = "REVOKED"
+	TrustStatusTerminated        = "TERMINATED"
+	TrustStatusInactive          = "INACTIVE"
+)
+
+// trustTransitions lists the statuses a trust may move to from each status.
+// INACTIVE is the soft-deleted state used by deleteTrust.
+var trustTransitions = map[string][]string{
+	TrustStatusDraft:             {TrustStatusPendingSignatures, TrustStatusInactive},
+	TrustStatusPendingSignatures: {TrustStatusActive, TrustStatusDraft, TrustStatusInactive},
+	TrustStatusActive:            {TrustStatusSuspended, TrustStatusRevoked, TrustStatusTerminated},
+	TrustStatusSuspended:         {TrustStatusActive, TrustStatusRevoked, TrustStatusTerminated},
+	TrustStatusRevoked:           {TrustStatusInactive},
+	TrustStatusTerminated:        {TrustStatusInactive},
+}
+
+// StatusChange represents an entry in a trust's status history
+type StatusChange struct {
+	ID         string    `json:"id"`
+	TrustID    string    `json:"trust_id"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    string    `json:"actor_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TransitionError is returned when a trust cannot move between two statuses
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change trust status from %s to %s", e.From, e.To)
+}
+
+// errTrustNotFound is returned when a transition targets a missing trust
+var errTrustNotFound = errors.New("trust not found")
+
+// errNoActor is returned when a request names no actor and has no valid auth session
+var errNoActor = errors.New("actor_id or a valid auth session is required")
+
+// transitionCheck verifies, under the trust's row lock, that a trust may
+// make a transition its status allows
+type transitionCheck func(ctx context.Context, tx pgx.Tx, trustID string) error
+
+// canTransition reports whether a trust may move from one status to another
+func canTransition(from, to string) bool {
+	for _, allowed := range trustTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionTrust moves a trust to a new status and appends the change to its
+// status history in the same transaction. check, when not nil, runs in that
+// transaction once the trust is locked and the transition allowed.
+func transitionTrust(ctx context.Context, trustID, to, actorID, reason string, check transitionCheck) (string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var from string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM trusts.trusts WHERE id = $1 FOR UPDATE
+	`, trustID).Scan(&from)
+	if err == pgx.ErrNoRows {
+		return "", errTrustNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !canTransition(from, to) {
+		return from, &TransitionError{From: from, To: to}
+	}
+
+	if check != nil {
+		if err := check(ctx, tx, trustID); err != nil {
+			return from, err
+		}
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(ctx, `
+		UPDATE trusts.trusts
+		SET status = $1,
+		    activated_at = CASE WHEN $1 = 'ACTIVE' THEN COALESCE(activated_at, $2) ELSE activated_at END,
+		    updated_at = $2
+		WHERE id = $3
+	`, to, now, trustID)
+	if err != nil {
+		return from, err
+	}
+
+	if err := recordStatusChange(ctx, tx, trustID, from, to, actorID, reason, now); err != nil {
+		return from, err
+	}
+
+	return from, tx.Commit(ctx)
+}
+
+// recordStatusChange appends an entry to trusts.status_history
+func recordStatusChange(ctx context.Context, tx pgx.Tx, trustID, from, to, actorID, reason string, at time.Time) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO trusts.status_history (
+			id, trust_id, from_status, to_status, actor_id, reason, created_at
+		) VALUES (
+			$1, $2, NULLIF($3, ''), $4, $5, $6, $7
+		)
+	`, uuid.New().String(), trustID, from, to, actorID, reason, at)
+	return err
+}
+
+// AllocationError is returned when a trust's beneficiary split is incomplete
+type AllocationError struct {
+	Allocated int
+}
+
+func (e *AllocationError) Error() string {
+	return "Beneficiary percentages must add up to 100 before activation"
+}
+
+// checkActivation verifies, with the trust locked, that its beneficiary split
+// is complete and that the trust document has been signed by every required
+// signatory. Trustee signatures are filled in from the document's signatories
+// in the same transaction.
+func checkActivation(ctx context.Context, tx pgx.Tx, trustID string) error {
+	allocated, err := beneficiaryAllocation(ctx, tx, trustID)
+	if err != nil {
+		return fmt.Errorf("failed to check beneficiaries: %w", err)
+	}
+	if !beneficiaries.Complete(allocated) {
+		return &AllocationError{Allocated: allocated}
+	}
+
+	if err := syncTrusteeSignatures(ctx, tx, trustID); err != nil {
+		return fmt.Errorf("failed to sync trustee signatures: %w", err)
+	}
+
+	return checkTrustDocumentSigned(ctx, tx, trustID)
+}
+
+// sessionActor returns the user whose auth session, given in the
+// X-Session-ID header, made the request
+func sessionActor(c *gin.Context) (string, error) {
+	sessionID := c.GetHeader("X-Session-ID")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return "", errNoActor
+	}
+
+	session, err := sessionService.GetActiveSession(c.Request.Context(), sessionID)
+	if err == pgx.ErrNoRows {
+		return "", errNoActor
+	}
+	if err != nil {
+		return "", err
+	}
+	return session.UserID, nil
+}
+
+// changeTrustStatus returns a handler that moves a trust to the given status.
+// The request body must identify the actor and the reason for the change.
+func changeTrustStatus(to string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trustID := c.Param("id")
+
+		var input struct {
+			ActorID string `json:"actor_id" binding:"required"`
+			Reason  string `json:"reason" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		respondToTransition(c, trustID, to, input.ActorID, input.Reason, nil)
+	}
+}
+
+// respondToTransition performs a status change and writes the handler response
+func respondToTransition(c *gin.Context, trustID, to, actorID, reason string, check transitionCheck) {
+	from, err := transitionTrust(context.Background(), trustID, to, actorID, reason, check)
+
+	var transitionErr *TransitionError
+	var signingErr *SigningError
+	var allocationErr *AllocationError
+	switch {
+	case errors.Is(err, errTrustNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trust not found"})
+		return
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   err.Error(),
+			"status":  transitionErr.From,
+			"allowed": trustTransitions[transitionErr.From],
+		})
+		return
+	case errors.As(err, &allocationErr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":            allocationErr.Error(),
+			"total_percentage": allocationErr.Allocated,
+		})
+		return
+	case errors.As(err, &signingErr):
+		respondToSigningError(c, err)
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change trust status: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Trust status changed successfully",
+		"from_status": from,
+		"status":      to,
+	})
+}
+
+func getTrustHistory(c *gin.Context) {
+	trustID := c.Param("id")
+	var history []StatusChange
+
+	rows, err := db.Query(context.Background(), `
+		SELECT id, trust_id, COALESCE(from_status, ''), to_status, actor_id, reason, created_at
+		FROM trusts.status_history
+		WHERE trust_id = $1
+		ORDER BY created_at, id
+	`, trustID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve trust history"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change StatusChange
+		err := rows.Scan(
+			&change.ID, &change.TrustID, &change.FromStatus, &change.ToStatus,
+			&change.ActorID, &change.Reason, &change.CreatedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan trust history data"})
+			return
+		}
+		history = append(history, change)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"history": history})
+}
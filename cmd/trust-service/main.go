@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -12,19 +13,23 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/auth"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
 )
 
 // Trust represents a legal trust
 type Trust struct {
-	ID            string     `json:"id"`
-	Name          string     `json:"name"`
-	Type          string     `json:"type"`
-	Status        string     `json:"status"`
-	CreatorUserID string     `json:"creator_user_id"`
-	DocumentID    string     `json:"document_id,omitempty"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
-	ActivatedAt   *time.Time `json:"activated_at,omitempty"`
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Type              string     `json:"type"`
+	Status            string     `json:"status"`
+	CreatorUserID     string     `json:"creator_user_id"`
+	DocumentID        string     `json:"document_id,omitempty"`
+	ReportingCurrency string     `json:"reporting_currency"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	ActivatedAt       *time.Time `json:"activated_at,omitempty"`
 }
 
 // Trustee represents a trustee on a trust
@@ -41,6 +46,9 @@ type Trustee struct {
 
 var db *pgxpool.Pool
 
+// sessionService identifies the users behind requests that name no actor
+var sessionService *auth.SessionService
+
 func main() {
 	log.Println("Starting trust-service...")
 
@@ -59,6 +67,18 @@ func main() {
 	}
 	log.Println("Connected to database")
 
+	// Ensure the trust-service tables exist
+	if err := ensureTrustSchema(db); err != nil {
+		log.Fatalf("Failed to ensure trust schema: %v", err)
+	}
+
+	sessionService = auth.NewSessionService(db)
+
+	// Start the disbursement rule evaluator
+	evalCtx, stopEval := context.WithCancel(context.Background())
+	defer stopEval()
+	go evaluateDisbursementRules(evalCtx)
+
 	// Set up Gin router
 	router := gin.Default()
 
@@ -86,13 +106,37 @@ func main() {
 			trusts.POST("/:id/trustees", addTrustee)
 			trusts.PUT("/:id/trustees/:trusteeId", updateTrustee)
 			trusts.DELETE("/:id/trustees/:trusteeId", removeTrustee)
+			trusts.POST("/:id/signatures/sync", syncTrustSignatures)
+
+			// Beneficiaries
+			trusts.GET("/:id/beneficiaries", getBeneficiaries)
+			trusts.POST("/:id/beneficiaries", addBeneficiary)
+			trusts.PUT("/:id/beneficiaries/:beneficiaryId", updateBeneficiary)
+			trusts.DELETE("/:id/beneficiaries/:beneficiaryId", removeBeneficiary)
 
 			// Accounts
 			trusts.POST("/:id/accounts", linkAccount)
 			trusts.DELETE("/:id/accounts/:accountId", unlinkAccount)
 
-			// Trust actions
+			// Disbursement rules
+			trusts.GET("/:id/disbursement-rules", getDisbursementRules)
+			trusts.GET("/:id/disbursement-rules/:ruleId", getDisbursementRuleByID)
+			trusts.POST("/:id/disbursement-rules", addDisbursementRule)
+			trusts.PUT("/:id/disbursement-rules/:ruleId", updateDisbursementRule)
+			trusts.DELETE("/:id/disbursement-rules/:ruleId", removeDisbursementRule)
+			trusts.POST("/:id/disbursement-rules/:ruleId/trigger", triggerDisbursementRule)
+
+			// Disbursements
+			trusts.GET("/:id/disbursements", getDisbursements)
+
+			// Trust lifecycle
+			trusts.GET("/:id/history", getTrustHistory)
+			trusts.POST("/:id/submit", submitTrust)
+			trusts.POST("/:id/return-to-draft", changeTrustStatus(TrustStatusDraft))
 			trusts.POST("/:id/activate", activateTrust)
+			trusts.POST("/:id/suspend", changeTrustStatus(TrustStatusSuspended))
+			trusts.POST("/:id/revoke", changeTrustStatus(TrustStatusRevoked))
+			trusts.POST("/:id/terminate", changeTrustStatus(TrustStatusTerminated))
 		}
 
 		userTrusts := v1.Group("/users/:userId/trusts")
@@ -119,6 +163,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down trust-service...")
+	stopEval()
 
 	// Give the server 5 seconds to finish ongoing requests
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -135,7 +180,7 @@ func listTrusts(c *gin.Context) {
 	var trusts []Trust
 	rows, err := db.Query(context.Background(), `
 		SELECT id, name, type, status, creator_user_id, document_id, 
-		       created_at, updated_at, activated_at
+		       reporting_currency, created_at, updated_at, activated_at
 		FROM trusts.trusts
 		WHERE status != 'INACTIVE'
 		LIMIT 100
@@ -150,7 +195,7 @@ func listTrusts(c *gin.Context) {
 		var trust Trust
 		err := rows.Scan(
 			&trust.ID, &trust.Name, &trust.Type, &trust.Status, &trust.CreatorUserID,
-			&trust.DocumentID, &trust.CreatedAt, &trust.UpdatedAt, &trust.ActivatedAt,
+			&trust.DocumentID, &trust.ReportingCurrency, &trust.CreatedAt, &trust.UpdatedAt, &trust.ActivatedAt,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan trust data"})
@@ -168,12 +213,12 @@ func getTrustByID(c *gin.Context) {
 
 	err := db.QueryRow(context.Background(), `
 		SELECT id, name, type, status, creator_user_id, document_id, 
-		       created_at, updated_at, activated_at
+		       reporting_currency, created_at, updated_at, activated_at
 		FROM trusts.trusts
 		WHERE id = $1 AND status != 'INACTIVE'
 	`, id).Scan(
 		&trust.ID, &trust.Name, &trust.Type, &trust.Status, &trust.CreatorUserID,
-		&trust.DocumentID, &trust.CreatedAt, &trust.UpdatedAt, &trust.ActivatedAt,
+		&trust.DocumentID, &trust.ReportingCurrency, &trust.CreatedAt, &trust.UpdatedAt, &trust.ActivatedAt,
 	)
 
 	if err != nil {
@@ -190,7 +235,7 @@ func getUserTrusts(c *gin.Context) {
 
 	rows, err := db.Query(context.Background(), `
 		SELECT id, name, type, status, creator_user_id, document_id, 
-		       created_at, updated_at, activated_at
+		       reporting_currency, created_at, updated_at, activated_at
 		FROM trusts.trusts
 		WHERE creator_user_id = $1 AND status != 'INACTIVE'
 	`, userID)
@@ -204,7 +249,7 @@ func getUserTrusts(c *gin.Context) {
 		var trust Trust
 		err := rows.Scan(
 			&trust.ID, &trust.Name, &trust.Type, &trust.Status, &trust.CreatorUserID,
-			&trust.DocumentID, &trust.CreatedAt, &trust.UpdatedAt, &trust.ActivatedAt,
+			&trust.DocumentID, &trust.ReportingCurrency, &trust.CreatedAt, &trust.UpdatedAt, &trust.ActivatedAt,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan trust data"})
@@ -218,10 +263,11 @@ func getUserTrusts(c *gin.Context) {
 
 func createTrust(c *gin.Context) {
 	var input struct {
-		Name          string `json:"name" binding:"required"`
-		Type          string `json:"type" binding:"required"`
-		CreatorUserID string `json:"creator_user_id" binding:"required"`
-		DocumentID    string `json:"document_id"`
+		Name              string `json:"name" binding:"required"`
+		Type              string `json:"type" binding:"required"`
+		CreatorUserID     string `json:"creator_user_id" binding:"required"`
+		DocumentID        string `json:"document_id"`
+		ReportingCurrency string `json:"reporting_currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -229,6 +275,15 @@ func createTrust(c *gin.Context) {
 		return
 	}
 
+	input.ReportingCurrency = fx.NormalizeCurrency(input.ReportingCurrency)
+	if input.ReportingCurrency == "" {
+		input.ReportingCurrency = fx.DefaultCurrency
+	}
+	if !fx.ValidCurrency(input.ReportingCurrency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reporting_currency must be a three letter currency code"})
+		return
+	}
+
 	// Validate if user exists
 	var userExists bool
 	err := db.QueryRow(context.Background(), `
@@ -242,13 +297,32 @@ func createTrust(c *gin.Context) {
 
 	id := uuid.New().String()
 
-	_, err = db.Exec(context.Background(), `
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trust: " + err.Error()})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
 		INSERT INTO trusts.trusts (
-			id, name, type, status, creator_user_id, document_id
+			id, name, type, status, creator_user_id, document_id, reporting_currency
 		) VALUES (
-			$1, $2, $3, $4, $5, $6
+			$1, $2, $3, $4, $5, $6, $7
 		)
-	`, id, input.Name, input.Type, "DRAFT", input.CreatorUserID, input.DocumentID)
+	`, id, input.Name, input.Type, TrustStatusDraft, input.CreatorUserID, input.DocumentID, input.ReportingCurrency)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trust: " + err.Error()})
+		return
+	}
+
+	// Start the status history with the creation of the trust
+	err = recordStatusChange(ctx, tx, id, "", TrustStatusDraft, input.CreatorUserID, "Trust created", time.Now())
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trust: " + err.Error()})
@@ -265,10 +339,11 @@ func updateTrust(c *gin.Context) {
 	id := c.Param("id")
 
 	var input struct {
-		Name       string `json:"name"`
-		Type       string `json:"type"`
-		Status     string `json:"status"`
-		DocumentID string `json:"document_id"`
+		Name              string `json:"name"`
+		Type              string `json:"type"`
+		Status            string `json:"status"`
+		DocumentID        string `json:"document_id"`
+		ReportingCurrency string `json:"reporting_currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -276,6 +351,19 @@ func updateTrust(c *gin.Context) {
 		return
 	}
 
+	if input.ReportingCurrency != "" {
+		if input.ReportingCurrency = fx.NormalizeCurrency(input.ReportingCurrency); !fx.ValidCurrency(input.ReportingCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reporting_currency must be a three letter currency code"})
+			return
+		}
+	}
+
+	// Status changes must go through the lifecycle endpoints so they are audited
+	if input.Status != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Trust status cannot be updated directly; use the trust lifecycle actions"})
+		return
+	}
+
 	// First check if trust exists
 	var exists bool
 	err := db.QueryRow(context.Background(), `
@@ -293,11 +381,11 @@ func updateTrust(c *gin.Context) {
 		SET 
 			name = COALESCE(NULLIF($1, ''), name),
 			type = COALESCE(NULLIF($2, ''), type),
-			status = COALESCE(NULLIF($3, ''), status),
-			document_id = COALESCE(NULLIF($4, ''), document_id),
+			document_id = COALESCE(NULLIF($3, ''), document_id),
+			reporting_currency = COALESCE(NULLIF($4, ''), reporting_currency),
 			updated_at = NOW()
 		WHERE id = $5 AND status != 'INACTIVE'
-	`, input.Name, input.Type, input.Status, input.DocumentID, id)
+	`, input.Name, input.Type, input.DocumentID, input.ReportingCurrency, id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trust: " + err.Error()})
@@ -310,21 +398,38 @@ func updateTrust(c *gin.Context) {
 func deleteTrust(c *gin.Context) {
 	id := c.Param("id")
 
-	result, err := db.Exec(context.Background(), `
-		UPDATE trusts.trusts
-		SET status = 'INACTIVE', updated_at = NOW()
-		WHERE id = $1 AND status != 'INACTIVE'
-	`, id)
+	actorID := c.Query("actor_id")
+	if actorID == "" {
+		var err error
+		actorID, err = sessionActor(c)
+		if errors.Is(err, errNoActor) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session: " + err.Error()})
+			return
+		}
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trust: " + err.Error()})
-		return
+	reason := c.Query("reason")
+	if reason == "" {
+		reason = "Trust deleted"
 	}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
+	_, err := transitionTrust(context.Background(), id, TrustStatusInactive, actorID, reason, nil)
+
+	var transitionErr *TransitionError
+	switch {
+	case errors.Is(err, errTrustNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "Trust not found"})
 		return
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trust: " + err.Error()})
+		return
 	}
 
 	c.Status(http.StatusNoContent)
@@ -569,39 +674,169 @@ func unlinkAccount(c *gin.Context) {
 func activateTrust(c *gin.Context) {
 	trustID := c.Param("id")
 
-	// Check if trust exists and is in proper state
-	var status string
-	err := db.QueryRow(context.Background(), `
-		SELECT status FROM trusts.trusts WHERE id = $1
-	`, trustID).Scan(&status)
+	var input struct {
+		ActorID string `json:"actor_id" binding:"required"`
+		Reason  string `json:"reason" binding:"required"`
+	}
 
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Trust not found"})
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if status == "ACTIVE" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Trust is already active"})
-		return
+	respondToTransition(c, trustID, TrustStatusActive, input.ActorID, input.Reason, checkActivation)
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
+	return value
+}
 
-	if status == "INACTIVE" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot activate an inactive trust"})
-		return
+// ensureTrustSchema ensures the tables owned by trust-service exist
+func ensureTrustSchema(db *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	// Create the trusts schema if it doesn't exist
+	_, err := db.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS trusts")
+	if err != nil {
+		return err
+	}
+
+	// Create the beneficiaries table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS trusts.beneficiaries (
+			id UUID PRIMARY KEY,
+			trust_id UUID NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			relationship VARCHAR(100),
+			percentage INTEGER NOT NULL DEFAULT 0,
+			date_of_birth DATE,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the disbursement_rules table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS trusts.disbursement_rules (
+			id UUID PRIMARY KEY,
+			trust_id UUID NOT NULL,
+			beneficiary_id UUID NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			trigger_type VARCHAR(20) NOT NULL,
+			trigger_value VARCHAR(255) NOT NULL DEFAULT '',
+			disbursement_type VARCHAR(20) NOT NULL,
+			amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			percentage INTEGER NOT NULL DEFAULT 0,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			triggered_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the disbursements table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS trusts.disbursements (
+			id UUID PRIMARY KEY,
+			trust_id UUID NOT NULL,
+			rule_id UUID NOT NULL REFERENCES trusts.disbursement_rules(id),
+			beneficiary_id UUID NOT NULL,
+			account_id UUID NOT NULL,
+			amount DECIMAL(19, 4) NOT NULL,
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the status_history table if it doesn't exist
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS trusts.status_history (
+			id UUID PRIMARY KEY,
+			trust_id UUID NOT NULL,
+			from_status VARCHAR(50),
+			to_status VARCHAR(50) NOT NULL,
+			actor_id VARCHAR(255) NOT NULL,
+			reason TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	// Activate the trust
-	now := time.Now()
-	_, err = db.Exec(context.Background(), `
-		UPDATE trusts.trusts
-		SET status = 'ACTIVE', activated_at = $1, updated_at = $1
-		WHERE id = $2
-	`, now, trustID)
+	// Keep the status history append-only
+	_, err = db.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION trusts.reject_status_history_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION 'trusts.status_history is append-only';
+		END;
+		$$ LANGUAGE plpgsql
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'status_history_append_only'
+			) THEN
+				CREATE TRIGGER status_history_append_only
+				BEFORE UPDATE OR DELETE ON trusts.status_history
+				FOR EACH ROW EXECUTE FUNCTION trusts.reject_status_history_change();
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return err
+	}
 
+	// Aggregated views convert balances into each trust's reporting currency
+	_, err = db.Exec(ctx, "ALTER TABLE trusts.trusts ADD COLUMN IF NOT EXISTS reporting_currency VARCHAR(3) NOT NULL DEFAULT 'USD'")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate trust: " + err.Error()})
-		return
+		return err
+	}
+
+	// Create indexes
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_trust_status_history_trust_id ON trusts.status_history(trust_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_trust_beneficiaries_trust_id ON trusts.beneficiaries(trust_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_disbursement_rules_trust_id ON trusts.disbursement_rules(trust_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_disbursements_trust_id ON trusts.disbursements(trust_id)")
+	if err != nil {
+		return err
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Trust activated successfully"})
+	return nil
 }
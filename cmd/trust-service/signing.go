@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// documentStatusSigned is the document-service status of a fully signed document
+const documentStatusSigned = "SIGNED"
+
+// execer is satisfied by both the connection pool and transactions
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// SigningError explains why a trust document does not yet allow activation
+type SigningError struct {
+	Reason         string   `json:"reason"`
+	DocumentID     string   `json:"document_id,omitempty"`
+	DocumentStatus string   `json:"document_status,omitempty"`
+	PendingSigners []string `json:"pending_signatories,omitempty"`
+}
+
+func (e *SigningError) Error() string {
+	return e.Reason
+}
+
+// checkTrustDocumentSigned verifies that the trust's linked document-service
+// document is SIGNED and that every required signatory has signed it. The
+// document is share-locked so its signatures hold until tx ends.
+func checkTrustDocumentSigned(ctx context.Context, tx pgx.Tx, trustID string) error {
+	var documentID string
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(document_id::text, '') FROM trusts.trusts WHERE id = $1
+	`, trustID).Scan(&documentID)
+	if err == pgx.ErrNoRows {
+		return errTrustNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if documentID == "" {
+		return &SigningError{Reason: "Trust has no linked trust document"}
+	}
+
+	var documentStatus string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM documents.documents WHERE id = $1 AND status != 'DELETED' FOR SHARE
+	`, documentID).Scan(&documentStatus)
+	if err == pgx.ErrNoRows {
+		return &SigningError{Reason: "Trust document not found", DocumentID: documentID}
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT name
+		FROM documents.signatories
+		WHERE document_id = $1 AND required = true AND signed_at IS NULL
+		ORDER BY "order"
+	`, documentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var pending []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		pending = append(pending, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if documentStatus != documentStatusSigned || len(pending) > 0 {
+		return &SigningError{
+			Reason:         fmt.Sprintf("Trust document must be signed by all required signatories (status %s)", documentStatus),
+			DocumentID:     documentID,
+			DocumentStatus: documentStatus,
+			PendingSigners: pending,
+		}
+	}
+
+	return nil
+}
+
+// syncTrusteeSignatures copies signed_at from the trust document's signatory
+// records onto the matching trustees. Trustees are matched to signatories by
+// user ID when both have one, and by email only when either has none, so a
+// signatory for another user cannot sign for a trustee sharing the email.
+func syncTrusteeSignatures(ctx context.Context, q execer, trustID string) error {
+	_, err := q.Exec(ctx, `
+		UPDATE trusts.trustees te
+		SET signed_at = s.signed_at
+		FROM trusts.trusts t, documents.signatories s
+		WHERE te.trust_id = t.id
+		  AND t.id = $1
+		  AND s.document_id::text = t.document_id::text
+		  AND s.signed_at IS NOT NULL
+		  AND te.is_active = true
+		  AND te.signed_at IS DISTINCT FROM s.signed_at
+		  AND CASE
+			WHEN NULLIF(te.user_id::text, '') IS NOT NULL AND NULLIF(s.user_id::text, '') IS NOT NULL
+			THEN te.user_id::text = s.user_id::text
+			ELSE LOWER(te.email) = LOWER(s.email)
+		  END
+	`, trustID)
+	return err
+}
+
+// respondToSigningError writes the response for a failed signing check
+func respondToSigningError(c *gin.Context, err error) {
+	var signingErr *SigningError
+	switch {
+	case errors.Is(err, errTrustNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trust not found"})
+	case errors.As(err, &signingErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   signingErr.Reason,
+			"signing": signingErr,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check trust document signatures: " + err.Error()})
+	}
+}
+
+// submitTrust sends a draft trust out for signature once it has a trust document
+func submitTrust(c *gin.Context) {
+	trustID := c.Param("id")
+
+	var input struct {
+		ActorID string `json:"actor_id" binding:"required"`
+		Reason  string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var documentID string
+	err := db.QueryRow(context.Background(), `
+		SELECT COALESCE(document_id::text, '') FROM trusts.trusts WHERE id = $1 AND status != 'INACTIVE'
+	`, trustID).Scan(&documentID)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trust not found"})
+		return
+	}
+
+	if documentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A trust document must be linked before the trust is sent for signatures"})
+		return
+	}
+
+	respondToTransition(c, trustID, TrustStatusPendingSignatures, input.ActorID, input.Reason, nil)
+}
+
+// syncTrustSignatures refreshes trustee signatures from the trust document on request
+func syncTrustSignatures(c *gin.Context) {
+	trustID := c.Param("id")
+
+	if err := syncTrusteeSignatures(context.Background(), db, trustID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync trustee signatures: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trustee signatures synced successfully"})
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/auth"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/risk"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -257,7 +258,7 @@ func registerUser(c *gin.Context) {
 	`, userID, user.Username, user.Email, user.PhoneNumber, user.FirstName,
 		user.LastName, user.DateOfBirth, user.Address.Street, user.Address.City,
 		user.Address.State, user.Address.ZipCode, user.Address.Country,
-		user.RiskProfile, user.DeviceID, "PENDING", false, hashedPassword)
+		risk.Normalize(user.RiskProfile), user.DeviceID, "PENDING", false, hashedPassword)
 
 	if err != nil {
 		log.Printf("Error creating user: %v", err)
@@ -656,6 +657,15 @@ func validateUser(user User) []ValidationError {
 		})
 	}
 
+	// Validate risk profile (if provided); the risk questionnaire in
+	// user-service replaces a self-reported profile once completed
+	if user.RiskProfile != "" && risk.Normalize(user.RiskProfile) == "" {
+		errors = append(errors, ValidationError{
+			Field:   "risk_profile",
+			Message: "Risk profile must be one of: " + strings.Join(risk.Profiles, ", "),
+		})
+	}
+
 	// Validate phone number (if provided)
 	if user.PhoneNumber != "" {
 		if !isValidPhoneNumber(user.PhoneNumber) {
@@ -6,28 +6,33 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/fx"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/risk"
 )
 
 // User represents a user entity
 type User struct {
-	ID          string    `json:"id"`
-	Username    string    `json:"username"`
-	Email       string    `json:"email"`
-	PhoneNumber string    `json:"phone_number,omitempty"`
-	FirstName   string    `json:"first_name"`
-	LastName    string    `json:"last_name"`
-	DateOfBirth string    `json:"date_of_birth"`
-	RiskProfile string    `json:"risk_profile,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	KYCStatus   string    `json:"kyc_status"`
-	IsActive    bool      `json:"is_active"`
+	ID                string    `json:"id"`
+	Username          string    `json:"username"`
+	Email             string    `json:"email"`
+	PhoneNumber       string    `json:"phone_number,omitempty"`
+	FirstName         string    `json:"first_name"`
+	LastName          string    `json:"last_name"`
+	DateOfBirth       string    `json:"date_of_birth"`
+	RiskProfile       string    `json:"risk_profile,omitempty"`
+	ReportingCurrency string    `json:"reporting_currency"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	KYCStatus         string    `json:"kyc_status"`
+	IsActive          bool      `json:"is_active"`
 }
 
 // Global db connection
@@ -51,6 +56,10 @@ func main() {
 	}
 	log.Println("Connected to database")
 
+	if err := ensureUserSchema(db); err != nil {
+		log.Fatalf("Failed to ensure user schema: %v", err)
+	}
+
 	// Set up Gin router
 	router := gin.Default()
 
@@ -72,7 +81,15 @@ func main() {
 			users.POST("", createUser)
 			users.PUT("/:id", updateUser)
 			users.DELETE("/:id", deleteUser)
+
+			// Risk tolerance questionnaire results
+			users.GET("/:id/risk-profile", getRiskProfile)
+			users.GET("/:id/risk-assessments", listRiskAssessments)
+			users.GET("/:id/risk-assessments/:assessmentId", getRiskAssessment)
+			users.POST("/:id/risk-assessments", createRiskAssessment)
 		}
+
+		v1.GET("/risk-questionnaire", getRiskQuestionnaire)
 	}
 
 	// Start server
@@ -109,7 +126,7 @@ func listUsers(c *gin.Context) {
 	var users []User
 	rows, err := db.Query(context.Background(), `
 		SELECT id, username, email, phone_number, first_name, last_name, 
-		       date_of_birth, risk_profile, created_at, updated_at,
+		       date_of_birth, risk_profile, reporting_currency, created_at, updated_at,
 		       kyc_status, is_active
 		FROM users.users
 		WHERE is_active = true
@@ -127,7 +144,7 @@ func listUsers(c *gin.Context) {
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.Email, &user.PhoneNumber,
 			&user.FirstName, &user.LastName, &dateOfBirth, &user.RiskProfile,
-			&user.CreatedAt, &user.UpdatedAt, &user.KYCStatus, &user.IsActive,
+			&user.ReportingCurrency, &user.CreatedAt, &user.UpdatedAt, &user.KYCStatus, &user.IsActive,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan user data"})
@@ -147,14 +164,14 @@ func getUserByID(c *gin.Context) {
 
 	err := db.QueryRow(context.Background(), `
 		SELECT id, username, email, phone_number, first_name, last_name, 
-		       date_of_birth, risk_profile, created_at, updated_at,
+		       date_of_birth, risk_profile, reporting_currency, created_at, updated_at,
 		       kyc_status, is_active
 		FROM users.users
 		WHERE id = $1 AND is_active = true
 	`, id).Scan(
 		&user.ID, &user.Username, &user.Email, &user.PhoneNumber,
 		&user.FirstName, &user.LastName, &dateOfBirth, &user.RiskProfile,
-		&user.CreatedAt, &user.UpdatedAt, &user.KYCStatus, &user.IsActive,
+		&user.ReportingCurrency, &user.CreatedAt, &user.UpdatedAt, &user.KYCStatus, &user.IsActive,
 	)
 
 	if err != nil {
@@ -168,13 +185,14 @@ func getUserByID(c *gin.Context) {
 
 func createUser(c *gin.Context) {
 	var input struct {
-		Username    string `json:"username" binding:"required"`
-		Email       string `json:"email" binding:"required"`
-		PhoneNumber string `json:"phone_number"`
-		FirstName   string `json:"first_name" binding:"required"`
-		LastName    string `json:"last_name" binding:"required"`
-		DateOfBirth string `json:"date_of_birth" binding:"required"`
-		RiskProfile string `json:"risk_profile"`
+		Username          string `json:"username" binding:"required"`
+		Email             string `json:"email" binding:"required"`
+		PhoneNumber       string `json:"phone_number"`
+		FirstName         string `json:"first_name" binding:"required"`
+		LastName          string `json:"last_name" binding:"required"`
+		DateOfBirth       string `json:"date_of_birth" binding:"required"`
+		RiskProfile       string `json:"risk_profile"`
+		ReportingCurrency string `json:"reporting_currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -182,17 +200,35 @@ func createUser(c *gin.Context) {
 		return
 	}
 
+	if input.RiskProfile != "" {
+		if input.RiskProfile = risk.Normalize(input.RiskProfile); input.RiskProfile == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "risk_profile must be one of: " + strings.Join(risk.Profiles, ", ")})
+			return
+		}
+	}
+
+	if input.ReportingCurrency != "" {
+		if input.ReportingCurrency = fx.NormalizeCurrency(input.ReportingCurrency); !fx.ValidCurrency(input.ReportingCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reporting_currency must be a three letter currency code"})
+			return
+		}
+	}
+
+	if input.ReportingCurrency == "" {
+		input.ReportingCurrency = fx.DefaultCurrency
+	}
+
 	id := uuid.New().String()
 
 	_, err := db.Exec(context.Background(), `
 		INSERT INTO users.users (
 			id, username, email, phone_number, first_name, last_name, 
-			date_of_birth, risk_profile, kyc_status, is_active
+			date_of_birth, risk_profile, reporting_currency, kyc_status, is_active
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
 		)
 	`, id, input.Username, input.Email, input.PhoneNumber, input.FirstName,
-		input.LastName, input.DateOfBirth, input.RiskProfile, "PENDING", false)
+		input.LastName, input.DateOfBirth, input.RiskProfile, input.ReportingCurrency, "PENDING", false)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user: " + err.Error()})
@@ -209,13 +245,14 @@ func updateUser(c *gin.Context) {
 	id := c.Param("id")
 
 	var input struct {
-		Username    string `json:"username"`
-		Email       string `json:"email"`
-		PhoneNumber string `json:"phone_number"`
-		FirstName   string `json:"first_name"`
-		LastName    string `json:"last_name"`
-		DateOfBirth string `json:"date_of_birth"`
-		RiskProfile string `json:"risk_profile"`
+		Username          string `json:"username"`
+		Email             string `json:"email"`
+		PhoneNumber       string `json:"phone_number"`
+		FirstName         string `json:"first_name"`
+		LastName          string `json:"last_name"`
+		DateOfBirth       string `json:"date_of_birth"`
+		RiskProfile       string `json:"risk_profile"`
+		ReportingCurrency string `json:"reporting_currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -223,6 +260,20 @@ func updateUser(c *gin.Context) {
 		return
 	}
 
+	if input.RiskProfile != "" {
+		if input.RiskProfile = risk.Normalize(input.RiskProfile); input.RiskProfile == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "risk_profile must be one of: " + strings.Join(risk.Profiles, ", ")})
+			return
+		}
+	}
+
+	if input.ReportingCurrency != "" {
+		if input.ReportingCurrency = fx.NormalizeCurrency(input.ReportingCurrency); !fx.ValidCurrency(input.ReportingCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reporting_currency must be a three letter currency code"})
+			return
+		}
+	}
+
 	// First check if user exists
 	var exists bool
 	err := db.QueryRow(context.Background(), `
@@ -245,10 +296,16 @@ func updateUser(c *gin.Context) {
 			last_name = COALESCE(NULLIF($5, ''), last_name),
 			date_of_birth = COALESCE(NULLIF($6, '')::date, date_of_birth),
 			risk_profile = COALESCE(NULLIF($7, ''), risk_profile),
+			-- A profile set by hand is no longer backed by a questionnaire
+			risk_assessment_id = CASE
+				WHEN NULLIF($7, '') IS NOT NULL AND $7 IS DISTINCT FROM risk_profile THEN NULL
+				ELSE risk_assessment_id
+			END,
+			reporting_currency = COALESCE(NULLIF($8, ''), reporting_currency),
 			updated_at = NOW()
-		WHERE id = $8 AND is_active = true
+		WHERE id = $9 AND is_active = true
 	`, input.Username, input.Email, input.PhoneNumber, input.FirstName,
-		input.LastName, input.DateOfBirth, input.RiskProfile, id)
+		input.LastName, input.DateOfBirth, input.RiskProfile, input.ReportingCurrency, id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user: " + err.Error()})
@@ -280,3 +337,79 @@ func deleteUser(c *gin.Context) {
 
 	c.Status(http.StatusNoContent)
 }
+
+// ensureUserSchema ensures the tables and columns added by user-service exist
+func ensureUserSchema(db *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	// Create the risk assessments table if it doesn't exist
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS users.risk_assessments (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users.users(id),
+			questionnaire_version INTEGER NOT NULL,
+			answers JSONB NOT NULL,
+			score INTEGER NOT NULL,
+			min_score INTEGER NOT NULL,
+			max_score INTEGER NOT NULL,
+			percent DECIMAL(5, 2) NOT NULL,
+			score_profile VARCHAR(50) NOT NULL,
+			profile VARCHAR(50) NOT NULL,
+			factors JSONB NOT NULL,
+			explanation JSONB NOT NULL,
+			completed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_risk_assessments_user_id ON users.risk_assessments(user_id, completed_at)")
+	if err != nil {
+		return err
+	}
+
+	// Assessments are suitability evidence and are never edited once recorded
+	_, err = db.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION users.reject_risk_assessment_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			RAISE EXCEPTION '% is append-only', TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME;
+		END;
+		$$ LANGUAGE plpgsql
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM pg_trigger WHERE tgname = 'risk_assessments_append_only'
+			) THEN
+				CREATE TRIGGER risk_assessments_append_only
+				BEFORE UPDATE OR DELETE ON users.risk_assessments
+				FOR EACH ROW EXECUTE FUNCTION users.reject_risk_assessment_change();
+			END IF;
+		END
+		$$
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Link each user's profile to the assessment it came from
+	_, err = db.Exec(ctx, "ALTER TABLE users.users ADD COLUMN IF NOT EXISTS risk_assessment_id UUID REFERENCES users.risk_assessments(id)")
+	if err != nil {
+		return err
+	}
+
+	// Aggregated views convert balances into each user's reporting currency
+	_, err = db.Exec(ctx, "ALTER TABLE users.users ADD COLUMN IF NOT EXISTS reporting_currency VARCHAR(3) NOT NULL DEFAULT 'USD'")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
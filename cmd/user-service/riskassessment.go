@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/risk"
+)
+
+// defaultRiskAssessmentMaxAge is how long questionnaire answers stay current
+// when RISK_ASSESSMENT_MAX_AGE is not set
+const defaultRiskAssessmentMaxAge = 365 * 24 * time.Hour
+
+// Risk profile sources
+const (
+	riskSourceQuestionnaire = "questionnaire"
+	riskSourceSelfReported  = "self_reported"
+)
+
+// RiskAssessment is a stored, scored risk questionnaire. Assessments are never
+// edited, so each one is evidence of how a profile was derived.
+type RiskAssessment struct {
+	ID                   string            `json:"id"`
+	UserID               string            `json:"user_id"`
+	QuestionnaireVersion int               `json:"questionnaire_version"`
+	Answers              map[string]string `json:"answers"`
+	Score                int               `json:"score"`
+	MinScore             int               `json:"min_score"`
+	MaxScore             int               `json:"max_score"`
+	Percent              float64           `json:"percent"`
+	ScoreProfile         string            `json:"score_profile"`
+	Profile              string            `json:"profile"`
+	Factors              []risk.Factor     `json:"factors"`
+	Explanation          []string          `json:"explanation"`
+	CompletedAt          time.Time         `json:"completed_at"`
+}
+
+// RetakeStatus says whether a user should be asked to retake the questionnaire
+type RetakeStatus struct {
+	Required bool       `json:"required"`
+	Reasons  []string   `json:"reasons,omitempty"`
+	DueAt    *time.Time `json:"due_at,omitempty"`
+}
+
+var errUserNotFound = errors.New("user not found")
+
+// riskAssessmentMaxAge returns how old answers may be before a retake is due
+func riskAssessmentMaxAge() time.Duration {
+	return durationEnv("RISK_ASSESSMENT_MAX_AGE", defaultRiskAssessmentMaxAge)
+}
+
+// retakeStatus decides whether the latest assessment still supports the
+// user's profile. linked is false when the profile was changed by hand after
+// the assessment.
+func retakeStatus(latest *RiskAssessment, linked bool, now time.Time) RetakeStatus {
+	if latest == nil {
+		return RetakeStatus{Required: true, Reasons: []string{"The risk questionnaire has not been completed"}}
+	}
+
+	status := RetakeStatus{}
+	dueAt := latest.CompletedAt.Add(riskAssessmentMaxAge())
+	status.DueAt = &dueAt
+
+	if !now.Before(dueAt) {
+		status.Required = true
+		status.Reasons = append(status.Reasons, fmt.Sprintf("Answers were given on %s and are out of date", latest.CompletedAt.Format("2006-01-02")))
+	}
+	if current := risk.Current().Version; latest.QuestionnaireVersion < current {
+		status.Required = true
+		status.Reasons = append(status.Reasons, fmt.Sprintf("Questionnaire version %d has been replaced by version %d", latest.QuestionnaireVersion, current))
+	}
+	if !linked {
+		status.Required = true
+		status.Reasons = append(status.Reasons, "The risk profile was changed after the last questionnaire")
+	}
+	return status
+}
+
+const riskAssessmentColumns = `
+	id, user_id, questionnaire_version, answers, score, min_score, max_score,
+	percent, score_profile, profile, factors, explanation, completed_at
+`
+
+// scanRiskAssessment scans a row selected with riskAssessmentColumns
+func scanRiskAssessment(row pgx.Row) (*RiskAssessment, error) {
+	var a RiskAssessment
+	var answers, factors, explanation []byte
+	err := row.Scan(
+		&a.ID, &a.UserID, &a.QuestionnaireVersion, &answers, &a.Score, &a.MinScore, &a.MaxScore,
+		&a.Percent, &a.ScoreProfile, &a.Profile, &factors, &explanation, &a.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(answers, &a.Answers); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(factors, &a.Factors); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(explanation, &a.Explanation); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// getRiskQuestionnaire returns the current questionnaire, or an earlier
+// version with ?version=
+func getRiskQuestionnaire(c *gin.Context) {
+	questionnaire := risk.Current()
+	if value := c.Query("version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a number"})
+			return
+		}
+		questionnaire, err = risk.Version(version)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Questionnaire version not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"questionnaire":  questionnaire,
+		"current":        questionnaire.Version == risk.Current().Version,
+		"max_age_days":   int(riskAssessmentMaxAge().Hours() / 24),
+		"risk_profiles":  risk.Profiles,
+		"scoring_method": "Answer scores are summed and placed in a band by their share of the possible range; some answers cap the profile",
+	})
+}
+
+// createRiskAssessment scores a user's answers to the current questionnaire,
+// stores them and sets the user's risk profile from the result
+func createRiskAssessment(c *gin.Context) {
+	userID := c.Param("id")
+
+	var input struct {
+		QuestionnaireVersion int               `json:"questionnaire_version"`
+		Answers              map[string]string `json:"answers" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	questionnaire := risk.Current()
+	if input.QuestionnaireVersion != 0 && input.QuestionnaireVersion != questionnaire.Version {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":                 fmt.Sprintf("Questionnaire version %d is not current; answer version %d", input.QuestionnaireVersion, questionnaire.Version),
+			"questionnaire_version": questionnaire.Version,
+		})
+		return
+	}
+
+	result, err := questionnaire.Score(input.Answers)
+	var answerErr *risk.AnswerError
+	if errors.As(err, &answerErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid answers", "problems": answerErr.Problems})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to score answers: " + err.Error()})
+		return
+	}
+
+	assessment := &RiskAssessment{
+		ID:                   uuid.New().String(),
+		UserID:               userID,
+		QuestionnaireVersion: result.Version,
+		Answers:              input.Answers,
+		Score:                result.Score,
+		MinScore:             result.MinScore,
+		MaxScore:             result.MaxScore,
+		Percent:              result.Percent,
+		ScoreProfile:         result.ScoreProfile,
+		Profile:              result.Profile,
+		Factors:              result.Factors,
+		Explanation:          result.Explanation,
+	}
+
+	err = saveRiskAssessment(c.Request.Context(), assessment)
+	if errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save risk assessment: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         assessment.ID,
+		"message":    "Risk assessment recorded successfully",
+		"assessment": assessment,
+		"retake":     retakeStatus(assessment, true, time.Now()),
+	})
+}
+
+// saveRiskAssessment stores an assessment and makes its profile the user's
+func saveRiskAssessment(ctx context.Context, a *RiskAssessment) error {
+	answers, err := json.Marshal(a.Answers)
+	if err != nil {
+		return err
+	}
+	factors, err := json.Marshal(a.Factors)
+	if err != nil {
+		return err
+	}
+	explanation, err := json.Marshal(a.Explanation)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM users.users WHERE id = $1 AND is_active = true)
+	`, a.UserID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errUserNotFound
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users.risk_assessments (
+			id, user_id, questionnaire_version, answers, score, min_score, max_score,
+			percent, score_profile, profile, factors, explanation
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+		)
+		RETURNING completed_at
+	`, a.ID, a.UserID, a.QuestionnaireVersion, answers, a.Score, a.MinScore, a.MaxScore,
+		a.Percent, a.ScoreProfile, a.Profile, factors, explanation).Scan(&a.CompletedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users.users
+		SET risk_profile = $1, risk_assessment_id = $2, updated_at = NOW()
+		WHERE id = $3
+	`, a.Profile, a.ID, a.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// listRiskAssessments returns a user's assessments, newest first
+func listRiskAssessments(c *gin.Context) {
+	userID := c.Param("id")
+
+	rows, err := db.Query(c.Request.Context(), `
+		SELECT `+riskAssessmentColumns+`
+		FROM users.risk_assessments
+		WHERE user_id = $1
+		ORDER BY completed_at DESC
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve risk assessments"})
+		return
+	}
+	defer rows.Close()
+
+	assessments := []*RiskAssessment{}
+	for rows.Next() {
+		a, err := scanRiskAssessment(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan risk assessment data"})
+			return
+		}
+		assessments = append(assessments, a)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve risk assessments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assessments": assessments})
+}
+
+// getRiskAssessment returns a single assessment with the questionnaire it answered
+func getRiskAssessment(c *gin.Context) {
+	userID := c.Param("id")
+	assessmentID := c.Param("assessmentId")
+
+	a, err := scanRiskAssessment(db.QueryRow(c.Request.Context(), `
+		SELECT `+riskAssessmentColumns+`
+		FROM users.risk_assessments
+		WHERE id = $1 AND user_id = $2
+	`, assessmentID, userID))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Risk assessment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve risk assessment: " + err.Error()})
+		return
+	}
+
+	response := gin.H{"assessment": a}
+	if questionnaire, err := risk.Version(a.QuestionnaireVersion); err == nil {
+		response["questionnaire"] = questionnaire
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getRiskProfile returns a user's risk profile, how it was derived and
+// whether the questionnaire should be retaken
+func getRiskProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("id")
+
+	var profile, assessmentID string
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(risk_profile, ''), COALESCE(risk_assessment_id::text, '')
+		FROM users.users
+		WHERE id = $1 AND is_active = true
+	`, userID).Scan(&profile, &assessmentID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user: " + err.Error()})
+		return
+	}
+
+	latest, err := scanRiskAssessment(db.QueryRow(ctx, `
+		SELECT `+riskAssessmentColumns+`
+		FROM users.risk_assessments
+		WHERE user_id = $1
+		ORDER BY completed_at DESC
+		LIMIT 1
+	`, userID))
+	if err == pgx.ErrNoRows {
+		latest = nil
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve risk assessment: " + err.Error()})
+		return
+	}
+
+	source := ""
+	switch {
+	case assessmentID != "":
+		source = riskSourceQuestionnaire
+	case profile != "":
+		source = riskSourceSelfReported
+	}
+
+	linked := latest != nil && latest.ID == assessmentID
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      userID,
+		"risk_profile": profile,
+		"source":       source,
+		"assessment":   latest,
+		"retake":       retakeStatus(latest, linked, time.Now()),
+	})
+}
+
+// durationEnv reads a duration such as "8760h" from the environment
+func durationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,374 @@
+// Package accountsync keeps accounts linked at outside institutions current.
+//
+// Each institution supplies a Syncer that lists the users connected to it
+// and fetches their accounts. A Scheduler syncs every connection on an
+// interval, writing balances into accounts.accounts, holdings into
+// investments.positions and transactions into accounts.transactions, and
+// records the outcome of each attempt per connection. A failing connection
+// is retried with exponential backoff, and when several connections in a
+// row fail the whole institution is paused, so an outage is not hammered
+// with requests.
+package accountsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// institutionFailureLimit is how many connections in a row may fail before
+// the institution is assumed to be down and the pass is abandoned
+const institutionFailureLimit = 3
+
+// Account is an institution's current view of an account
+type Account struct {
+	ExternalID string
+	Balance    decimal.Decimal
+	Currency   string
+	// Positions are the account's holdings. They are nil for accounts that
+	// do not hold securities, and empty for one that has sold everything.
+	Positions []Position
+	// Transactions are the account's transactions dated on or after
+	// TransactionsSince, which is zero when the range read is not known.
+	// They are nil when the institution does not report transactions.
+	Transactions      []Transaction
+	TransactionsSince time.Time
+}
+
+// Position is a holding reported by an institution
+type Position struct {
+	Symbol      string          `json:"symbol"`
+	Quantity    float64         `json:"quantity"`
+	CostBasis   decimal.Decimal `json:"cost_basis"`
+	MarketValue decimal.Decimal `json:"market_value"`
+}
+
+// Syncer fetches the linked accounts of one institution
+type Syncer interface {
+	// Institution is the institution_id of the accounts the syncer updates
+	Institution() string
+
+	// Connections returns the users with credentials for the institution and
+	// at least one active linked account
+	Connections(ctx context.Context) ([]string, error)
+
+	// FetchAccounts returns the user's accounts at the institution. It fails
+	// rather than returning accounts whose balance or holdings could not be
+	// read, so a sync never records a partial view.
+	FetchAccounts(ctx context.Context, userID string) ([]Account, error)
+}
+
+// Result summarizes one sync of a connection
+type Result struct {
+	Institution  string    `json:"institution"`
+	UserID       string    `json:"user_id"`
+	Accounts     int       `json:"accounts"`
+	Positions    int       `json:"positions"`
+	Closed       int64     `json:"closed_positions"`
+	Transactions int64     `json:"transactions"`
+	Unlinked     int       `json:"unlinked_accounts"`
+	SyncedAt     time.Time `json:"synced_at"`
+}
+
+// Status is the sync state of one connection
+type Status struct {
+	Institution         string     `json:"institution"`
+	UserID              string     `json:"user_id"`
+	LastAttemptAt       *time.Time `json:"last_attempt_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	NextSyncAt          time.Time  `json:"next_sync_at"`
+}
+
+// Backoff returns how long to wait before retrying after failures
+// consecutive failures: base doubled for each failure after the first,
+// capped at max
+func Backoff(failures int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < failures && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
+
+// Scheduler syncs an institution's connections on an interval
+type Scheduler struct {
+	db         *pgxpool.Pool
+	syncer     Syncer
+	interval   time.Duration
+	maxBackoff time.Duration
+
+	// mu keeps scheduled passes and requested syncs from overlapping
+	mu                  sync.Mutex
+	institutionFailures int
+	pausedUntil         time.Time
+}
+
+// NewScheduler creates a scheduler that syncs each connection every interval
+// and backs off failures for up to maxBackoff
+func NewScheduler(db *pgxpool.Pool, syncer Syncer, interval, maxBackoff time.Duration) *Scheduler {
+	if maxBackoff < interval {
+		maxBackoff = interval
+	}
+	return &Scheduler{db: db, syncer: syncer, interval: interval, maxBackoff: maxBackoff}
+}
+
+// Run syncs due connections at startup and then every minute until ctx is
+// cancelled. Connections are only contacted once their own interval or
+// backoff has passed.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	log.Printf("Syncing %s accounts every %s", s.syncer.Institution(), s.interval)
+
+	for {
+		s.SyncDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncDue syncs every connection whose next sync time has passed, unless the
+// institution is paused after repeated failures
+func (s *Scheduler) SyncDue(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	institution := s.syncer.Institution()
+	if time.Now().Before(s.pausedUntil) {
+		return
+	}
+
+	due, err := s.dueConnections(ctx)
+	if err != nil {
+		log.Printf("Failed to find %s connections to sync: %v", institution, err)
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	var synced, failedInRow int
+	for _, userID := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := s.syncLocked(ctx, userID); err != nil {
+			log.Printf("Sync of %s accounts for user %s failed: %v", institution, userID, err)
+			failedInRow++
+			if synced == 0 && failedInRow >= institutionFailureLimit {
+				break
+			}
+			continue
+		}
+		synced++
+		failedInRow = 0
+	}
+
+	if synced == 0 && failedInRow >= institutionFailureLimit {
+		s.institutionFailures++
+		wait := Backoff(s.institutionFailures, s.interval, s.maxBackoff)
+		s.pausedUntil = time.Now().Add(wait)
+		log.Printf("Pausing %s syncs for %s after %d failed connections", institution, wait, failedInRow)
+		return
+	}
+	if synced > 0 {
+		s.institutionFailures = 0
+	}
+	log.Printf("Synced %d of %d due %s connections", synced, len(due), institution)
+}
+
+// Sync syncs one user's connection now, whatever its backoff, and records
+// the outcome
+func (s *Scheduler) Sync(ctx context.Context, userID string) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked(ctx, userID)
+}
+
+// Status returns the sync state of a user's connection, or nil when it has
+// never been synced
+func (s *Scheduler) Status(ctx context.Context, userID string) (*Status, error) {
+	status := &Status{Institution: s.syncer.Institution(), UserID: userID}
+	var lastError *string
+	err := s.db.QueryRow(ctx, `
+		SELECT last_attempt_at, last_success_at, last_error, consecutive_failures, next_sync_at
+		FROM accounts.sync_connections
+		WHERE institution_id = $1 AND user_id = $2
+	`, status.Institution, userID).Scan(
+		&status.LastAttemptAt, &status.LastSuccessAt, &lastError,
+		&status.ConsecutiveFailures, &status.NextSyncAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastError != nil {
+		status.LastError = *lastError
+	}
+	return status, nil
+}
+
+// syncLocked fetches and stores a user's accounts and records the attempt
+func (s *Scheduler) syncLocked(ctx context.Context, userID string) (*Result, error) {
+	institution := s.syncer.Institution()
+
+	accounts, err := s.syncer.FetchAccounts(ctx, userID)
+	var result *Result
+	if err == nil {
+		result, err = Apply(ctx, s.db, institution, userID, accounts)
+	}
+
+	if recordErr := s.record(ctx, userID, err); recordErr != nil {
+		log.Printf("Failed to record %s sync status for user %s: %v", institution, userID, recordErr)
+	}
+	return result, err
+}
+
+// record stores the outcome of a sync attempt and schedules the next one
+func (s *Scheduler) record(ctx context.Context, userID string, syncErr error) error {
+	institution := s.syncer.Institution()
+	now := time.Now()
+
+	if syncErr == nil {
+		_, err := s.db.Exec(ctx, `
+			INSERT INTO accounts.sync_connections (
+				institution_id, user_id, last_attempt_at, last_success_at,
+				last_error, consecutive_failures, next_sync_at
+			) VALUES ($1, $2, $3, $3, NULL, 0, $4)
+			ON CONFLICT (institution_id, user_id) DO UPDATE
+			SET last_attempt_at = EXCLUDED.last_attempt_at,
+			    last_success_at = EXCLUDED.last_success_at,
+			    last_error = NULL,
+			    consecutive_failures = 0,
+			    next_sync_at = EXCLUDED.next_sync_at
+		`, institution, userID, now, now.Add(s.interval))
+		return err
+	}
+
+	var failures int
+	err := s.db.QueryRow(ctx, `
+		SELECT consecutive_failures FROM accounts.sync_connections
+		WHERE institution_id = $1 AND user_id = $2
+	`, institution, userID).Scan(&failures)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+	failures++
+
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO accounts.sync_connections (
+			institution_id, user_id, last_attempt_at, last_error,
+			consecutive_failures, next_sync_at
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (institution_id, user_id) DO UPDATE
+		SET last_attempt_at = EXCLUDED.last_attempt_at,
+		    last_error = EXCLUDED.last_error,
+		    consecutive_failures = EXCLUDED.consecutive_failures,
+		    next_sync_at = EXCLUDED.next_sync_at
+	`, institution, userID, now, syncErr.Error(), failures,
+		now.Add(Backoff(failures, s.interval, s.maxBackoff)))
+	return err
+}
+
+// dueConnections returns the connected users whose next sync time has
+// passed, those never synced first
+func (s *Scheduler) dueConnections(ctx context.Context) ([]string, error) {
+	users, err := s.syncer.Connections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT u.user_id
+		FROM UNNEST($2::uuid[]) AS u(user_id)
+		LEFT JOIN accounts.sync_connections sc
+		       ON sc.institution_id = $1 AND sc.user_id = u.user_id
+		WHERE sc.next_sync_at IS NULL OR sc.next_sync_at <= NOW()
+		ORDER BY sc.next_sync_at NULLS FIRST
+	`, s.syncer.Institution(), users)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sync status: %w", err)
+	}
+	defer rows.Close()
+
+	var due []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		due = append(due, userID)
+	}
+	return due, rows.Err()
+}
+
+// EnsureSchema creates the tables syncs write to
+func EnsureSchema(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS accounts.sync_connections (
+			institution_id VARCHAR(50) NOT NULL,
+			user_id UUID NOT NULL REFERENCES users.users(id),
+			last_attempt_at TIMESTAMP WITH TIME ZONE,
+			last_success_at TIMESTAMP WITH TIME ZONE,
+			last_error TEXT,
+			consecutive_failures INTEGER NOT NULL DEFAULT 0,
+			next_sync_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (institution_id, user_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS accounts.transactions (
+			id UUID PRIMARY KEY,
+			account_id UUID NOT NULL REFERENCES accounts.accounts(id),
+			external_transaction_id VARCHAR(100) NOT NULL,
+			transaction_date DATE NOT NULL,
+			post_date DATE,
+			description TEXT,
+			category VARCHAR(100),
+			amount DECIMAL(19, 4) NOT NULL,
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			type VARCHAR(50),
+			status VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			UNIQUE (account_id, external_transaction_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Linked accounts are looked up by their institution's account ID
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_accounts_external_account_id ON accounts.accounts(institution_id, external_account_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_accounts_transactions_account_date ON accounts.transactions(account_id, transaction_date)")
+	return err
+}
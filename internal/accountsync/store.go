@@ -0,0 +1,201 @@
+package accountsync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// unclassifiedAssetClass is the asset class of assets first seen in a sync
+const unclassifiedAssetClass = "UNCLASSIFIED"
+
+// Apply stores an institution's view of a user's accounts in one
+// transaction. The balance of each linked account is replaced, and where
+// holdings are reported the account's open positions are made to match them:
+// positions are updated or opened, and positions no longer held are closed.
+// Reported transactions are stored or updated; see SyncTransactions. Accounts
+// the user has not linked are counted and left alone.
+//
+// Synced positions mirror the institution and carry no tax lots; lot-level
+// history is only kept for positions traded through the ledger, which syncs
+// leave alone.
+func Apply(ctx context.Context, db *pgxpool.Pool, institution, userID string, accounts []Account) (*Result, error) {
+	result := &Result{Institution: institution, UserID: userID, SyncedAt: time.Now()}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, account := range accounts {
+		var accountID string
+		err := tx.QueryRow(ctx, `
+			SELECT id FROM accounts.accounts
+			WHERE institution_id = $1 AND user_id = $2 AND external_account_id = $3 AND is_active = true
+			FOR UPDATE
+		`, institution, userID, account.ExternalID).Scan(&accountID)
+		if err == pgx.ErrNoRows {
+			result.Unlinked++
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find account %s: %w", account.ExternalID, err)
+		}
+
+		currency := strings.ToUpper(account.Currency)
+		if currency == "" {
+			currency = "USD"
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE accounts.accounts
+			SET balance_amount = $1, balance_currency = $2, updated_at = $3
+			WHERE id = $4
+		`, money.Round(account.Balance, currency), currency, result.SyncedAt, accountID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update balance of account %s: %w", account.ExternalID, err)
+		}
+		result.Accounts++
+
+		if account.Positions != nil {
+			positions, closed, err := SyncPositions(ctx, tx, accountID, currency, account.Positions, result.SyncedAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update positions of account %s: %w", account.ExternalID, err)
+			}
+			result.Positions += positions
+			result.Closed += closed
+		}
+
+		if account.Transactions != nil {
+			stored, err := SyncTransactions(ctx, tx, accountID, currency, account.Transactions, account.TransactionsSince, result.SyncedAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update transactions of account %s: %w", account.ExternalID, err)
+			}
+			result.Transactions += stored
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SyncPositions makes an account's open positions match the reported
+// holdings within tx. Holdings of the same symbol are combined. Positions
+// with tax lots are managed by the ledger, whose lots set their quantity and
+// basis, so they are neither updated nor closed. It returns how many
+// positions are held and how many were closed.
+func SyncPositions(ctx context.Context, tx pgx.Tx, accountID, currency string, reported []Position, now time.Time) (int, int64, error) {
+	combined := make(map[string]*Position)
+	var symbols []string
+	for _, p := range reported {
+		symbol := strings.ToUpper(strings.TrimSpace(p.Symbol))
+		if symbol == "" || p.Quantity <= 0 {
+			continue
+		}
+		if existing, ok := combined[symbol]; ok {
+			existing.Quantity += p.Quantity
+			existing.CostBasis = existing.CostBasis.Add(p.CostBasis)
+			existing.MarketValue = existing.MarketValue.Add(p.MarketValue)
+			continue
+		}
+		p.Symbol = symbol
+		combined[symbol] = &p
+		symbols = append(symbols, symbol)
+	}
+
+	held := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		p := combined[symbol]
+		assetID, err := syncedAsset(ctx, tx, symbol, currency, p, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		held = append(held, assetID)
+
+		var lotManaged bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM investments.positions p
+				JOIN investments.tax_lots l ON l.position_id = p.id
+				WHERE p.account_id = $1 AND p.asset_id = $2 AND p.is_open = true
+			)
+		`, accountID, assetID).Scan(&lotManaged)
+		if err != nil {
+			return 0, 0, err
+		}
+		if lotManaged {
+			continue
+		}
+
+		costBasis := money.Round(p.CostBasis, currency)
+		marketValue := money.Round(p.MarketValue, currency)
+		tag, err := tx.Exec(ctx, `
+			UPDATE investments.positions
+			SET quantity = $1, cost_basis = $2, current_value = $3, last_updated = $4
+			WHERE account_id = $5 AND asset_id = $6 AND is_open = true
+		`, p.Quantity, costBasis, marketValue, now, accountID, assetID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if tag.RowsAffected() > 0 {
+			continue
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO investments.positions (
+				id, account_id, asset_id, quantity, cost_basis, current_value,
+				purchase_date, last_updated, is_open
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $7, true
+			)
+		`, uuid.New().String(), accountID, assetID, p.Quantity, costBasis, marketValue, now)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE investments.positions
+		SET is_open = false, current_value = 0, last_updated = $2
+		WHERE account_id = $1 AND is_open = true AND NOT (asset_id = ANY($3::uuid[]))
+		  AND NOT EXISTS (SELECT 1 FROM investments.tax_lots l WHERE l.position_id = positions.id)
+	`, accountID, now, held)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(held), tag.RowsAffected(), nil
+}
+
+// syncedAsset returns the asset for a reported symbol, creating it priced
+// from the holding when it is not tracked yet
+func syncedAsset(ctx context.Context, tx pgx.Tx, symbol, currency string, p *Position, now time.Time) (string, error) {
+	var id string
+	err := tx.QueryRow(ctx, `SELECT id FROM investments.assets WHERE UPPER(symbol) = $1`, symbol).Scan(&id)
+	if err == nil || err != pgx.ErrNoRows {
+		return id, err
+	}
+
+	price := decimal.Zero
+	if p.Quantity > 0 {
+		price = p.MarketValue.Div(decimal.NewFromFloat(p.Quantity)).Round(4)
+	}
+	id = uuid.New().String()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO investments.assets (
+			id, symbol, name, asset_class, current_price_amount,
+			current_price_currency, last_updated
+		) VALUES (
+			$1, $2, $2, $3, $4, $5, $6
+		)
+	`, id, symbol, unclassifiedAssetClass, price, currency, now)
+	return id, err
+}
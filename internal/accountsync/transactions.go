@@ -0,0 +1,142 @@
+package accountsync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Transaction statuses
+const (
+	TransactionPending = "PENDING"
+	TransactionPosted  = "POSTED"
+)
+
+// transactionOverlap is how far before the last stored transaction a sync
+// reads again, so transactions posted late with an earlier post date are not
+// missed
+const transactionOverlap = 7 * 24 * time.Hour
+
+// Transaction is an account transaction reported by an institution
+type Transaction struct {
+	ExternalID  string          `json:"transaction_id"`
+	Date        time.Time       `json:"transaction_date"`
+	PostDate    *time.Time      `json:"post_date,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Category    string          `json:"category,omitempty"`
+	Amount      decimal.Decimal `json:"amount"`
+	Type        string          `json:"type,omitempty"`
+	Pending     bool            `json:"pending"`
+}
+
+// TransactionsSince returns, for each of a user's linked accounts at an
+// institution that has stored transactions, the date a sync should read its
+// transactions from: the earliest still pending transaction or the last
+// posted one, whichever is earlier, less an overlap. Accounts without stored
+// transactions are left out so the institution's default history is read.
+func TransactionsSince(ctx context.Context, db *pgxpool.Pool, institution, userID string) (map[string]time.Time, error) {
+	rows, err := db.Query(ctx, `
+		SELECT a.external_account_id,
+		       LEAST(
+		           MIN(t.transaction_date) FILTER (WHERE t.status = $3),
+		           MAX(t.post_date) FILTER (WHERE t.status = $4)
+		       )
+		FROM accounts.accounts a
+		JOIN accounts.transactions t ON t.account_id = a.id
+		WHERE a.institution_id = $1 AND a.user_id = $2 AND a.is_active = true
+		GROUP BY a.external_account_id
+	`, institution, userID, TransactionPending, TransactionPosted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve transaction sync dates: %w", err)
+	}
+	defer rows.Close()
+
+	since := make(map[string]time.Time)
+	for rows.Next() {
+		var externalID string
+		var date *time.Time
+		if err := rows.Scan(&externalID, &date); err != nil {
+			return nil, err
+		}
+		if date != nil {
+			since[externalID] = date.Add(-transactionOverlap)
+		}
+	}
+	return since, rows.Err()
+}
+
+// SyncTransactions stores reported transactions within tx, keyed by the
+// institution's transaction ID. A stored transaction is updated when it
+// changes, such as a pending transaction posting. Pending transactions dated
+// within the reported range that the institution no longer reports have been
+// dropped by it and are deleted; when since is zero the range is not known
+// and none are. It returns how many transactions were added or changed.
+func SyncTransactions(ctx context.Context, tx pgx.Tx, accountID, currency string, reported []Transaction, since, now time.Time) (int64, error) {
+	var stored int64
+	seen := make([]string, 0, len(reported))
+	for _, t := range reported {
+		externalID := strings.TrimSpace(t.ExternalID)
+		if externalID == "" {
+			continue
+		}
+		seen = append(seen, externalID)
+
+		status, postDate := TransactionPosted, t.PostDate
+		if t.Pending {
+			status, postDate = TransactionPending, nil
+		}
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO accounts.transactions (
+				id, account_id, external_transaction_id, transaction_date, post_date,
+				description, category, amount, currency, type, status, created_at, updated_at
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12
+			)
+			ON CONFLICT (account_id, external_transaction_id) DO UPDATE
+			SET transaction_date = EXCLUDED.transaction_date,
+			    post_date = EXCLUDED.post_date,
+			    description = EXCLUDED.description,
+			    category = EXCLUDED.category,
+			    amount = EXCLUDED.amount,
+			    currency = EXCLUDED.currency,
+			    type = EXCLUDED.type,
+			    status = EXCLUDED.status,
+			    updated_at = EXCLUDED.updated_at
+			WHERE (accounts.transactions.transaction_date, accounts.transactions.post_date,
+			       accounts.transactions.description, accounts.transactions.category,
+			       accounts.transactions.amount, accounts.transactions.currency,
+			       accounts.transactions.type, accounts.transactions.status)
+			      IS DISTINCT FROM
+			      (EXCLUDED.transaction_date, EXCLUDED.post_date, EXCLUDED.description,
+			       EXCLUDED.category, EXCLUDED.amount, EXCLUDED.currency,
+			       EXCLUDED.type, EXCLUDED.status)
+		`, uuid.New().String(), accountID, externalID, t.Date, postDate,
+			t.Description, t.Category, money.Round(t.Amount, currency), currency,
+			t.Type, status, now)
+		if err != nil {
+			return 0, err
+		}
+		stored += tag.RowsAffected()
+	}
+
+	if since.IsZero() {
+		return stored, nil
+	}
+	_, err := tx.Exec(ctx, `
+		DELETE FROM accounts.transactions
+		WHERE account_id = $1 AND status = $2 AND transaction_date >= $3
+		  AND NOT (external_transaction_id = ANY($4::text[]))
+	`, accountID, TransactionPending, since, seen)
+	if err != nil {
+		return 0, err
+	}
+	return stored, nil
+}
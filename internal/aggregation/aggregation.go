@@ -0,0 +1,145 @@
+// Package aggregation links accounts held at outside institutions.
+//
+// Each institution is a Provider: it runs the institution's authorization
+// flow, keeps the user's credentials, and reads accounts, positions and
+// transactions. A Registry holds the providers a service serves, and Handler
+// exposes the same routes for each of them, linking accounts into
+// accounts.accounts and syncing them through accountsync. FakeProvider is a
+// local institution with generated data for development and testing.
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+)
+
+// Errors providers return so handlers can answer them consistently
+var (
+	// ErrNotConnected is returned when the user has not authorized the
+	// institution, or the authorization has been removed
+	ErrNotConnected = errors.New("institution is not connected")
+
+	// ErrAuthMismatch is returned when an authorization callback does not
+	// match the flow started for the user
+	ErrAuthMismatch = errors.New("authorization does not match the pending request")
+
+	// ErrAccountNotFound is returned when the institution has no such account
+	// for the user
+	ErrAccountNotFound = errors.New("account not found")
+
+	// ErrNotSupported is returned for operations an institution does not offer
+	ErrNotSupported = errors.New("not supported by this institution")
+)
+
+// AuthStart is the start of an authorization flow. The user visits AuthURL,
+// and State comes back in the callback.
+type AuthStart struct {
+	AuthURL string `json:"auth_url"`
+	State   string `json:"state"`
+}
+
+// AuthCallback completes an authorization flow. OAuth 1.0a institutions call
+// the state the request token and the code the verifier.
+type AuthCallback struct {
+	UserID      string `json:"user_id" binding:"required"`
+	State       string `json:"state"`
+	Code        string `json:"code"`
+	RedirectURI string `json:"redirect_uri,omitempty"`
+}
+
+// Account is an account the user holds at an institution
+type Account struct {
+	ExternalID  string          `json:"account_id"`
+	Name        string          `json:"account_name"`
+	Type        string          `json:"account_type"`
+	Description string          `json:"description,omitempty"`
+	Balance     decimal.Decimal `json:"balance"`
+	Currency    string          `json:"currency"`
+	// HoldsSecurities is set for accounts positions can be read for
+	HoldsSecurities bool `json:"holds_securities"`
+}
+
+// Provider connects users to one institution
+type Provider interface {
+	// ID is the institution_id linked accounts are stored under
+	ID() string
+
+	// Name is the institution's display name
+	Name() string
+
+	// StartAuth starts the authorization flow for a user
+	StartAuth(ctx context.Context, userID string) (*AuthStart, error)
+
+	// CompleteAuth finishes the authorization flow and stores the user's
+	// credentials
+	CompleteAuth(ctx context.Context, callback AuthCallback) error
+
+	// Accounts returns the user's accounts with their balances. It fails
+	// rather than returning an account whose balance could not be read.
+	Accounts(ctx context.Context, userID string) ([]Account, error)
+
+	// Positions returns the holdings of an account that holds securities
+	Positions(ctx context.Context, userID, accountID string) ([]accountsync.Position, error)
+
+	// Transactions returns an account's transactions dated on or after
+	// since, or the institution's default history when since is zero
+	Transactions(ctx context.Context, userID, accountID string, since time.Time) ([]accountsync.Transaction, error)
+
+	// Refresh renews the user's credentials before they expire
+	Refresh(ctx context.Context, userID string) error
+
+	// Disconnect removes the user's credentials, revoking them where the
+	// institution allows
+	Disconnect(ctx context.Context, userID string) error
+
+	// Connections returns the users with credentials for the institution
+	Connections(ctx context.Context) ([]string, error)
+}
+
+// Registry holds the providers a service serves, by institution ID
+type Registry struct {
+	providers map[string]Provider
+}
+
+// NewRegistry creates a registry holding providers
+func NewRegistry(providers ...Provider) (*Registry, error) {
+	r := &Registry{providers: make(map[string]Provider)}
+	for _, p := range providers {
+		if err := r.Register(p); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds a provider to the registry
+func (r *Registry) Register(p Provider) error {
+	if _, ok := r.providers[p.ID()]; ok {
+		return fmt.Errorf("provider %s is already registered", p.ID())
+	}
+	r.providers[p.ID()] = p
+	return nil
+}
+
+// Get returns the provider for an institution ID
+func (r *Registry) Get(id string) (Provider, bool) {
+	p, ok := r.providers[id]
+	return p, ok
+}
+
+// Providers returns the registered providers ordered by institution ID
+func (r *Registry) Providers() []Provider {
+	providers := make([]Provider, 0, len(r.providers))
+	for _, p := range r.providers {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].ID() < providers[j].ID() })
+	return providers
+}
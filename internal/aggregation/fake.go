@@ -0,0 +1,239 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+)
+
+// FakeInstitutionID is the institution ID of the fake institution
+const FakeInstitutionID = "fakebank"
+
+// fakeHoldings are the positions of every fake brokerage account
+var fakeHoldings = []accountsync.Position{
+	{Symbol: "VTI", Quantity: 20, CostBasis: decimal.RequireFromString("4200.00"), MarketValue: decimal.RequireFromString("5300.00")},
+	{Symbol: "BND", Quantity: 30, CostBasis: decimal.RequireFromString("2200.00"), MarketValue: decimal.RequireFromString("2150.00")},
+	{Symbol: "AAPL", Quantity: 10, CostBasis: decimal.RequireFromString("1500.00"), MarketValue: decimal.RequireFromString("1890.00")},
+}
+
+// fakeCategories are the categories fake card purchases cycle through
+var fakeCategories = []string{"Groceries", "Dining", "Utilities", "Travel"}
+
+// FakeProvider is an institution that runs entirely in process, for
+// developing and testing aggregation without outside credentials. Any
+// authorization code is accepted. Each connected user has a checking account
+// with a transaction every few days, the last two days of them pending, and a
+// brokerage account with fixed holdings; both are generated from the user ID
+// so they are the same on every call. Connections are kept in memory and are
+// lost on restart.
+type FakeProvider struct {
+	mu        sync.Mutex
+	states    map[string]string
+	connected map[string]bool
+}
+
+// NewFakeProvider creates a fake institution with no connected users
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		states:    make(map[string]string),
+		connected: make(map[string]bool),
+	}
+}
+
+// ID returns the fake institution's ID
+func (f *FakeProvider) ID() string {
+	return FakeInstitutionID
+}
+
+// Name returns the fake institution's display name
+func (f *FakeProvider) Name() string {
+	return "Fake Bank"
+}
+
+// StartAuth starts an authorization that any code completes
+func (f *FakeProvider) StartAuth(ctx context.Context, userID string) (*AuthStart, error) {
+	state := uuid.New().String()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[userID] = state
+
+	return &AuthStart{
+		AuthURL: "https://fakebank.local/authorize?state=" + state,
+		State:   state,
+	}, nil
+}
+
+// CompleteAuth connects the user when the state matches the pending
+// authorization
+func (f *FakeProvider) CompleteAuth(ctx context.Context, callback AuthCallback) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if state, ok := f.states[callback.UserID]; !ok || state != callback.State {
+		return ErrAuthMismatch
+	}
+	delete(f.states, callback.UserID)
+	f.connected[callback.UserID] = true
+	return nil
+}
+
+// Accounts returns the user's checking and brokerage accounts
+func (f *FakeProvider) Accounts(ctx context.Context, userID string) ([]Account, error) {
+	if !f.isConnected(userID) {
+		return nil, ErrNotConnected
+	}
+
+	seed := fakeSeed(userID)
+	brokerage := decimal.NewFromInt(1000)
+	for _, p := range fakeHoldings {
+		brokerage = brokerage.Add(p.MarketValue)
+	}
+
+	return []Account{
+		{
+			ExternalID:  fakeAccountID("CHK", userID),
+			Name:        "Fake Bank Checking",
+			Type:        "CHECKING",
+			Description: "Fake Bank checking account",
+			Balance:     decimal.New(250000+int64(seed%100000), -2),
+			Currency:    "USD",
+		},
+		{
+			ExternalID:      fakeAccountID("BRK", userID),
+			Name:            "Fake Bank Brokerage",
+			Type:            "BROKERAGE",
+			Description:     "Fake Bank brokerage account",
+			Balance:         brokerage,
+			Currency:        "USD",
+			HoldsSecurities: true,
+		},
+	}, nil
+}
+
+// Positions returns the fixed holdings of the user's brokerage account
+func (f *FakeProvider) Positions(ctx context.Context, userID, accountID string) ([]accountsync.Position, error) {
+	if !f.isConnected(userID) {
+		return nil, ErrNotConnected
+	}
+	switch accountID {
+	case fakeAccountID("BRK", userID):
+		positions := make([]accountsync.Position, len(fakeHoldings))
+		copy(positions, fakeHoldings)
+		return positions, nil
+	case fakeAccountID("CHK", userID):
+		return nil, ErrNotSupported
+	default:
+		return nil, ErrAccountNotFound
+	}
+}
+
+// Transactions returns the checking account's transactions since a date, or
+// for the last 30 days. The brokerage account has none.
+func (f *FakeProvider) Transactions(ctx context.Context, userID, accountID string, since time.Time) ([]accountsync.Transaction, error) {
+	if !f.isConnected(userID) {
+		return nil, ErrNotConnected
+	}
+	switch accountID {
+	case fakeAccountID("BRK", userID):
+		return []accountsync.Transaction{}, nil
+	case fakeAccountID("CHK", userID):
+	default:
+		return nil, ErrAccountNotFound
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if since.IsZero() {
+		since = today.AddDate(0, 0, -30)
+	}
+	since = since.UTC().Truncate(24 * time.Hour)
+	seed := fakeSeed(userID)
+
+	transactions := []accountsync.Transaction{}
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		n := uint32(day.Unix()/86400) + seed
+		t := accountsync.Transaction{
+			ExternalID: fmt.Sprintf("%s-%s", fakeAccountID("TX", userID), day.Format("20060102")),
+			Date:       day,
+			Pending:    today.Sub(day) < 2*24*time.Hour,
+		}
+		switch {
+		case n%14 == 0:
+			t.Description, t.Category, t.Type = "Payroll deposit", "Income", "CREDIT"
+			t.Amount = decimal.NewFromInt(1500)
+		case n%3 == 0:
+			category := fakeCategories[n/3%uint32(len(fakeCategories))]
+			t.Description, t.Category, t.Type = category+" purchase", category, "DEBIT"
+			t.Amount = decimal.New(-int64(1234+n%4000), -2)
+		default:
+			continue
+		}
+		if !t.Pending {
+			posted := day.AddDate(0, 0, 1)
+			t.PostDate = &posted
+		}
+		transactions = append(transactions, t)
+	}
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].Date.After(transactions[j].Date) })
+	return transactions, nil
+}
+
+// Refresh succeeds for connected users; fake credentials do not expire
+func (f *FakeProvider) Refresh(ctx context.Context, userID string) error {
+	if !f.isConnected(userID) {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// Disconnect forgets the user's connection
+func (f *FakeProvider) Disconnect(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.connected[userID] {
+		return ErrNotConnected
+	}
+	delete(f.connected, userID)
+	return nil
+}
+
+// Connections returns the connected users
+func (f *FakeProvider) Connections(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users := make([]string, 0, len(f.connected))
+	for userID := range f.connected {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// isConnected reports whether the user has completed an authorization
+func (f *FakeProvider) isConnected(userID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected[userID]
+}
+
+// fakeAccountID returns the ID of one of a user's fake accounts
+func fakeAccountID(kind, userID string) string {
+	return fmt.Sprintf("FAKE-%s-%08X", kind, fakeSeed(userID))
+}
+
+// fakeSeed derives the numbers a user's fake data is generated from
+func fakeSeed(userID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return h.Sum32()
+}
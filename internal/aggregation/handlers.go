@@ -0,0 +1,365 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+)
+
+// dateLayout is the layout of dates in query parameters
+const dateLayout = "2006-01-02"
+
+// Handler serves the routes of every provider in a registry and syncs their
+// linked accounts
+type Handler struct {
+	db         *pgxpool.Pool
+	registry   *Registry
+	schedulers map[string]*accountsync.Scheduler
+}
+
+// NewHandler creates a handler for the registry's providers. Linked accounts
+// are synced every syncInterval, with failures backed off for up to
+// maxBackoff.
+func NewHandler(db *pgxpool.Pool, registry *Registry, syncInterval, maxBackoff time.Duration) *Handler {
+	h := &Handler{
+		db:         db,
+		registry:   registry,
+		schedulers: make(map[string]*accountsync.Scheduler),
+	}
+	for _, p := range registry.Providers() {
+		h.schedulers[p.ID()] = accountsync.NewScheduler(db, NewSyncer(db, p), syncInterval, maxBackoff)
+	}
+	return h
+}
+
+// RunSync runs the sync of every provider until ctx is cancelled
+func (h *Handler) RunSync(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, scheduler := range h.schedulers {
+		wg.Add(1)
+		go func(scheduler *accountsync.Scheduler) {
+			defer wg.Done()
+			scheduler.Run(ctx)
+		}(scheduler)
+	}
+	wg.Wait()
+}
+
+// RegisterRoutes registers each provider's routes under its institution ID
+func (h *Handler) RegisterRoutes(router *gin.RouterGroup) {
+	for _, p := range h.registry.Providers() {
+		group := router.Group("/" + p.ID())
+		{
+			group.POST("/auth/initiate", h.startAuth(p))
+			group.POST("/auth/callback", h.completeAuth(p))
+			group.POST("/auth/refresh", h.refresh(p))
+			group.POST("/disconnect", h.disconnect(p))
+			group.GET("/accounts", h.listAccounts(p))
+			group.POST("/accounts/link", h.linkAccount(p))
+			group.GET("/accounts/:accountId/positions", h.listPositions(p))
+			group.GET("/accounts/:accountId/transactions", h.listTransactions(p))
+			group.POST("/sync", h.syncAccounts(p))
+			group.GET("/sync", h.getSyncStatus(p))
+		}
+	}
+}
+
+// userRequest is the body of requests that only name the user
+type userRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+// bindUser reads and validates the user ID in a request body
+func bindUser(c *gin.Context) (string, bool) {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return "", false
+	}
+	return req.UserID, true
+}
+
+// queryUser reads and validates the user_id query parameter
+func queryUser(c *gin.Context) (string, bool) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		return "", false
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return "", false
+	}
+	return userID, true
+}
+
+// respondToProviderError answers a failed provider call
+func respondToProviderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotConnected):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAuthMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotSupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// startAuth starts the authorization flow for a user
+func (h *Handler) startAuth(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := bindUser(c)
+		if !ok {
+			return
+		}
+
+		start, err := p.StartAuth(c.Request.Context(), userID)
+		if err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, start)
+	}
+}
+
+// completeAuth completes the authorization flow. The OAuth 1.0a names
+// request_token and verifier are accepted for state and code.
+func (h *Handler) completeAuth(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			AuthCallback
+			RequestToken string `json:"request_token"`
+			Verifier     string `json:"verifier"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := uuid.Parse(req.UserID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		callback := req.AuthCallback
+		if callback.State == "" {
+			callback.State = req.RequestToken
+		}
+		if callback.Code == "" {
+			callback.Code = req.Verifier
+		}
+		if callback.State == "" || callback.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "state and code are required"})
+			return
+		}
+
+		if err := p.CompleteAuth(c.Request.Context(), callback); err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": p.Name() + " connected successfully",
+		})
+	}
+}
+
+// refresh renews a user's credentials
+func (h *Handler) refresh(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := bindUser(c)
+		if !ok {
+			return
+		}
+
+		if err := p.Refresh(c.Request.Context(), userID); err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+// disconnect removes a user's credentials and sync status. Linked accounts
+// are kept with their last synced balances.
+func (h *Handler) disconnect(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := bindUser(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := p.Disconnect(ctx, userID); err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+		_, err := h.db.Exec(ctx, `
+			DELETE FROM accounts.sync_connections
+			WHERE institution_id = $1 AND user_id = $2
+		`, p.ID(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove sync status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": p.Name() + " disconnected",
+		})
+	}
+}
+
+// listAccounts returns the user's accounts at the institution
+func (h *Handler) listAccounts(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := queryUser(c)
+		if !ok {
+			return
+		}
+
+		accounts, err := p.Accounts(c.Request.Context(), userID)
+		if err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+	}
+}
+
+// linkAccount links one of the user's accounts at the institution
+func (h *Handler) linkAccount(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LinkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := uuid.Parse(req.UserID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		result, err := Link(c.Request.Context(), h.db, p, req)
+		if err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// listPositions returns the holdings of one of the user's accounts
+func (h *Handler) listPositions(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := queryUser(c)
+		if !ok {
+			return
+		}
+
+		positions, err := p.Positions(c.Request.Context(), userID, c.Param("accountId"))
+		if err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+		if positions == nil {
+			positions = []accountsync.Position{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"positions": positions})
+	}
+}
+
+// listTransactions returns the transactions of one of the user's accounts,
+// from ?from= when given
+func (h *Handler) listTransactions(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := queryUser(c)
+		if !ok {
+			return
+		}
+
+		var since time.Time
+		if value := c.Query("from"); value != "" {
+			date, err := time.Parse(dateLayout, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+			since = date
+		}
+
+		transactions, err := p.Transactions(c.Request.Context(), userID, c.Param("accountId"), since)
+		if err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+		if transactions == nil {
+			transactions = []accountsync.Transaction{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"transactions": transactions})
+	}
+}
+
+// syncAccounts syncs the balances, positions and transactions of a user's
+// linked accounts now, regardless of when the next scheduled sync is due
+func (h *Handler) syncAccounts(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := bindUser(c)
+		if !ok {
+			return
+		}
+
+		result, err := h.schedulers[p.ID()].Sync(c.Request.Context(), userID)
+		if err != nil {
+			respondToProviderError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// getSyncStatus returns when a user's linked accounts were last synced, the
+// last error, and when the next sync is due
+func (h *Handler) getSyncStatus(p Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := queryUser(c)
+		if !ok {
+			return
+		}
+
+		status, err := h.schedulers[p.ID()].Status(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if status == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Accounts have not been synced"})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
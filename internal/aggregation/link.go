@@ -0,0 +1,97 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// LinkRequest is a request to link one of the user's accounts at an
+// institution to a TrustAInvest account
+type LinkRequest struct {
+	UserID      string `json:"user_id" binding:"required"`
+	AccountID   string `json:"account_id" binding:"required"`
+	AccountName string `json:"account_name,omitempty"`
+}
+
+// LinkResult is the outcome of linking an account
+type LinkResult struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	AccountID  string `json:"account_id,omitempty"`
+	InternalID string `json:"internal_id,omitempty"`
+}
+
+// Link creates a TrustAInvest account for one of the user's accounts at the
+// provider's institution, named as requested or after the institution's
+// account. An account that is already linked is returned as it is.
+func Link(ctx context.Context, db *pgxpool.Pool, p Provider, req LinkRequest) (*LinkResult, error) {
+	accounts, err := p.Accounts(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var account *Account
+	for i := range accounts {
+		if accounts[i].ExternalID == req.AccountID {
+			account = &accounts[i]
+			break
+		}
+	}
+	if account == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	var internalID string
+	err = db.QueryRow(ctx, `
+		SELECT id FROM accounts.accounts
+		WHERE institution_id = $1 AND user_id = $2 AND external_account_id = $3 AND is_active = true
+	`, p.ID(), req.UserID, account.ExternalID).Scan(&internalID)
+	if err == nil {
+		return &LinkResult{
+			Success:    true,
+			Message:    "Account already linked",
+			AccountID:  account.ExternalID,
+			InternalID: internalID,
+		}, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to check linked accounts: %w", err)
+	}
+
+	name := account.Name
+	if req.AccountName != "" {
+		name = req.AccountName
+	}
+	currency := strings.ToUpper(account.Currency)
+	if currency == "" {
+		currency = "USD"
+	}
+
+	internalID = uuid.New().String()
+	_, err = db.Exec(ctx, `
+		INSERT INTO accounts.accounts (
+			id, user_id, type, name, description, institution_id, institution_name,
+			external_account_id, balance_amount, balance_currency, is_active
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, true
+		)
+	`, internalID, req.UserID, account.Type, name, account.Description, p.ID(), p.Name(),
+		account.ExternalID, money.Round(account.Balance, currency), currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account in database: %w", err)
+	}
+
+	return &LinkResult{
+		Success:    true,
+		Message:    "Account linked successfully",
+		AccountID:  account.ExternalID,
+		InternalID: internalID,
+	}, nil
+}
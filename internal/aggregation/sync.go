@@ -0,0 +1,141 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+)
+
+// syncer syncs a provider's linked accounts through accountsync
+type syncer struct {
+	db       *pgxpool.Pool
+	provider Provider
+}
+
+// NewSyncer returns the accountsync.Syncer for a provider's institution
+func NewSyncer(db *pgxpool.Pool, p Provider) accountsync.Syncer {
+	return &syncer{db: db, provider: p}
+}
+
+// Institution returns the provider's institution ID
+func (s *syncer) Institution() string {
+	return s.provider.ID()
+}
+
+// Connections returns the users with credentials for the institution and at
+// least one active linked account
+func (s *syncer) Connections(ctx context.Context) ([]string, error) {
+	users, err := s.provider.Connections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT DISTINCT user_id::text
+		FROM accounts.accounts
+		WHERE institution_id = $1 AND user_id = ANY($2::uuid[]) AND is_active = true
+	`, s.provider.ID(), users)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve linked accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var linked []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		linked = append(linked, userID)
+	}
+	return linked, rows.Err()
+}
+
+// FetchAccounts reads the user's accounts with their balances. The positions
+// of linked accounts are read too, with the transactions since each account's
+// last sync; accounts the user has not linked are not read further.
+func (s *syncer) FetchAccounts(ctx context.Context, userID string) ([]accountsync.Account, error) {
+	accounts, err := s.provider.Accounts(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accounts: %w", err)
+	}
+
+	linked, err := s.linkedAccounts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionsSince, err := accountsync.TransactionsSince(ctx, s.db, s.provider.ID(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	synced := make([]accountsync.Account, 0, len(accounts))
+	for _, account := range accounts {
+		a := accountsync.Account{
+			ExternalID: account.ExternalID,
+			Balance:    account.Balance,
+			Currency:   account.Currency,
+		}
+		if !linked[account.ExternalID] {
+			synced = append(synced, a)
+			continue
+		}
+
+		if account.HoldsSecurities {
+			positions, err := s.provider.Positions(ctx, userID, account.ExternalID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get positions for account %s: %w", account.ExternalID, err)
+			}
+			// No positions means everything has been sold
+			a.Positions = make([]accountsync.Position, 0, len(positions))
+			a.Positions = append(a.Positions, positions...)
+		}
+
+		since := transactionsSince[account.ExternalID]
+		transactions, err := s.provider.Transactions(ctx, userID, account.ExternalID, since)
+		switch {
+		case errors.Is(err, ErrNotSupported):
+		case err != nil:
+			return nil, fmt.Errorf("failed to get transactions for account %s: %w", account.ExternalID, err)
+		default:
+			a.Transactions = make([]accountsync.Transaction, 0, len(transactions))
+			a.Transactions = append(a.Transactions, transactions...)
+			a.TransactionsSince = since
+		}
+
+		synced = append(synced, a)
+	}
+	return synced, nil
+}
+
+// linkedAccounts returns the institution account IDs of the user's active
+// linked accounts
+func (s *syncer) linkedAccounts(ctx context.Context, userID string) (map[string]bool, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT external_account_id
+		FROM accounts.accounts
+		WHERE institution_id = $1 AND user_id = $2 AND is_active = true
+	`, s.provider.ID(), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve linked accounts: %w", err)
+	}
+	defer rows.Close()
+
+	linked := make(map[string]bool)
+	for rows.Next() {
+		var externalID string
+		if err := rows.Scan(&externalID); err != nil {
+			return nil, err
+		}
+		linked[externalID] = true
+	}
+	return linked, rows.Err()
+}
@@ -8,6 +8,17 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+// Session is an active user session
+type Session struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	DeviceInfo     string    `json:"device_info,omitempty"`
+	IPAddress      string    `json:"ip_address,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+}
+
 // SessionService handles user session management
 type SessionService struct {
 	db *pgxpool.Pool
@@ -81,6 +92,26 @@ func (s *SessionService) ValidateSession(ctx context.Context, sessionID string)
 	return exists, nil
 }
 
+// GetActiveSession returns a session if it is valid and active
+func (s *SessionService) GetActiveSession(ctx context.Context, sessionID string) (*Session, error) {
+	var session Session
+	err := s.db.QueryRow(ctx, `
+		SELECT id, user_id, COALESCE(device_info, ''), COALESCE(ip_address, ''),
+		       created_at, expires_at, last_activity_at
+		FROM users.active_sessions
+		WHERE id = $1 AND is_active = true AND expires_at > NOW()
+	`, sessionID).Scan(
+		&session.ID, &session.UserID, &session.DeviceInfo, &session.IPAddress,
+		&session.CreatedAt, &session.ExpiresAt, &session.LastActivityAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
 // UpdateSessionActivity updates the last activity timestamp for a session
 func (s *SessionService) UpdateSessionActivity(ctx context.Context, sessionID string) error {
 	_, err := s.db.Exec(ctx, `
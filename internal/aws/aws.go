@@ -1,124 +1,180 @@
 package aws
 
 import (
-"bytes"
-"context"
-"fmt"
-"io"
-"time"
-
-"github.com/aws/aws-sdk-go/aws"
-"github.com/aws/aws-sdk-go/aws/session"
-"github.com/aws/aws-sdk-go/service/s3"
-"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// NewSession creates an AWS session for the given region. When endpoint is
+// set (for example LocalStack in development) requests are sent there instead
+// of the AWS service endpoints.
+func NewSession(region, endpoint string) (*session.Session, error) {
+	cfg := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	return sess, nil
+}
+
 // S3Client is a wrapper for AWS S3 operations
 type S3Client struct {
-client *s3.S3
-bucket string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
 }
 
 // NewS3Client creates a new S3Client
 func NewS3Client(sess *session.Session, bucket string) *S3Client {
-return &S3Client{
-client: s3.New(sess),
-bucket: bucket,
-}
+	client := s3.New(sess)
+	return &S3Client{
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+		bucket:   bucket,
+	}
 }
 
-// UploadFile uploads a file to S3
+// UploadFile uploads a file to S3. The body is streamed in parts, so it does
+// not need to be held in memory or be seekable.
 func (c *S3Client) UploadFile(ctx context.Context, key string, body io.Reader, contentType string) error {
-_, err := c.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
-Bucket:      aws.String(c.bucket),
-Key:         aws.String(key),
-Body:        aws.ReadSeekCloser(body),
-ContentType: aws.String(contentType),
-})
-
-if err != nil {
-return fmt.Errorf("failed to upload file: %w", err)
-}
-
-return nil
+	_, err := c.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
 }
 
 // DownloadFile downloads a file from S3
 func (c *S3Client) DownloadFile(ctx context.Context, key string) ([]byte, error) {
-result, err := c.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-Bucket: aws.String(c.bucket),
-Key:    aws.String(key),
-})
-
-if err != nil {
-return nil, fmt.Errorf("failed to download file: %w", err)
+	result, err := c.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer result.Body.Close()
+
+	buf := new(bytes.Buffer)
+	_, err = io.Copy(buf, result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return buf.Bytes(), nil
 }
-defer result.Body.Close()
 
-buf := new(bytes.Buffer)
-_, err = io.Copy(buf, result.Body)
-if err != nil {
-return nil, fmt.Errorf("failed to read file: %w", err)
+// DeleteFile deletes a file from S3
+func (c *S3Client) DeleteFile(ctx context.Context, key string) error {
+	_, err := c.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	return nil
 }
 
-return buf.Bytes(), nil
+// PresignDownloadURL returns a URL that downloads a file without credentials
+// until it expires. fileName, when set, is suggested to the browser.
+func (c *S3Client) PresignDownloadURL(key, fileName string, expires time.Duration) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	}
+	if fileName != "" {
+		input.ResponseContentDisposition = aws.String(fmt.Sprintf("attachment; filename=%q", fileName))
+	}
+
+	req, _ := c.client.GetObjectRequest(input)
+	url, err := req.Presign(expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign download URL: %w", err)
+	}
+
+	return url, nil
 }
 
 // CognitoClient is a wrapper for AWS Cognito operations
 type CognitoClient struct {
-client       *cognitoidentityprovider.CognitoIdentityProvider
-userPoolID   string
-clientID     string
-clientSecret string
+	client       *cognitoidentityprovider.CognitoIdentityProvider
+	userPoolID   string
+	clientID     string
+	clientSecret string
 }
 
 // NewCognitoClient creates a new CognitoClient
 func NewCognitoClient(sess *session.Session, userPoolID, clientID, clientSecret string) *CognitoClient {
-return &CognitoClient{
-client:       cognitoidentityprovider.New(sess),
-userPoolID:   userPoolID,
-clientID:     clientID,
-clientSecret: clientSecret,
-}
+	return &CognitoClient{
+		client:       cognitoidentityprovider.New(sess),
+		userPoolID:   userPoolID,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}
 }
 
 // CreateUser creates a new user in Cognito
 func (c *CognitoClient) CreateUser(ctx context.Context, username, password, email string) error {
-_, err := c.client.AdminCreateUser(&cognitoidentityprovider.AdminCreateUserInput{
-UserPoolId: aws.String(c.userPoolID),
-Username:   aws.String(username),
-TemporaryPassword: aws.String(password),
-UserAttributes: []*cognitoidentityprovider.AttributeType{
-	{
-		Name:  aws.String("email"),
-		Value: aws.String(email),
-	},
-	{
-		Name:  aws.String("email_verified"),
-		Value: aws.String("true"),
-	},
-},
-})
-
-if err != nil {
-return fmt.Errorf("failed to create user: %w", err)
-}
-
-return nil
+	_, err := c.client.AdminCreateUser(&cognitoidentityprovider.AdminCreateUserInput{
+		UserPoolId:        aws.String(c.userPoolID),
+		Username:          aws.String(username),
+		TemporaryPassword: aws.String(password),
+		UserAttributes: []*cognitoidentityprovider.AttributeType{
+			{
+				Name:  aws.String("email"),
+				Value: aws.String(email),
+			},
+			{
+				Name:  aws.String("email_verified"),
+				Value: aws.String("true"),
+			},
+		},
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
 }
 
 // SetUserPassword sets a permanent password for a user
 func (c *CognitoClient) SetUserPassword(ctx context.Context, username, password string) error {
-_, err := c.client.AdminSetUserPassword(&cognitoidentityprovider.AdminSetUserPasswordInput{
-UserPoolId: aws.String(c.userPoolID),
-Username:   aws.String(username),
-Password:   aws.String(password),
-Permanent:  aws.Bool(true),
-})
-
-if err != nil {
-return fmt.Errorf("failed to set user password: %w", err)
-}
-
-return nil
+	_, err := c.client.AdminSetUserPassword(&cognitoidentityprovider.AdminSetUserPasswordInput{
+		UserPoolId: aws.String(c.userPoolID),
+		Username:   aws.String(username),
+		Password:   aws.String(password),
+		Permanent:  aws.Bool(true),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set user password: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,45 @@
+// Package beneficiaries holds the rules for beneficiary designations that
+// trusts and accounts share.
+//
+// A trust or account splits its assets between its active beneficiaries in
+// whole percentages. The split may never total more than 100, and it is
+// complete once it totals exactly 100.
+package beneficiaries
+
+import (
+	"errors"
+	"time"
+)
+
+// FullAllocation is the total percentage of a complete split
+const FullAllocation = 100
+
+// ErrAllocationExceeded is returned when a change would push the total split past 100 percent
+var ErrAllocationExceeded = errors.New("beneficiary percentages would exceed 100")
+
+// CheckAllocation verifies that giving percentage to a beneficiary, on top
+// of the allocated percentage the other beneficiaries hold, keeps the split
+// at or below 100
+func CheckAllocation(allocated, percentage int) error {
+	if allocated+percentage > FullAllocation {
+		return ErrAllocationExceeded
+	}
+	return nil
+}
+
+// Complete reports whether a split totals exactly 100
+func Complete(allocated int) bool {
+	return allocated == FullAllocation
+}
+
+// ParseDateOfBirth parses a YYYY-MM-DD date, returning nil for an empty string
+func ParseDateOfBirth(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
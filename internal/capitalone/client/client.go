@@ -13,6 +13,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/capitalone/models"
+	"github.com/shopspring/decimal"
 )
 
 const (
@@ -21,13 +22,12 @@ const (
 	productionBaseURL = "https://api.capitalone.com"
 
 	// Capital One API endpoints
-	authEndpoint           = "/oauth2/authorize"
-	tokenEndpoint          = "/oauth2/token" // Updated to match the documentation
-	accountsEndpoint       = "/accounts"
-	accountDetailsEndpoint = "/accounts/%s"
-	transactionsEndpoint   = "/accounts/%s/transactions"
-	investmentsEndpoint    = "/investments/accounts/%s/positions"
-	bankProductsEndpoint   = "/deposits/products/%s/search"
+	authEndpoint         = "/oauth2/authorize"
+	tokenEndpoint        = "/oauth2/token" // Updated to match the documentation
+	accountsEndpoint     = "/accounts"
+	transactionsEndpoint = "/accounts/%s/transactions"
+	investmentsEndpoint  = "/investments/accounts/%s/positions"
+	bankProductsEndpoint = "/deposits/products/%s/search"
 
 	// Default timeout for HTTP requests
 	defaultTimeout = 30 * time.Second
@@ -243,8 +243,9 @@ func (c *CapitalOneClient) ensureValidToken() error {
 	return nil
 }
 
-// GetAccounts retrieves the list of accounts for the authenticated user
-func (c *CapitalOneClient) GetAccounts() ([]models.CapitalOneAccount, error) {
+// ListAccounts retrieves the accounts of the authenticated user with their
+// balances
+func (c *CapitalOneClient) ListAccounts() ([]models.CapitalOneAccount, error) {
 	// Ensure we have a valid token
 	if err := c.ensureValidToken(); err != nil {
 		return nil, err
@@ -282,11 +283,11 @@ func (c *CapitalOneClient) GetAccounts() ([]models.CapitalOneAccount, error) {
 	// Parse the JSON response
 	var response struct {
 		Accounts []struct {
-			AccountID   string  `json:"accountId"`
-			AccountName string  `json:"accountName"`
-			AccountType string  `json:"accountType"`
-			Balance     float64 `json:"balance"`
-			Currency    string  `json:"currency"`
+			AccountID   string          `json:"accountId"`
+			AccountName string          `json:"accountName"`
+			AccountType string          `json:"accountType"`
+			Balance     decimal.Decimal `json:"balance"`
+			Currency    string          `json:"currency"`
 		} `json:"accounts"`
 	}
 
@@ -310,84 +311,67 @@ func (c *CapitalOneClient) GetAccounts() ([]models.CapitalOneAccount, error) {
 		})
 	}
 
-	// Get additional details for each account
-	for i := range accounts {
-		// Get account transactions
-		if err := c.enrichAccountWithTransactions(&accounts[i]); err != nil {
-			// Log the error but continue with other accounts
-			fmt.Printf("Failed to get transactions for account %s: %v\n", accounts[i].AccountID, err)
-		}
-
-		// If it's an investment account, get positions
-		if accounts[i].AccountType == "INVESTMENT" {
-			if err := c.enrichAccountWithPositions(&accounts[i]); err != nil {
-				// Log the error but continue with other accounts
-				fmt.Printf("Failed to get positions for account %s: %v\n", accounts[i].AccountID, err)
-			}
-		}
-	}
-
 	return accounts, nil
 }
 
-// enrichAccountWithTransactions adds transaction information to an account
-func (c *CapitalOneClient) enrichAccountWithTransactions(account *models.CapitalOneAccount) error {
+// GetTransactions retrieves an account's transactions from startDate to today
+func (c *CapitalOneClient) GetTransactions(accountID string, startDate time.Time) ([]models.CapitalOneTransaction, error) {
 	// Ensure we have a valid token
 	if err := c.ensureValidToken(); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create the URL
-	transactionsURL := c.baseURL + fmt.Sprintf(transactionsEndpoint, account.AccountID)
+	transactionsURL := c.baseURL + fmt.Sprintf(transactionsEndpoint, accountID)
 
 	// Create the request
 	req, err := http.NewRequest("GET", transactionsURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create transactions request: %w", err)
+		return nil, fmt.Errorf("failed to create transactions request: %w", err)
 	}
 	req.Header.Add("Authorization", "Bearer "+c.accessToken)
 	req.Header.Add("Accept", "application/json")
 
-	// Add query parameters for date range (last 30 days)
+	// Add query parameters for date range
 	q := req.URL.Query()
-	q.Add("startDate", time.Now().AddDate(0, 0, -30).Format("2006-01-02"))
+	q.Add("startDate", startDate.Format("2006-01-02"))
 	q.Add("endDate", time.Now().Format("2006-01-02"))
 	req.URL.RawQuery = q.Encode()
 
 	// Send the request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send transactions request: %w", err)
+		return nil, fmt.Errorf("failed to send transactions request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read transactions response: %w", err)
+		return nil, fmt.Errorf("failed to read transactions response: %w", err)
 	}
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("transactions request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("transactions request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse the JSON response
 	var response struct {
 		Transactions []struct {
-			TransactionID   string  `json:"transactionId"`
-			TransactionDate string  `json:"transactionDate"`
-			PostDate        string  `json:"postDate"`
-			Description     string  `json:"description"`
-			Category        string  `json:"category"`
-			Amount          float64 `json:"amount"`
-			Type            string  `json:"type"`
-			Status          string  `json:"status"`
+			TransactionID   string          `json:"transactionId"`
+			TransactionDate string          `json:"transactionDate"`
+			PostDate        string          `json:"postDate"`
+			Description     string          `json:"description"`
+			Category        string          `json:"category"`
+			Amount          decimal.Decimal `json:"amount"`
+			Type            string          `json:"type"`
+			Status          string          `json:"status"`
 		} `json:"transactions"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
-		return fmt.Errorf("failed to parse transactions response: %w", err)
+		return nil, fmt.Errorf("failed to parse transactions response: %w", err)
 	}
 
 	// Convert to our model
@@ -418,26 +402,23 @@ func (c *CapitalOneClient) enrichAccountWithTransactions(account *models.Capital
 		})
 	}
 
-	// Update the account
-	account.Transactions = transactions
-
-	return nil
+	return transactions, nil
 }
 
-// enrichAccountWithPositions adds position information to an investment account
-func (c *CapitalOneClient) enrichAccountWithPositions(account *models.CapitalOneAccount) error {
+// GetPositions retrieves the positions held in an investment account
+func (c *CapitalOneClient) GetPositions(accountID string) ([]models.CapitalOnePosition, error) {
 	// Ensure we have a valid token
 	if err := c.ensureValidToken(); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create the URL
-	positionsURL := c.baseURL + fmt.Sprintf(investmentsEndpoint, account.AccountID)
+	positionsURL := c.baseURL + fmt.Sprintf(investmentsEndpoint, accountID)
 
 	// Create the request
 	req, err := http.NewRequest("GET", positionsURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create positions request: %w", err)
+		return nil, fmt.Errorf("failed to create positions request: %w", err)
 	}
 	req.Header.Add("Authorization", "Bearer "+c.accessToken)
 	req.Header.Add("Accept", "application/json")
@@ -445,37 +426,37 @@ func (c *CapitalOneClient) enrichAccountWithPositions(account *models.CapitalOne
 	// Send the request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send positions request: %w", err)
+		return nil, fmt.Errorf("failed to send positions request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read positions response: %w", err)
+		return nil, fmt.Errorf("failed to read positions response: %w", err)
 	}
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("positions request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("positions request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse the JSON response
 	var response struct {
 		Positions []struct {
-			Symbol        string  `json:"symbol"`
-			Quantity      float64 `json:"quantity"`
-			CostBasis     float64 `json:"costBasis"`
-			MarketValue   float64 `json:"marketValue"`
-			GainLoss      float64 `json:"gainLoss"`
-			GainLossPerc  float64 `json:"gainLossPerc"`
-			LastPrice     float64 `json:"lastPrice"`
-			LastPriceTime string  `json:"lastPriceTime"`
+			Symbol        string          `json:"symbol"`
+			Quantity      float64         `json:"quantity"`
+			CostBasis     decimal.Decimal `json:"costBasis"`
+			MarketValue   decimal.Decimal `json:"marketValue"`
+			GainLoss      decimal.Decimal `json:"gainLoss"`
+			GainLossPerc  float64         `json:"gainLossPerc"`
+			LastPrice     float64         `json:"lastPrice"`
+			LastPriceTime string          `json:"lastPriceTime"`
 		} `json:"positions"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
-		return fmt.Errorf("failed to parse positions response: %w", err)
+		return nil, fmt.Errorf("failed to parse positions response: %w", err)
 	}
 
 	// Convert to our model
@@ -500,91 +481,7 @@ func (c *CapitalOneClient) enrichAccountWithPositions(account *models.CapitalOne
 		})
 	}
 
-	// Update the account
-	account.AccountPositions = positions
-
-	return nil
-}
-
-// GetAccountDetails retrieves detailed information for a specific account
-func (c *CapitalOneClient) GetAccountDetails(accountID string) (*models.CapitalOneAccount, error) {
-	// Ensure we have a valid token
-	if err := c.ensureValidToken(); err != nil {
-		return nil, err
-	}
-
-	// Create the URL
-	accountURL := c.baseURL + fmt.Sprintf(accountDetailsEndpoint, accountID)
-
-	// Create the request
-	req, err := http.NewRequest("GET", accountURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create account details request: %w", err)
-	}
-	req.Header.Add("Authorization", "Bearer "+c.accessToken)
-	req.Header.Add("Accept", "application/json")
-
-	// Send the request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send account details request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read account details response: %w", err)
-	}
-
-	// Check the response status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("account details request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Parse the JSON response
-	var response struct {
-		Account struct {
-			AccountID   string  `json:"accountId"`
-			AccountName string  `json:"accountName"`
-			AccountType string  `json:"accountType"`
-			Balance     float64 `json:"balance"`
-			Currency    string  `json:"currency"`
-		} `json:"account"`
-	}
-
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse account details response: %w", err)
-	}
-
-	// Convert to our model
-	account := &models.CapitalOneAccount{
-		AccountID:       response.Account.AccountID,
-		AccountName:     response.Account.AccountName,
-		AccountType:     response.Account.AccountType,
-		InstitutionID:   "capitalone",
-		InstitutionName: "Capital One",
-		Balance:         response.Account.Balance,
-		Currency:        response.Account.Currency,
-		Status:          "active",
-		LastUpdated:     time.Now(),
-	}
-
-	// Get account transactions
-	if err := c.enrichAccountWithTransactions(account); err != nil {
-		// Log the error but continue
-		fmt.Printf("Failed to get transactions for account %s: %v\n", account.AccountID, err)
-	}
-
-	// If it's an investment account, get positions
-	if account.AccountType == "INVESTMENT" {
-		if err := c.enrichAccountWithPositions(account); err != nil {
-			// Log the error but continue
-			fmt.Printf("Failed to get positions for account %s: %v\n", account.AccountID, err)
-		}
-	}
-
-	return account, nil
+	return positions, nil
 }
 
 // SearchBankProducts searches for bank products based on the provided criteria
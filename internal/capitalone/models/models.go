@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 // CapitalOneCredentials represents the credentials needed to authenticate with Capital One API
@@ -15,62 +17,39 @@ type CapitalOneCredentials struct {
 
 // CapitalOneAccount represents a Capital One account
 type CapitalOneAccount struct {
-	AccountID        string                  `json:"account_id"`
-	AccountName      string                  `json:"account_name"`
-	AccountType      string                  `json:"account_type"`
-	InstitutionID    string                  `json:"institution_id"`
-	InstitutionName  string                  `json:"institution_name"`
-	Balance          float64                 `json:"balance"`
-	Currency         string                  `json:"currency"`
-	LastUpdated      time.Time               `json:"last_updated"`
-	Status           string                  `json:"status"`
-	AccountPositions []CapitalOnePosition    `json:"account_positions,omitempty"`
-	Transactions     []CapitalOneTransaction `json:"transactions,omitempty"`
+	AccountID       string          `json:"account_id"`
+	AccountName     string          `json:"account_name"`
+	AccountType     string          `json:"account_type"`
+	InstitutionID   string          `json:"institution_id"`
+	InstitutionName string          `json:"institution_name"`
+	Balance         decimal.Decimal `json:"balance"`
+	Currency        string          `json:"currency"`
+	LastUpdated     time.Time       `json:"last_updated"`
+	Status          string          `json:"status"`
 }
 
 // CapitalOnePosition represents a position in a Capital One investment account
 type CapitalOnePosition struct {
-	Symbol        string    `json:"symbol"`
-	Quantity      float64   `json:"quantity"`
-	CostBasis     float64   `json:"cost_basis"`
-	MarketValue   float64   `json:"market_value"`
-	GainLoss      float64   `json:"gain_loss"`
-	GainLossPerc  float64   `json:"gain_loss_perc"`
-	LastPrice     float64   `json:"last_price"`
-	LastPriceTime time.Time `json:"last_price_time"`
+	Symbol        string          `json:"symbol"`
+	Quantity      float64         `json:"quantity"`
+	CostBasis     decimal.Decimal `json:"cost_basis"`
+	MarketValue   decimal.Decimal `json:"market_value"`
+	GainLoss      decimal.Decimal `json:"gain_loss"`
+	GainLossPerc  float64         `json:"gain_loss_perc"`
+	LastPrice     float64         `json:"last_price"`
+	LastPriceTime time.Time       `json:"last_price_time"`
 }
 
 // CapitalOneTransaction represents a transaction in a Capital One account
 type CapitalOneTransaction struct {
-	TransactionID   string    `json:"transaction_id"`
-	TransactionDate time.Time `json:"transaction_date"`
-	PostDate        time.Time `json:"post_date"`
-	Description     string    `json:"description"`
-	Category        string    `json:"category"`
-	Amount          float64   `json:"amount"`
-	Type            string    `json:"type"`
-	Status          string    `json:"status"`
-}
-
-// CapitalOneAuthRequest represents a request to link a Capital One account
-type CapitalOneAuthRequest struct {
-	UserID      string `json:"user_id" binding:"required"`
-	ClientID    string `json:"client_id" binding:"required"`
-	RedirectURI string `json:"redirect_uri" binding:"required"`
-}
-
-// CapitalOneAuthResponse represents a response from the Capital One auth process
-type CapitalOneAuthResponse struct {
-	AuthURL string `json:"auth_url"`
-	State   string `json:"state"`
-}
-
-// CapitalOneAuthCallback represents the callback data from Capital One after user authorization
-type CapitalOneAuthCallback struct {
-	Code        string `json:"code"`
-	State       string `json:"state"`
-	UserID      string `json:"user_id"`
-	RedirectURI string `json:"redirect_uri"`
+	TransactionID   string          `json:"transaction_id"`
+	TransactionDate time.Time       `json:"transaction_date"`
+	PostDate        time.Time       `json:"post_date"`
+	Description     string          `json:"description"`
+	Category        string          `json:"category"`
+	Amount          decimal.Decimal `json:"amount"`
+	Type            string          `json:"type"`
+	Status          string          `json:"status"`
 }
 
 // CapitalOneTokenResponse represents the response from the Capital One token endpoint
@@ -81,21 +60,6 @@ type CapitalOneTokenResponse struct {
 	ExpiresIn    int    `json:"expires_in"`
 }
 
-// CapitalOneAccountLinkRequest represents a request to link a Capital One account to a TrustAInvest account
-type CapitalOneAccountLinkRequest struct {
-	UserID      string `json:"user_id" binding:"required"`
-	AccountID   string `json:"account_id" binding:"required"`
-	AccountName string `json:"account_name,omitempty"`
-}
-
-// CapitalOneAccountLinkResponse represents a response after linking a Capital One account
-type CapitalOneAccountLinkResponse struct {
-	Success    bool   `json:"success"`
-	Message    string `json:"message,omitempty"`
-	AccountID  string `json:"account_id,omitempty"`
-	InternalID string `json:"internal_id,omitempty"`
-}
-
 // CapitalOneError represents an error from the Capital One API
 type CapitalOneError struct {
 	Code    string `json:"code"`
@@ -2,146 +2,309 @@ package service
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/aggregation"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/capitalone/client"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/capitalone/models"
 )
 
-// CapitalOneService handles the business logic for Capital One integration
+// institutionID is the institution_id of linked Capital One accounts
+const institutionID = "capitalone"
+
+// tokenRefreshMargin is how long before expiry an access token is refreshed
+const tokenRefreshMargin = 5 * time.Minute
+
+// defaultTransactionHistory is how far back transactions are read when no
+// start date is given
+const defaultTransactionHistory = 30 * 24 * time.Hour
+
+// CapitalOneService connects users to Capital One. It is the aggregation
+// provider for Capital One and also searches Capital One bank products.
 type CapitalOneService struct {
-	db               *pgxpool.Pool
-	capitalOneClient *client.CapitalOneClient
-	redirectURI      string
+	db           *pgxpool.Pool
+	redirectURI  string
+	clientID     string
+	clientSecret string
+	useSandbox   bool
 }
 
 // NewCapitalOneService creates a new Capital One service
 func NewCapitalOneService(db *pgxpool.Pool, clientID, clientSecret, redirectURI string, useSandbox bool) *CapitalOneService {
 	return &CapitalOneService{
-		db:               db,
-		capitalOneClient: client.NewCapitalOneClient(clientID, clientSecret, useSandbox),
-		redirectURI:      redirectURI,
+		db:           db,
+		redirectURI:  redirectURI,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		useSandbox:   useSandbox,
 	}
 }
 
-// InitiateAuth starts the OAuth flow for Capital One
-func (s *CapitalOneService) InitiateAuth(userID string) (*models.CapitalOneAuthResponse, error) {
+// ID returns the institution ID linked Capital One accounts are stored under
+func (s *CapitalOneService) ID() string {
+	return institutionID
+}
+
+// Name returns the institution's display name
+func (s *CapitalOneService) Name() string {
+	return "Capital One"
+}
+
+// StartAuth starts the OAuth flow for Capital One
+func (s *CapitalOneService) StartAuth(ctx context.Context, userID string) (*aggregation.AuthStart, error) {
 	// Generate the authorization URL
-	state, authURL, err := s.capitalOneClient.GetAuthorizationURL(s.redirectURI)
+	state, authURL, err := s.newClient().GetAuthorizationURL(s.redirectURI)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get authorization URL: %w", err)
 	}
 
 	// Store the state in the database
-	err = s.storeAuthState(userID, state)
-	if err != nil {
+	if err := s.storeAuthState(ctx, userID, state); err != nil {
 		return nil, fmt.Errorf("failed to store auth state: %w", err)
 	}
 
-	return &models.CapitalOneAuthResponse{
+	return &aggregation.AuthStart{
 		AuthURL: authURL,
 		State:   state,
 	}, nil
 }
 
-// CompleteAuth completes the OAuth flow for Capital One
-func (s *CapitalOneService) CompleteAuth(callback *models.CapitalOneAuthCallback) (string, error) {
+// CompleteAuth exchanges the authorization code for tokens
+func (s *CapitalOneService) CompleteAuth(ctx context.Context, callback aggregation.AuthCallback) error {
 	// Verify the state
-	storedState, err := s.getAuthState(callback.UserID)
+	storedState, err := s.getAuthState(ctx, callback.UserID)
+	if err == pgx.ErrNoRows {
+		return aggregation.ErrAuthMismatch
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to get stored auth state: %w", err)
+		return fmt.Errorf("failed to get stored auth state: %w", err)
 	}
-
 	if storedState != callback.State {
-		return "", errors.New("state mismatch")
+		return aggregation.ErrAuthMismatch
+	}
+
+	redirectURI := callback.RedirectURI
+	if redirectURI == "" {
+		redirectURI = s.redirectURI
 	}
 
 	// Exchange the code for an access token
-	accessToken, refreshToken, expiresIn, err := s.capitalOneClient.ExchangeCodeForToken(callback.Code, callback.RedirectURI)
+	accessToken, refreshToken, expiresIn, err := s.newClient().ExchangeCodeForToken(callback.Code, redirectURI)
 	if err != nil {
-		return "", fmt.Errorf("failed to exchange code for token: %w", err)
+		return fmt.Errorf("failed to exchange code for token: %w", err)
 	}
 
 	// Store the tokens in the database
-	err = s.storeTokens(callback.UserID, accessToken, refreshToken, expiresIn)
-	if err != nil {
-		return "", fmt.Errorf("failed to store tokens: %w", err)
+	if err := s.storeTokens(ctx, callback.UserID, accessToken, refreshToken, expiresIn); err != nil {
+		return fmt.Errorf("failed to store tokens: %w", err)
 	}
-
-	return accessToken, nil
+	return nil
 }
 
-// GetAccounts retrieves the list of accounts for the authenticated user
-func (s *CapitalOneService) GetAccounts(userID string) ([]models.CapitalOneAccount, error) {
-	// Get the tokens from the database
-	accessToken, refreshToken, expiresIn, err := s.getTokens(userID)
+// Accounts retrieves the user's Capital One accounts with their balances
+func (s *CapitalOneService) Accounts(ctx context.Context, userID string) ([]aggregation.Account, error) {
+	capitalOneClient, err := s.userClient(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tokens: %w", err)
+		return nil, err
 	}
 
-	// Set the credentials in the client
-	s.capitalOneClient.SetCredentials(accessToken, refreshToken, expiresIn)
-
-	// Get the accounts
-	accounts, err := s.capitalOneClient.GetAccounts()
+	accounts, err := capitalOneClient.ListAccounts()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get accounts: %w", err)
 	}
 
-	return accounts, nil
+	result := make([]aggregation.Account, 0, len(accounts))
+	for _, account := range accounts {
+		// Determine the account type for our system
+		accountType := "CHECKING"
+		switch account.AccountType {
+		case "CREDIT_CARD":
+			accountType = "CREDIT_CARD"
+		case "INVESTMENT":
+			accountType = "BROKERAGE"
+		case "SAVINGS":
+			accountType = "SAVINGS"
+		}
+
+		result = append(result, aggregation.Account{
+			ExternalID:      account.AccountID,
+			Name:            account.AccountName,
+			Type:            accountType,
+			Description:     "Capital One " + account.AccountType + " account",
+			Balance:         account.Balance,
+			Currency:        account.Currency,
+			HoldsSecurities: account.AccountType == "INVESTMENT",
+		})
+	}
+	return result, nil
 }
 
-// LinkAccount links a Capital One account to a TrustAInvest account
-func (s *CapitalOneService) LinkAccount(req *models.CapitalOneAccountLinkRequest) (*models.CapitalOneAccountLinkResponse, error) {
-	// Get the tokens from the database
-	accessToken, refreshToken, expiresIn, err := s.getTokens(req.UserID)
+// Positions retrieves the positions held in one of the user's Capital One
+// investment accounts
+func (s *CapitalOneService) Positions(ctx context.Context, userID, accountID string) ([]accountsync.Position, error) {
+	capitalOneClient, err := s.userClient(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tokens: %w", err)
+		return nil, err
+	}
+
+	positions, err := capitalOneClient.GetPositions(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get positions: %w", err)
 	}
 
-	// Set the credentials in the client
-	s.capitalOneClient.SetCredentials(accessToken, refreshToken, expiresIn)
+	result := make([]accountsync.Position, 0, len(positions))
+	for _, p := range positions {
+		result = append(result, accountsync.Position{
+			Symbol:      p.Symbol,
+			Quantity:    p.Quantity,
+			CostBasis:   p.CostBasis,
+			MarketValue: p.MarketValue,
+		})
+	}
+	return result, nil
+}
+
+// Transactions retrieves one of the user's Capital One account transactions
+// since a date, or for the last 30 days
+func (s *CapitalOneService) Transactions(ctx context.Context, userID, accountID string, since time.Time) ([]accountsync.Transaction, error) {
+	capitalOneClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Get the account details to verify the account exists
-	account, err := s.capitalOneClient.GetAccountDetails(req.AccountID)
+	if since.IsZero() {
+		since = time.Now().Add(-defaultTransactionHistory)
+	}
+	transactions, err := capitalOneClient.GetTransactions(accountID, since)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get account details: %w", err)
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	result := make([]accountsync.Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		postDate := t.PostDate
+		result = append(result, accountsync.Transaction{
+			ExternalID:  t.TransactionID,
+			Date:        t.TransactionDate,
+			PostDate:    &postDate,
+			Description: t.Description,
+			Category:    t.Category,
+			Amount:      t.Amount,
+			Type:        t.Type,
+			Pending:     strings.EqualFold(t.Status, accountsync.TransactionPending),
+		})
 	}
+	return result, nil
+}
 
-	// Use the provided account name if available, otherwise use the one from Capital One
-	accountName := account.AccountName
-	if req.AccountName != "" {
-		accountName = req.AccountName
+// Refresh exchanges the user's refresh token for a new access token
+func (s *CapitalOneService) Refresh(ctx context.Context, userID string) error {
+	accessToken, refreshToken, expiresIn, err := s.getTokens(ctx, userID)
+	if err == pgx.ErrNoRows {
+		return aggregation.ErrNotConnected
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get tokens: %w", err)
 	}
 
-	// Create the account in the database
-	internalID, err := s.createAccountInDB(req.UserID, account, accountName)
+	capitalOneClient := s.newClient()
+	capitalOneClient.SetCredentials(accessToken, refreshToken, expiresIn)
+	return s.refreshTokens(ctx, capitalOneClient, userID, refreshToken)
+}
+
+// Disconnect removes the user's stored tokens and pending authorization
+func (s *CapitalOneService) Disconnect(ctx context.Context, userID string) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM capitalone.auth_tokens WHERE user_id = $1`, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create account in database: %w", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return aggregation.ErrNotConnected
 	}
 
-	return &models.CapitalOneAccountLinkResponse{
-		Success:    true,
-		Message:    "Account linked successfully",
-		AccountID:  account.AccountID,
-		InternalID: internalID,
-	}, nil
+	_, err = s.db.Exec(ctx, `DELETE FROM capitalone.auth_states WHERE user_id = $1`, userID)
+	return err
+}
+
+// Connections returns the users with Capital One tokens
+func (s *CapitalOneService) Connections(ctx context.Context) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT user_id::text FROM capitalone.auth_tokens`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve Capital One connections: %w", err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
 }
 
 // SearchBankProducts searches for bank products based on the provided criteria
 func (s *CapitalOneService) SearchBankProducts(productID string, searchRequest *models.BankProductSearchRequest) (*models.BankProductSearchResponse, error) {
 	// Search for bank products using the client
-	return s.capitalOneClient.SearchBankProducts(productID, searchRequest)
+	return s.newClient().SearchBankProducts(productID, searchRequest)
+}
+
+// newClient creates a Capital One client without user credentials. Each call
+// gets its own client so concurrent requests never share credentials.
+func (s *CapitalOneService) newClient() *client.CapitalOneClient {
+	return client.NewCapitalOneClient(s.clientID, s.clientSecret, s.useSandbox)
+}
+
+// userClient creates a Capital One client with the user's tokens. An access
+// token close to expiry is refreshed first and the new tokens are stored, so
+// the refresh token the next call reads is still valid.
+func (s *CapitalOneService) userClient(ctx context.Context, userID string) (*client.CapitalOneClient, error) {
+	accessToken, refreshToken, expiresIn, err := s.getTokens(ctx, userID)
+	if err == pgx.ErrNoRows {
+		return nil, aggregation.ErrNotConnected
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tokens: %w", err)
+	}
+
+	capitalOneClient := s.newClient()
+	capitalOneClient.SetCredentials(accessToken, refreshToken, expiresIn)
+
+	if time.Duration(expiresIn)*time.Second < tokenRefreshMargin {
+		if err := s.refreshTokens(ctx, capitalOneClient, userID, refreshToken); err != nil {
+			return nil, err
+		}
+	}
+	return capitalOneClient, nil
+}
+
+// refreshTokens refreshes the client's access token and stores the new
+// tokens. The refresh token stays valid when a new one is not issued.
+func (s *CapitalOneService) refreshTokens(ctx context.Context, capitalOneClient *client.CapitalOneClient, userID, refreshToken string) error {
+	accessToken, newRefreshToken, expiresIn, err := capitalOneClient.RefreshAccessToken()
+	if err != nil {
+		return fmt.Errorf("failed to refresh access token: %w", err)
+	}
+	if newRefreshToken == "" {
+		newRefreshToken = refreshToken
+		capitalOneClient.SetCredentials(accessToken, newRefreshToken, expiresIn)
+	}
+	if err := s.storeTokens(ctx, userID, accessToken, newRefreshToken, expiresIn); err != nil {
+		return fmt.Errorf("failed to store tokens: %w", err)
+	}
+	return nil
 }
 
 // storeAuthState stores the auth state in the database
-func (s *CapitalOneService) storeAuthState(userID, state string) error {
-	ctx := context.Background()
+func (s *CapitalOneService) storeAuthState(ctx context.Context, userID, state string) error {
 	_, err := s.db.Exec(
 		ctx,
 		`INSERT INTO capitalone.auth_states (user_id, state, created_at)
@@ -154,8 +317,7 @@ func (s *CapitalOneService) storeAuthState(userID, state string) error {
 }
 
 // getAuthState retrieves the auth state from the database
-func (s *CapitalOneService) getAuthState(userID string) (string, error) {
-	ctx := context.Background()
+func (s *CapitalOneService) getAuthState(ctx context.Context, userID string) (string, error) {
 	var state string
 	err := s.db.QueryRow(
 		ctx,
@@ -166,8 +328,7 @@ func (s *CapitalOneService) getAuthState(userID string) (string, error) {
 }
 
 // storeTokens stores the tokens in the database
-func (s *CapitalOneService) storeTokens(userID, accessToken, refreshToken string, expiresIn int) error {
-	ctx := context.Background()
+func (s *CapitalOneService) storeTokens(ctx context.Context, userID, accessToken, refreshToken string, expiresIn int) error {
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 	_, err := s.db.Exec(
 		ctx,
@@ -181,8 +342,7 @@ func (s *CapitalOneService) storeTokens(userID, accessToken, refreshToken string
 }
 
 // getTokens retrieves the tokens from the database
-func (s *CapitalOneService) getTokens(userID string) (string, string, int, error) {
-	ctx := context.Background()
+func (s *CapitalOneService) getTokens(ctx context.Context, userID string) (string, string, int, error) {
 	var accessToken, refreshToken string
 	var expiresAt time.Time
 	err := s.db.QueryRow(
@@ -200,40 +360,6 @@ func (s *CapitalOneService) getTokens(userID string) (string, string, int, error
 	return accessToken, refreshToken, expiresIn, err
 }
 
-// createAccountInDB creates an account in the database
-func (s *CapitalOneService) createAccountInDB(userID string, account *models.CapitalOneAccount, accountName string) (string, error) {
-	ctx := context.Background()
-	id := uuid.New().String()
-
-	// Determine the account type for our system
-	accountType := "CHECKING"
-	if account.AccountType == "CREDIT_CARD" {
-		accountType = "CREDIT_CARD"
-	} else if account.AccountType == "INVESTMENT" {
-		accountType = "BROKERAGE"
-	} else if account.AccountType == "SAVINGS" {
-		accountType = "SAVINGS"
-	}
-
-	_, err := s.db.Exec(
-		ctx,
-		`INSERT INTO accounts.accounts (
-			id, user_id, type, name, description, institution_id, institution_name,
-			external_account_id, balance_amount, balance_currency, is_active
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
-		)`,
-		id, userID, accountType, accountName, "Capital One "+account.AccountType+" account",
-		"capitalone", "Capital One", account.AccountID, account.Balance, account.Currency, true,
-	)
-
-	if err != nil {
-		return "", err
-	}
-
-	return id, nil
-}
-
 // ensureCapitalOneSchema ensures the capitalone schema and tables exist
 func (s *CapitalOneService) EnsureCapitalOneSchema() error {
 	ctx := context.Background()
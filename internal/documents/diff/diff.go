@@ -0,0 +1,164 @@
+// Package diff compares two versions of a document template line by line.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operations in a line diff
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+// Line is one line of a diff. OldLine and NewLine are 1-based line numbers in
+// the old and new text, or zero when the line does not appear there.
+type Line struct {
+	Op      string `json:"op"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+	Text    string `json:"text"`
+}
+
+// Lines returns the line diff that turns old into new, using the longest
+// common subsequence of the two texts' lines
+func Lines(old, new string) []Line {
+	a, b := split(old), split(new)
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]Line, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, Line{Op: OpEqual, OldLine: i + 1, NewLine: j + 1, Text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, Line{Op: OpDelete, OldLine: i + 1, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: OpInsert, NewLine: j + 1, Text: b[j]})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// Changed reports whether a diff contains any insertions or deletions
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != OpEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// Unified formats a diff in unified diff format with the given number of
+// context lines around each change
+func Unified(lines []Line, oldName, newName string, context int) string {
+	if !Changed(lines) {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	for start := 0; start < len(lines); {
+		// Find the next change
+		first := start
+		for first < len(lines) && lines[first].Op == OpEqual {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other
+		hunkStart := max(first-context, start)
+		end := first
+		for end < len(lines) {
+			next := end
+			for next < len(lines) && lines[next].Op != OpEqual {
+				next++
+			}
+			equal := next
+			for equal < len(lines) && lines[equal].Op == OpEqual {
+				equal++
+			}
+			end = next
+			if equal == len(lines) || equal-next > 2*context {
+				break
+			}
+			end = equal
+		}
+		hunkEnd := min(end+context, len(lines))
+
+		writeHunk(&b, lines[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+
+	return b.String()
+}
+
+// writeHunk writes one unified diff hunk
+func writeHunk(b *strings.Builder, hunk []Line) {
+	oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+	for _, l := range hunk {
+		if l.OldLine > 0 {
+			if oldStart == 0 {
+				oldStart = l.OldLine
+			}
+			oldCount++
+		}
+		if l.NewLine > 0 {
+			if newStart == 0 {
+				newStart = l.NewLine
+			}
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, l := range hunk {
+		switch l.Op {
+		case OpInsert:
+			b.WriteString("+")
+		case OpDelete:
+			b.WriteString("-")
+		default:
+			b.WriteString(" ")
+		}
+		b.WriteString(l.Text)
+		b.WriteString("\n")
+	}
+}
+
+// split breaks text into lines without their line endings
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
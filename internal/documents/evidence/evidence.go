@@ -0,0 +1,149 @@
+// Package evidence records tamper-evident electronic signature events.
+//
+// Each signature event captures a hash of the document content at signing
+// time along with who signed and from where. Events for a document form a
+// hash chain: every event's hash covers its own fields and the hash of the
+// event before it, so altering, removing or reordering an event breaks the
+// chain from that point on.
+package evidence
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Event types recorded in a document's signature chain
+const (
+	EventSigned = "SIGNED"
+)
+
+// Authentication methods a signer can use
+const (
+	AuthSession     = "SESSION"
+	AuthInviteToken = "INVITE_TOKEN"
+)
+
+// Event is one entry in a document's signature chain
+type Event struct {
+	ID           string    `json:"id"`
+	DocumentID   string    `json:"document_id"`
+	SignatoryID  string    `json:"signatory_id"`
+	Type         string    `json:"type"`
+	ContentHash  string    `json:"content_hash"`
+	SignerName   string    `json:"signer_name"`
+	SignerEmail  string    `json:"signer_email"`
+	UserID       string    `json:"user_id,omitempty"`
+	SessionID    string    `json:"session_id,omitempty"`
+	AuthMethod   string    `json:"auth_method"`
+	IPAddress    string    `json:"ip_address"`
+	UserAgent    string    `json:"user_agent"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	PreviousHash string    `json:"previous_hash"`
+	Hash         string    `json:"hash"`
+}
+
+// ComputeHash returns the hash of the event's fields and its previous hash.
+// The Hash field itself is not included.
+func (e Event) ComputeHash() string {
+	fields := []string{
+		e.ID, e.DocumentID, e.SignatoryID, e.Type, e.ContentHash,
+		e.SignerName, e.SignerEmail, e.UserID, e.SessionID, e.AuthMethod,
+		e.IPAddress, e.UserAgent, e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.PreviousHash,
+	}
+
+	// JSON encoding keeps field boundaries unambiguous
+	encoded, _ := json.Marshal(fields)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// Seal links the event to the previous hash in the chain and sets its hash
+func (e *Event) Seal(previousHash string) {
+	e.PreviousHash = previousHash
+	e.Hash = e.ComputeHash()
+}
+
+// EventCheck is the verification result for one event
+type EventCheck struct {
+	EventID        string `json:"event_id"`
+	HashValid      bool   `json:"hash_valid"`
+	LinkValid      bool   `json:"link_valid"`
+	ContentMatches bool   `json:"content_matches"`
+}
+
+// Verification is the result of verifying a document's signature chain
+type Verification struct {
+	ChainValid       bool         `json:"chain_valid"`
+	ContentUnchanged bool         `json:"content_unchanged"`
+	Events           []EventCheck `json:"events"`
+}
+
+// Verify checks a document's events in chain order against the document's
+// current content hash. The chain is valid when every event's hash matches
+// its fields and links to the event before it; content is unchanged when
+// every event was signed over the current content.
+func Verify(events []Event, currentContentHash string) Verification {
+	result := Verification{ChainValid: true, ContentUnchanged: true}
+
+	previous := ""
+	for _, e := range events {
+		check := EventCheck{
+			EventID:        e.ID,
+			HashValid:      e.ComputeHash() == e.Hash,
+			LinkValid:      e.PreviousHash == previous,
+			ContentMatches: e.ContentHash == currentContentHash,
+		}
+		if !check.HashValid || !check.LinkValid {
+			result.ChainValid = false
+		}
+		if !check.ContentMatches {
+			result.ContentUnchanged = false
+		}
+		result.Events = append(result.Events, check)
+		previous = e.Hash
+	}
+
+	return result
+}
+
+// ContentFile is one file included in a document's content hash
+type ContentFile struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// HashBytes returns the hex SHA-256 of data
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashReader returns the hex SHA-256 of everything read from r
+func HashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to hash content: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentHash combines the hashes of a document's files into one content
+// hash. Files are ordered by ID so the result does not depend on query order.
+func ContentHash(files []ContentFile) string {
+	sorted := make([]ContentFile, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "%s:%s\n", f.ID, f.SHA256)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
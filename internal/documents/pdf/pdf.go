@@ -0,0 +1,359 @@
+// Package pdf lays out rendered document text as a PDF.
+//
+// The output uses the standard Helvetica fonts, so no font files are embedded.
+// Every page carries a header with the document title and a page number
+// footer, and the document ends with a signature block for each signatory.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Page geometry in points (US Letter with one inch margins)
+const (
+	pageWidth    = 612.0
+	pageHeight   = 792.0
+	marginLeft   = 72.0
+	marginRight  = 72.0
+	marginTop    = 72.0
+	marginBottom = 72.0
+	contentWidth = pageWidth - marginLeft - marginRight
+
+	headerY = pageHeight - 40
+	footerY = 36.0
+
+	bodySize    = 11.0
+	bodyLeading = 15.0
+	titleSize   = 16.0
+	headingSize = 12.0
+	smallSize   = 9.0
+
+	// signatureBlockHeight is the space reserved for one signature block
+	signatureBlockHeight = 96.0
+)
+
+// Fonts registered in every page's resources
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// Document is the content to lay out
+type Document struct {
+	Title       string
+	Subtitle    string
+	Body        string
+	Signatories []Signatory
+	GeneratedAt time.Time
+}
+
+// Signatory is a person who signs the document
+type Signatory struct {
+	Name     string
+	Email    string
+	Role     string
+	Required bool
+	SignedAt *time.Time
+}
+
+// page collects the drawing operators for one page
+type page struct {
+	ops bytes.Buffer
+}
+
+func (p *page) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(&p.ops, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+func (p *page) line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.ops, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// layout tracks the pages and the current vertical position while writing
+type layout struct {
+	pages []*page
+	y     float64
+}
+
+func (l *layout) current() *page {
+	return l.pages[len(l.pages)-1]
+}
+
+func (l *layout) newPage() {
+	l.pages = append(l.pages, &page{})
+	l.y = pageHeight - marginTop
+}
+
+// ensure starts a new page unless height points remain above the bottom margin
+func (l *layout) ensure(height float64) {
+	if l.y-height < marginBottom {
+		l.newPage()
+	}
+}
+
+// Render lays out the document and returns the PDF bytes
+func Render(doc Document) ([]byte, error) {
+	if doc.GeneratedAt.IsZero() {
+		doc.GeneratedAt = time.Now()
+	}
+
+	l := &layout{}
+	l.newPage()
+
+	// Title block on the first page
+	for _, line := range wrap(doc.Title, titleSize, contentWidth) {
+		l.ensure(titleSize + 6)
+		l.y -= titleSize
+		l.current().text(fontBold, titleSize, marginLeft, l.y, line)
+		l.y -= 6
+	}
+	if doc.Subtitle != "" {
+		l.y -= smallSize
+		l.current().text(fontRegular, smallSize, marginLeft, l.y, doc.Subtitle)
+	}
+	l.y -= bodyLeading
+
+	// Body text, one paragraph per input line
+	for _, paragraph := range strings.Split(strings.ReplaceAll(doc.Body, "\r\n", "\n"), "\n") {
+		lines := wrap(paragraph, bodySize, contentWidth)
+		if len(lines) == 0 {
+			l.ensure(bodyLeading)
+			l.y -= bodyLeading
+			continue
+		}
+		for _, line := range lines {
+			l.ensure(bodyLeading)
+			l.y -= bodyLeading
+			l.current().text(fontRegular, bodySize, marginLeft, l.y, line)
+		}
+	}
+
+	// Signature blocks, never split across pages
+	if len(doc.Signatories) > 0 {
+		l.ensure(headingSize + bodyLeading*2 + signatureBlockHeight)
+		l.y -= bodyLeading * 2
+		l.current().text(fontBold, headingSize, marginLeft, l.y, "Signatures")
+		l.y -= bodyLeading / 2
+
+		for _, s := range doc.Signatories {
+			l.ensure(signatureBlockHeight)
+			signatureBlock(l.current(), l.y, s)
+			l.y -= signatureBlockHeight
+		}
+	}
+
+	// Headers and footers need the final page count
+	for i, p := range l.pages {
+		p.text(fontBold, smallSize, marginLeft, headerY, truncate(doc.Title, smallSize, contentWidth*0.6))
+		generated := "Generated " + doc.GeneratedAt.UTC().Format("January 2, 2006")
+		p.text(fontRegular, smallSize, pageWidth-marginRight-textWidth(generated, smallSize), headerY, generated)
+		p.line(marginLeft, headerY-6, pageWidth-marginRight, headerY-6, 0.5)
+
+		number := fmt.Sprintf("Page %d of %d", i+1, len(l.pages))
+		p.text(fontRegular, smallSize, (pageWidth-textWidth(number, smallSize))/2, footerY, number)
+	}
+
+	return write(l.pages), nil
+}
+
+// signatureBlock draws a signature line and the signatory's details below top
+func signatureBlock(p *page, top float64, s Signatory) {
+	lineY := top - 36
+	p.line(marginLeft, lineY, marginLeft+240, lineY, 0.75)
+	p.line(marginLeft+280, lineY, marginLeft+400, lineY, 0.75)
+
+	if s.SignedAt != nil {
+		p.text(fontRegular, bodySize, marginLeft+4, lineY+6, "Signed electronically")
+		p.text(fontRegular, bodySize, marginLeft+284, lineY+6, s.SignedAt.UTC().Format("2006-01-02"))
+	}
+
+	name := s.Name
+	if s.Role != "" {
+		name += ", " + s.Role
+	}
+	if !s.Required {
+		name += " (optional)"
+	}
+	p.text(fontBold, smallSize+1, marginLeft, lineY-13, truncate(name, smallSize+1, 270))
+	p.text(fontRegular, smallSize, marginLeft, lineY-25, truncate(s.Email, smallSize, 270))
+	p.text(fontRegular, smallSize, marginLeft+280, lineY-13, "Date")
+}
+
+// write serializes pages into a PDF file
+func write(pages []*page) []byte {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then
+	// takes two objects, the page itself and its content stream.
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range pages {
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+				"/Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, fontRegular, fontBold, 6+i*2,
+		))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.ops.Len(), p.ops.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+// wrap breaks text into lines no wider than width at the given font size
+func wrap(text string, size, width float64) []string {
+	words := strings.Fields(text)
+	var lines []string
+	var current string
+
+	for _, word := range words {
+		// Words longer than a whole line are broken by character
+		for textWidth(word, size) > width {
+			cut := fit(word, size, width)
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			lines = append(lines, word[:cut])
+			word = word[cut:]
+		}
+
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if textWidth(candidate, size) > width {
+			lines = append(lines, current)
+			candidate = word
+		}
+		current = candidate
+	}
+
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// truncate shortens text with an ellipsis so it fits in width
+func truncate(text string, size, width float64) string {
+	if textWidth(text, size) <= width {
+		return text
+	}
+	cut := fit(text, size, width-textWidth("...", size))
+	return text[:cut] + "..."
+}
+
+// fit returns the byte length of the longest prefix of text that fits in width
+func fit(text string, size, width float64) int {
+	cut := 0
+	for i, r := range text {
+		if textWidth(text[:i+len(string(r))], size) > width {
+			break
+		}
+		cut = i + len(string(r))
+	}
+	if cut == 0 && text != "" {
+		// Always make progress, even if a single character is too wide
+		cut = len(string([]rune(text)[0]))
+	}
+	return cut
+}
+
+// textWidth returns the width of text in points for the regular font
+func textWidth(text string, size float64) float64 {
+	units := 0
+	for _, b := range encode(text) {
+		units += charWidth(b)
+	}
+	return float64(units) * size / 1000
+}
+
+// escape encodes text for a PDF string literal
+func escape(text string) string {
+	var buf bytes.Buffer
+	for _, b := range encode(text) {
+		switch b {
+		case '\\', '(', ')':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
+// encode converts text to WinAnsiEncoding, replacing characters the
+// standard fonts cannot show
+func encode(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r == '\t':
+			out = append(out, ' ', ' ', ' ', ' ')
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			out = append(out, byte(r))
+		case r == '‘' || r == '’':
+			out = append(out, '\'')
+		case r == '“' || r == '”':
+			out = append(out, '"')
+		case r == '–' || r == '—':
+			out = append(out, '-')
+		case r == '•':
+			out = append(out, 0x95)
+		case r == '€':
+			out = append(out, 0x80)
+		case r < 0x20:
+			// Drop control characters
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// charWidth returns the Helvetica advance width of a WinAnsi byte in
+// thousandths of the font size
+func charWidth(b byte) int {
+	if b >= 0x20 && b < 0x7f {
+		return helveticaWidths[b-0x20]
+	}
+	// Accented Latin-1 letters are close to the average lowercase width
+	return 556
+}
+
+// helveticaWidths are the Helvetica widths for bytes 0x20 to 0x7e
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
@@ -0,0 +1,345 @@
+// Package render turns document templates into document content.
+//
+// Template content uses Go text/template syntax, so a trust agreement refers to
+// its variables as {{ .grantor.name }} or {{ date .effective_date "January 2, 2006" }}.
+// Each template declares a Schema describing the variables it expects, and the
+// supplied values are checked against it before rendering.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Variable types supported in a template variable schema
+const (
+	TypeAny     = ""
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeDate    = "date"
+	TypeList    = "list"
+	TypeObject  = "object"
+)
+
+// dateLayout is the layout date variables must be supplied in
+const dateLayout = "2006-01-02"
+
+// Variable describes a single template variable
+type Variable struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type,omitempty"`
+	Required    bool        `json:"required"`
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+}
+
+// Schema is the set of variables a template accepts
+type Schema []Variable
+
+// ValidationError lists every problem found while validating variables
+type ValidationError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid template variables: " + strings.Join(e.Problems, "; ")
+}
+
+// SchemaFromNames builds a schema from a plain list of variable names, as
+// stored on templates created before variable schemas existed. Every variable
+// is required and may be of any type.
+func SchemaFromNames(names []string) Schema {
+	schema := make(Schema, 0, len(names))
+	for _, name := range names {
+		schema = append(schema, Variable{Name: name, Required: true})
+	}
+	return schema
+}
+
+// Names returns the variable names declared in the schema
+func (s Schema) Names() []string {
+	names := make([]string, 0, len(s))
+	for _, v := range s {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// Check verifies that the schema itself is well formed
+func (s Schema) Check() error {
+	var problems []string
+	seen := make(map[string]bool)
+
+	for i, v := range s {
+		if v.Name == "" {
+			problems = append(problems, fmt.Sprintf("variable %d has no name", i))
+			continue
+		}
+		if seen[v.Name] {
+			problems = append(problems, fmt.Sprintf("variable %q is declared more than once", v.Name))
+		}
+		seen[v.Name] = true
+
+		switch v.Type {
+		case TypeAny, TypeString, TypeNumber, TypeBoolean, TypeDate, TypeList, TypeObject:
+		default:
+			problems = append(problems, fmt.Sprintf("variable %q has unknown type %q", v.Name, v.Type))
+			continue
+		}
+
+		if v.Default != nil {
+			if err := checkType(v.Type, v.Default); err != nil {
+				problems = append(problems, fmt.Sprintf("default for %q %s", v.Name, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Validate checks the supplied values against the schema and returns them with
+// defaults applied. Values for variables the schema does not declare are rejected.
+func (s Schema) Validate(values map[string]interface{}) (map[string]interface{}, error) {
+	var problems []string
+	result := make(map[string]interface{}, len(s))
+	declared := make(map[string]bool, len(s))
+
+	for _, v := range s {
+		declared[v.Name] = true
+
+		value, ok := values[v.Name]
+		if !ok || value == nil {
+			switch {
+			case v.Default != nil:
+				result[v.Name] = v.Default
+			case v.Required:
+				problems = append(problems, fmt.Sprintf("%q is required", v.Name))
+			default:
+				// Optional variables are present but empty so templates can test them
+				result[v.Name] = nil
+			}
+			continue
+		}
+
+		if err := checkType(v.Type, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%q %s", v.Name, err))
+			continue
+		}
+		result[v.Name] = value
+	}
+
+	for name := range values {
+		if !declared[name] {
+			problems = append(problems, fmt.Sprintf("%q is not declared by the template", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return result, nil
+}
+
+// checkType reports whether a JSON-decoded value matches a schema type
+func checkType(typ string, value interface{}) error {
+	switch typ {
+	case TypeAny:
+		return nil
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("must be a string")
+		}
+	case TypeNumber:
+		switch value.(type) {
+		case float64, float32, int, int64, json.Number:
+		default:
+			return fmt.Errorf("must be a number")
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+	case TypeDate:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a date in YYYY-MM-DD format")
+		}
+		if _, err := time.Parse(dateLayout, str); err != nil {
+			return fmt.Errorf("must be a date in YYYY-MM-DD format")
+		}
+	case TypeList:
+		if _, ok := value.([]interface{}); !ok {
+			return fmt.Errorf("must be a list")
+		}
+	case TypeObject:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("must be an object")
+		}
+	default:
+		return fmt.Errorf("has unknown type %q", typ)
+	}
+	return nil
+}
+
+// Parse parses template content, reporting syntax errors
+func Parse(content string) (*template.Template, error) {
+	tmpl, err := template.New("document").
+		Option("missingkey=error").
+		Funcs(funcs).
+		Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Render executes template content with the given variables
+func Render(content string, values map[string]interface{}) (string, error) {
+	tmpl, err := Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// funcs are the helper functions available inside templates
+var funcs = template.FuncMap{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"title":   titleCase,
+	"join":    join,
+	"date":    formatDate,
+	"money":   formatMoney,
+	"default": defaultValue,
+}
+
+// titleCase upper-cases the first letter of every word
+func titleCase(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
+
+// join joins the items of a list with a separator
+func join(items interface{}, sep string) (string, error) {
+	switch list := items.(type) {
+	case []string:
+		return strings.Join(list, sep), nil
+	case []interface{}:
+		parts := make([]string, 0, len(list))
+		for _, item := range list {
+			parts = append(parts, fmt.Sprint(item))
+		}
+		return strings.Join(parts, sep), nil
+	}
+	return "", fmt.Errorf("join: expected a list, got %T", items)
+}
+
+// formatDate formats a YYYY-MM-DD string or time value with a Go layout
+func formatDate(value interface{}, layout string) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case string:
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return "", fmt.Errorf("date: %q is not a YYYY-MM-DD date", v)
+		}
+		return t.Format(layout), nil
+	}
+	return "", fmt.Errorf("date: expected a date, got %T", value)
+}
+
+// formatMoney formats a number with thousands separators, rounded to the
+// minor unit of the optional currency (two decimals when none is given)
+func formatMoney(value interface{}, currency ...string) (string, error) {
+	var amount decimal.Decimal
+	switch v := value.(type) {
+	case decimal.Decimal:
+		amount = v
+	case float64:
+		amount = decimal.NewFromFloat(v)
+	case float32:
+		amount = decimal.NewFromFloat32(v)
+	case int:
+		amount = decimal.NewFromInt(int64(v))
+	case int64:
+		amount = decimal.NewFromInt(v)
+	case json.Number:
+		d, err := decimal.NewFromString(v.String())
+		if err != nil {
+			return "", fmt.Errorf("money: %w", err)
+		}
+		amount = d
+	case string:
+		d, err := decimal.NewFromString(v)
+		if err != nil {
+			return "", fmt.Errorf("money: %q is not a number", v)
+		}
+		amount = d
+	default:
+		return "", fmt.Errorf("money: expected a number, got %T", value)
+	}
+
+	code := ""
+	if len(currency) > 0 {
+		code = currency[0]
+	}
+
+	// Round before taking the sign so amounts that round to zero print unsigned
+	amount = money.Round(amount, code)
+	sign := ""
+	if amount.IsNegative() {
+		sign = "-"
+		amount = amount.Neg()
+	}
+
+	formatted := amount.StringFixed(money.Places(code))
+	whole, fraction := formatted, ""
+	if dot := strings.IndexByte(formatted, '.'); dot >= 0 {
+		whole, fraction = formatted[:dot], formatted[dot:]
+	}
+
+	var grouped []string
+	for len(whole) > 3 {
+		grouped = append([]string{whole[len(whole)-3:]}, grouped...)
+		whole = whole[:len(whole)-3]
+	}
+	grouped = append([]string{whole}, grouped...)
+
+	return sign + strings.Join(grouped, ",") + fraction, nil
+}
+
+// defaultValue returns fallback when value is empty
+func defaultValue(fallback, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return fallback
+	}
+	return value
+}
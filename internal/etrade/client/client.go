@@ -7,9 +7,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/dghubble/oauth1"
+	"github.com/shopspring/decimal"
 
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/models"
 )
@@ -22,9 +24,15 @@ const (
 	// E-Trade API endpoints
 	authRequestTokenEndpoint = "/oauth/request_token"
 	authAccessTokenEndpoint  = "/oauth/access_token"
+	authRenewTokenEndpoint   = "/oauth/renew_access_token"
+	authRevokeTokenEndpoint  = "/oauth/revoke_access_token"
 	accountListEndpoint      = "/v1/accounts/list"
 	accountBalanceEndpoint   = "/v1/accounts/%s/balance"
 	accountPositionsEndpoint = "/v1/accounts/%s/portfolio"
+	marketQuoteEndpoint      = "/v1/market/quote/%s"
+
+	// Maximum number of symbols E-Trade accepts in one quote request
+	maxQuoteSymbols = 25
 
 	// Default timeout for HTTP requests
 	defaultTimeout = 30 * time.Second
@@ -198,8 +206,51 @@ func (c *ETradeClient) ExchangeRequestTokenForAccessToken(requestToken, verifier
 	return accessToken, tokenSecret, nil
 }
 
-// GetAccounts retrieves the list of accounts for the authenticated user
-func (c *ETradeClient) GetAccounts() ([]models.ETradeAccount, error) {
+// RenewAccessToken reactivates the access token after two hours without use.
+// Access tokens still expire at midnight US Eastern time, after which the user
+// must authorize again.
+func (c *ETradeClient) RenewAccessToken() error {
+	return c.tokenRequest(authRenewTokenEndpoint, "renew access token")
+}
+
+// RevokeAccessToken revokes the access token so it can no longer be used
+func (c *ETradeClient) RevokeAccessToken() error {
+	return c.tokenRequest(authRevokeTokenEndpoint, "revoke access token")
+}
+
+// tokenRequest makes a signed request to one of the access token endpoints
+func (c *ETradeClient) tokenRequest(endpoint, action string) error {
+	// Check if we have credentials
+	if c.accessToken == "" || c.tokenSecret == "" {
+		return errors.New("client not authenticated")
+	}
+
+	// Create an authenticated client
+	token := oauth1.NewToken(c.accessToken, c.tokenSecret)
+	httpClient := c.oauthConfig.Client(oauth1.NoContext, token)
+
+	// Make the request
+	resp, err := httpClient.Get(c.baseURL + endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to %s: %s", action, string(body))
+	}
+	return nil
+}
+
+// ListAccounts retrieves the accounts of the authenticated user with their
+// balances. It fails if the balance of any account cannot be read.
+func (c *ETradeClient) ListAccounts() ([]models.ETradeAccount, error) {
 	// Check if we have credentials
 	if c.accessToken == "" || c.tokenSecret == "" {
 		return nil, errors.New("client not authenticated")
@@ -261,18 +312,10 @@ func (c *ETradeClient) GetAccounts() ([]models.ETradeAccount, error) {
 		})
 	}
 
-	// Get additional details for each account
+	// Get the balance of each account
 	for i := range accounts {
-		// Get account balance
 		if err := c.enrichAccountWithBalance(&accounts[i]); err != nil {
-			// Log the error but continue with other accounts
-			fmt.Printf("Failed to get balance for account %s: %v\n", accounts[i].AccountID, err)
-		}
-
-		// Get account positions
-		if err := c.enrichAccountWithPositions(&accounts[i]); err != nil {
-			// Log the error but continue with other accounts
-			fmt.Printf("Failed to get positions for account %s: %v\n", accounts[i].AccountID, err)
+			return nil, fmt.Errorf("failed to get balance for account %s: %w", accounts[i].AccountID, err)
 		}
 	}
 
@@ -310,8 +353,8 @@ func (c *ETradeClient) enrichAccountWithBalance(account *models.ETradeAccount) e
 	var response struct {
 		BalanceResponse struct {
 			AccountBalance struct {
-				NetAccountValue float64 `json:"netAccountValue"`
-				Currency        string  `json:"currency"`
+				NetAccountValue decimal.Decimal `json:"netAccountValue"`
+				Currency        string          `json:"currency"`
 			} `json:"accountBalance"`
 		} `json:"BalanceResponse"`
 	}
@@ -344,10 +387,15 @@ func generateTimestamp() string {
 	return fmt.Sprintf("%d", time.Now().Unix())
 }
 
-// enrichAccountWithPositions adds position information to an account
-func (c *ETradeClient) enrichAccountWithPositions(account *models.ETradeAccount) error {
+// GetPositions retrieves the positions held in an account
+func (c *ETradeClient) GetPositions(accountID string) ([]models.ETradePosition, error) {
+	// Check if we have credentials
+	if c.accessToken == "" || c.tokenSecret == "" {
+		return nil, errors.New("client not authenticated")
+	}
+
 	// Create the URL
-	positionsURL := c.baseURL + fmt.Sprintf(accountPositionsEndpoint, account.AccountID)
+	positionsURL := c.baseURL + fmt.Sprintf(accountPositionsEndpoint, accountID)
 
 	// Create an authenticated client
 	token := oauth1.NewToken(c.accessToken, c.tokenSecret)
@@ -356,19 +404,19 @@ func (c *ETradeClient) enrichAccountWithPositions(account *models.ETradeAccount)
 	// Make the request
 	resp, err := httpClient.Get(positionsURL)
 	if err != nil {
-		return fmt.Errorf("failed to get account positions: %w", err)
+		return nil, fmt.Errorf("failed to get account positions: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	// Parse the response
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to get account positions: %s", string(body))
+		return nil, fmt.Errorf("failed to get account positions: %s", string(body))
 	}
 
 	// Parse the JSON response
@@ -377,12 +425,12 @@ func (c *ETradeClient) enrichAccountWithPositions(account *models.ETradeAccount)
 			AccountPortfolio struct {
 				Position []struct {
 					PositionDetails struct {
-						Symbol       string  `json:"symbol"`
-						Quantity     float64 `json:"quantity"`
-						CostBasis    float64 `json:"costBasis"`
-						MarketValue  float64 `json:"marketValue"`
-						TotalGain    float64 `json:"totalGain"`
-						TotalGainPct float64 `json:"totalGainPct"`
+						Symbol       string          `json:"symbol"`
+						Quantity     float64         `json:"quantity"`
+						CostBasis    decimal.Decimal `json:"costBasis"`
+						MarketValue  decimal.Decimal `json:"marketValue"`
+						TotalGain    decimal.Decimal `json:"totalGain"`
+						TotalGainPct float64         `json:"totalGainPct"`
 					} `json:"positionDetails"`
 					Quote struct {
 						LastPrice     float64 `json:"lastPrice"`
@@ -394,7 +442,7 @@ func (c *ETradeClient) enrichAccountWithPositions(account *models.ETradeAccount)
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
-		return fmt.Errorf("failed to parse positions response: %w", err)
+		return nil, fmt.Errorf("failed to parse positions response: %w", err)
 	}
 
 	// Convert to our model
@@ -419,8 +467,108 @@ func (c *ETradeClient) enrichAccountWithPositions(account *models.ETradeAccount)
 		})
 	}
 
-	// Update the account
-	account.AccountPositions = positions
+	return positions, nil
+}
 
-	return nil
+// GetQuotes retrieves the latest quotes for the given symbols. Symbols are
+// requested in batches of up to 25, the most E-Trade accepts per request.
+func (c *ETradeClient) GetQuotes(symbols []string) ([]models.ETradeQuote, error) {
+	// Check if we have credentials
+	if c.accessToken == "" || c.tokenSecret == "" {
+		return nil, errors.New("client not authenticated")
+	}
+
+	quotes := make([]models.ETradeQuote, 0, len(symbols))
+	for start := 0; start < len(symbols); start += maxQuoteSymbols {
+		end := start + maxQuoteSymbols
+		if end > len(symbols) {
+			end = len(symbols)
+		}
+
+		batch, err := c.getQuoteBatch(symbols[start:end])
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, batch...)
+	}
+
+	return quotes, nil
+}
+
+// getQuoteBatch retrieves quotes for a single batch of symbols
+func (c *ETradeClient) getQuoteBatch(symbols []string) ([]models.ETradeQuote, error) {
+	// Create the URL
+	escaped := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		escaped[i] = url.PathEscape(symbol)
+	}
+	quoteURL := c.baseURL + fmt.Sprintf(marketQuoteEndpoint, strings.Join(escaped, ","))
+
+	// Create an authenticated client
+	token := oauth1.NewToken(c.accessToken, c.tokenSecret)
+	httpClient := c.oauthConfig.Client(oauth1.NoContext, token)
+
+	// Make the request
+	req, err := http.NewRequest(http.MethodGet, quoteURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quote request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quotes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Parse the response
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get quotes: %s", string(body))
+	}
+
+	// Parse the JSON response
+	var response struct {
+		QuoteResponse struct {
+			QuoteData []struct {
+				DateTimeUTC int64  `json:"dateTimeUTC"`
+				QuoteStatus string `json:"quoteStatus"`
+				All         struct {
+					LastTrade float64 `json:"lastTrade"`
+				} `json:"All"`
+				Product struct {
+					Symbol       string `json:"symbol"`
+					SecurityType string `json:"securityType"`
+				} `json:"Product"`
+			} `json:"QuoteData"`
+		} `json:"QuoteResponse"`
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse quote response: %w", err)
+	}
+
+	// Convert to our model
+	quotes := make([]models.ETradeQuote, 0, len(response.QuoteResponse.QuoteData))
+	for _, data := range response.QuoteResponse.QuoteData {
+		quoteTime := time.Now()
+		if data.DateTimeUTC > 0 {
+			quoteTime = time.Unix(data.DateTimeUTC, 0)
+		}
+
+		quotes = append(quotes, models.ETradeQuote{
+			Symbol:       data.Product.Symbol,
+			SecurityType: data.Product.SecurityType,
+			LastPrice:    data.All.LastTrade,
+			QuoteStatus:  data.QuoteStatus,
+			QuoteTime:    quoteTime,
+		})
+	}
+
+	return quotes, nil
 }
@@ -0,0 +1,349 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dghubble/oauth1"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/models"
+)
+
+const (
+	// E-Trade order endpoints
+	orderPreviewEndpoint = "/v1/accounts/%s/orders/preview"
+	orderPlaceEndpoint   = "/v1/accounts/%s/orders/place"
+	orderCancelEndpoint  = "/v1/accounts/%s/orders/cancel"
+	orderListEndpoint    = "/v1/accounts/%s/orders"
+
+	// Order type for equities and ETFs
+	equityOrderType = "EQ"
+
+	// Default order term when none is given
+	defaultOrderTerm = "GOOD_FOR_DAY"
+)
+
+// OrderRejectedError is returned when E-Trade refuses an order request, as
+// opposed to failing to answer it
+type OrderRejectedError struct {
+	Action  string
+	Message string
+}
+
+func (e *OrderRejectedError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Action, e.Message)
+}
+
+// orderMessages is the messages block E-Trade attaches to order responses
+type orderMessages struct {
+	Message []struct {
+		Description string `json:"description"`
+		Type        string `json:"type"`
+	} `json:"Message"`
+}
+
+func (m orderMessages) descriptions() []string {
+	var out []string
+	for _, msg := range m.Message {
+		out = append(out, msg.Description)
+	}
+	return out
+}
+
+// orderDetail builds the Order element shared by preview and place requests
+func orderDetail(req models.ETradeOrderRequest) []map[string]interface{} {
+	term := req.OrderTerm
+	if term == "" {
+		term = defaultOrderTerm
+	}
+
+	order := map[string]interface{}{
+		"allOrNone":     false,
+		"priceType":     req.PriceType,
+		"orderTerm":     term,
+		"marketSession": "REGULAR",
+		"Instrument": []map[string]interface{}{
+			{
+				"Product": map[string]string{
+					"securityType": equityOrderType,
+					"symbol":       req.Symbol,
+				},
+				"orderAction":  req.OrderAction,
+				"quantityType": "QUANTITY",
+				"quantity":     req.Quantity,
+			},
+		},
+	}
+	if req.LimitPrice > 0 {
+		order["limitPrice"] = req.LimitPrice
+	}
+	if req.StopPrice > 0 {
+		order["stopPrice"] = req.StopPrice
+	}
+	return []map[string]interface{}{order}
+}
+
+// PreviewOrder asks E-Trade to validate an order and estimate its cost.
+// The returned preview ID must be passed to PlaceOrder.
+func (c *ETradeClient) PreviewOrder(req models.ETradeOrderRequest) (*models.ETradeOrderPreview, error) {
+	body := map[string]interface{}{
+		"PreviewOrderRequest": map[string]interface{}{
+			"orderType":     equityOrderType,
+			"clientOrderId": req.ClientOrderID,
+			"Order":         orderDetail(req),
+		},
+	}
+
+	var response struct {
+		PreviewOrderResponse struct {
+			PreviewIds []struct {
+				PreviewID json.Number `json:"previewId"`
+			} `json:"PreviewIds"`
+			Order []struct {
+				EstimatedTotalAmount float64       `json:"estimatedTotalAmount"`
+				EstimatedCommission  float64       `json:"estimatedCommission"`
+				Messages             orderMessages `json:"messages"`
+			} `json:"Order"`
+		} `json:"PreviewOrderResponse"`
+	}
+
+	previewURL := c.baseURL + fmt.Sprintf(orderPreviewEndpoint, req.AccountID)
+	if err := c.doOrderRequest(http.MethodPost, previewURL, body, &response, "preview order"); err != nil {
+		return nil, err
+	}
+
+	if len(response.PreviewOrderResponse.PreviewIds) == 0 {
+		return nil, errors.New("failed to preview order: no preview ID returned")
+	}
+
+	preview := &models.ETradeOrderPreview{
+		PreviewID: response.PreviewOrderResponse.PreviewIds[0].PreviewID.String(),
+	}
+	for _, order := range response.PreviewOrderResponse.Order {
+		preview.EstimatedTotalAmount += order.EstimatedTotalAmount
+		preview.EstimatedCommission += order.EstimatedCommission
+		preview.Messages = append(preview.Messages, order.Messages.descriptions()...)
+	}
+
+	return preview, nil
+}
+
+// PlaceOrder places a previously previewed order. The order must match the
+// one that was previewed.
+func (c *ETradeClient) PlaceOrder(req models.ETradeOrderRequest, previewID string) (*models.ETradeOrderPlacement, error) {
+	id, err := strconv.ParseInt(previewID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preview ID %q", previewID)
+	}
+
+	body := map[string]interface{}{
+		"PlaceOrderRequest": map[string]interface{}{
+			"orderType":     equityOrderType,
+			"clientOrderId": req.ClientOrderID,
+			"PreviewIds":    []map[string]int64{{"previewId": id}},
+			"Order":         orderDetail(req),
+		},
+	}
+
+	var response struct {
+		PlaceOrderResponse struct {
+			OrderIds []struct {
+				OrderID json.Number `json:"orderId"`
+			} `json:"OrderIds"`
+			PlacedTime int64 `json:"placedTime"`
+			Order      []struct {
+				Messages orderMessages `json:"messages"`
+			} `json:"Order"`
+		} `json:"PlaceOrderResponse"`
+	}
+
+	placeURL := c.baseURL + fmt.Sprintf(orderPlaceEndpoint, req.AccountID)
+	if err := c.doOrderRequest(http.MethodPost, placeURL, body, &response, "place order"); err != nil {
+		return nil, err
+	}
+
+	if len(response.PlaceOrderResponse.OrderIds) == 0 {
+		return nil, errors.New("failed to place order: no order ID returned")
+	}
+
+	placement := &models.ETradeOrderPlacement{
+		OrderID:    response.PlaceOrderResponse.OrderIds[0].OrderID.String(),
+		PlacedTime: epochMillis(response.PlaceOrderResponse.PlacedTime),
+	}
+	for _, order := range response.PlaceOrderResponse.Order {
+		placement.Messages = append(placement.Messages, order.Messages.descriptions()...)
+	}
+
+	return placement, nil
+}
+
+// CancelOrder asks E-Trade to cancel an open order
+func (c *ETradeClient) CancelOrder(accountID, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid order ID %q", orderID)
+	}
+
+	body := map[string]interface{}{
+		"CancelOrderRequest": map[string]int64{"orderId": id},
+	}
+
+	cancelURL := c.baseURL + fmt.Sprintf(orderCancelEndpoint, accountID)
+	return c.doOrderRequest(http.MethodPut, cancelURL, body, nil, "cancel order")
+}
+
+// GetOrder retrieves the status of a placed order
+func (c *ETradeClient) GetOrder(accountID, orderID string) (*models.ETradeOrderStatus, error) {
+	status, err := c.findOrder(accountID, func(id, _ string) bool { return id == orderID })
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	return status, nil
+}
+
+// FindOrder retrieves the status of the order placed with a client order ID,
+// or nil when the account has no such order
+func (c *ETradeClient) FindOrder(accountID, clientOrderID string) (*models.ETradeOrderStatus, error) {
+	return c.findOrder(accountID, func(_, id string) bool { return id == clientOrderID })
+}
+
+// findOrder lists the account's orders and returns the status of the first
+// one matching by order ID and client order ID, or nil if none does
+func (c *ETradeClient) findOrder(accountID string, match func(orderID, clientOrderID string) bool) (*models.ETradeOrderStatus, error) {
+	var response struct {
+		OrdersResponse struct {
+			Order []struct {
+				OrderID       json.Number `json:"orderId"`
+				ClientOrderID string      `json:"clientOrderId"`
+				OrderDetail   []struct {
+					PlacedTime   int64  `json:"placedTime"`
+					ExecutedTime int64  `json:"executedTime"`
+					Status       string `json:"status"`
+					Instrument   []struct {
+						OrderedQuantity       float64 `json:"orderedQuantity"`
+						FilledQuantity        float64 `json:"filledQuantity"`
+						AverageExecutionPrice float64 `json:"averageExecutionPrice"`
+					} `json:"Instrument"`
+				} `json:"OrderDetail"`
+			} `json:"Order"`
+		} `json:"OrdersResponse"`
+	}
+
+	listURL := c.baseURL + fmt.Sprintf(orderListEndpoint, accountID)
+	if err := c.doOrderRequest(http.MethodGet, listURL, nil, &response, "get orders"); err != nil {
+		return nil, err
+	}
+
+	for _, order := range response.OrdersResponse.Order {
+		if !match(order.OrderID.String(), order.ClientOrderID) || len(order.OrderDetail) == 0 {
+			continue
+		}
+
+		detail := order.OrderDetail[0]
+		status := &models.ETradeOrderStatus{
+			OrderID:      order.OrderID.String(),
+			Status:       detail.Status,
+			PlacedTime:   epochMillis(detail.PlacedTime),
+			ExecutedTime: epochMillis(detail.ExecutedTime),
+		}
+
+		// Weight the average price across instruments by filled quantity
+		var filledValue float64
+		for _, instrument := range detail.Instrument {
+			status.OrderedQuantity += instrument.OrderedQuantity
+			status.FilledQuantity += instrument.FilledQuantity
+			filledValue += instrument.FilledQuantity * instrument.AverageExecutionPrice
+		}
+		if status.FilledQuantity > 0 {
+			status.AverageExecutionPrice = filledValue / status.FilledQuantity
+		}
+		return status, nil
+	}
+
+	return nil, nil
+}
+
+// doOrderRequest sends an authenticated JSON request to an order endpoint and
+// decodes the response into out when it is not nil. Requests E-Trade refuses
+// are returned as *OrderRejectedError.
+func (c *ETradeClient) doOrderRequest(method, requestURL string, body, out interface{}, action string) error {
+	// Check if we have credentials
+	if c.accessToken == "" || c.tokenSecret == "" {
+		return errors.New("client not authenticated")
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", action, err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", action, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Create an authenticated client
+	token := oauth1.NewToken(c.accessToken, c.tokenSecret)
+	httpClient := c.oauthConfig.Client(oauth1.NoContext, token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusBadRequest {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"Error"`
+		}
+		message := string(respBody)
+		if json.Unmarshal(respBody, &failure) == nil && failure.Error.Message != "" {
+			message = failure.Error.Message
+		}
+		return &OrderRejectedError{Action: action, Message: message}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to %s: %s", action, string(respBody))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", action, err)
+	}
+	return nil
+}
+
+// epochMillis converts an E-Trade millisecond timestamp to a time
+func epochMillis(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -1,16 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/client"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/models"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/service"
 )
 
-// ETradeHandler handles HTTP requests for E-Trade integration
+// ETradeHandler handles HTTP requests for E-Trade orders. Connecting,
+// account linking and sync are served by the aggregation handler.
 type ETradeHandler struct {
 	etradeService *service.ETradeService
 }
@@ -22,20 +25,35 @@ func NewETradeHandler(etradeService *service.ETradeService) *ETradeHandler {
 	}
 }
 
-// RegisterRoutes registers the E-Trade routes
+// RegisterRoutes registers the E-Trade order routes
 func (h *ETradeHandler) RegisterRoutes(router *gin.RouterGroup) {
 	etrade := router.Group("/etrade")
 	{
-		etrade.POST("/auth/initiate", h.InitiateAuth)
-		etrade.POST("/auth/callback", h.AuthCallback)
-		etrade.GET("/accounts", h.GetAccounts)
-		etrade.POST("/accounts/link", h.LinkAccount)
+		etrade.POST("/orders/preview", h.PreviewOrder)
+		etrade.POST("/orders/place", h.PlaceOrder)
+		etrade.POST("/orders/cancel", h.CancelOrder)
+		etrade.GET("/orders", h.FindOrder)
+		etrade.GET("/orders/:orderId", h.GetOrder)
 	}
 }
 
-// InitiateAuth initiates the OAuth flow for E-Trade
-func (h *ETradeHandler) InitiateAuth(c *gin.Context) {
-	var req models.ETradeAuthRequest
+// respondToOrderError writes the response for a failed order request. Orders
+// E-Trade refused are 422 so callers can tell them from failures to reach it.
+func respondToOrderError(c *gin.Context, err error) {
+	var rejected *client.OrderRejectedError
+	if errors.As(err, &rejected) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// PreviewOrder previews an order in a linked E-Trade account
+func (h *ETradeHandler) PreviewOrder(c *gin.Context) {
+	var req struct {
+		UserID string                    `json:"user_id" binding:"required"`
+		Order  models.ETradeOrderRequest `json:"order" binding:"required"`
+	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -47,48 +65,74 @@ func (h *ETradeHandler) InitiateAuth(c *gin.Context) {
 		return
 	}
 
-	// Initiate the auth flow
-	resp, err := h.etradeService.InitiateAuth(req.UserID)
+	preview, err := h.etradeService.PreviewOrder(c.Request.Context(), req.UserID, req.Order)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondToOrderError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, preview)
 }
 
-// AuthCallback handles the callback from E-Trade after user authorization
-func (h *ETradeHandler) AuthCallback(c *gin.Context) {
-	var callback models.ETradeAuthCallback
-	if err := c.ShouldBindJSON(&callback); err != nil {
+// PlaceOrder places a previewed order in a linked E-Trade account
+func (h *ETradeHandler) PlaceOrder(c *gin.Context) {
+	var req struct {
+		UserID    string                    `json:"user_id" binding:"required"`
+		PreviewID string                    `json:"preview_id" binding:"required"`
+		Order     models.ETradeOrderRequest `json:"order" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Validate user ID
-	if _, err := uuid.Parse(callback.UserID); err != nil {
+	if _, err := uuid.Parse(req.UserID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	// Complete the auth flow
-	accessToken, err := h.etradeService.CompleteAuth(&callback)
+	placement, err := h.etradeService.PlaceOrder(c.Request.Context(), req.UserID, req.Order, req.PreviewID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondToOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, placement)
+}
+
+// CancelOrder cancels an open order in a linked E-Trade account
+func (h *ETradeHandler) CancelOrder(c *gin.Context) {
+	var req struct {
+		UserID    string `json:"user_id" binding:"required"`
+		AccountID string `json:"account_id" binding:"required"`
+		OrderID   string `json:"order_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":      true,
-		"access_token": accessToken,
-	})
+	// Validate user ID
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.etradeService.CancelOrder(c.Request.Context(), req.UserID, req.AccountID, req.OrderID); err != nil {
+		respondToOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// GetAccounts retrieves the list of accounts for the authenticated user
-func (h *ETradeHandler) GetAccounts(c *gin.Context) {
+// GetOrder retrieves the status of an order in a linked E-Trade account
+func (h *ETradeHandler) GetOrder(c *gin.Context) {
 	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+	accountID := c.Query("account_id")
+	if userID == "" || accountID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and account ID are required"})
 		return
 	}
 
@@ -98,38 +142,41 @@ func (h *ETradeHandler) GetAccounts(c *gin.Context) {
 		return
 	}
 
-	// Get the accounts
-	accounts, err := h.etradeService.GetAccounts(userID)
+	status, err := h.etradeService.GetOrder(c.Request.Context(), userID, accountID, c.Param("orderId"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondToOrderError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"accounts": accounts,
-	})
+	c.JSON(http.StatusOK, status)
 }
 
-// LinkAccount links an E-Trade account to a TrustAInvest account
-func (h *ETradeHandler) LinkAccount(c *gin.Context) {
-	var req models.ETradeAccountLinkRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// FindOrder retrieves the status of the order placed with ?client_order_id=
+// in a linked E-Trade account
+func (h *ETradeHandler) FindOrder(c *gin.Context) {
+	userID := c.Query("user_id")
+	accountID := c.Query("account_id")
+	clientOrderID := c.Query("client_order_id")
+	if userID == "" || accountID == "" || clientOrderID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID, account ID and client order ID are required"})
 		return
 	}
 
 	// Validate user ID
-	if _, err := uuid.Parse(req.UserID); err != nil {
+	if _, err := uuid.Parse(userID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	// Link the account
-	resp, err := h.etradeService.LinkAccount(&req)
+	status, err := h.etradeService.FindOrder(c.Request.Context(), userID, accountID, clientOrderID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondToOrderError(c, err)
+		return
+	}
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, status)
 }
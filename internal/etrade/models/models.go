@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 // ETradeCredentials represents the credentials needed to authenticate with E-Trade API
@@ -15,67 +17,79 @@ type ETradeCredentials struct {
 
 // ETradeAccount represents an E-Trade account
 type ETradeAccount struct {
-	AccountID        string           `json:"account_id"`
-	AccountName      string           `json:"account_name"`
-	AccountType      string           `json:"account_type"`
-	InstitutionID    string           `json:"institution_id"`
-	InstitutionName  string           `json:"institution_name"`
-	Balance          float64          `json:"balance"`
-	Currency         string           `json:"currency"`
-	LastUpdated      time.Time        `json:"last_updated"`
-	Status           string           `json:"status"`
-	AccountPositions []ETradePosition `json:"account_positions,omitempty"`
+	AccountID       string          `json:"account_id"`
+	AccountName     string          `json:"account_name"`
+	AccountType     string          `json:"account_type"`
+	InstitutionID   string          `json:"institution_id"`
+	InstitutionName string          `json:"institution_name"`
+	Balance         decimal.Decimal `json:"balance"`
+	Currency        string          `json:"currency"`
+	LastUpdated     time.Time       `json:"last_updated"`
+	Status          string          `json:"status"`
 }
 
 // ETradePosition represents a position in an E-Trade account
 type ETradePosition struct {
-	Symbol        string    `json:"symbol"`
-	Quantity      float64   `json:"quantity"`
-	CostBasis     float64   `json:"cost_basis"`
-	MarketValue   float64   `json:"market_value"`
-	GainLoss      float64   `json:"gain_loss"`
-	GainLossPerc  float64   `json:"gain_loss_perc"`
-	LastPrice     float64   `json:"last_price"`
-	LastPriceTime time.Time `json:"last_price_time"`
+	Symbol        string          `json:"symbol"`
+	Quantity      float64         `json:"quantity"`
+	CostBasis     decimal.Decimal `json:"cost_basis"`
+	MarketValue   decimal.Decimal `json:"market_value"`
+	GainLoss      decimal.Decimal `json:"gain_loss"`
+	GainLossPerc  float64         `json:"gain_loss_perc"`
+	LastPrice     float64         `json:"last_price"`
+	LastPriceTime time.Time       `json:"last_price_time"`
 }
 
-// ETradeAuthRequest represents a request to link an E-Trade account
-type ETradeAuthRequest struct {
-	UserID      string `json:"user_id" binding:"required"`
-	ConsumerKey string `json:"consumer_key" binding:"required"`
-	CallbackURL string `json:"callback_url" binding:"required"`
+// ETradeQuote represents a market quote from E-Trade
+type ETradeQuote struct {
+	Symbol       string    `json:"symbol"`
+	SecurityType string    `json:"security_type"`
+	LastPrice    float64   `json:"last_price"`
+	QuoteStatus  string    `json:"quote_status"`
+	QuoteTime    time.Time `json:"quote_time"`
 }
 
-// ETradeAuthResponse represents a response from the E-Trade auth process
-type ETradeAuthResponse struct {
-	RequestToken string `json:"request_token"`
-	AuthURL      string `json:"auth_url"`
+// ETradeError represents an error from the E-Trade API
+type ETradeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
-// ETradeAuthCallback represents the callback data from E-Trade after user authorization
-type ETradeAuthCallback struct {
-	RequestToken string `json:"request_token"`
-	Verifier     string `json:"verifier"`
-	UserID       string `json:"user_id"`
+// ETradeOrderRequest describes an equity or ETF order to preview or place
+type ETradeOrderRequest struct {
+	AccountID     string  `json:"account_id" binding:"required"`
+	ClientOrderID string  `json:"client_order_id" binding:"required"`
+	Symbol        string  `json:"symbol" binding:"required"`
+	OrderAction   string  `json:"order_action" binding:"required"`
+	Quantity      float64 `json:"quantity" binding:"required"`
+	PriceType     string  `json:"price_type" binding:"required"`
+	LimitPrice    float64 `json:"limit_price,omitempty"`
+	StopPrice     float64 `json:"stop_price,omitempty"`
+	OrderTerm     string  `json:"order_term,omitempty"`
 }
 
-// ETradeAccountLinkRequest represents a request to link an E-Trade account to a TrustAInvest account
-type ETradeAccountLinkRequest struct {
-	UserID      string `json:"user_id" binding:"required"`
-	AccountID   string `json:"account_id" binding:"required"`
-	AccountName string `json:"account_name,omitempty"`
+// ETradeOrderPreview is E-Trade's estimate for an order before it is placed
+type ETradeOrderPreview struct {
+	PreviewID            string   `json:"preview_id"`
+	EstimatedTotalAmount float64  `json:"estimated_total_amount"`
+	EstimatedCommission  float64  `json:"estimated_commission"`
+	Messages             []string `json:"messages,omitempty"`
 }
 
-// ETradeAccountLinkResponse represents a response after linking an E-Trade account
-type ETradeAccountLinkResponse struct {
-	Success    bool   `json:"success"`
-	Message    string `json:"message,omitempty"`
-	AccountID  string `json:"account_id,omitempty"`
-	InternalID string `json:"internal_id,omitempty"`
+// ETradeOrderPlacement is E-Trade's response to a placed order
+type ETradeOrderPlacement struct {
+	OrderID    string    `json:"order_id"`
+	PlacedTime time.Time `json:"placed_time"`
+	Messages   []string  `json:"messages,omitempty"`
 }
 
-// ETradeError represents an error from the E-Trade API
-type ETradeError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+// ETradeOrderStatus is the current state of a placed order
+type ETradeOrderStatus struct {
+	OrderID               string    `json:"order_id"`
+	Status                string    `json:"status"`
+	OrderedQuantity       float64   `json:"ordered_quantity"`
+	FilledQuantity        float64   `json:"filled_quantity"`
+	AverageExecutionPrice float64   `json:"average_execution_price"`
+	PlacedTime            time.Time `json:"placed_time"`
+	ExecutedTime          time.Time `json:"executed_time,omitempty"`
 }
@@ -2,153 +2,274 @@ package service
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"log"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/accountsync"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/aggregation"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/client"
 	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/models"
 )
 
-// ETradeService handles the business logic for E-Trade integration
+// institutionID is the institution_id of linked E-Trade accounts
+const institutionID = "etrade"
+
+// ETradeService connects users to E-Trade. It is the aggregation provider for
+// E-Trade and also routes orders to linked E-Trade accounts.
 type ETradeService struct {
-	db           *pgxpool.Pool
-	etradeClient *client.ETradeClient
-	callbackURL  string
+	db             *pgxpool.Pool
+	callbackURL    string
+	consumerKey    string
+	consumerSecret string
+	useSandbox     bool
 }
 
 // NewETradeService creates a new E-Trade service
 func NewETradeService(db *pgxpool.Pool, consumerKey, consumerSecret, callbackURL string, useSandbox bool) *ETradeService {
 	return &ETradeService{
-		db:           db,
-		etradeClient: client.NewETradeClient(consumerKey, consumerSecret, useSandbox),
-		callbackURL:  callbackURL,
+		db:             db,
+		callbackURL:    callbackURL,
+		consumerKey:    consumerKey,
+		consumerSecret: consumerSecret,
+		useSandbox:     useSandbox,
 	}
 }
 
-// InitiateAuth starts the OAuth flow for E-Trade
-func (s *ETradeService) InitiateAuth(userID string) (*models.ETradeAuthResponse, error) {
+// ID returns the institution ID linked E-Trade accounts are stored under
+func (s *ETradeService) ID() string {
+	return institutionID
+}
+
+// Name returns the institution's display name
+func (s *ETradeService) Name() string {
+	return "E-Trade"
+}
+
+// StartAuth starts the OAuth flow for E-Trade. The state is the request
+// token.
+func (s *ETradeService) StartAuth(ctx context.Context, userID string) (*aggregation.AuthStart, error) {
 	// Generate the authorization URL
-	requestToken, authURL, err := s.etradeClient.GetAuthorizationURL(s.callbackURL)
+	requestToken, authURL, err := s.newClient().GetAuthorizationURL(s.callbackURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get authorization URL: %w", err)
 	}
 
 	// Store the request token in the database
-	err = s.storeRequestToken(userID, requestToken)
-	if err != nil {
+	if err := s.storeRequestToken(ctx, userID, requestToken); err != nil {
 		return nil, fmt.Errorf("failed to store request token: %w", err)
 	}
 
-	return &models.ETradeAuthResponse{
-		RequestToken: requestToken,
-		AuthURL:      authURL,
+	return &aggregation.AuthStart{
+		AuthURL: authURL,
+		State:   requestToken,
 	}, nil
 }
 
-// CompleteAuth completes the OAuth flow for E-Trade
-func (s *ETradeService) CompleteAuth(callback *models.ETradeAuthCallback) (string, error) {
+// CompleteAuth exchanges the request token and verifier for an access token
+func (s *ETradeService) CompleteAuth(ctx context.Context, callback aggregation.AuthCallback) error {
 	// Verify the request token
-	storedToken, err := s.getRequestToken(callback.UserID)
+	storedToken, err := s.getRequestToken(ctx, callback.UserID)
+	if err == pgx.ErrNoRows {
+		return aggregation.ErrAuthMismatch
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to get stored request token: %w", err)
+		return fmt.Errorf("failed to get stored request token: %w", err)
 	}
-
-	if storedToken != callback.RequestToken {
-		return "", errors.New("request token mismatch")
+	if storedToken != callback.State {
+		return aggregation.ErrAuthMismatch
 	}
 
 	// Exchange the request token for an access token
-	accessToken, tokenSecret, err := s.etradeClient.ExchangeRequestTokenForAccessToken(callback.RequestToken, callback.Verifier)
+	accessToken, tokenSecret, err := s.newClient().ExchangeRequestTokenForAccessToken(callback.State, callback.Code)
 	if err != nil {
-		return "", fmt.Errorf("failed to exchange request token: %w", err)
+		return fmt.Errorf("failed to exchange request token: %w", err)
 	}
 
 	// Store the access token in the database
-	err = s.storeAccessToken(callback.UserID, accessToken, tokenSecret)
+	if err := s.storeAccessToken(ctx, callback.UserID, accessToken, tokenSecret); err != nil {
+		return fmt.Errorf("failed to store access token: %w", err)
+	}
+	return nil
+}
+
+// Accounts retrieves the user's E-Trade accounts with their balances
+func (s *ETradeService) Accounts(ctx context.Context, userID string) ([]aggregation.Account, error) {
+	etradeClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := etradeClient.ListAccounts()
 	if err != nil {
-		return "", fmt.Errorf("failed to store access token: %w", err)
+		return nil, fmt.Errorf("failed to get accounts: %w", err)
 	}
 
-	return accessToken, nil
+	result := make([]aggregation.Account, 0, len(accounts))
+	for _, account := range accounts {
+		// Every E-Trade account is a brokerage account
+		result = append(result, aggregation.Account{
+			ExternalID:      account.AccountID,
+			Name:            account.AccountName,
+			Type:            "BROKERAGE",
+			Description:     "E-Trade brokerage account",
+			Balance:         account.Balance,
+			Currency:        account.Currency,
+			HoldsSecurities: true,
+		})
+	}
+	return result, nil
 }
 
-// GetAccounts retrieves the list of accounts for the authenticated user
-func (s *ETradeService) GetAccounts(userID string) ([]models.ETradeAccount, error) {
-	// Get the access token from the database
-	accessToken, tokenSecret, err := s.getAccessToken(userID)
+// Positions retrieves the positions held in one of the user's E-Trade accounts
+func (s *ETradeService) Positions(ctx context.Context, userID, accountID string) ([]accountsync.Position, error) {
+	etradeClient, err := s.userClient(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+		return nil, err
 	}
 
-	// Set the credentials in the client
-	s.etradeClient.SetCredentials(accessToken, tokenSecret)
-
-	// Get the accounts
-	accounts, err := s.etradeClient.GetAccounts()
+	positions, err := etradeClient.GetPositions(accountID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get accounts: %w", err)
+		return nil, fmt.Errorf("failed to get positions: %w", err)
+	}
+
+	result := make([]accountsync.Position, 0, len(positions))
+	for _, p := range positions {
+		result = append(result, accountsync.Position{
+			Symbol:      p.Symbol,
+			Quantity:    p.Quantity,
+			CostBasis:   p.CostBasis,
+			MarketValue: p.MarketValue,
+		})
 	}
+	return result, nil
+}
 
-	return accounts, nil
+// Transactions is not supported; the E-Trade client does not read
+// transaction history
+func (s *ETradeService) Transactions(ctx context.Context, userID, accountID string, since time.Time) ([]accountsync.Transaction, error) {
+	return nil, aggregation.ErrNotSupported
 }
 
-// LinkAccount links an E-Trade account to a TrustAInvest account
-func (s *ETradeService) LinkAccount(req *models.ETradeAccountLinkRequest) (*models.ETradeAccountLinkResponse, error) {
-	// Get the access token from the database
-	accessToken, tokenSecret, err := s.getAccessToken(req.UserID)
+// Refresh renews the user's access token after two hours without use
+func (s *ETradeService) Refresh(ctx context.Context, userID string) error {
+	etradeClient, err := s.userClient(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+		return err
+	}
+	if err := etradeClient.RenewAccessToken(); err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, `UPDATE etrade.auth_tokens SET updated_at = $1 WHERE user_id = $2`, time.Now(), userID)
+	return err
+}
+
+// Disconnect revokes the user's access token and removes the stored tokens.
+// Tokens E-Trade has already expired cannot be revoked and are just removed.
+func (s *ETradeService) Disconnect(ctx context.Context, userID string) error {
+	etradeClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := etradeClient.RevokeAccessToken(); err != nil {
+		log.Printf("Failed to revoke E-Trade access token for user %s: %v", userID, err)
 	}
 
-	// Set the credentials in the client
-	s.etradeClient.SetCredentials(accessToken, tokenSecret)
+	_, err = s.db.Exec(ctx, `DELETE FROM etrade.auth_tokens WHERE user_id = $1`, userID)
+	return err
+}
 
-	// Get the accounts to verify the account exists
-	accounts, err := s.etradeClient.GetAccounts()
+// Connections returns the users with an E-Trade access token
+func (s *ETradeService) Connections(ctx context.Context) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT user_id::text FROM etrade.auth_tokens WHERE access_token IS NOT NULL`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get accounts: %w", err)
+		return nil, fmt.Errorf("failed to retrieve E-Trade connections: %w", err)
 	}
+	defer rows.Close()
 
-	// Find the account
-	var account *models.ETradeAccount
-	for i, a := range accounts {
-		if a.AccountID == req.AccountID {
-			account = &accounts[i]
-			break
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
 		}
+		users = append(users, userID)
 	}
+	return users, rows.Err()
+}
 
-	if account == nil {
-		return nil, errors.New("account not found")
+// PreviewOrder previews an order in one of the user's E-Trade accounts
+func (s *ETradeService) PreviewOrder(ctx context.Context, userID string, req models.ETradeOrderRequest) (*models.ETradeOrderPreview, error) {
+	etradeClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
+	return etradeClient.PreviewOrder(req)
+}
 
-	// Use the provided account name if available, otherwise use the one from E-Trade
-	accountName := account.AccountName
-	if req.AccountName != "" {
-		accountName = req.AccountName
+// PlaceOrder places a previewed order in one of the user's E-Trade accounts
+func (s *ETradeService) PlaceOrder(ctx context.Context, userID string, req models.ETradeOrderRequest, previewID string) (*models.ETradeOrderPlacement, error) {
+	etradeClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
+	return etradeClient.PlaceOrder(req, previewID)
+}
 
-	// Create the account in the database
-	internalID, err := s.createAccountInDB(req.UserID, account, accountName)
+// CancelOrder cancels an open order in one of the user's E-Trade accounts
+func (s *ETradeService) CancelOrder(ctx context.Context, userID, accountID, orderID string) error {
+	etradeClient, err := s.userClient(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create account in database: %w", err)
+		return err
 	}
+	return etradeClient.CancelOrder(accountID, orderID)
+}
 
-	return &models.ETradeAccountLinkResponse{
-		Success:    true,
-		Message:    "Account linked successfully",
-		AccountID:  account.AccountID,
-		InternalID: internalID,
-	}, nil
+// GetOrder retrieves the status of an order in one of the user's E-Trade accounts
+func (s *ETradeService) GetOrder(ctx context.Context, userID, accountID, orderID string) (*models.ETradeOrderStatus, error) {
+	etradeClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return etradeClient.GetOrder(accountID, orderID)
+}
+
+// FindOrder retrieves the status of the order placed with a client order ID
+// in one of the user's E-Trade accounts, or nil when there is none
+func (s *ETradeService) FindOrder(ctx context.Context, userID, accountID, clientOrderID string) (*models.ETradeOrderStatus, error) {
+	etradeClient, err := s.userClient(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return etradeClient.FindOrder(accountID, clientOrderID)
+}
+
+// newClient creates an E-Trade client without user credentials. Each call
+// gets its own client so concurrent requests never share credentials.
+func (s *ETradeService) newClient() *client.ETradeClient {
+	return client.NewETradeClient(s.consumerKey, s.consumerSecret, s.useSandbox)
+}
+
+// userClient creates an E-Trade client with the user's access token
+func (s *ETradeService) userClient(ctx context.Context, userID string) (*client.ETradeClient, error) {
+	accessToken, tokenSecret, err := s.getAccessToken(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	if accessToken == "" || tokenSecret == "" {
+		return nil, aggregation.ErrNotConnected
+	}
+
+	etradeClient := s.newClient()
+	etradeClient.SetCredentials(accessToken, tokenSecret)
+	return etradeClient, nil
 }
 
 // storeRequestToken stores the request token in the database
-func (s *ETradeService) storeRequestToken(userID, requestToken string) error {
-	ctx := context.Background()
+func (s *ETradeService) storeRequestToken(ctx context.Context, userID, requestToken string) error {
 	_, err := s.db.Exec(
 		ctx,
 		`INSERT INTO etrade.auth_tokens (user_id, request_token, created_at)
@@ -161,20 +282,18 @@ func (s *ETradeService) storeRequestToken(userID, requestToken string) error {
 }
 
 // getRequestToken retrieves the request token from the database
-func (s *ETradeService) getRequestToken(userID string) (string, error) {
-	ctx := context.Background()
+func (s *ETradeService) getRequestToken(ctx context.Context, userID string) (string, error) {
 	var requestToken string
 	err := s.db.QueryRow(
 		ctx,
-		`SELECT request_token FROM etrade.auth_tokens WHERE user_id = $1`,
+		`SELECT COALESCE(request_token, '') FROM etrade.auth_tokens WHERE user_id = $1`,
 		userID,
 	).Scan(&requestToken)
 	return requestToken, err
 }
 
 // storeAccessToken stores the access token in the database
-func (s *ETradeService) storeAccessToken(userID, accessToken, tokenSecret string) error {
-	ctx := context.Background()
+func (s *ETradeService) storeAccessToken(ctx context.Context, userID, accessToken, tokenSecret string) error {
 	_, err := s.db.Exec(
 		ctx,
 		`UPDATE etrade.auth_tokens
@@ -185,38 +304,17 @@ func (s *ETradeService) storeAccessToken(userID, accessToken, tokenSecret string
 	return err
 }
 
-// getAccessToken retrieves the access token from the database
-func (s *ETradeService) getAccessToken(userID string) (string, string, error) {
-	ctx := context.Background()
+// getAccessToken retrieves the access token from the database. Users who
+// have not completed authorization have empty tokens.
+func (s *ETradeService) getAccessToken(ctx context.Context, userID string) (string, string, error) {
 	var accessToken, tokenSecret string
 	err := s.db.QueryRow(
 		ctx,
-		`SELECT access_token, token_secret FROM etrade.auth_tokens WHERE user_id = $1`,
+		`SELECT COALESCE(access_token, ''), COALESCE(token_secret, '') FROM etrade.auth_tokens WHERE user_id = $1`,
 		userID,
 	).Scan(&accessToken, &tokenSecret)
-	return accessToken, tokenSecret, err
-}
-
-// createAccountInDB creates an account in the database
-func (s *ETradeService) createAccountInDB(userID string, account *models.ETradeAccount, accountName string) (string, error) {
-	ctx := context.Background()
-	id := uuid.New().String()
-
-	_, err := s.db.Exec(
-		ctx,
-		`INSERT INTO accounts.accounts (
-			id, user_id, type, name, description, institution_id, institution_name,
-			external_account_id, balance_amount, balance_currency, is_active
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
-		)`,
-		id, userID, "BROKERAGE", accountName, "E-Trade brokerage account",
-		"etrade", "E-Trade", account.AccountID, account.Balance, account.Currency, true,
-	)
-
-	if err != nil {
-		return "", err
+	if err == pgx.ErrNoRows {
+		return "", "", nil
 	}
-
-	return id, nil
+	return accessToken, tokenSecret, err
 }
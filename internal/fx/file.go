@@ -0,0 +1,121 @@
+package fx
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// FileSource reads daily rates from a CSV file with a header row naming the
+// date, base, quote and rate columns. Each rate is the price of one unit of
+// base in quote:
+//
+//	date,base,quote,rate
+//	2024-05-01,EUR,USD,1.0666
+//	2024-05-01,GBP,USD,1.2488
+//
+// The file is read on every call, so replacing it takes effect on the next
+// refresh.
+type FileSource struct {
+	path string
+}
+
+// NewFileSource creates a source that reads rates from path
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+// Name returns the source name
+func (s *FileSource) Name() string {
+	return "file"
+}
+
+// Rates returns the rates in the file dated from from to to
+func (s *FileSource) Rates(ctx context.Context, from, to time.Time) ([]Rate, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exchange rate file: %w", err)
+	}
+	defer f.Close()
+
+	all, err := ParseCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	from, to = Day(from), Day(to)
+	var rates []Rate
+	for _, r := range all {
+		if !r.Date.Before(from) && !r.Date.After(to) {
+			rates = append(rates, r)
+		}
+	}
+	return rates, nil
+}
+
+// ParseCSV reads rates in the FileSource format
+func ParseCSV(r io.Reader) ([]Rate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "base", "quote", "rate"} {
+		if _, ok := columns[required]; !ok {
+			return nil, errors.New("missing " + required + " column")
+		}
+	}
+
+	var rates []Rate
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		date, err := time.Parse("2006-01-02", field("date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q, expected YYYY-MM-DD", line, field("date"))
+		}
+		base, quote := NormalizeCurrency(field("base")), NormalizeCurrency(field("quote"))
+		if !ValidCurrency(base) || !ValidCurrency(quote) || base == quote {
+			return nil, fmt.Errorf("line %d: invalid currency pair %q/%q", line, field("base"), field("quote"))
+		}
+		rate, err := decimal.NewFromString(field("rate"))
+		if err != nil || !rate.IsPositive() {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, field("rate"))
+		}
+
+		rates = append(rates, Rate{Base: base, Quote: quote, Date: date, Rate: rate})
+	}
+	return rates, nil
+}
@@ -0,0 +1,171 @@
+// Package fx converts money between currencies at historical daily rates.
+//
+// A Source supplies daily rates; FileSource reads them from a CSV file so
+// rates can be loaded without network access. A Table holds the rates a
+// conversion may use and looks up the rate for a currency pair as of a
+// valuation date: the latest rate on or before that date, read directly,
+// inverted, or crossed through US dollars when no rate names both
+// currencies.
+package fx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// DefaultCurrency is the reporting currency of users and trusts that have
+// not chosen one, and the currency cross rates are taken through
+const DefaultCurrency = "USD"
+
+// Rate is the price of one unit of Base in Quote on a day
+type Rate struct {
+	Base  string          `json:"base"`
+	Quote string          `json:"quote"`
+	Date  time.Time       `json:"date"`
+	Rate  decimal.Decimal `json:"rate"`
+}
+
+// Source supplies daily exchange rates
+type Source interface {
+	// Name identifies the source in logs and stored rates
+	Name() string
+
+	// Rates returns the rates the source has dated from from to to,
+	// inclusive
+	Rates(ctx context.Context, from, to time.Time) ([]Rate, error)
+}
+
+// MissingRateError is returned when no rate converts between two currencies
+// as of a date
+type MissingRateError struct {
+	From string
+	To   string
+	Date time.Time
+}
+
+func (e *MissingRateError) Error() string {
+	return fmt.Sprintf("no %s/%s exchange rate on or before %s", e.From, e.To, e.Date.Format("2006-01-02"))
+}
+
+// NormalizeCurrency returns the form currency codes are stored in
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// ValidCurrency reports whether code is a three letter currency code
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Table holds daily rates for conversions
+type Table struct {
+	// rates are keyed by base and quote and sorted by date
+	rates map[[2]string][]Rate
+}
+
+// NewTable creates a table holding rates
+func NewTable(rates []Rate) *Table {
+	t := &Table{rates: make(map[[2]string][]Rate)}
+	for _, r := range rates {
+		t.Add(r)
+	}
+	return t
+}
+
+// Add adds a rate to the table, replacing any rate for the same pair and day
+func (t *Table) Add(r Rate) {
+	r.Base, r.Quote = NormalizeCurrency(r.Base), NormalizeCurrency(r.Quote)
+	r.Date = Day(r.Date)
+	key := [2]string{r.Base, r.Quote}
+
+	series := t.rates[key]
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Date.Before(r.Date) })
+	if i < len(series) && series[i].Date.Equal(r.Date) {
+		series[i] = r
+		return
+	}
+	series = append(series, Rate{})
+	copy(series[i+1:], series[i:])
+	series[i] = r
+	t.rates[key] = series
+}
+
+// Lookup returns how many units of to one unit of from buys as of date,
+// with the date of the oldest rate used
+func (t *Table) Lookup(from, to string, date time.Time) (decimal.Decimal, time.Time, error) {
+	from, to = NormalizeCurrency(from), NormalizeCurrency(to)
+	date = Day(date)
+	if from == to {
+		return decimal.NewFromInt(1), date, nil
+	}
+
+	if rate, rateDate, ok := t.direct(from, to, date); ok {
+		return rate, rateDate, nil
+	}
+	if from != DefaultCurrency && to != DefaultCurrency {
+		fromRate, fromDate, okFrom := t.direct(from, DefaultCurrency, date)
+		toRate, toDate, okTo := t.direct(DefaultCurrency, to, date)
+		if okFrom && okTo {
+			if toDate.Before(fromDate) {
+				fromDate = toDate
+			}
+			return fromRate.Mul(toRate), fromDate, nil
+		}
+	}
+	return decimal.Zero, time.Time{}, &MissingRateError{From: from, To: to, Date: date}
+}
+
+// Convert returns m in currency to at the rate as of date, rounded to the
+// minor unit of to. Zero converts without a rate.
+func (t *Table) Convert(m money.Money, to string, date time.Time) (money.Money, error) {
+	if m.IsZero() {
+		return money.Zero(to), nil
+	}
+	rate, _, err := t.Lookup(m.Currency, to, date)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.New(m.Amount.Mul(rate), to), nil
+}
+
+// direct returns the rate for a pair as of date from a rate quoted either way
+// round, preferring the more recent of the two
+func (t *Table) direct(from, to string, date time.Time) (decimal.Decimal, time.Time, bool) {
+	rate, rateDate, ok := t.latest(from, to, date)
+	inverse, inverseDate, okInverse := t.latest(to, from, date)
+	if okInverse && (!ok || inverseDate.After(rateDate)) {
+		return decimal.NewFromInt(1).DivRound(inverse, 12), inverseDate, true
+	}
+	return rate, rateDate, ok
+}
+
+// latest returns the last rate for base in quote on or before date, so
+// valuations on weekends and holidays use the last published rate
+func (t *Table) latest(base, quote string, date time.Time) (decimal.Decimal, time.Time, bool) {
+	series := t.rates[[2]string{base, quote}]
+	i := sort.Search(len(series), func(i int) bool { return series[i].Date.After(date) })
+	if i == 0 {
+		return decimal.Zero, time.Time{}, false
+	}
+	return series[i-1].Rate, series[i-1].Date, true
+}
+
+// Day truncates t to midnight UTC on its calendar date
+func Day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,436 @@
+// Package corpactions models corporate actions and how they adjust the tax
+// lots of the positions they affect.
+//
+// Splits and stock dividends multiply the shares in each lot and divide its
+// unit cost, so a lot keeps its cost basis and acquisition date. A cash
+// dividend pays each holder per share held on the ex-date. A symbol change
+// renames the asset and leaves lots alone. A merger converts each lot into
+// shares of the acquirer at a ratio and may pay cash per share; the part of
+// the basis that belongs to the cash is treated as sold.
+package corpactions
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Corporate action types
+const (
+	TypeSplit         = "SPLIT"
+	TypeCashDividend  = "CASH_DIVIDEND"
+	TypeStockDividend = "STOCK_DIVIDEND"
+	TypeSymbolChange  = "SYMBOL_CHANGE"
+	TypeMerger        = "MERGER"
+)
+
+// Types lists the supported corporate action types
+var Types = []string{TypeSplit, TypeCashDividend, TypeStockDividend, TypeSymbolChange, TypeMerger}
+
+// dateLayout is the layout of action dates
+const dateLayout = "2006-01-02"
+
+// Action is a corporate action affecting holders of one symbol.
+//
+// Ratio means new shares per old share for a split (4 for a 4-for-1 split,
+// 0.1 for a 1-for-10 reverse split) and a merger (acquirer shares per
+// share), and additional shares per share held for a stock dividend (0.05
+// for 5%). CashPerShare is the dividend for a cash dividend and the cash
+// paid per share in a merger. A merger paying both stock and cash needs
+// NewPrice, the acquirer's share price on the effective date, to split the
+// basis between them.
+//
+// EffectiveDate is the ex-date of a dividend and the date a split, symbol
+// change or merger takes effect. PayDate is when a cash dividend is paid
+// and defaults to the effective date.
+type Action struct {
+	Type          string          `json:"type"`
+	Symbol        string          `json:"symbol"`
+	EffectiveDate time.Time       `json:"effective_date"`
+	PayDate       *time.Time      `json:"pay_date,omitempty"`
+	Ratio         float64         `json:"ratio,omitempty"`
+	CashPerShare  decimal.Decimal `json:"cash_per_share"`
+	NewSymbol     string          `json:"new_symbol,omitempty"`
+	NewName       string          `json:"new_name,omitempty"`
+	NewPrice      decimal.Decimal `json:"new_price"`
+	Description   string          `json:"description,omitempty"`
+}
+
+// ValidationError lists every problem found in a corporate action
+type ValidationError struct {
+	Line     int      `json:"line,omitempty"`
+	Problems []string `json:"problems"`
+}
+
+func (e *ValidationError) Error() string {
+	msg := "invalid corporate action: " + strings.Join(e.Problems, "; ")
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	return msg
+}
+
+// Normalize upper-cases the action's codes and symbols and truncates its
+// dates to the day
+func (a *Action) Normalize() {
+	a.Type = strings.ToUpper(strings.TrimSpace(a.Type))
+	a.Symbol = strings.ToUpper(strings.TrimSpace(a.Symbol))
+	a.NewSymbol = strings.ToUpper(strings.TrimSpace(a.NewSymbol))
+	a.NewName = strings.TrimSpace(a.NewName)
+	a.Description = strings.TrimSpace(a.Description)
+	a.EffectiveDate = day(a.EffectiveDate)
+	if a.PayDate != nil {
+		pay := day(*a.PayDate)
+		a.PayDate = &pay
+	}
+}
+
+// Validate checks the action carries the terms its type needs and nothing
+// it does not. Actions may not take effect after asOf.
+func (a Action) Validate(asOf time.Time) error {
+	var problems []string
+
+	if a.Symbol == "" {
+		problems = append(problems, "symbol is required")
+	}
+	if a.EffectiveDate.IsZero() {
+		problems = append(problems, "effective_date is required")
+	} else if a.EffectiveDate.After(asOf) {
+		problems = append(problems, "effective_date cannot be in the future")
+	}
+	if a.PayDate != nil && a.Type != TypeCashDividend {
+		problems = append(problems, "pay_date only applies to cash dividends")
+	}
+	if a.PayDate != nil && a.PayDate.Before(a.EffectiveDate) {
+		problems = append(problems, "pay_date cannot be before effective_date")
+	}
+	if a.Ratio < 0 || a.CashPerShare.IsNegative() || a.NewPrice.IsNegative() {
+		problems = append(problems, "ratio, cash_per_share and new_price cannot be negative")
+	}
+
+	switch a.Type {
+	case TypeSplit, TypeStockDividend:
+		if a.Ratio <= 0 {
+			problems = append(problems, "ratio is required")
+		}
+		if a.Type == TypeSplit && a.Ratio == 1 {
+			problems = append(problems, "a split ratio of 1 changes nothing")
+		}
+		if !a.CashPerShare.IsZero() || a.NewSymbol != "" || !a.NewPrice.IsZero() {
+			problems = append(problems, strings.ToLower(a.Type)+" takes only a ratio")
+		}
+	case TypeCashDividend:
+		if !a.CashPerShare.IsPositive() {
+			problems = append(problems, "cash_per_share is required")
+		}
+		if a.Ratio != 0 || a.NewSymbol != "" || !a.NewPrice.IsZero() {
+			problems = append(problems, "cash dividends take only cash_per_share")
+		}
+	case TypeSymbolChange:
+		if a.NewSymbol == "" {
+			problems = append(problems, "new_symbol is required")
+		} else if a.NewSymbol == a.Symbol {
+			problems = append(problems, "new_symbol must differ from symbol")
+		}
+		if a.Ratio != 0 || !a.CashPerShare.IsZero() || !a.NewPrice.IsZero() {
+			problems = append(problems, "symbol changes take only new_symbol and new_name")
+		}
+	case TypeMerger:
+		if a.Ratio == 0 && a.CashPerShare.IsZero() {
+			problems = append(problems, "mergers need a ratio, cash_per_share or both")
+		}
+		if a.Ratio > 0 && a.NewSymbol == "" {
+			problems = append(problems, "new_symbol is required when shares are converted")
+		}
+		if a.Ratio > 0 && a.NewSymbol == a.Symbol {
+			problems = append(problems, "new_symbol must differ from symbol")
+		}
+		if a.Ratio > 0 && a.CashPerShare.IsPositive() && !a.NewPrice.IsPositive() {
+			problems = append(problems, "new_price is required when a merger pays stock and cash")
+		}
+	default:
+		problems = append(problems, "type must be one of "+strings.Join(Types, ", "))
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ShareFactor returns what the action multiplies each held share by. It is
+// 1 for actions that do not change share counts.
+func (a Action) ShareFactor() float64 {
+	switch a.Type {
+	case TypeSplit, TypeMerger:
+		return a.Ratio
+	case TypeStockDividend:
+		return 1 + a.Ratio
+	default:
+		return 1
+	}
+}
+
+// StockFraction returns the fraction of a merger's value paid in acquirer
+// shares. The same fraction of each lot's basis carries over to the new
+// shares; the rest is relieved against the cash.
+func (a Action) StockFraction() float64 {
+	switch {
+	case a.Type != TypeMerger || a.CashPerShare.IsZero():
+		return 1
+	case a.Ratio == 0:
+		return 0
+	}
+	stock := a.NewPrice.Mul(decimal.NewFromFloat(a.Ratio))
+	fraction, _ := stock.Div(stock.Add(a.CashPerShare)).Float64()
+	return fraction
+}
+
+// PaymentDate returns the date cash from the action is paid
+func (a Action) PaymentDate() time.Time {
+	if a.PayDate != nil {
+		return *a.PayDate
+	}
+	return a.EffectiveDate
+}
+
+// Summary describes the action in a line suitable for ledger notes
+func (a Action) Summary() string {
+	switch a.Type {
+	case TypeSplit:
+		return fmt.Sprintf("%s split %s", a.Symbol, FormatRatio(a.Ratio))
+	case TypeStockDividend:
+		return fmt.Sprintf("%s stock dividend of %g shares per share", a.Symbol, a.Ratio)
+	case TypeCashDividend:
+		return fmt.Sprintf("%s cash dividend of %s per share", a.Symbol, a.CashPerShare)
+	case TypeSymbolChange:
+		return fmt.Sprintf("%s renamed %s", a.Symbol, a.NewSymbol)
+	case TypeMerger:
+		var terms []string
+		if a.Ratio > 0 {
+			terms = append(terms, fmt.Sprintf("%g %s", a.Ratio, a.NewSymbol))
+		}
+		if a.CashPerShare.IsPositive() {
+			terms = append(terms, fmt.Sprintf("%s cash", a.CashPerShare))
+		}
+		return fmt.Sprintf("%s merger for %s per share", a.Symbol, strings.Join(terms, " and "))
+	default:
+		return a.Type
+	}
+}
+
+// Lot is the part of a tax lot a corporate action adjusts
+type Lot struct {
+	Quantity          float64
+	RemainingQuantity float64
+	UnitCost          decimal.Decimal
+}
+
+// Scale multiplies a lot's shares by factor and divides its unit cost so
+// the basis of the remaining shares is unchanged
+func (l Lot) Scale(factor float64) Lot {
+	return Lot{
+		Quantity:          l.Quantity * factor,
+		RemainingQuantity: l.RemainingQuantity * factor,
+		UnitCost:          l.UnitCost.Div(decimal.NewFromFloat(factor)),
+	}
+}
+
+// ParseRatio reads a ratio written as a decimal ("0.05") or as new for old
+// shares ("4:1", "1:10" or "3/2")
+func ParseRatio(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	if i := strings.IndexAny(s, ":/"); i >= 0 {
+		to, errTo := strconv.ParseFloat(strings.TrimSpace(s[:i]), 64)
+		from, errFrom := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+		if errTo != nil || errFrom != nil || to <= 0 || from <= 0 {
+			return 0, fmt.Errorf("invalid ratio %q", s)
+		}
+		return to / from, nil
+	}
+
+	ratio, err := strconv.ParseFloat(s, 64)
+	if err != nil || ratio < 0 {
+		return 0, fmt.Errorf("invalid ratio %q", s)
+	}
+	return ratio, nil
+}
+
+// FormatRatio writes a ratio as new for old shares where it is a whole
+// number either way round
+func FormatRatio(ratio float64) string {
+	switch {
+	case ratio >= 1 && ratio == math.Trunc(ratio):
+		return fmt.Sprintf("%g:1", ratio)
+	case ratio > 0 && ratio < 1 && math.Abs(1/ratio-math.Round(1/ratio)) < 1e-9:
+		return fmt.Sprintf("1:%g", math.Round(1/ratio))
+	default:
+		return fmt.Sprintf("%g", ratio)
+	}
+}
+
+// ParseCSV reads corporate actions from CSV with a header row. The type,
+// symbol and effective_date columns are required; ratio, cash_per_share,
+// new_symbol, new_name, new_price, pay_date and description are optional:
+//
+//	type,symbol,effective_date,ratio,cash_per_share,new_symbol
+//	SPLIT,AAPL,2020-08-31,4:1,,
+//	CASH_DIVIDEND,VTI,2024-03-22,,0.9305,
+//	SYMBOL_CHANGE,FB,2022-06-09,,,META
+//
+// Every row is normalized and validated against asOf. Rows with problems
+// are reported together as ValidationErrors carrying their line numbers.
+// The actions are returned in effective date order, keeping file order
+// for actions on the same day.
+func ParseCSV(r io.Reader, asOf time.Time) ([]Action, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"type", "symbol", "effective_date"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	var actions []Action
+	var invalid ValidationErrors
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		action, problems := parseRecord(field)
+		if len(problems) == 0 {
+			action.Normalize()
+			if err := action.Validate(asOf); err != nil {
+				problems = err.(*ValidationError).Problems
+			}
+		}
+		if len(problems) > 0 {
+			invalid = append(invalid, &ValidationError{Line: line, Problems: problems})
+			continue
+		}
+		actions = append(actions, action)
+	}
+
+	if len(invalid) > 0 {
+		return nil, invalid
+	}
+	if len(actions) == 0 {
+		return nil, errors.New("file has no corporate actions")
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].EffectiveDate.Before(actions[j].EffectiveDate)
+	})
+	return actions, nil
+}
+
+// parseRecord reads an action's fields from a CSV row
+func parseRecord(field func(string) string) (Action, []string) {
+	var problems []string
+	action := Action{
+		Type:        field("type"),
+		Symbol:      field("symbol"),
+		NewSymbol:   field("new_symbol"),
+		NewName:     field("new_name"),
+		Description: field("description"),
+	}
+
+	if value := field("effective_date"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid effective_date %q, expected YYYY-MM-DD", value))
+		}
+		action.EffectiveDate = date
+	}
+	if value := field("pay_date"); value != "" {
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pay_date %q, expected YYYY-MM-DD", value))
+		}
+		action.PayDate = &date
+	}
+
+	ratio, err := ParseRatio(field("ratio"))
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	action.Ratio = ratio
+
+	for _, amount := range []struct {
+		name string
+		dest *decimal.Decimal
+	}{
+		{"cash_per_share", &action.CashPerShare},
+		{"new_price", &action.NewPrice},
+	} {
+		value := field(amount.name)
+		if value == "" {
+			continue
+		}
+		n, err := decimal.NewFromString(strings.TrimPrefix(value, "$"))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s %q", amount.name, value))
+		}
+		*amount.dest = n
+	}
+
+	return action, problems
+}
+
+// ValidationErrors is returned when several corporate actions are invalid
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// day truncates t to midnight UTC on its calendar date
+func day(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,521 @@
+// Package csvimport reads positions and transactions from the CSV files
+// brokers export, whose columns are named and ordered however the broker
+// chose.
+//
+// A Mapping names the file's column for each field and, for transaction
+// files, the values the file uses for each transaction type. A positions
+// file lists holdings, each read as a purchase at its unit cost; a
+// transactions file lists buys, sells, dividends and fees. Every row is
+// checked on its own, so all the problems in a file are reported together
+// with their line numbers.
+package csvimport
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Kinds of import file
+const (
+	KindPositions    = "POSITIONS"
+	KindTransactions = "TRANSACTIONS"
+)
+
+// Transaction types rows are read as, as recorded in the ledger
+const (
+	TypeBuy      = "BUY"
+	TypeSell     = "SELL"
+	TypeDividend = "DIVIDEND"
+	TypeFee      = "FEE"
+)
+
+// Fields a mapping can name a column for
+const (
+	FieldSymbol     = "symbol"
+	FieldName       = "name"
+	FieldAssetClass = "asset_class"
+	FieldCurrency   = "currency"
+	FieldDate       = "date"
+	FieldType       = "type"
+	FieldQuantity   = "quantity"
+	FieldPrice      = "price"
+	FieldCostBasis  = "cost_basis"
+	FieldFees       = "fees"
+	FieldAmount     = "amount"
+	FieldNotes      = "notes"
+)
+
+// Fields lists the fields a mapping can name a column for
+var Fields = []string{
+	FieldSymbol, FieldName, FieldAssetClass, FieldCurrency, FieldDate, FieldType,
+	FieldQuantity, FieldPrice, FieldCostBasis, FieldFees, FieldAmount, FieldNotes,
+}
+
+// PricePlaces is the number of decimal places a price derived from a cost
+// basis or an amount is rounded to, as the ledger stores prices
+const PricePlaces = 6
+
+// DefaultDateFormat is the date format of mappings that do not set one
+const DefaultDateFormat = "YYYY-MM-DD"
+
+// dateFormats are the layouts of the date formats a mapping can use. Days
+// and months may be written with one digit where separators are used.
+var dateFormats = map[string]string{
+	"YYYY-MM-DD": "2006-01-02",
+	"YYYY/MM/DD": "2006/1/2",
+	"MM/DD/YYYY": "1/2/2006",
+	"DD/MM/YYYY": "2/1/2006",
+	"DD.MM.YYYY": "2.1.2006",
+	"MM-DD-YYYY": "1-2-2006",
+	"YYYYMMDD":   "20060102",
+}
+
+// defaultTypes are the transaction type values recognized in every file
+var defaultTypes = map[string]string{
+	"BUY":        TypeBuy,
+	"BOUGHT":     TypeBuy,
+	"PURCHASE":   TypeBuy,
+	"SELL":       TypeSell,
+	"SOLD":       TypeSell,
+	"SALE":       TypeSell,
+	"DIVIDEND":   TypeDividend,
+	"DIV":        TypeDividend,
+	"FEE":        TypeFee,
+	"COMMISSION": TypeFee,
+}
+
+// Mapping describes the layout of one kind of import file.
+//
+// Columns maps fields to the header of the column holding them; fields
+// left out are read from a column named after the field, and headers are
+// matched ignoring case. Types maps the values of the type column to
+// transaction types, adding to or overriding the usual values such as
+// "Bought" and "Sold". DateFormat is one of the formats listed by
+// DateFormats.
+type Mapping struct {
+	Kind       string            `json:"kind"`
+	Columns    map[string]string `json:"columns,omitempty"`
+	DateFormat string            `json:"date_format,omitempty"`
+	Types      map[string]string `json:"types,omitempty"`
+}
+
+// DateFormats lists the date formats a mapping can use
+func DateFormats() []string {
+	formats := make([]string, 0, len(dateFormats))
+	for format := range dateFormats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Normalize upper-cases the mapping's kind, date format and types, lower-cases
+// its fields and trims every value
+func (m *Mapping) Normalize() {
+	m.Kind = strings.ToUpper(strings.TrimSpace(m.Kind))
+	m.DateFormat = strings.ToUpper(strings.TrimSpace(m.DateFormat))
+	if m.DateFormat == "" {
+		m.DateFormat = DefaultDateFormat
+	}
+
+	columns := make(map[string]string, len(m.Columns))
+	for field, header := range m.Columns {
+		columns[strings.ToLower(strings.TrimSpace(field))] = strings.TrimSpace(header)
+	}
+	m.Columns = columns
+
+	types := make(map[string]string, len(m.Types))
+	for value, t := range m.Types {
+		types[strings.ToUpper(strings.TrimSpace(value))] = strings.ToUpper(strings.TrimSpace(t))
+	}
+	m.Types = types
+}
+
+// Validate checks the mapping names a kind, known fields, a known date
+// format and known transaction types
+func (m Mapping) Validate() error {
+	var problems []string
+
+	if m.Kind != KindPositions && m.Kind != KindTransactions {
+		problems = append(problems, fmt.Sprintf("kind must be %s or %s", KindPositions, KindTransactions))
+	}
+	if _, ok := dateFormats[m.DateFormat]; !ok {
+		problems = append(problems, "date_format must be one of "+strings.Join(DateFormats(), ", "))
+	}
+
+	known := make(map[string]bool, len(Fields))
+	for _, field := range Fields {
+		known[field] = true
+	}
+	for _, field := range sortedKeys(m.Columns) {
+		if !known[field] {
+			problems = append(problems, fmt.Sprintf("unknown field %q, expected one of %s", field, strings.Join(Fields, ", ")))
+		} else if m.Columns[field] == "" {
+			problems = append(problems, fmt.Sprintf("column of %s cannot be empty", field))
+		}
+	}
+
+	for _, value := range sortedKeys(m.Types) {
+		switch m.Types[value] {
+		case TypeBuy, TypeSell, TypeDividend, TypeFee:
+		default:
+			problems = append(problems, fmt.Sprintf("type %q must map to %s, %s, %s or %s", value, TypeBuy, TypeSell, TypeDividend, TypeFee))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// column returns the header of a field's column
+func (m Mapping) column(field string) string {
+	if header, ok := m.Columns[field]; ok {
+		return header
+	}
+	return field
+}
+
+// transactionType returns the transaction type a value of the type column
+// stands for
+func (m Mapping) transactionType(value string) (string, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if t, ok := m.Types[value]; ok {
+		return t, true
+	}
+	t, ok := defaultTypes[value]
+	return t, ok
+}
+
+// Row is a transaction read from one line of a file. Rows of a positions
+// file are buys. Quantities and amounts are positive; the type says which
+// way shares and cash move.
+type Row struct {
+	Line       int             `json:"line"`
+	Type       string          `json:"type"`
+	Symbol     string          `json:"symbol,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	AssetClass string          `json:"asset_class,omitempty"`
+	Currency   string          `json:"currency,omitempty"`
+	Date       *time.Time      `json:"date,omitempty"`
+	Quantity   float64         `json:"quantity,omitempty"`
+	Price      decimal.Decimal `json:"price"`
+	Fees       decimal.Decimal `json:"fees"`
+	Amount     decimal.Decimal `json:"amount"`
+	Notes      string          `json:"notes,omitempty"`
+}
+
+// RowError lists every problem found in one row
+type RowError struct {
+	Line     int      `json:"line"`
+	Problems []string `json:"problems"`
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, strings.Join(e.Problems, "; "))
+}
+
+// RowErrors lists the rows of a file that cannot be imported
+type RowErrors []*RowError
+
+func (e RowErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Parse reads the rows of a CSV file with a header row laid out as the
+// mapping describes. Dates may not be after asOf.
+//
+// A positions file needs symbol and quantity columns and a price or
+// cost_basis column; each row is a buy at the unit cost, taken from the
+// cost basis when the row has one so the lot keeps the basis the broker
+// reported. A transactions file needs date and type columns. A buy or sell
+// without a price is priced from its amount.
+//
+// The rows that can be imported are returned in date order, keeping file
+// order for rows on the same day, along with the problems of the others.
+// An error is returned only when the file cannot be read at all.
+func Parse(r io.Reader, m Mapping, asOf time.Time) ([]Row, RowErrors, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	headers := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := headers[name]; !ok {
+			headers[name] = i
+		}
+	}
+	columns := make(map[string]int)
+	for _, field := range Fields {
+		if i, ok := headers[strings.ToLower(m.column(field))]; ok {
+			columns[field] = i
+		}
+	}
+
+	required := []string{FieldDate, FieldType}
+	if m.Kind == KindPositions {
+		required = []string{FieldSymbol, FieldQuantity}
+	}
+	for _, field := range required {
+		if _, ok := columns[field]; !ok {
+			return nil, nil, fmt.Errorf("missing %s column %q", field, m.column(field))
+		}
+	}
+	if m.Kind == KindPositions {
+		_, hasPrice := columns[FieldPrice]
+		_, hasCostBasis := columns[FieldCostBasis]
+		if !hasPrice && !hasCostBasis {
+			return nil, nil, fmt.Errorf("missing price column %q or cost_basis column %q", m.column(FieldPrice), m.column(FieldCostBasis))
+		}
+	}
+
+	var rows []Row
+	var invalid RowErrors
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if blank(record) {
+			continue
+		}
+
+		var row Row
+		var problems []string
+		if m.Kind == KindPositions {
+			row, problems = parsePosition(field, m, asOf)
+		} else {
+			row, problems = parseTransaction(field, m, asOf)
+		}
+		if len(problems) > 0 {
+			invalid = append(invalid, &RowError{Line: line, Problems: problems})
+			continue
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 && len(invalid) == 0 {
+		return nil, nil, errors.New("file has no rows")
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return dateOr(rows[i].Date, asOf).Before(dateOr(rows[j].Date, asOf))
+	})
+	return rows, invalid, nil
+}
+
+// parsePosition reads a holding from a positions file as a buy
+func parsePosition(field func(string) string, m Mapping, asOf time.Time) (Row, []string) {
+	row, problems := parseCommon(field, m, asOf)
+	row.Type = TypeBuy
+
+	if row.Symbol == "" {
+		problems = append(problems, "symbol is required")
+	}
+	if row.Quantity == 0 {
+		problems = append(problems, "quantity is required")
+	}
+
+	costBasis, err := parseAmount(field(FieldCostBasis))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid cost_basis: %v", err))
+	}
+	switch {
+	case !costBasis.IsZero() && row.Quantity != 0:
+		row.Price, row.Fees = costBasis.Abs().DivRound(decimal.NewFromFloat(row.Quantity), PricePlaces), decimal.Zero
+	case row.Price.IsZero() && field(FieldPrice) == "":
+		problems = append(problems, "price or cost_basis is required")
+	}
+	return row, problems
+}
+
+// parseTransaction reads a row of a transactions file
+func parseTransaction(field func(string) string, m Mapping, asOf time.Time) (Row, []string) {
+	row, problems := parseCommon(field, m, asOf)
+
+	if row.Date == nil && field(FieldDate) == "" {
+		problems = append(problems, "date is required")
+	}
+	value := field(FieldType)
+	if value == "" {
+		problems = append(problems, "type is required")
+		return row, problems
+	}
+	t, ok := m.transactionType(value)
+	if !ok {
+		problems = append(problems, fmt.Sprintf("unknown type %q", value))
+		return row, problems
+	}
+	row.Type = t
+
+	switch row.Type {
+	case TypeBuy, TypeSell:
+		if row.Symbol == "" {
+			problems = append(problems, fmt.Sprintf("symbol is required for %s transactions", row.Type))
+		}
+		if row.Quantity == 0 {
+			problems = append(problems, fmt.Sprintf("quantity is required for %s transactions", row.Type))
+			break
+		}
+		if field(FieldPrice) != "" || row.Amount.IsZero() {
+			if field(FieldPrice) == "" {
+				problems = append(problems, "price or amount is required")
+			}
+			break
+		}
+		// Buys cost the price plus fees and sells pay the price less fees
+		quantity := decimal.NewFromFloat(row.Quantity)
+		if row.Type == TypeBuy {
+			row.Price = row.Amount.Sub(row.Fees).DivRound(quantity, PricePlaces)
+		} else {
+			row.Price = row.Amount.Add(row.Fees).DivRound(quantity, PricePlaces)
+		}
+		if row.Price.IsNegative() {
+			problems = append(problems, "fees cannot exceed the amount")
+		}
+	case TypeDividend, TypeFee:
+		if row.Type == TypeDividend && row.Symbol == "" {
+			problems = append(problems, "symbol is required for DIVIDEND transactions")
+		}
+		if row.Amount.IsZero() {
+			problems = append(problems, fmt.Sprintf("amount is required for %s transactions", row.Type))
+		}
+	}
+	return row, problems
+}
+
+// parseCommon reads the fields shared by every kind of row. Quantities,
+// amounts and fees lose their sign, which brokers use inconsistently.
+func parseCommon(field func(string) string, m Mapping, asOf time.Time) (Row, []string) {
+	var problems []string
+	row := Row{
+		Symbol:     strings.ToUpper(field(FieldSymbol)),
+		Name:       field(FieldName),
+		AssetClass: strings.ToUpper(field(FieldAssetClass)),
+		Currency:   strings.ToUpper(field(FieldCurrency)),
+		Notes:      field(FieldNotes),
+	}
+
+	if value := field(FieldDate); value != "" {
+		date, err := time.Parse(dateFormats[m.DateFormat], value)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("invalid date %q, expected %s", value, m.DateFormat))
+		case date.After(asOf):
+			problems = append(problems, "date cannot be in the future")
+		default:
+			row.Date = &date
+		}
+	}
+	if row.Currency != "" && len(row.Currency) != 3 {
+		problems = append(problems, fmt.Sprintf("invalid currency %q", row.Currency))
+	}
+
+	quantity, err := parseAmount(field(FieldQuantity))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid %s: %v", FieldQuantity, err))
+	}
+	row.Quantity, _ = quantity.Abs().Float64()
+
+	for _, amount := range []struct {
+		name string
+		dest *decimal.Decimal
+	}{
+		{FieldPrice, &row.Price},
+		{FieldFees, &row.Fees},
+		{FieldAmount, &row.Amount},
+	} {
+		n, err := parseAmount(field(amount.name))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %v", amount.name, err))
+		}
+		*amount.dest = n.Abs()
+	}
+	return row, problems
+}
+
+// parseAmount reads a number written with an optional currency sign,
+// thousands separators, or parentheses for a negative amount. An empty
+// value is zero.
+func parseAmount(value string) (decimal.Decimal, error) {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	if negative {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(s)
+
+	n, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%q is not a number", value)
+	}
+	if negative {
+		n = n.Neg()
+	}
+	return n, nil
+}
+
+// blank reports whether every field of a record is empty
+func blank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// dateOr returns the date, or fallback when there is none
+func dateOr(date *time.Time, fallback time.Time) time.Time {
+	if date != nil {
+		return *date
+	}
+	return fallback
+}
+
+// sortedKeys returns the keys of a map in order so problems are reported
+// the same way every time
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,243 @@
+// Package lots implements tax-lot relief for investment sales.
+//
+// Every purchase opens a lot recording when the shares were acquired and what
+// they cost. A sale relieves shares from open lots in the order given by the
+// relief method (first in first out, last in first out, highest cost first,
+// or lots named by the seller) and realizes a gain or loss per lot against
+// the proceeds allocated to it.
+package lots
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Method is a lot relief method
+type Method string
+
+// Supported lot relief methods
+const (
+	FIFO     Method = "FIFO"
+	LIFO     Method = "LIFO"
+	HIFO     Method = "HIFO"
+	Specific Method = "SPECIFIC"
+)
+
+// epsilon absorbs rounding in share quantities
+const epsilon = 1e-9
+
+// ErrInsufficientQuantity is returned when open lots hold fewer shares than are sold
+var ErrInsufficientQuantity = errors.New("open lots hold fewer shares than the quantity sold")
+
+// ParseMethod returns the method named by s, defaulting to FIFO when s is empty
+func ParseMethod(s string) (Method, error) {
+	if s == "" {
+		return FIFO, nil
+	}
+	switch m := Method(strings.ToUpper(strings.TrimSpace(s))); m {
+	case FIFO, LIFO, HIFO, Specific:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown lot method %q", s)
+	}
+}
+
+// Lot is an open tax lot
+type Lot struct {
+	ID         string          `json:"id"`
+	AcquiredAt time.Time       `json:"acquired_at"`
+	Quantity   float64         `json:"quantity"`
+	UnitCost   decimal.Decimal `json:"unit_cost"`
+}
+
+// Selection names a quantity to relieve from one lot
+type Selection struct {
+	LotID    string  `json:"lot_id"`
+	Quantity float64 `json:"quantity"`
+}
+
+// Disposal is the part of a sale relieved from one lot. Amounts are in the
+// currency of the sale's proceeds.
+type Disposal struct {
+	LotID        string          `json:"lot_id"`
+	AcquiredAt   time.Time       `json:"acquired_at"`
+	Quantity     float64         `json:"quantity"`
+	CostBasis    decimal.Decimal `json:"cost_basis"`
+	Proceeds     decimal.Decimal `json:"proceeds"`
+	RealizedGain decimal.Decimal `json:"realized_gain"`
+}
+
+// Order returns the lots in the order method relieves them. Ties are broken
+// by acquisition date and then lot ID so the order is deterministic.
+func Order(open []Lot, method Method) []Lot {
+	ordered := make([]Lot, len(open))
+	copy(ordered, open)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		switch method {
+		case LIFO:
+			if !a.AcquiredAt.Equal(b.AcquiredAt) {
+				return a.AcquiredAt.After(b.AcquiredAt)
+			}
+		case HIFO:
+			if !a.UnitCost.Equal(b.UnitCost) {
+				return a.UnitCost.GreaterThan(b.UnitCost)
+			}
+		}
+		if !a.AcquiredAt.Equal(b.AcquiredAt) {
+			return a.AcquiredAt.Before(b.AcquiredAt)
+		}
+		return a.ID < b.ID
+	})
+	return ordered
+}
+
+// Select chooses the quantities to relieve from open lots to sell quantity
+// shares. For the Specific method the seller's selections are checked
+// against the open lots and must add up to quantity.
+func Select(open []Lot, method Method, quantity float64, specific []Selection) ([]Selection, error) {
+	if quantity <= 0 {
+		return nil, errors.New("quantity must be positive")
+	}
+
+	if method == Specific {
+		return checkSpecific(open, quantity, specific)
+	}
+
+	var selections []Selection
+	remaining := quantity
+	for _, lot := range Order(open, method) {
+		if remaining <= epsilon {
+			break
+		}
+		if lot.Quantity <= epsilon {
+			continue
+		}
+		take := math.Min(lot.Quantity, remaining)
+		selections = append(selections, Selection{LotID: lot.ID, Quantity: take})
+		remaining -= take
+	}
+	if remaining > epsilon {
+		return nil, ErrInsufficientQuantity
+	}
+	return selections, nil
+}
+
+// checkSpecific validates lots named by the seller
+func checkSpecific(open []Lot, quantity float64, specific []Selection) ([]Selection, error) {
+	if len(specific) == 0 {
+		return nil, errors.New("specific lot relief requires lots to be selected")
+	}
+
+	available := make(map[string]float64, len(open))
+	for _, lot := range open {
+		available[lot.ID] = lot.Quantity
+	}
+
+	total := 0.0
+	selected := make(map[string]float64, len(specific))
+	for _, s := range specific {
+		if s.Quantity <= 0 {
+			return nil, fmt.Errorf("lot %s: quantity must be positive", s.LotID)
+		}
+		open, ok := available[s.LotID]
+		if !ok {
+			return nil, fmt.Errorf("lot %s is not an open lot of this position", s.LotID)
+		}
+		selected[s.LotID] += s.Quantity
+		if selected[s.LotID] > open+epsilon {
+			return nil, fmt.Errorf("lot %s holds %g shares", s.LotID, open)
+		}
+		total += s.Quantity
+	}
+	if math.Abs(total-quantity) > epsilon {
+		return nil, fmt.Errorf("selected lots total %g shares but %g are sold", total, quantity)
+	}
+	return specific, nil
+}
+
+// Relieve sells quantity shares from open lots for the given total proceeds
+// (net of fees). Proceeds are allocated to lots in proportion to the shares
+// taken from each, with any rounding remainder assigned to the last lot, and
+// every amount is rounded to the minor unit of the proceeds' currency.
+func Relieve(open []Lot, method Method, quantity float64, proceeds money.Money, specific []Selection) ([]Disposal, error) {
+	selections, err := Select(open, method, quantity, specific)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Lot, len(open))
+	for _, lot := range open {
+		byID[lot.ID] = lot
+	}
+
+	currency := proceeds.Currency
+	total := decimal.NewFromFloat(quantity)
+	disposals := make([]Disposal, 0, len(selections))
+	allocated := decimal.Zero
+	for i, s := range selections {
+		lot := byID[s.LotID]
+		shares := decimal.NewFromFloat(s.Quantity)
+		share := money.Round(proceeds.Amount.Mul(shares).Div(total), currency)
+		if i == len(selections)-1 {
+			share = proceeds.Amount.Sub(allocated)
+		}
+		allocated = allocated.Add(share)
+
+		costBasis := money.Round(lot.UnitCost.Mul(shares), currency)
+		disposals = append(disposals, Disposal{
+			LotID:        lot.ID,
+			AcquiredAt:   lot.AcquiredAt,
+			Quantity:     s.Quantity,
+			CostBasis:    costBasis,
+			Proceeds:     share,
+			RealizedGain: share.Sub(costBasis),
+		})
+	}
+	return disposals, nil
+}
+
+// RealizedGain totals the gain across disposals
+func RealizedGain(disposals []Disposal) decimal.Decimal {
+	total := decimal.Zero
+	for _, d := range disposals {
+		total = total.Add(d.RealizedGain)
+	}
+	return total
+}
+
+// IsLongTerm reports whether shares acquired at acquired and disposed of at
+// disposed were held for more than one year. The holding period is counted
+// in calendar days from the day after acquisition, so shares sold on the
+// anniversary of their purchase are short-term. Shares bought on February 29
+// reach their anniversary on February 28.
+func IsLongTerm(acquired, disposed time.Time) bool {
+	return calendarDate(disposed).After(anniversary(acquired))
+}
+
+// anniversary returns the UTC date one year after t, clamped to the end of
+// the month when that month is shorter
+func anniversary(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	a := time.Date(year+1, month, day, 0, 0, 0, 0, time.UTC)
+	if a.Month() != month {
+		a = time.Date(year+1, month+1, 0, 0, 0, 0, 0, time.UTC)
+	}
+	return a
+}
+
+// calendarDate returns t's UTC date at midnight, ignoring the time of day.
+// Trade dates are reported in UTC.
+func calendarDate(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
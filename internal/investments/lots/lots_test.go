@@ -0,0 +1,123 @@
+package lots
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+func TestIsLongTerm(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		acquired string
+		disposed string
+		want     bool
+	}{
+		{"day before anniversary", "2023-01-05 10:00", "2024-01-04 15:00", false},
+		{"anniversary later in the day", "2023-01-05 10:00", "2024-01-05 15:00", false},
+		{"anniversary earlier in the day", "2023-01-05 10:00", "2024-01-05 09:00", false},
+		{"day after anniversary", "2023-01-05 10:00", "2024-01-06 09:00", true},
+		{"same day", "2023-01-05 10:00", "2023-01-05 15:00", false},
+		{"leap day on anniversary", "2020-02-29 10:00", "2021-02-28 15:00", false},
+		{"leap day after anniversary", "2020-02-29 10:00", "2021-03-01 09:00", true},
+		{"across a leap day before anniversary", "2023-03-01 10:00", "2024-03-01 15:00", false},
+		{"across a leap day after anniversary", "2023-03-01 10:00", "2024-03-02 09:00", true},
+		{"into a leap year anniversary", "2023-02-28 10:00", "2024-02-29 09:00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsLongTerm(at(tt.acquired), at(tt.disposed)); got != tt.want {
+				t.Errorf("IsLongTerm(%s, %s) = %v, want %v", tt.acquired, tt.disposed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelieve(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	open := []Lot{
+		{ID: "a", AcquiredAt: day(1), Quantity: 10, UnitCost: decimal.NewFromInt(10)},
+		{ID: "b", AcquiredAt: day(2), Quantity: 10, UnitCost: decimal.NewFromInt(30)},
+		{ID: "c", AcquiredAt: day(3), Quantity: 10, UnitCost: decimal.NewFromInt(20)},
+	}
+
+	tests := []struct {
+		method Method
+		want   []string
+		gain   string
+	}{
+		{FIFO, []string{"a", "b"}, "50"},
+		{LIFO, []string{"c", "b"}, "-50"},
+		{HIFO, []string{"b", "c"}, "-100"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			disposals, err := Relieve(open, tt.method, 15, money.New(decimal.NewFromInt(300), "USD"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(disposals) != len(tt.want) {
+				t.Fatalf("got %d disposals, want %d", len(disposals), len(tt.want))
+			}
+			proceeds := decimal.Zero
+			for i, d := range disposals {
+				if d.LotID != tt.want[i] {
+					t.Errorf("disposal %d relieved lot %s, want %s", i, d.LotID, tt.want[i])
+				}
+				proceeds = proceeds.Add(d.Proceeds)
+			}
+			if !proceeds.Equal(decimal.NewFromInt(300)) {
+				t.Errorf("proceeds total %s, want 300", proceeds)
+			}
+			if gain := RealizedGain(disposals); gain.String() != tt.gain {
+				t.Errorf("realized gain %s, want %s", gain, tt.gain)
+			}
+		})
+	}
+
+	if _, err := Relieve(open, FIFO, 31, money.New(decimal.NewFromInt(310), "USD"), nil); err != ErrInsufficientQuantity {
+		t.Errorf("selling more than is held returned %v, want ErrInsufficientQuantity", err)
+	}
+}
+
+func TestRelieveAllocatesRemainder(t *testing.T) {
+	open := []Lot{
+		{ID: "a", AcquiredAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Quantity: 1, UnitCost: decimal.RequireFromString("0.10")},
+		{ID: "b", AcquiredAt: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), Quantity: 1, UnitCost: decimal.RequireFromString("0.20")},
+		{ID: "c", AcquiredAt: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC), Quantity: 1, UnitCost: decimal.RequireFromString("0.30")},
+	}
+
+	disposals, err := Relieve(open, FIFO, 3, money.New(decimal.NewFromInt(100), "USD"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"33.33", "33.33", "33.34"} {
+		if got := disposals[i].Proceeds.StringFixed(2); got != want {
+			t.Errorf("lot %s proceeds %s, want %s", disposals[i].LotID, got, want)
+		}
+	}
+	if gain := RealizedGain(disposals); gain.StringFixed(2) != "99.40" {
+		t.Errorf("realized gain %s, want 99.40", gain)
+	}
+
+	disposals, err = Relieve(open, FIFO, 3, money.New(decimal.NewFromInt(1000), "JPY"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"333", "333", "334"} {
+		if got := disposals[i].Proceeds.String(); got != want {
+			t.Errorf("lot %s proceeds %s JPY, want %s", disposals[i].LotID, got, want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package performance computes portfolio returns from daily valuations and
+// cash flows.
+//
+// The time-weighted return chains the return of each day between valuations
+// so deposits and withdrawals do not distort it; it measures how the
+// investments performed. The money-weighted return is the internal rate of
+// return of the actual cash flows; it measures what the investor earned given
+// when money went in and out. Cash flows are positive when money is added to
+// the portfolio and are assumed to happen at the start of the day they are
+// dated, so a purchase on the first day is valued by that day's close.
+// Values and cash flows are exact decimals in one currency; only the returns
+// computed from them are floats.
+package performance
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// daysPerYear is used to annualize returns
+const daysPerYear = 365.0
+
+// ErrNotEnoughData is returned when fewer than two valuations are available
+var ErrNotEnoughData = errors.New("at least two valuations are needed to compute returns")
+
+// Valuation is a portfolio's value at the end of a day
+type Valuation struct {
+	Date  time.Time       `json:"date"`
+	Value decimal.Decimal `json:"value"`
+}
+
+// CashFlow is money added to (positive) or withdrawn from (negative) the portfolio
+type CashFlow struct {
+	Date   time.Time       `json:"date"`
+	Amount decimal.Decimal `json:"amount"`
+}
+
+// Point is one day of the return series
+type Point struct {
+	Date             time.Time       `json:"date"`
+	Value            decimal.Decimal `json:"value"`
+	NetCashFlow      decimal.Decimal `json:"net_cash_flow"`
+	CumulativeReturn float64         `json:"cumulative_return"`
+}
+
+// Result is a portfolio's performance over a period
+type Result struct {
+	From                time.Time       `json:"from"`
+	To                  time.Time       `json:"to"`
+	StartValue          decimal.Decimal `json:"start_value"`
+	EndValue            decimal.Decimal `json:"end_value"`
+	NetCashFlow         decimal.Decimal `json:"net_cash_flow"`
+	Gain                decimal.Decimal `json:"gain"`
+	TimeWeightedReturn  float64         `json:"time_weighted_return"`
+	AnnualizedTWR       *float64        `json:"annualized_time_weighted_return,omitempty"`
+	MoneyWeightedReturn *float64        `json:"money_weighted_return"`
+	MoneyWeightedAnnual bool            `json:"money_weighted_return_annualized"`
+	Series              []Point         `json:"series"`
+}
+
+// Compute returns the performance between the first and last valuation.
+// Cash flows dated on or before the first valuation are part of the starting
+// value and are ignored; flows after the last valuation are ignored too.
+func Compute(valuations []Valuation, flows []CashFlow) (*Result, error) {
+	if len(valuations) < 2 {
+		return nil, ErrNotEnoughData
+	}
+
+	sorted := make([]Valuation, len(valuations))
+	copy(sorted, valuations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	start, end := sorted[0], sorted[len(sorted)-1]
+
+	// Total each day's flows, keyed by the valuation date they fall into
+	flowByDay := make(map[int]decimal.Decimal)
+	var periodFlows []CashFlow
+	for _, f := range flows {
+		if !f.Date.After(start.Date) || f.Date.After(end.Date) {
+			continue
+		}
+		i := sort.Search(len(sorted), func(i int) bool { return !sorted[i].Date.Before(f.Date) })
+		flowByDay[i] = flowByDay[i].Add(f.Amount)
+		periodFlows = append(periodFlows, f)
+	}
+
+	result := &Result{
+		From:       start.Date,
+		To:         end.Date,
+		StartValue: start.Value,
+		EndValue:   end.Value,
+		Series:     []Point{{Date: start.Date, Value: start.Value}},
+	}
+
+	growth := 1.0
+	for i := 1; i < len(sorted); i++ {
+		flow := flowByDay[i]
+		result.NetCashFlow = result.NetCashFlow.Add(flow)
+
+		// A day that starts with nothing invested has no return
+		if base := sorted[i-1].Value.Add(flow); base.IsPositive() {
+			growth *= toFloat(sorted[i].Value) / toFloat(base)
+		}
+		result.Series = append(result.Series, Point{
+			Date:             sorted[i].Date,
+			Value:            sorted[i].Value,
+			NetCashFlow:      flow,
+			CumulativeReturn: round(growth-1, 6),
+		})
+	}
+
+	result.Gain = end.Value.Sub(start.Value).Sub(result.NetCashFlow)
+	result.TimeWeightedReturn = round(growth-1, 6)
+
+	days := end.Date.Sub(start.Date).Hours() / 24
+	if days >= daysPerYear {
+		annualized := round(Annualize(growth-1, days), 6)
+		result.AnnualizedTWR = &annualized
+	}
+
+	if irr, ok := IRR(start, end, periodFlows); ok {
+		// Periods shorter than a year report the period rate rather than
+		// extrapolating a few days' return to a year
+		if days >= daysPerYear {
+			irr = Annualize(irr, days)
+			result.MoneyWeightedAnnual = true
+		}
+		irr = round(irr, 6)
+		result.MoneyWeightedReturn = &irr
+	}
+
+	return result, nil
+}
+
+// Annualize converts a return over days into an annual rate
+func Annualize(periodReturn, days float64) float64 {
+	if days <= 0 || periodReturn <= -1 {
+		return periodReturn
+	}
+	return math.Pow(1+periodReturn, daysPerYear/days) - 1
+}
+
+// IRR returns the internal rate of return, over the whole period, of
+// investing the start value and the cash flows in between and receiving the
+// end value. It reports false when the flows have no rate, such as when
+// nothing was ever invested.
+func IRR(start, end Valuation, flows []CashFlow) (float64, bool) {
+	period := end.Date.Sub(start.Date)
+	if period <= 0 {
+		return 0, false
+	}
+
+	type flow struct {
+		at     float64 // fraction of the period elapsed
+		amount float64
+	}
+
+	// From the investor's side money put in is negative and the end value is received
+	cashFlows := []flow{{0, -toFloat(start.Value)}}
+	for _, f := range flows {
+		cashFlows = append(cashFlows, flow{float64(f.Date.Sub(start.Date)) / float64(period), -toFloat(f.Amount)})
+	}
+	cashFlows = append(cashFlows, flow{1, toFloat(end.Value)})
+
+	hasIn, hasOut := false, false
+	for _, f := range cashFlows {
+		hasIn = hasIn || f.amount < 0
+		hasOut = hasOut || f.amount > 0
+	}
+	if !hasIn || !hasOut {
+		return 0, false
+	}
+
+	npv := func(rate float64) float64 {
+		total := 0.0
+		for _, f := range cashFlows {
+			total += f.amount / math.Pow(1+rate, f.at)
+		}
+		return total
+	}
+
+	// Bisection on a bracket wide enough for any plausible return
+	low, high := -0.9999, 100.0
+	fLow, fHigh := npv(low), npv(high)
+	if math.IsNaN(fLow) || math.IsNaN(fHigh) || fLow*fHigh > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		fMid := npv(mid)
+		if math.Abs(fMid) < 1e-9 || high-low < 1e-12 {
+			return mid, true
+		}
+		if fLow*fMid < 0 {
+			high = mid
+		} else {
+			low, fLow = mid, fMid
+		}
+	}
+	return (low + high) / 2, true
+}
+
+// PriceReturn returns the return of holding an asset from its first to its
+// last price, which is how a benchmark is compared
+func PriceReturn(prices []Valuation) (float64, error) {
+	if len(prices) < 2 {
+		return 0, ErrNotEnoughData
+	}
+	sorted := make([]Valuation, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	first := sorted[0].Value
+	if !first.IsPositive() {
+		return 0, errors.New("benchmark has no starting price")
+	}
+	return round(toFloat(sorted[len(sorted)-1].Value)/toFloat(first)-1, 6), nil
+}
+
+// toFloat returns an amount as a float for computing returns
+func toFloat(amount decimal.Decimal) float64 {
+	f, _ := amount.Float64()
+	return f
+}
+
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
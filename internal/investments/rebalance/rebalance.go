@@ -0,0 +1,465 @@
+// Package rebalance proposes trades that bring holdings back to a model
+// portfolio's target allocation.
+//
+// Targets are set by asset class or by symbol. A target is in balance while
+// its weight stays within its tolerance band around the target weight. Once
+// any target drifts outside its band, every target is traded back to its
+// target weight. Holdings matching no target are sold, except unclassified
+// assets, which are left out of the proposal until they are classified.
+//
+// Sales are tax-aware: lots in tax-advantaged accounts are sold first since
+// selling them has no tax cost, then lots at a loss, then lots with long-term
+// gains, and lots with short-term gains last. Within each group the lots
+// with the lowest gain relative to their value go first.
+//
+// Prices, unit costs and values are all in the proposal's currency; holdings
+// priced in other currencies are converted before they are proposed on.
+package rebalance
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/lots"
+)
+
+// Target kinds
+const (
+	KindAssetClass = "ASSET_CLASS"
+	KindSymbol     = "SYMBOL"
+	kindUnmodeled  = "UNMODELED"
+)
+
+// Unclassified is the asset class of assets imported or synced without one
+const Unclassified = "UNCLASSIFIED"
+
+// Trade sides
+const (
+	Buy  = "BUY"
+	Sell = "SELL"
+)
+
+// Target is one allocation of a model portfolio
+type Target struct {
+	Kind      string
+	Key       string
+	Weight    float64 // fraction of the portfolio, 0 to 1
+	Tolerance float64 // allowed drift either side of Weight, 0 to 1
+
+	// The asset bought to raise the target; for symbol targets it is the
+	// symbol itself. Asset class targets without one buy the largest holding.
+	BuyAssetID string
+	BuySymbol  string
+	BuyPrice   decimal.Decimal
+}
+
+// Holding is one asset held in one account. Shares of a position without tax
+// lots are held as one lot with no ID, costed at the position's cost basis;
+// sales of them name no lots.
+type Holding struct {
+	AccountID     string
+	TaxAdvantaged bool
+	AssetID       string
+	Symbol        string
+	AssetClass    string
+	Price         decimal.Decimal
+	Lots          []lots.Lot
+}
+
+// Quantity totals the holding's open lots
+func (h Holding) Quantity() float64 {
+	total := 0.0
+	for _, l := range h.Lots {
+		total += l.Quantity
+	}
+	return total
+}
+
+// Value is the holding's market value
+func (h Holding) Value() decimal.Decimal {
+	return h.Price.Mul(decimal.NewFromFloat(h.Quantity()))
+}
+
+// Options adjust a proposal
+type Options struct {
+	Currency         string
+	AsOf             time.Time
+	DefaultTolerance float64 // band for holdings that match no target
+	FractionalShares bool
+	MinTradeValue    decimal.Decimal
+}
+
+// Drift compares a target's current and target weight
+type Drift struct {
+	Kind          string          `json:"kind"`
+	Key           string          `json:"key"`
+	TargetWeight  float64         `json:"target_weight"`
+	CurrentWeight float64         `json:"current_weight"`
+	Drift         float64         `json:"drift"`
+	Tolerance     float64         `json:"tolerance"`
+	CurrentValue  decimal.Decimal `json:"current_value"`
+	TargetValue   decimal.Decimal `json:"target_value"`
+	OutOfBand     bool            `json:"out_of_band"`
+}
+
+// Trade is one proposed order. Sales name the lots to sell, so they can be
+// recorded with the SPECIFIC lot method.
+type Trade struct {
+	Side          string           `json:"side"`
+	AccountID     string           `json:"account_id"`
+	AssetID       string           `json:"asset_id"`
+	Symbol        string           `json:"symbol"`
+	Target        string           `json:"target"`
+	Quantity      float64          `json:"quantity"`
+	Price         decimal.Decimal  `json:"price"`
+	Value         decimal.Decimal  `json:"value"`
+	LotMethod     string           `json:"lot_method,omitempty"`
+	Lots          []lots.Selection `json:"lots,omitempty"`
+	ShortTermGain decimal.Decimal  `json:"short_term_gain"`
+	LongTermGain  decimal.Decimal  `json:"long_term_gain"`
+	TaxAdvantaged bool             `json:"tax_advantaged"`
+}
+
+// Proposal is the outcome of comparing holdings with a model
+type Proposal struct {
+	Currency               string          `json:"currency"`
+	TotalValue             decimal.Decimal `json:"total_value"`
+	NeedsRebalance         bool            `json:"needs_rebalance"`
+	Drifts                 []Drift         `json:"drifts"`
+	Trades                 []Trade         `json:"trades"`
+	SellValue              decimal.Decimal `json:"sell_value"`
+	BuyValue               decimal.Decimal `json:"buy_value"`
+	NetCash                decimal.Decimal `json:"net_cash"`
+	EstimatedShortTermGain decimal.Decimal `json:"estimated_short_term_gain"`
+	EstimatedLongTermGain  decimal.Decimal `json:"estimated_long_term_gain"`
+	Warnings               []string        `json:"warnings"`
+}
+
+// group is the holdings assigned to one target
+type group struct {
+	target   Target
+	holdings []Holding
+	value    decimal.Decimal
+}
+
+func (t Target) label() string {
+	if t.Kind == kindUnmodeled {
+		return kindUnmodeled
+	}
+	return t.Kind + ":" + t.Key
+}
+
+// Propose compares holdings with the targets and proposes trades
+func Propose(targets []Target, holdings []Holding, opts Options) Proposal {
+	proposal := Proposal{Currency: opts.Currency, Drifts: []Drift{}, Trades: []Trade{}, Warnings: []string{}}
+
+	groups := make([]*group, len(targets))
+	bySymbol := make(map[string]*group)
+	byClass := make(map[string]*group)
+	for i, t := range targets {
+		groups[i] = &group{target: t}
+		switch t.Kind {
+		case KindSymbol:
+			bySymbol[strings.ToUpper(t.Key)] = groups[i]
+		case KindAssetClass:
+			byClass[strings.ToUpper(t.Key)] = groups[i]
+		}
+	}
+	unmodeled := &group{target: Target{Kind: kindUnmodeled, Tolerance: opts.DefaultTolerance}}
+
+	// Symbol targets take precedence over asset class targets
+	total := decimal.Zero
+	var unclassified []string
+	for _, h := range holdings {
+		g, ok := bySymbol[strings.ToUpper(h.Symbol)]
+		if !ok {
+			g, ok = byClass[strings.ToUpper(h.AssetClass)]
+		}
+		if !ok && strings.EqualFold(h.AssetClass, Unclassified) {
+			unclassified = append(unclassified, h.Symbol)
+			continue
+		}
+		if !ok {
+			g = unmodeled
+		}
+		g.holdings = append(g.holdings, h)
+		g.value = g.value.Add(h.Value())
+		total = total.Add(h.Value())
+	}
+	proposal.TotalValue = roundAmount(total)
+	if len(unclassified) > 0 {
+		proposal.Warnings = append(proposal.Warnings,
+			fmt.Sprintf("Left out unclassified holdings %s; set their asset class to rebalance them", strings.Join(unclassified, ", ")))
+	}
+	if len(unmodeled.holdings) > 0 {
+		groups = append(groups, unmodeled)
+	}
+
+	if !total.IsPositive() {
+		proposal.Warnings = append(proposal.Warnings, "No holdings to rebalance")
+		return proposal
+	}
+
+	for _, g := range groups {
+		current := ratio(g.value, total)
+		d := Drift{
+			Kind:          g.target.Kind,
+			Key:           g.target.Key,
+			TargetWeight:  round(g.target.Weight, 6),
+			CurrentWeight: round(current, 6),
+			Drift:         round(current-g.target.Weight, 6),
+			Tolerance:     g.target.Tolerance,
+			CurrentValue:  roundAmount(g.value),
+			TargetValue:   roundAmount(g.target.targetValue(total)),
+		}
+		d.OutOfBand = math.Abs(current-g.target.Weight) > g.target.Tolerance+1e-9
+		proposal.NeedsRebalance = proposal.NeedsRebalance || d.OutOfBand
+		proposal.Drifts = append(proposal.Drifts, d)
+	}
+
+	if !proposal.NeedsRebalance {
+		return proposal
+	}
+
+	minTrade := decimal.Max(opts.MinTradeValue, decimal.New(1, -2))
+	for _, g := range groups {
+		delta := g.target.targetValue(total).Sub(g.value)
+		if delta.Abs().LessThan(minTrade) {
+			continue
+		}
+		if delta.IsNegative() {
+			proposal.Trades = append(proposal.Trades, sellTrades(g, delta.Neg(), opts)...)
+		} else if trade, warning := buyTrade(g, delta, holdings, opts); warning != "" {
+			proposal.Warnings = append(proposal.Warnings, warning)
+		} else if trade != nil {
+			proposal.Trades = append(proposal.Trades, *trade)
+		}
+	}
+
+	for _, t := range proposal.Trades {
+		if t.Side == Sell {
+			proposal.SellValue = proposal.SellValue.Add(t.Value)
+			proposal.EstimatedShortTermGain = proposal.EstimatedShortTermGain.Add(t.ShortTermGain)
+			proposal.EstimatedLongTermGain = proposal.EstimatedLongTermGain.Add(t.LongTermGain)
+		} else {
+			proposal.BuyValue = proposal.BuyValue.Add(t.Value)
+		}
+	}
+	proposal.NetCash = proposal.SellValue.Sub(proposal.BuyValue)
+	if proposal.NetCash.IsNegative() {
+		proposal.Warnings = append(proposal.Warnings,
+			fmt.Sprintf("Purchases exceed sale proceeds by %s", proposal.NetCash.Neg().StringFixed(2)))
+	}
+
+	return proposal
+}
+
+// candidate is a lot that could be sold, with the holding it belongs to
+type candidate struct {
+	holding  *Holding
+	lot      lots.Lot
+	gain     decimal.Decimal // per share
+	longTerm bool
+}
+
+// taxRank orders candidates: tax-advantaged accounts, then losses (short-term
+// first, as they offset gains taxed at higher rates), then long-term gains,
+// then short-term gains
+func (c candidate) taxRank() int {
+	switch {
+	case c.holding.TaxAdvantaged:
+		return 0
+	case c.gain.IsNegative() && !c.longTerm:
+		return 1
+	case c.gain.IsNegative():
+		return 2
+	case c.longTerm:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// sellTrades sells value from a group's lots in tax-aware order, one trade
+// per account and asset
+func sellTrades(g *group, value decimal.Decimal, opts Options) []Trade {
+	var candidates []candidate
+	for i := range g.holdings {
+		h := &g.holdings[i]
+		if !h.Price.IsPositive() {
+			continue
+		}
+		for _, l := range h.Lots {
+			candidates = append(candidates, candidate{
+				holding:  h,
+				lot:      l,
+				gain:     h.Price.Sub(l.UnitCost),
+				longTerm: lots.IsLongTerm(l.AcquiredAt, opts.AsOf),
+			})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.taxRank() != b.taxRank() {
+			return a.taxRank() < b.taxRank()
+		}
+		ra, rb := a.gain.Div(a.holding.Price), b.gain.Div(b.holding.Price)
+		if !ra.Equal(rb) {
+			return ra.LessThan(rb)
+		}
+		return a.lot.AcquiredAt.Before(b.lot.AcquiredAt)
+	})
+
+	trades := make(map[*Holding]*Trade)
+	var order []*Holding
+	remaining := value
+	for _, c := range candidates {
+		if roundAmount(remaining).Sign() <= 0 {
+			break
+		}
+		quantity := math.Min(c.lot.Quantity, ratio(remaining, c.holding.Price))
+		if !opts.FractionalShares && quantity < c.lot.Quantity {
+			quantity = math.Min(math.Ceil(quantity), c.lot.Quantity)
+		}
+		quantity = roundQuantity(quantity)
+		if quantity <= 0 {
+			continue
+		}
+
+		t, ok := trades[c.holding]
+		if !ok {
+			t = &Trade{
+				Side:          Sell,
+				AccountID:     c.holding.AccountID,
+				AssetID:       c.holding.AssetID,
+				Symbol:        c.holding.Symbol,
+				Target:        g.target.label(),
+				Price:         c.holding.Price,
+				TaxAdvantaged: c.holding.TaxAdvantaged,
+			}
+			trades[c.holding] = t
+			order = append(order, c.holding)
+		}
+
+		t.Quantity = roundQuantity(t.Quantity + quantity)
+		if c.lot.ID != "" {
+			t.LotMethod = string(lots.Specific)
+			t.Lots = append(t.Lots, lots.Selection{LotID: c.lot.ID, Quantity: quantity})
+		}
+		shares := decimal.NewFromFloat(quantity)
+		if !c.holding.TaxAdvantaged {
+			if c.longTerm {
+				t.LongTermGain = t.LongTermGain.Add(c.gain.Mul(shares))
+			} else {
+				t.ShortTermGain = t.ShortTermGain.Add(c.gain.Mul(shares))
+			}
+		}
+		remaining = remaining.Sub(c.holding.Price.Mul(shares))
+	}
+
+	result := make([]Trade, 0, len(order))
+	for _, h := range order {
+		t := trades[h]
+		t.Value = roundAmount(t.Price.Mul(decimal.NewFromFloat(t.Quantity)))
+		t.ShortTermGain = roundAmount(t.ShortTermGain)
+		t.LongTermGain = roundAmount(t.LongTermGain)
+		result = append(result, *t)
+	}
+	return result
+}
+
+// buyTrade buys value of a group's buy asset. The purchase goes to the
+// account holding most of the group, or the largest account when the group
+// is not held yet.
+func buyTrade(g *group, value decimal.Decimal, all []Holding, opts Options) (*Trade, string) {
+	assetID, symbol, price := g.target.BuyAssetID, g.target.BuySymbol, g.target.BuyPrice
+	var largest *Holding
+	for i := range g.holdings {
+		if largest == nil || g.holdings[i].Value().GreaterThan(largest.Value()) {
+			largest = &g.holdings[i]
+		}
+	}
+	if assetID == "" && largest != nil {
+		assetID, symbol, price = largest.AssetID, largest.Symbol, largest.Price
+	}
+	if assetID == "" {
+		return nil, fmt.Sprintf("No asset to buy for %s; set a buy symbol on the allocation", g.target.label())
+	}
+	if !price.IsPositive() {
+		return nil, fmt.Sprintf("No price for %s; cannot size the purchase", symbol)
+	}
+
+	accountID := ""
+	if largest != nil {
+		accountID = largest.AccountID
+	} else {
+		accountID = largestAccount(all)
+	}
+
+	quantity := ratio(value, price)
+	if !opts.FractionalShares {
+		quantity = math.Floor(quantity)
+	}
+	quantity = roundQuantity(quantity)
+	if quantity <= 0 {
+		return nil, ""
+	}
+
+	return &Trade{
+		Side:      Buy,
+		AccountID: accountID,
+		AssetID:   assetID,
+		Symbol:    symbol,
+		Target:    g.target.label(),
+		Quantity:  quantity,
+		Price:     price,
+		Value:     roundAmount(price.Mul(decimal.NewFromFloat(quantity))),
+	}, ""
+}
+
+// largestAccount returns the account with the most value across holdings
+func largestAccount(holdings []Holding) string {
+	totals := make(map[string]decimal.Decimal)
+	for _, h := range holdings {
+		totals[h.AccountID] = totals[h.AccountID].Add(h.Value())
+	}
+
+	best := ""
+	for accountID, total := range totals {
+		if best == "" || total.GreaterThan(totals[best]) || (total.Equal(totals[best]) && accountID < best) {
+			best = accountID
+		}
+	}
+	return best
+}
+
+// targetValue is the value the target should hold of a portfolio worth total
+func (t Target) targetValue(total decimal.Decimal) decimal.Decimal {
+	return total.Mul(decimal.NewFromFloat(t.Weight))
+}
+
+// ratio divides a by b as a float, for weights and share quantities
+func ratio(a, b decimal.Decimal) float64 {
+	f, _ := a.Div(b).Float64()
+	return f
+}
+
+// roundAmount rounds an amount to cents
+func roundAmount(amount decimal.Decimal) decimal.Decimal {
+	return amount.Round(2)
+}
+
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
+
+// roundQuantity rounds share quantities to the precision they are stored at
+func roundQuantity(quantity float64) float64 {
+	return round(quantity, 6)
+}
@@ -0,0 +1,303 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Option is one answer to a questionnaire question. MaxProfile, when set,
+// caps the resulting profile regardless of the total score, so a very short
+// time horizon can never produce an aggressive profile.
+type Option struct {
+	Value      string `json:"value"`
+	Label      string `json:"label"`
+	Score      int    `json:"score"`
+	MaxProfile string `json:"max_profile,omitempty"`
+}
+
+// Question is a single questionnaire question
+type Question struct {
+	ID      string   `json:"id"`
+	Text    string   `json:"text"`
+	Options []Option `json:"options"`
+}
+
+// Band maps a share of the maximum score to a profile. A score falls in the
+// first band whose upper bound it does not exceed.
+type Band struct {
+	Profile string  `json:"profile"`
+	UpTo    float64 `json:"up_to"`
+}
+
+// Questionnaire is a versioned set of questions and the bands used to score them.
+// Published versions must never change, since stored assessments are explained
+// against the version they were answered on.
+type Questionnaire struct {
+	Version   int        `json:"version"`
+	Title     string     `json:"title"`
+	Questions []Question `json:"questions"`
+	Bands     []Band     `json:"bands"`
+}
+
+// Factor records how one answer contributed to a result
+type Factor struct {
+	QuestionID string `json:"question_id"`
+	Question   string `json:"question"`
+	Answer     string `json:"answer"`
+	Label      string `json:"label"`
+	Score      int    `json:"score"`
+	MaxScore   int    `json:"max_score"`
+	MaxProfile string `json:"max_profile,omitempty"`
+}
+
+// Result is a scored questionnaire
+type Result struct {
+	Version      int      `json:"questionnaire_version"`
+	Score        int      `json:"score"`
+	MinScore     int      `json:"min_score"`
+	MaxScore     int      `json:"max_score"`
+	Percent      float64  `json:"percent"`
+	ScoreProfile string   `json:"score_profile"`
+	Profile      string   `json:"profile"`
+	Factors      []Factor `json:"factors"`
+	Explanation  []string `json:"explanation"`
+}
+
+// AnswerError lists every problem found in a set of answers
+type AnswerError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *AnswerError) Error() string {
+	return "invalid questionnaire answers: " + strings.Join(e.Problems, "; ")
+}
+
+// ErrUnknownVersion is returned for a questionnaire version that does not exist
+var ErrUnknownVersion = errors.New("unknown questionnaire version")
+
+// questionnaires are the published questionnaire versions, oldest first
+var questionnaires = []*Questionnaire{
+	{
+		Version: 1,
+		Title:   "Investor risk tolerance",
+		Questions: []Question{
+			{
+				ID:   "time_horizon",
+				Text: "When do you expect to start withdrawing most of this money?",
+				Options: []Option{
+					{Value: "under_3_years", Label: "In less than 3 years", Score: 1, MaxProfile: ModeratelyConservative},
+					{Value: "3_to_5_years", Label: "In 3 to 5 years", Score: 2, MaxProfile: Moderate},
+					{Value: "6_to_10_years", Label: "In 6 to 10 years", Score: 3},
+					{Value: "11_to_15_years", Label: "In 11 to 15 years", Score: 4},
+					{Value: "over_15_years", Label: "In more than 15 years", Score: 5},
+				},
+			},
+			{
+				ID:   "goal",
+				Text: "Which best describes your main goal for this money?",
+				Options: []Option{
+					{Value: "preserve", Label: "Preserve what I have", Score: 1},
+					{Value: "income", Label: "Generate steady income", Score: 2},
+					{Value: "balanced", Label: "A balance of income and growth", Score: 3},
+					{Value: "growth", Label: "Grow it over time", Score: 4},
+					{Value: "max_growth", Label: "Grow it as much as possible", Score: 5},
+				},
+			},
+			{
+				ID:   "market_drop",
+				Text: "If your portfolio lost 20% of its value in a year, what would you most likely do?",
+				Options: []Option{
+					{Value: "sell_all", Label: "Sell everything", Score: 1, MaxProfile: ModeratelyConservative},
+					{Value: "sell_some", Label: "Sell some investments", Score: 2},
+					{Value: "hold", Label: "Do nothing", Score: 3},
+					{Value: "buy_some", Label: "Buy a little more", Score: 4},
+					{Value: "buy_more", Label: "Buy significantly more", Score: 5},
+				},
+			},
+			{
+				ID:   "loss_tolerance",
+				Text: "What is the largest one-year loss you could accept without changing your plans?",
+				Options: []Option{
+					{Value: "none", Label: "No loss", Score: 1},
+					{Value: "up_to_10", Label: "Up to 10%", Score: 2},
+					{Value: "up_to_20", Label: "Up to 20%", Score: 3},
+					{Value: "up_to_30", Label: "Up to 30%", Score: 4},
+					{Value: "over_30", Label: "More than 30%", Score: 5},
+				},
+			},
+			{
+				ID:   "income_stability",
+				Text: "How stable are your current and future income sources?",
+				Options: []Option{
+					{Value: "very_unstable", Label: "Very unstable", Score: 1},
+					{Value: "unstable", Label: "Somewhat unstable", Score: 2},
+					{Value: "stable", Label: "Somewhat stable", Score: 3},
+					{Value: "very_stable", Label: "Very stable", Score: 4},
+				},
+			},
+			{
+				ID:   "emergency_fund",
+				Text: "How many months of expenses do you hold in cash outside these investments?",
+				Options: []Option{
+					{Value: "none", Label: "None", Score: 1},
+					{Value: "under_3_months", Label: "Less than 3 months", Score: 2},
+					{Value: "3_to_6_months", Label: "3 to 6 months", Score: 3},
+					{Value: "over_6_months", Label: "More than 6 months", Score: 4},
+				},
+			},
+			{
+				ID:   "experience",
+				Text: "How would you describe your investment experience?",
+				Options: []Option{
+					{Value: "none", Label: "None", Score: 1},
+					{Value: "limited", Label: "Limited: savings accounts, CDs or bonds", Score: 2},
+					{Value: "moderate", Label: "Moderate: mutual funds or ETFs", Score: 3},
+					{Value: "extensive", Label: "Extensive: individual stocks, options or alternatives", Score: 4},
+				},
+			},
+		},
+		Bands: []Band{
+			{Profile: Conservative, UpTo: 0.2},
+			{Profile: ModeratelyConservative, UpTo: 0.4},
+			{Profile: Moderate, UpTo: 0.6},
+			{Profile: ModeratelyAggressive, UpTo: 0.8},
+			{Profile: Aggressive, UpTo: 1},
+		},
+	},
+}
+
+// Current returns the questionnaire new assessments should use
+func Current() *Questionnaire {
+	return questionnaires[len(questionnaires)-1]
+}
+
+// Version returns a published questionnaire by version number
+func Version(version int) (*Questionnaire, error) {
+	for _, q := range questionnaires {
+		if q.Version == version {
+			return q, nil
+		}
+	}
+	return nil, ErrUnknownVersion
+}
+
+// rank is a profile's position from least to most risk tolerant
+func rank(profile string) int {
+	for i, p := range Profiles {
+		if p == profile {
+			return i
+		}
+	}
+	return -1
+}
+
+// Label returns a profile in human readable form, such as "moderately aggressive"
+func Label(profile string) string {
+	return strings.ReplaceAll(profile, "_", " ")
+}
+
+// Score checks a set of answers, keyed by question ID, and scores them into a
+// profile. The explanation describes the score band and any answer that capped
+// the profile below it.
+func (q *Questionnaire) Score(answers map[string]string) (*Result, error) {
+	var problems []string
+	result := &Result{Version: q.Version}
+
+	known := make(map[string]bool, len(q.Questions))
+	var caps []Factor
+	for _, question := range q.Questions {
+		known[question.ID] = true
+
+		low, high := question.Options[0].Score, question.Options[0].Score
+		for _, o := range question.Options {
+			low = min(low, o.Score)
+			high = max(high, o.Score)
+		}
+		result.MinScore += low
+		result.MaxScore += high
+
+		answer, ok := answers[question.ID]
+		if !ok || answer == "" {
+			problems = append(problems, fmt.Sprintf("%q is required", question.ID))
+			continue
+		}
+
+		var chosen *Option
+		for i := range question.Options {
+			if question.Options[i].Value == answer {
+				chosen = &question.Options[i]
+				break
+			}
+		}
+		if chosen == nil {
+			problems = append(problems, fmt.Sprintf("%q is not an answer to %q", answer, question.ID))
+			continue
+		}
+
+		factor := Factor{
+			QuestionID: question.ID,
+			Question:   question.Text,
+			Answer:     chosen.Value,
+			Label:      chosen.Label,
+			Score:      chosen.Score,
+			MaxScore:   high,
+			MaxProfile: chosen.MaxProfile,
+		}
+		result.Score += chosen.Score
+		result.Factors = append(result.Factors, factor)
+		if chosen.MaxProfile != "" {
+			caps = append(caps, factor)
+		}
+	}
+
+	var unknown []string
+	for id := range answers {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	sort.Strings(unknown)
+	for _, id := range unknown {
+		problems = append(problems, fmt.Sprintf("%q is not a question in version %d", id, q.Version))
+	}
+
+	if len(problems) > 0 {
+		return nil, &AnswerError{Problems: problems}
+	}
+
+	if span := result.MaxScore - result.MinScore; span > 0 {
+		result.Percent = math.Round(float64(result.Score-result.MinScore)/float64(span)*10000) / 100
+	}
+
+	lower := 0.0
+	for _, band := range q.Bands {
+		if result.Percent/100 <= band.UpTo || band.UpTo >= 1 {
+			result.ScoreProfile = band.Profile
+			result.Explanation = append(result.Explanation, fmt.Sprintf(
+				"A score of %d out of %d (%.0f%% of the range) falls in the %s band (%.0f%% to %.0f%%).",
+				result.Score, result.MaxScore, result.Percent, Label(band.Profile), lower*100, band.UpTo*100))
+			break
+		}
+		lower = band.UpTo
+	}
+
+	result.Profile = result.ScoreProfile
+	for _, f := range caps {
+		if rank(f.MaxProfile) < rank(result.Profile) {
+			result.Profile = f.MaxProfile
+		}
+	}
+	for _, f := range caps {
+		if rank(f.MaxProfile) < rank(result.ScoreProfile) {
+			result.Explanation = append(result.Explanation, fmt.Sprintf(
+				"Answering %q to %q limits the profile to at most %s.",
+				f.Label, f.Question, Label(f.MaxProfile)))
+		}
+	}
+	result.Explanation = append(result.Explanation, fmt.Sprintf("Resulting risk profile: %s.", Label(result.Profile)))
+
+	return result, nil
+}
@@ -0,0 +1,31 @@
+// Package risk defines the investor risk profiles used to choose model
+// portfolios, from conservative to aggressive.
+package risk
+
+import "strings"
+
+// Risk profiles, from least to most risk tolerant
+const (
+	Conservative           = "conservative"
+	ModeratelyConservative = "moderately_conservative"
+	Moderate               = "moderate"
+	ModeratelyAggressive   = "moderately_aggressive"
+	Aggressive             = "aggressive"
+)
+
+// Profiles lists the risk profiles from least to most risk tolerant
+var Profiles = []string{Conservative, ModeratelyConservative, Moderate, ModeratelyAggressive, Aggressive}
+
+// Normalize returns a profile in its canonical form, so "Moderately
+// Aggressive" and "MODERATELY-AGGRESSIVE" both become "moderately_aggressive".
+// It returns "" for values that are not a known profile.
+func Normalize(profile string) string {
+	p := strings.ToLower(strings.TrimSpace(profile))
+	p = strings.NewReplacer(" ", "_", "-", "_").Replace(p)
+	for _, known := range Profiles {
+		if p == known {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,402 @@
+// Package taxreport builds capital gains reports from tax-lot data.
+//
+// A report covers one tax year. Realized gains come from lot disposals and
+// are split by holding period: lots held for more than a year are long-term.
+// Losses are checked against the wash sale rule, which disallows a loss when
+// the same security was bought within 30 days before or after the sale.
+// Open lots are listed with their unrealized gain at the reporting date.
+// Every amount is in the report's currency; callers convert sales and lots
+// held in other currencies before building it.
+package taxreport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/investments/lots"
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
+)
+
+// Holding periods
+const (
+	ShortTerm = "SHORT"
+	LongTerm  = "LONG"
+)
+
+// WashSaleDays is how many calendar days before and after a loss sale a
+// purchase of the same security makes it a wash sale
+const WashSaleDays = 30
+
+// Sale is the part of a sale relieved from one lot
+type Sale struct {
+	TransactionID string
+	AccountID     string
+	AssetID       string
+	Symbol        string
+	Name          string
+	LotID         string
+	Quantity      float64
+	AcquiredAt    time.Time
+	SoldAt        time.Time
+	Proceeds      decimal.Decimal
+	CostBasis     decimal.Decimal
+}
+
+// Purchase is a lot opened by a purchase, considered as a wash sale replacement
+type Purchase struct {
+	LotID      string
+	AccountID  string
+	AssetID    string
+	Quantity   float64
+	AcquiredAt time.Time
+}
+
+// OpenLot is a lot held at the reporting date
+type OpenLot struct {
+	LotID      string
+	AccountID  string
+	AssetID    string
+	Symbol     string
+	Name       string
+	Quantity   float64
+	UnitCost   decimal.Decimal
+	AcquiredAt time.Time
+	Price      decimal.Decimal
+	PriceAsOf  time.Time
+}
+
+// RealizedRow is one line of the report in Form 1099-B layout
+type RealizedRow struct {
+	Description        string          `json:"description"` // Box 1a
+	Symbol             string          `json:"symbol"`
+	Quantity           float64         `json:"quantity"`
+	DateAcquired       time.Time       `json:"date_acquired"`        // Box 1b
+	DateSold           time.Time       `json:"date_sold"`            // Box 1c
+	Proceeds           decimal.Decimal `json:"proceeds"`             // Box 1d
+	CostBasis          decimal.Decimal `json:"cost_basis"`           // Box 1e
+	WashSaleDisallowed decimal.Decimal `json:"wash_sale_disallowed"` // Box 1g
+	GainLoss           decimal.Decimal `json:"gain_loss"`
+	AdjustedGainLoss   decimal.Decimal `json:"adjusted_gain_loss"`
+	Term               string          `json:"term"` // Box 2
+	WashSale           bool            `json:"wash_sale"`
+	AccountID          string          `json:"account_id"`
+	TransactionID      string          `json:"transaction_id"`
+	LotID              string          `json:"lot_id"`
+}
+
+// UnrealizedRow is an open lot with its unrealized gain
+type UnrealizedRow struct {
+	LotID        string          `json:"lot_id"`
+	AccountID    string          `json:"account_id"`
+	Symbol       string          `json:"symbol"`
+	Name         string          `json:"name"`
+	Quantity     float64         `json:"quantity"`
+	DateAcquired time.Time       `json:"date_acquired"`
+	CostBasis    decimal.Decimal `json:"cost_basis"`
+	MarketValue  decimal.Decimal `json:"market_value"`
+	Price        decimal.Decimal `json:"price"`
+	PriceAsOf    time.Time       `json:"price_as_of"`
+	GainLoss     decimal.Decimal `json:"gain_loss"`
+	Term         string          `json:"term"`
+}
+
+// Totals sums one group of report rows
+type Totals struct {
+	Proceeds           decimal.Decimal `json:"proceeds"`
+	CostBasis          decimal.Decimal `json:"cost_basis"`
+	WashSaleDisallowed decimal.Decimal `json:"wash_sale_disallowed"`
+	GainLoss           decimal.Decimal `json:"gain_loss"`
+	AdjustedGainLoss   decimal.Decimal `json:"adjusted_gain_loss"`
+}
+
+// UnrealizedTotals sums open lots
+type UnrealizedTotals struct {
+	CostBasis   decimal.Decimal `json:"cost_basis"`
+	MarketValue decimal.Decimal `json:"market_value"`
+	GainLoss    decimal.Decimal `json:"gain_loss"`
+}
+
+// Summary totals a report by holding period
+type Summary struct {
+	ShortTerm           Totals           `json:"short_term"`
+	LongTerm            Totals           `json:"long_term"`
+	Total               Totals           `json:"total"`
+	WashSales           int              `json:"wash_sales"`
+	UnrealizedShortTerm UnrealizedTotals `json:"unrealized_short_term"`
+	UnrealizedLongTerm  UnrealizedTotals `json:"unrealized_long_term"`
+}
+
+// Report is a tax year's gains report
+type Report struct {
+	ScopeType   string          `json:"scope_type"`
+	ScopeID     string          `json:"scope_id"`
+	TaxYear     int             `json:"tax_year"`
+	Currency    string          `json:"currency"`
+	AccountIDs  []string        `json:"account_ids"`
+	AsOf        time.Time       `json:"as_of"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Summary     Summary         `json:"summary"`
+	Realized    []RealizedRow   `json:"realized"`
+	Unrealized  []UnrealizedRow `json:"unrealized"`
+	Warnings    []string        `json:"warnings,omitempty"`
+}
+
+// YearBounds returns the start of the tax year and the start of the next
+func YearBounds(year int) (time.Time, time.Time) {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(1, 0, 0)
+}
+
+// Build assembles a report from the year's sales, the purchases around them
+// and the lots open at asOf. Purchases must cover WashSaleDays calendar days
+// either side of the year for wash sales to be found at its edges.
+func Build(report Report, sales []Sale, purchases []Purchase, open []OpenLot) Report {
+	sorted := make([]Sale, len(sales))
+	copy(sorted, sales)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].SoldAt.Equal(sorted[j].SoldAt) {
+			return sorted[i].SoldAt.Before(sorted[j].SoldAt)
+		}
+		return sorted[i].LotID < sorted[j].LotID
+	})
+
+	disallowed := washSales(sorted, purchases, report.Currency)
+
+	report.Realized = make([]RealizedRow, 0, len(sorted))
+	for i, s := range sorted {
+		term := ShortTerm
+		if lots.IsLongTerm(s.AcquiredAt, s.SoldAt) {
+			term = LongTerm
+		}
+		proceeds, costBasis := money.Round(s.Proceeds, report.Currency), money.Round(s.CostBasis, report.Currency)
+		gain := proceeds.Sub(costBasis)
+		row := RealizedRow{
+			Description:        fmt.Sprintf("%s SH %s", formatQuantity(s.Quantity), s.Symbol),
+			Symbol:             s.Symbol,
+			Quantity:           s.Quantity,
+			DateAcquired:       s.AcquiredAt,
+			DateSold:           s.SoldAt,
+			Proceeds:           proceeds,
+			CostBasis:          costBasis,
+			WashSaleDisallowed: disallowed[i],
+			GainLoss:           gain,
+			AdjustedGainLoss:   gain.Add(disallowed[i]),
+			Term:               term,
+			WashSale:           disallowed[i].IsPositive(),
+			AccountID:          s.AccountID,
+			TransactionID:      s.TransactionID,
+			LotID:              s.LotID,
+		}
+		report.Realized = append(report.Realized, row)
+
+		totals := &report.Summary.ShortTerm
+		if term == LongTerm {
+			totals = &report.Summary.LongTerm
+		}
+		totals.add(row)
+		report.Summary.Total.add(row)
+		if row.WashSale {
+			report.Summary.WashSales++
+		}
+	}
+
+	report.Unrealized = make([]UnrealizedRow, 0, len(open))
+	for _, l := range open {
+		term := ShortTerm
+		if lots.IsLongTerm(l.AcquiredAt, report.AsOf) {
+			term = LongTerm
+		}
+		quantity := decimal.NewFromFloat(l.Quantity)
+		costBasis := money.Round(l.UnitCost.Mul(quantity), report.Currency)
+		value := money.Round(l.Price.Mul(quantity), report.Currency)
+		row := UnrealizedRow{
+			LotID:        l.LotID,
+			AccountID:    l.AccountID,
+			Symbol:       l.Symbol,
+			Name:         l.Name,
+			Quantity:     l.Quantity,
+			DateAcquired: l.AcquiredAt,
+			CostBasis:    costBasis,
+			MarketValue:  value,
+			Price:        l.Price,
+			PriceAsOf:    l.PriceAsOf,
+			GainLoss:     value.Sub(costBasis),
+			Term:         term,
+		}
+		report.Unrealized = append(report.Unrealized, row)
+
+		totals := &report.Summary.UnrealizedShortTerm
+		if term == LongTerm {
+			totals = &report.Summary.UnrealizedLongTerm
+		}
+		totals.CostBasis = totals.CostBasis.Add(row.CostBasis)
+		totals.MarketValue = totals.MarketValue.Add(row.MarketValue)
+		totals.GainLoss = totals.GainLoss.Add(row.GainLoss)
+	}
+
+	return report
+}
+
+func (t *Totals) add(row RealizedRow) {
+	t.Proceeds = t.Proceeds.Add(row.Proceeds)
+	t.CostBasis = t.CostBasis.Add(row.CostBasis)
+	t.WashSaleDisallowed = t.WashSaleDisallowed.Add(row.WashSaleDisallowed)
+	t.GainLoss = t.GainLoss.Add(row.GainLoss)
+	t.AdjustedGainLoss = t.AdjustedGainLoss.Add(row.AdjustedGainLoss)
+}
+
+// washSales returns the loss disallowed on each sale, in sale order. A loss
+// is disallowed in proportion to the shares replaced by purchases of the same
+// asset within 30 days of the sale; each purchased share replaces at most one
+// sold share, earlier sales first. The lot being sold is not its own
+// replacement. Amounts are rounded to the minor unit of currency.
+func washSales(sales []Sale, purchases []Purchase, currency string) []decimal.Decimal {
+	available := make([]float64, len(purchases))
+	for i, p := range purchases {
+		available[i] = p.Quantity
+	}
+
+	disallowed := make([]decimal.Decimal, len(sales))
+	for i, s := range sales {
+		loss := money.Round(s.CostBasis, currency).Sub(money.Round(s.Proceeds, currency))
+		if !loss.IsPositive() || s.Quantity <= 0 {
+			continue
+		}
+
+		replaced := 0.0
+		for j, p := range purchases {
+			if replaced >= s.Quantity {
+				break
+			}
+			if p.AssetID != s.AssetID || p.LotID == s.LotID || available[j] <= 0 {
+				continue
+			}
+			if !inWashSaleWindow(s.SoldAt, p.AcquiredAt) {
+				continue
+			}
+			take := math.Min(available[j], s.Quantity-replaced)
+			available[j] -= take
+			replaced += take
+		}
+
+		if replaced > 0 {
+			disallowed[i] = money.Round(loss.Mul(decimal.NewFromFloat(replaced)).Div(decimal.NewFromFloat(s.Quantity)), currency)
+		}
+	}
+	return disallowed
+}
+
+// inWashSaleWindow reports whether a purchase at bought falls within
+// WashSaleDays calendar days of a sale at sold, whatever the time of day
+func inWashSaleWindow(sold, bought time.Time) bool {
+	soldDate, boughtDate := calendarDate(sold), calendarDate(bought)
+	return !boughtDate.Before(soldDate.AddDate(0, 0, -WashSaleDays)) &&
+		!boughtDate.After(soldDate.AddDate(0, 0, WashSaleDays))
+}
+
+// calendarDate returns t's UTC date at midnight
+func calendarDate(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// realizedCSVHeader is the CSV header for realized rows
+var realizedCSVHeader = []string{
+	"1a Description", "Symbol", "Quantity", "1b Date Acquired", "1c Date Sold",
+	"1d Proceeds", "1e Cost Basis", "1g Wash Sale Loss Disallowed", "Gain/Loss",
+	"Adjusted Gain/Loss", "2 Term", "Account ID", "Transaction ID", "Lot ID",
+}
+
+// unrealizedCSVHeader is the CSV header for unrealized rows
+var unrealizedCSVHeader = []string{
+	"Symbol", "Name", "Quantity", "Date Acquired", "Cost Basis", "Price",
+	"Price As Of", "Market Value", "Unrealized Gain/Loss", "Term", "Account ID", "Lot ID",
+}
+
+// WriteRealizedCSV writes the report's realized rows in 1099-B column order,
+// short-term sales first, each section followed by its totals
+func WriteRealizedCSV(w io.Writer, report Report) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(realizedCSVHeader); err != nil {
+		return err
+	}
+
+	for _, term := range []string{ShortTerm, LongTerm} {
+		totals := report.Summary.ShortTerm
+		if term == LongTerm {
+			totals = report.Summary.LongTerm
+		}
+		for _, r := range report.Realized {
+			if r.Term != term {
+				continue
+			}
+			out.Write([]string{
+				r.Description, r.Symbol, formatQuantity(r.Quantity),
+				formatDate(r.DateAcquired), formatDate(r.DateSold),
+				formatAmount(r.Proceeds, report.Currency), formatAmount(r.CostBasis, report.Currency),
+				formatAmount(r.WashSaleDisallowed, report.Currency), formatAmount(r.GainLoss, report.Currency),
+				formatAmount(r.AdjustedGainLoss, report.Currency), termLabel(r.Term),
+				r.AccountID, r.TransactionID, r.LotID,
+			})
+		}
+		out.Write([]string{
+			"Total " + termLabel(term), "", "", "", "",
+			formatAmount(totals.Proceeds, report.Currency), formatAmount(totals.CostBasis, report.Currency),
+			formatAmount(totals.WashSaleDisallowed, report.Currency), formatAmount(totals.GainLoss, report.Currency),
+			formatAmount(totals.AdjustedGainLoss, report.Currency), termLabel(term), "", "", "",
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// WriteUnrealizedCSV writes the report's open lots
+func WriteUnrealizedCSV(w io.Writer, report Report) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(unrealizedCSVHeader); err != nil {
+		return err
+	}
+
+	for _, r := range report.Unrealized {
+		out.Write([]string{
+			r.Symbol, r.Name, formatQuantity(r.Quantity), formatDate(r.DateAcquired),
+			formatAmount(r.CostBasis, report.Currency), formatAmount(r.Price, report.Currency), formatDate(r.PriceAsOf),
+			formatAmount(r.MarketValue, report.Currency), formatAmount(r.GainLoss, report.Currency), termLabel(r.Term),
+			r.AccountID, r.LotID,
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// termLabel returns the holding period as printed on Form 1099-B
+func termLabel(term string) string {
+	if term == LongTerm {
+		return "Long-term"
+	}
+	return "Short-term"
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("01/02/2006")
+}
+
+func formatAmount(amount decimal.Decimal, currency string) string {
+	return amount.StringFixed(money.Places(currency))
+}
+
+func formatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', -1, 64)
+}
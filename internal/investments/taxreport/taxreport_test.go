@@ -0,0 +1,75 @@
+package taxreport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestWashSaleWindow(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	sale := Sale{
+		LotID:      "sold",
+		AssetID:    "asset",
+		Quantity:   10,
+		AcquiredAt: at("2023-01-05 10:00"),
+		SoldAt:     at("2024-03-15 10:00"),
+		Proceeds:   decimal.NewFromInt(800),
+		CostBasis:  decimal.NewFromInt(1000),
+	}
+
+	tests := []struct {
+		name   string
+		bought string
+		want   int64
+	}{
+		{"31 days before", "2024-02-13 23:59", 0},
+		{"30 days before early in the day", "2024-02-14 00:01", 200},
+		{"same day", "2024-03-15 16:00", 200},
+		{"30 days after late in the day", "2024-04-14 23:59", 200},
+		{"31 days after", "2024-04-15 00:01", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purchases := []Purchase{{LotID: "bought", AssetID: "asset", Quantity: 10, AcquiredAt: at(tt.bought)}}
+			report := Build(Report{AsOf: at("2024-12-31 00:00")}, []Sale{sale}, purchases, nil)
+			if got := report.Realized[0].WashSaleDisallowed; !got.Equal(decimal.NewFromInt(tt.want)) {
+				t.Errorf("disallowed %s, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWashSalePartialReplacement(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.March, d, 12, 0, 0, 0, time.UTC) }
+	sales := []Sale{
+		{LotID: "a", AssetID: "asset", Quantity: 10, AcquiredAt: day(1), SoldAt: day(10), Proceeds: decimal.NewFromInt(900), CostBasis: decimal.NewFromInt(1000)},
+		{LotID: "b", AssetID: "asset", Quantity: 10, AcquiredAt: day(1), SoldAt: day(11), Proceeds: decimal.NewFromInt(900), CostBasis: decimal.NewFromInt(1000)},
+	}
+	purchases := []Purchase{
+		{LotID: "c", AssetID: "asset", Quantity: 5, AcquiredAt: day(12)},
+		{LotID: "d", AssetID: "other", Quantity: 10, AcquiredAt: day(12)},
+	}
+
+	report := Build(Report{AsOf: day(31)}, sales, purchases, nil)
+	// Lot c replaces half of the first sale and none is left for the second
+	if got := report.Realized[0].WashSaleDisallowed; !got.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("first sale disallowed %s, want 50", got)
+	}
+	if got := report.Realized[1].WashSaleDisallowed; !got.IsZero() {
+		t.Errorf("second sale disallowed %s, want 0", got)
+	}
+	if got := report.Summary.Total.AdjustedGainLoss; !got.Equal(decimal.NewFromInt(-150)) {
+		t.Errorf("adjusted loss %s, want -150", got)
+	}
+	if report.Summary.WashSales != 1 {
+		t.Errorf("%d wash sales, want 1", report.Summary.WashSales)
+	}
+}
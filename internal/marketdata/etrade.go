@@ -0,0 +1,58 @@
+package marketdata
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/etrade/models"
+)
+
+// ETradeQuoteClient is the part of the E-Trade client the provider uses
+type ETradeQuoteClient interface {
+	GetQuotes(symbols []string) ([]models.ETradeQuote, error)
+}
+
+// ETradeProvider fetches quotes from the E-Trade market API. E-Trade quotes
+// US listings, so prices are in US dollars.
+type ETradeProvider struct {
+	client ETradeQuoteClient
+}
+
+// NewETradeProvider creates a provider backed by an authenticated E-Trade client
+func NewETradeProvider(client ETradeQuoteClient) *ETradeProvider {
+	return &ETradeProvider{client: client}
+}
+
+// Name returns the provider name
+func (p *ETradeProvider) Name() string {
+	return "etrade"
+}
+
+// Quotes returns E-Trade's last trade price for each symbol
+func (p *ETradeProvider) Quotes(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	if len(symbols) == 0 {
+		return map[string]Quote{}, nil
+	}
+
+	results, err := p.client.GetQuotes(symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]Quote, len(results))
+	for _, result := range results {
+		// Symbols E-Trade cannot price come back without a last trade
+		if result.LastPrice <= 0 {
+			continue
+		}
+		symbol := NormalizeSymbol(result.Symbol)
+		quotes[symbol] = Quote{
+			Symbol:   symbol,
+			Price:    decimal.NewFromFloat(result.LastPrice),
+			Currency: DefaultCurrency,
+			AsOf:     result.QuoteTime,
+		}
+	}
+	return quotes, nil
+}
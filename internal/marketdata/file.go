@@ -0,0 +1,157 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultCurrency is used for quotes that do not name a currency
+const DefaultCurrency = "USD"
+
+// FileProvider reads quotes from a CSV file with a header row naming at least
+// the symbol and price columns, and optionally currency and as_of:
+//
+//	symbol,price,currency,as_of
+//	AAPL,189.84,USD,2024-05-01T20:00:00Z
+//	VTI,254.12,USD,2024-05-01
+//
+// The file is read on every call, so replacing it takes effect on the next
+// refresh. Quotes without an as_of use the file's modification time.
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a provider that reads quotes from path
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+// Name returns the provider name
+func (p *FileProvider) Name() string {
+	return "file"
+}
+
+// Quotes returns the quotes in the file for the requested symbols
+func (p *FileProvider) Quotes(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open market data file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read market data file: %w", err)
+	}
+
+	all, err := ParseCSV(f, info.ModTime())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	quotes := make(map[string]Quote, len(symbols))
+	for _, symbol := range symbols {
+		if quote, ok := all[NormalizeSymbol(symbol)]; ok {
+			quotes[quote.Symbol] = quote
+		}
+	}
+	return quotes, nil
+}
+
+// ParseCSV reads quotes in the FileProvider format. Quotes without an as_of
+// are dated defaultAsOf. When a symbol appears more than once, the latest
+// quote wins.
+func ParseCSV(r io.Reader, defaultAsOf time.Time) (map[string]Quote, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return map[string]Quote{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	symbolCol, ok := columns["symbol"]
+	if !ok {
+		return nil, errors.New("missing symbol column")
+	}
+	priceCol, ok := columns["price"]
+	if !ok {
+		return nil, errors.New("missing price column")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	quotes := make(map[string]Quote)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if symbolCol >= len(record) || priceCol >= len(record) {
+			return nil, fmt.Errorf("line %d: missing symbol or price", line)
+		}
+		symbol := NormalizeSymbol(record[symbolCol])
+		if symbol == "" {
+			return nil, fmt.Errorf("line %d: missing symbol", line)
+		}
+
+		price, err := decimal.NewFromString(strings.TrimSpace(record[priceCol]))
+		if err != nil || !price.IsPositive() {
+			return nil, fmt.Errorf("line %d: invalid price %q", line, record[priceCol])
+		}
+
+		currency := strings.ToUpper(field(record, "currency"))
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+
+		asOf := defaultAsOf
+		if value := field(record, "as_of"); value != "" {
+			asOf, err = parseAsOf(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid as_of %q", line, value)
+			}
+		}
+
+		if existing, ok := quotes[symbol]; ok && existing.AsOf.After(asOf) {
+			continue
+		}
+		quotes[symbol] = Quote{Symbol: symbol, Price: price, Currency: currency, AsOf: asOf}
+	}
+
+	return quotes, nil
+}
+
+// parseAsOf accepts RFC 3339 timestamps and plain dates
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
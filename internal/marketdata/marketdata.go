@@ -0,0 +1,38 @@
+// Package marketdata supplies current prices for investment assets.
+//
+// A Provider returns quotes for a set of symbols. FileProvider reads prices
+// from a CSV file so prices can be refreshed without network access, and
+// ETradeProvider fetches live quotes from the E-Trade market API.
+package marketdata
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Quote is the latest price of one symbol
+type Quote struct {
+	Symbol   string          `json:"symbol"`
+	Price    decimal.Decimal `json:"price"`
+	Currency string          `json:"currency"`
+	AsOf     time.Time       `json:"as_of"`
+}
+
+// Provider returns quotes for symbols
+type Provider interface {
+	// Name identifies the provider in logs and refresh results
+	Name() string
+
+	// Quotes returns the latest quote for each symbol the provider knows,
+	// keyed by upper-case symbol. Symbols without a quote are left out
+	// rather than reported as errors.
+	Quotes(ctx context.Context, symbols []string) (map[string]Quote, error)
+}
+
+// NormalizeSymbol returns the form symbols are keyed by
+func NormalizeSymbol(symbol string) string {
+	return strings.ToUpper(strings.TrimSpace(symbol))
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/leonardovarelatrust/TrustAInvest.com/internal/money"
 )
 
 // User represents a user in the system
@@ -51,10 +53,7 @@ type Account struct {
 }
 
 // Money represents a monetary amount with currency
-type Money struct {
-	Amount   float64 `json:"amount"`
-	Currency string  `json:"currency"`
-}
+type Money = money.Money
 
 // Beneficiary represents a beneficiary on an account
 type Beneficiary struct {
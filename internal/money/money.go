@@ -0,0 +1,187 @@
+// Package money represents monetary amounts exactly.
+//
+// Amounts are decimals rather than floats so balances and gains add up to
+// the penny. Every Money is rounded to the minor unit of its currency:
+// cents for US dollars and euros, whole yen, thousandths of a Kuwaiti dinar.
+// Amounts are encoded in JSON as strings so clients do not round them through
+// floating point either.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are combined
+var ErrCurrencyMismatch = errors.New("currencies differ")
+
+// minorUnits lists the ISO 4217 currencies whose minor unit is not a
+// hundredth of the major unit
+var minorUnits = map[string]int32{
+	"BHD": 3,
+	"CLP": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"UGX": 0,
+	"VND": 0,
+}
+
+// Money is an amount in a currency
+type Money struct {
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// Places returns the number of decimal places in a currency's minor unit
+func Places(currency string) int32 {
+	if places, ok := minorUnits[normalizeCurrency(currency)]; ok {
+		return places
+	}
+	return 2
+}
+
+// Round rounds an amount to the minor unit of a currency, half away from zero
+func Round(amount decimal.Decimal, currency string) decimal.Decimal {
+	return amount.Round(Places(currency))
+}
+
+// New returns amount in currency, rounded to the currency's minor unit
+func New(amount decimal.Decimal, currency string) Money {
+	currency = normalizeCurrency(currency)
+	return Money{Amount: Round(amount, currency), Currency: currency}
+}
+
+// FromFloat returns a float amount as money. It is meant for amounts that
+// arrive as floats, such as JSON numbers from other systems, and takes the
+// shortest decimal that represents the float before rounding.
+func FromFloat(amount float64, currency string) Money {
+	return New(decimal.NewFromFloat(amount), currency)
+}
+
+// Parse reads an amount written as a decimal string
+func Parse(amount, currency string) (Money, error) {
+	d, err := decimal.NewFromString(strings.TrimSpace(amount))
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	return New(d, currency), nil
+}
+
+// Zero returns no money in a currency
+func Zero(currency string) Money {
+	return New(decimal.Zero, currency)
+}
+
+// Round returns m rounded to its currency's minor unit. Amounts scanned from
+// the database carry the column's scale and should be rounded before use.
+func (m Money) Round() Money {
+	return New(m.Amount, m.Currency)
+}
+
+// Add returns m plus o, which must be in the same currency
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	return New(m.Amount.Add(o.Amount), m.Currency), nil
+}
+
+// Sub returns m minus o, which must be in the same currency
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	return New(m.Amount.Sub(o.Amount), m.Currency), nil
+}
+
+// Mul returns m multiplied by factor, rounded to the minor unit
+func (m Money) Mul(factor decimal.Decimal) Money {
+	return New(m.Amount.Mul(factor), m.Currency)
+}
+
+// Percent returns percent percent of m, rounded to the minor unit
+func (m Money) Percent(percent decimal.Decimal) Money {
+	return m.Mul(percent.Div(decimal.NewFromInt(100)))
+}
+
+// Min returns the smaller of m and o, which must be in the same currency
+func (m Money) Min(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if o.Amount.LessThan(m.Amount) {
+		return o, nil
+	}
+	return m, nil
+}
+
+// IsZero reports whether m is zero
+func (m Money) IsZero() bool {
+	return m.Amount.IsZero()
+}
+
+// IsPositive reports whether m is greater than zero
+func (m Money) IsPositive() bool {
+	return m.Amount.IsPositive()
+}
+
+// Sum adds amounts, which must all be in currency
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Zero(currency)
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// String formats m with its currency's minor unit, as in "1234.50 USD"
+func (m Money) String() string {
+	return m.Amount.StringFixed(Places(m.Currency)) + " " + m.Currency
+}
+
+// MarshalJSON encodes the amount as a string with its currency's minor unit
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Amount.StringFixed(Places(m.Currency)), m.Currency})
+}
+
+// UnmarshalJSON accepts the amount as a string or a number and rounds it to
+// its currency's minor unit
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Amount   decimal.Decimal `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = New(raw.Amount, raw.Currency)
+	return nil
+}
+
+func (m Money) sameCurrency(o Money) error {
+	if normalizeCurrency(m.Currency) != normalizeCurrency(o.Currency) {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return nil
+}
+
+func normalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
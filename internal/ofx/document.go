@@ -0,0 +1,164 @@
+package ofx
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"strings"
+)
+
+// element is a node of an OFX document. Aggregates have children and leaf
+// elements have a value.
+type element struct {
+	name     string
+	value    string
+	children []*element
+}
+
+// parseDocument reads the element tree of an OFX file and returns its OFX
+// element. The SGML of OFX 1.x leaves leaf elements unclosed, so a leaf ends
+// where the next tag starts; closing an aggregate closes everything open
+// inside it. XML files of OFX 2.x read the same way. Headers, processing
+// instructions and comments are skipped.
+func parseDocument(data []byte) (*element, error) {
+	start := indexTag(data, "OFX")
+	if start < 0 {
+		return nil, errors.New("not an OFX file")
+	}
+
+	root := &element{}
+	stack := []*element{root}
+	rest := data[start:]
+	for len(rest) > 0 {
+		open := bytes.IndexByte(rest, '<')
+		if open < 0 {
+			open = len(rest)
+		}
+		if value := strings.TrimSpace(string(rest[:open])); value != "" {
+			if top := stack[len(stack)-1]; top != root && len(top.children) == 0 {
+				top.value = html.UnescapeString(value)
+			}
+		}
+		if open == len(rest) {
+			break
+		}
+
+		rest = rest[open:]
+		end := bytes.IndexByte(rest, '>')
+		if end < 0 {
+			return nil, errors.New("unterminated tag")
+		}
+		tag := strings.TrimSpace(string(rest[1:end]))
+		rest = rest[end+1:]
+
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+			continue
+		case tag[0] == '/':
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		default:
+			selfClosing := strings.HasSuffix(tag, "/")
+			fields := strings.Fields(strings.TrimSuffix(tag, "/"))
+			if len(fields) == 0 {
+				continue
+			}
+			if top := stack[len(stack)-1]; top != root && top.value != "" {
+				stack = stack[:len(stack)-1]
+			}
+			el := &element{name: strings.ToUpper(fields[0])}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, el)
+			if !selfClosing {
+				stack = append(stack, el)
+			}
+		}
+	}
+
+	doc := root.child("OFX")
+	if doc == nil {
+		return nil, errors.New("not an OFX file")
+	}
+	return doc, nil
+}
+
+// indexTag returns where the opening tag <name> first appears in data,
+// ignoring case, or -1. Files are often Latin-1, so data is compared byte by
+// byte rather than upper-cased as UTF-8.
+func indexTag(data []byte, name string) int {
+	tag := "<" + name + ">"
+	for i := 0; i+len(tag) <= len(data); i++ {
+		if data[i] == '<' && strings.EqualFold(string(data[i:i+len(tag)]), tag) {
+			return i
+		}
+	}
+	return -1
+}
+
+// child follows a path of names through direct children and returns the
+// first element found, or nil
+func (e *element) child(path ...string) *element {
+	for _, name := range path {
+		if e == nil {
+			return nil
+		}
+		var next *element
+		for _, c := range e.children {
+			if c.name == name {
+				next = c
+				break
+			}
+		}
+		e = next
+	}
+	return e
+}
+
+// text returns the value of the leaf at a path below e, or "" when there is
+// none
+func (e *element) text(path ...string) string {
+	if c := e.child(path...); c != nil {
+		return c.value
+	}
+	return ""
+}
+
+// items returns the children of e, or nil when e is nil
+func (e *element) items() []*element {
+	if e == nil {
+		return nil
+	}
+	return e.children
+}
+
+// find returns the first element with a name anywhere below e, or nil
+func (e *element) find(name string) *element {
+	for _, c := range e.items() {
+		if c.name == name {
+			return c
+		}
+		if found := c.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findAll returns every element with a name below e in document order. The
+// elements found are not searched further.
+func (e *element) findAll(name string) []*element {
+	var found []*element
+	for _, c := range e.items() {
+		if c.name == name {
+			found = append(found, c)
+			continue
+		}
+		found = append(found, c.findAll(name)...)
+	}
+	return found
+}
@@ -0,0 +1,464 @@
+// Package ofx reads OFX and QFX statement files.
+//
+// Both the SGML of OFX 1.x and the XML of OFX 2.x are read; QFX files are
+// OFX with Intuit extensions, which are ignored. A file carries statements
+// for one or more bank, credit card or investment accounts. Each Statement
+// has the account's balance and transactions, and an investment statement
+// also has the account's positions, with securities named by ticker from the
+// file's security list.
+package ofx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Account types. Bank statements carry their own type: CHECKING, SAVINGS,
+// MONEYMRKT, CREDITLINE or CD.
+const (
+	AccountCreditCard = "CREDITCARD"
+	AccountInvestment = "INVESTMENT"
+)
+
+// File is the content of an OFX file
+type File struct {
+	// Institution is the name of the institution that produced the file,
+	// when it is given
+	Institution string
+	Statements  []Statement
+}
+
+// Statement is one account's statement
+type Statement struct {
+	AccountID string
+	// InstitutionID is the bank's routing number or the broker's ID, when
+	// it is given
+	InstitutionID string
+	Type          string
+	Currency      string
+	// Start and End are the period the transactions cover, when given
+	Start time.Time
+	End   time.Time
+	// Balance is the ledger balance of a bank or credit card account and
+	// the cash plus the market value of the positions of an investment
+	// account, as of BalanceAsOf. BalanceAsOf is zero when the statement
+	// has no balance.
+	Balance      decimal.Decimal
+	BalanceAsOf  time.Time
+	Transactions []Transaction
+	// Positions are the holdings of an investment account; they are nil
+	// for other accounts
+	Positions []Position
+}
+
+// Transaction is a transaction on a statement
+type Transaction struct {
+	// FITID is the institution's ID for the transaction, unique within the
+	// account
+	FITID string
+	// Type is the transaction type of a bank transaction, such as DEBIT or
+	// CHECK, or the kind of an investment transaction, such as BUYSTOCK or
+	// INCOME
+	Type string
+	// Date is when the transaction was made and Posted when it was posted
+	// or settled
+	Date     time.Time
+	Posted   time.Time
+	Amount   decimal.Decimal
+	Name     string
+	Memo     string
+	Category string
+	// Symbol and Units are the security and number of shares of an
+	// investment transaction, and UnitPrice and Fees what each share cost
+	// and the commission, fees, load and taxes paid on top
+	Symbol    string
+	Units     float64
+	UnitPrice decimal.Decimal
+	Fees      decimal.Decimal
+}
+
+// Position is a holding on an investment statement
+type Position struct {
+	// Symbol is the security's ticker, or its CUSIP when the file lists no
+	// ticker for it
+	Symbol string
+	Name   string
+	// Units is negative for a short position
+	Units       float64
+	UnitPrice   decimal.Decimal
+	MarketValue decimal.Decimal
+}
+
+// security is an entry of the file's security list
+type security struct {
+	symbol string
+	name   string
+}
+
+// Parse reads an OFX or QFX file
+func Parse(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Institution: doc.text("SIGNONMSGSRSV1", "SONRS", "FI", "ORG")}
+	securities := readSecurities(doc)
+
+	for _, rs := range doc.findAll("STMTRS") {
+		from := rs.child("BANKACCTFROM")
+		s, err := readBankStatement(rs, from, strings.ToUpper(from.text("ACCTTYPE")))
+		if err != nil {
+			return nil, err
+		}
+		file.Statements = append(file.Statements, s)
+	}
+	for _, rs := range doc.findAll("CCSTMTRS") {
+		s, err := readBankStatement(rs, rs.child("CCACCTFROM"), AccountCreditCard)
+		if err != nil {
+			return nil, err
+		}
+		file.Statements = append(file.Statements, s)
+	}
+	for _, rs := range doc.findAll("INVSTMTRS") {
+		s, err := readInvestmentStatement(rs, securities)
+		if err != nil {
+			return nil, err
+		}
+		file.Statements = append(file.Statements, s)
+	}
+
+	if len(file.Statements) == 0 {
+		return nil, errors.New("file has no statements")
+	}
+	return file, nil
+}
+
+// readBankStatement reads a bank or credit card statement
+func readBankStatement(rs, from *element, accountType string) (Statement, error) {
+	s := Statement{
+		AccountID:     from.text("ACCTID"),
+		InstitutionID: from.text("BANKID"),
+		Type:          accountType,
+		Currency:      strings.ToUpper(rs.text("CURDEF")),
+	}
+	if s.AccountID == "" {
+		return s, errors.New("statement has no account ID")
+	}
+
+	list := rs.child("BANKTRANLIST")
+	if err := readPeriod(&s, list); err != nil {
+		return s, err
+	}
+
+	if balance := rs.child("LEDGERBAL"); balance != nil {
+		amount, err := parseAmount(balance.text("BALAMT"))
+		if err != nil {
+			return s, fmt.Errorf("account %s: invalid balance: %w", s.AccountID, err)
+		}
+		asOf, err := parseDate(balance.text("DTASOF"))
+		if err != nil {
+			return s, fmt.Errorf("account %s: invalid balance date: %w", s.AccountID, err)
+		}
+		s.Balance, s.BalanceAsOf = amount, asOf
+	}
+
+	for _, trn := range list.items() {
+		if trn.name != "STMTTRN" {
+			continue
+		}
+		t, err := readBankTransaction(trn)
+		if err != nil {
+			return s, fmt.Errorf("account %s: %w", s.AccountID, err)
+		}
+		s.Transactions = append(s.Transactions, t)
+	}
+	return s, nil
+}
+
+// readBankTransaction reads an STMTTRN aggregate
+func readBankTransaction(trn *element) (Transaction, error) {
+	t := Transaction{
+		FITID: trn.text("FITID"),
+		Type:  strings.ToUpper(trn.text("TRNTYPE")),
+		Name:  trn.text("NAME"),
+		Memo:  trn.text("MEMO"),
+	}
+	if t.FITID == "" {
+		return t, errors.New("transaction has no FITID")
+	}
+	if t.Name == "" {
+		t.Name = trn.text("PAYEE", "NAME")
+	}
+	if t.Name == "" && trn.text("CHECKNUM") != "" {
+		t.Name = "Check " + trn.text("CHECKNUM")
+	}
+
+	posted, err := parseDate(trn.text("DTPOSTED"))
+	if err != nil {
+		return t, fmt.Errorf("transaction %s: invalid posted date: %w", t.FITID, err)
+	}
+	t.Date, t.Posted = posted, posted
+	if value := trn.text("DTUSER"); value != "" {
+		if t.Date, err = parseDate(value); err != nil {
+			return t, fmt.Errorf("transaction %s: invalid date: %w", t.FITID, err)
+		}
+	}
+
+	if t.Amount, err = parseAmount(trn.text("TRNAMT")); err != nil {
+		return t, fmt.Errorf("transaction %s: invalid amount: %w", t.FITID, err)
+	}
+	return t, nil
+}
+
+// readInvestmentStatement reads an investment statement with its positions
+func readInvestmentStatement(rs *element, securities map[string]security) (Statement, error) {
+	from := rs.child("INVACCTFROM")
+	s := Statement{
+		AccountID:     from.text("ACCTID"),
+		InstitutionID: from.text("BROKERID"),
+		Type:          AccountInvestment,
+		Currency:      strings.ToUpper(rs.text("CURDEF")),
+		Positions:     []Position{},
+	}
+	if s.AccountID == "" {
+		return s, errors.New("statement has no account ID")
+	}
+
+	var err error
+	if value := rs.text("DTASOF"); value != "" {
+		if s.BalanceAsOf, err = parseDate(value); err != nil {
+			return s, fmt.Errorf("account %s: invalid statement date: %w", s.AccountID, err)
+		}
+	}
+	cash, err := optionalAmount(rs.text("INVBAL", "AVAILCASH"))
+	if err != nil {
+		return s, fmt.Errorf("account %s: invalid cash balance: %w", s.AccountID, err)
+	}
+	s.Balance = cash
+
+	for _, holding := range rs.child("INVPOSLIST").items() {
+		pos := holding.child("INVPOS")
+		if pos == nil {
+			continue
+		}
+		p, err := readPosition(pos, securities)
+		if err != nil {
+			return s, fmt.Errorf("account %s: %w", s.AccountID, err)
+		}
+		s.Positions = append(s.Positions, p)
+		s.Balance = s.Balance.Add(p.MarketValue)
+	}
+
+	list := rs.child("INVTRANLIST")
+	if err := readPeriod(&s, list); err != nil {
+		return s, err
+	}
+	for _, trn := range list.items() {
+		var t Transaction
+		var err error
+		switch trn.name {
+		case "DTSTART", "DTEND":
+			continue
+		case "INVBANKTRAN":
+			t, err = readBankTransaction(trn.child("STMTTRN"))
+		default:
+			t, err = readInvestmentTransaction(trn, securities)
+		}
+		if err != nil {
+			return s, fmt.Errorf("account %s: %w", s.AccountID, err)
+		}
+		s.Transactions = append(s.Transactions, t)
+	}
+	return s, nil
+}
+
+// readPosition reads an INVPOS aggregate
+func readPosition(pos *element, securities map[string]security) (Position, error) {
+	p := Position{}
+	p.Symbol, p.Name = securityOf(pos.child("SECID"), securities)
+	if p.Symbol == "" {
+		return p, errors.New("position has no security")
+	}
+
+	var err error
+	if p.Units, err = strconv.ParseFloat(strings.TrimSpace(pos.text("UNITS")), 64); err != nil {
+		return p, fmt.Errorf("position %s: invalid units %q", p.Symbol, pos.text("UNITS"))
+	}
+	if strings.EqualFold(pos.text("POSTYPE"), "SHORT") && p.Units > 0 {
+		p.Units = -p.Units
+	}
+	if p.UnitPrice, err = optionalAmount(pos.text("UNITPRICE")); err != nil {
+		return p, fmt.Errorf("position %s: invalid unit price: %w", p.Symbol, err)
+	}
+	if p.MarketValue, err = optionalAmount(pos.text("MKTVAL")); err != nil {
+		return p, fmt.Errorf("position %s: invalid market value: %w", p.Symbol, err)
+	}
+	return p, nil
+}
+
+// readInvestmentTransaction reads an investment transaction such as
+// BUYSTOCK, SELLMF, INCOME or REINVEST. Its details sit in INVTRAN, and the
+// security, units and total are found wherever the kind of transaction
+// keeps them.
+func readInvestmentTransaction(trn *element, securities map[string]security) (Transaction, error) {
+	inv := trn.find("INVTRAN")
+	t := Transaction{
+		FITID:    inv.text("FITID"),
+		Type:     trn.name,
+		Memo:     inv.text("MEMO"),
+		Category: strings.ToUpper(trn.find("INCOMETYPE").text()),
+	}
+	if t.FITID == "" {
+		return t, fmt.Errorf("%s transaction has no FITID", trn.name)
+	}
+
+	var err error
+	if t.Date, err = parseDate(inv.text("DTTRADE")); err != nil {
+		return t, fmt.Errorf("transaction %s: invalid trade date: %w", t.FITID, err)
+	}
+	t.Posted = t.Date
+	if value := inv.text("DTSETTLE"); value != "" {
+		if t.Posted, err = parseDate(value); err != nil {
+			return t, fmt.Errorf("transaction %s: invalid settlement date: %w", t.FITID, err)
+		}
+	}
+
+	t.Symbol, t.Name = securityOf(trn.find("SECID"), securities)
+	if value := strings.TrimSpace(trn.find("UNITS").text()); value != "" {
+		if t.Units, err = strconv.ParseFloat(value, 64); err != nil {
+			return t, fmt.Errorf("transaction %s: invalid units %q", t.FITID, value)
+		}
+	}
+	if t.Amount, err = optionalAmount(trn.find("TOTAL").text()); err != nil {
+		return t, fmt.Errorf("transaction %s: invalid total: %w", t.FITID, err)
+	}
+	if t.UnitPrice, err = optionalAmount(trn.find("UNITPRICE").text()); err != nil {
+		return t, fmt.Errorf("transaction %s: invalid unit price: %w", t.FITID, err)
+	}
+	for _, charge := range []string{"COMMISSION", "FEES", "LOAD", "TAXES"} {
+		amount, err := optionalAmount(trn.find(charge).text())
+		if err != nil {
+			return t, fmt.Errorf("transaction %s: invalid %s: %w", t.FITID, strings.ToLower(charge), err)
+		}
+		t.Fees = t.Fees.Add(amount)
+	}
+	return t, nil
+}
+
+// readSecurities reads the file's security list by unique ID
+func readSecurities(doc *element) map[string]security {
+	securities := make(map[string]security)
+	for _, info := range doc.find("SECLIST").items() {
+		sec := info.child("SECINFO")
+		id := sec.text("SECID", "UNIQUEID")
+		if id == "" {
+			continue
+		}
+		securities[id] = security{
+			symbol: strings.ToUpper(strings.TrimSpace(sec.text("TICKER"))),
+			name:   sec.text("SECNAME"),
+		}
+	}
+	return securities
+}
+
+// securityOf returns the symbol and name of the security a SECID refers to
+func securityOf(secID *element, securities map[string]security) (string, string) {
+	id := strings.TrimSpace(secID.text("UNIQUEID"))
+	sec := securities[id]
+	if sec.symbol != "" {
+		return sec.symbol, sec.name
+	}
+	return strings.ToUpper(id), sec.name
+}
+
+// readPeriod reads the period a transaction list covers
+func readPeriod(s *Statement, list *element) error {
+	var err error
+	if value := list.text("DTSTART"); value != "" {
+		if s.Start, err = parseDate(value); err != nil {
+			return fmt.Errorf("account %s: invalid start date: %w", s.AccountID, err)
+		}
+	}
+	if value := list.text("DTEND"); value != "" {
+		if s.End, err = parseDate(value); err != nil {
+			return fmt.Errorf("account %s: invalid end date: %w", s.AccountID, err)
+		}
+	}
+	return nil
+}
+
+// parseDate reads an OFX date such as 20240503, 20240503120000 or
+// 20240503120000.000[-5:EST]. Dates without a time zone are UTC.
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	original := value
+
+	loc := time.UTC
+	if i := strings.IndexByte(value, '['); i >= 0 {
+		zone := strings.TrimSuffix(value[i+1:], "]")
+		value = value[:i]
+		name := ""
+		if j := strings.IndexByte(zone, ':'); j >= 0 {
+			zone, name = zone[:j], zone[j+1:]
+		}
+		hours, err := strconv.ParseFloat(zone, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a date", original)
+		}
+		loc = time.FixedZone(name, int(hours*3600))
+	}
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("%q is not a date", original)
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date", original)
+	}
+	return t, nil
+}
+
+// parseAmount reads an amount, which some institutions write with a decimal
+// comma
+func parseAmount(value string) (decimal.Decimal, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "+")
+	if !strings.Contains(value, ".") {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	amount, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%q is not a number", value)
+	}
+	return amount, nil
+}
+
+// optionalAmount reads an amount that may be left out, which is zero
+func optionalAmount(value string) (decimal.Decimal, error) {
+	if strings.TrimSpace(value) == "" {
+		return decimal.Zero, nil
+	}
+	return parseAmount(value)
+}
@@ -0,0 +1,245 @@
+// Package orders models brokerage orders for equities and ETFs: the order
+// request, the states an order moves through once placed, and the Broker
+// interface orders are routed through.
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Order sides
+const (
+	SideBuy  = "BUY"
+	SideSell = "SELL"
+)
+
+// Order types
+const (
+	TypeMarket = "MARKET"
+	TypeLimit  = "LIMIT"
+	TypeStop   = "STOP"
+)
+
+// Order durations
+const (
+	DurationDay = "DAY"
+	DurationGTC = "GTC"
+)
+
+// Order statuses. PENDING is an order being submitted to the broker, or one
+// whose submission failed without the broker refusing it, until the broker
+// is asked for it by its client order ID; FILLED, CANCELLED, REJECTED and
+// EXPIRED are final.
+const (
+	StatusPending         = "PENDING"
+	StatusOpen            = "OPEN"
+	StatusPartiallyFilled = "PARTIALLY_FILLED"
+	StatusCancelRequested = "CANCEL_REQUESTED"
+	StatusFilled          = "FILLED"
+	StatusCancelled       = "CANCELLED"
+	StatusRejected        = "REJECTED"
+	StatusExpired         = "EXPIRED"
+)
+
+// Transitions lists the statuses an order may move to from each status
+var Transitions = map[string][]string{
+	StatusPending:         {StatusOpen, StatusPartiallyFilled, StatusFilled, StatusRejected},
+	StatusOpen:            {StatusPartiallyFilled, StatusFilled, StatusCancelRequested, StatusCancelled, StatusRejected, StatusExpired},
+	StatusPartiallyFilled: {StatusFilled, StatusCancelRequested, StatusCancelled, StatusExpired},
+	StatusCancelRequested: {StatusPartiallyFilled, StatusFilled, StatusCancelled, StatusExpired},
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range Transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether an order in the status can no longer change
+func IsFinal(status string) bool {
+	return len(Transitions[status]) == 0
+}
+
+// Cancelable reports whether an order in the status may be cancelled
+func Cancelable(status string) bool {
+	return CanTransition(status, StatusCancelRequested)
+}
+
+// Request is an order to buy or sell an equity or ETF
+type Request struct {
+	ClientOrderID string           `json:"client_order_id,omitempty"`
+	Symbol        string           `json:"symbol"`
+	Side          string           `json:"side"`
+	Type          string           `json:"order_type"`
+	Quantity      float64          `json:"quantity"`
+	LimitPrice    *decimal.Decimal `json:"limit_price,omitempty"`
+	StopPrice     *decimal.Decimal `json:"stop_price,omitempty"`
+	Duration      string           `json:"duration"`
+}
+
+// ValidationError lists every problem found in an order request
+type ValidationError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid order: " + strings.Join(e.Problems, "; ")
+}
+
+// Normalize upper-cases the request's codes and fills in the default duration
+func (r *Request) Normalize() {
+	r.Symbol = strings.ToUpper(strings.TrimSpace(r.Symbol))
+	r.Side = strings.ToUpper(r.Side)
+	r.Type = strings.ToUpper(r.Type)
+	r.Duration = strings.ToUpper(r.Duration)
+	if r.Duration == "" {
+		r.Duration = DurationDay
+	}
+}
+
+// Equal reports whether two requests are for the same order
+func (r Request) Equal(other Request) bool {
+	return r.ClientOrderID == other.ClientOrderID && r.Symbol == other.Symbol &&
+		r.Side == other.Side && r.Type == other.Type && r.Quantity == other.Quantity &&
+		samePrice(r.LimitPrice, other.LimitPrice) && samePrice(r.StopPrice, other.StopPrice) &&
+		r.Duration == other.Duration
+}
+
+// samePrice reports whether two optional prices are equal
+func samePrice(a, b *decimal.Decimal) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+// Validate checks the request is a well formed market, limit or stop order
+func (r Request) Validate() error {
+	var problems []string
+
+	if r.Symbol == "" {
+		problems = append(problems, "symbol is required")
+	}
+	if r.Side != SideBuy && r.Side != SideSell {
+		problems = append(problems, "side must be BUY or SELL")
+	}
+	if r.Quantity <= 0 || r.Quantity != float64(int64(r.Quantity)) {
+		problems = append(problems, "quantity must be a positive whole number of shares")
+	}
+	if len(r.ClientOrderID) > 20 || strings.IndexFunc(r.ClientOrderID, notAlphanumeric) >= 0 {
+		problems = append(problems, "client_order_id must be at most 20 letters and digits")
+	}
+	if r.Duration != DurationDay && r.Duration != DurationGTC {
+		problems = append(problems, "duration must be DAY or GTC")
+	}
+
+	switch r.Type {
+	case TypeMarket:
+		if r.LimitPrice != nil || r.StopPrice != nil {
+			problems = append(problems, "market orders take no limit or stop price")
+		}
+		if r.Duration != DurationDay {
+			problems = append(problems, "market orders must be DAY orders")
+		}
+	case TypeLimit:
+		if r.LimitPrice == nil || !r.LimitPrice.IsPositive() {
+			problems = append(problems, "limit orders need a positive limit_price")
+		}
+		if r.StopPrice != nil {
+			problems = append(problems, "limit orders take no stop price")
+		}
+	case TypeStop:
+		if r.StopPrice == nil || !r.StopPrice.IsPositive() {
+			problems = append(problems, "stop orders need a positive stop_price")
+		}
+		if r.LimitPrice != nil {
+			problems = append(problems, "stop orders take no limit price")
+		}
+	default:
+		problems = append(problems, "order_type must be MARKET, LIMIT or STOP")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// notAlphanumeric reports whether r is outside the characters brokers accept in order IDs
+func notAlphanumeric(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+}
+
+// Account identifies the brokerage account an order is routed to
+type Account struct {
+	ID         string
+	UserID     string
+	ExternalID string
+}
+
+// Preview is a broker's estimate for an order before it is placed
+type Preview struct {
+	PreviewID           string           `json:"preview_id"`
+	EstimatedPrice      *decimal.Decimal `json:"estimated_price,omitempty"`
+	EstimatedTotal      decimal.Decimal  `json:"estimated_total"`
+	EstimatedCommission decimal.Decimal  `json:"estimated_commission"`
+	Messages            []string         `json:"messages,omitempty"`
+}
+
+// Execution is a broker's view of a placed order
+type Execution struct {
+	BrokerOrderID  string          `json:"broker_order_id"`
+	Status         string          `json:"status"`
+	FilledQuantity float64         `json:"filled_quantity"`
+	AveragePrice   decimal.Decimal `json:"average_price"`
+	PlacedAt       time.Time       `json:"placed_at"`
+	Messages       []string        `json:"messages,omitempty"`
+}
+
+// Broker routes orders to a brokerage
+type Broker interface {
+	// Name identifies the broker in stored orders
+	Name() string
+	// Preview validates an order and estimates its cost
+	Preview(ctx context.Context, account Account, req Request) (*Preview, error)
+	// Place submits an order previewed with the given preview ID
+	Place(ctx context.Context, account Account, req Request, previewID string) (*Execution, error)
+	// Cancel asks the broker to cancel an open order
+	Cancel(ctx context.Context, account Account, brokerOrderID string) error
+	// Status returns the broker's current view of an order
+	Status(ctx context.Context, account Account, brokerOrderID string) (*Execution, error)
+	// Lookup finds an order by the client order ID it was placed with, for
+	// orders whose placement was not confirmed. It returns ErrUnknownOrder
+	// when the broker has no such order.
+	Lookup(ctx context.Context, account Account, clientOrderID string) (*Execution, error)
+}
+
+// ErrUnknownOrder is returned by a broker that has no record of an order
+var ErrUnknownOrder = errors.New("unknown order")
+
+// BrokerError wraps a failure reported by a broker. Rejected is set when the
+// broker refused the request; otherwise, as when it could not be reached,
+// whether the request took effect is unknown.
+type BrokerError struct {
+	Broker   string
+	Err      error
+	Rejected bool
+}
+
+func (e *BrokerError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Broker, e.Err)
+}
+
+func (e *BrokerError) Unwrap() error {
+	return e.Err
+}
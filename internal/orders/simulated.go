@@ -0,0 +1,210 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// SimulatedBrokerName identifies orders routed to the simulated broker
+const SimulatedBrokerName = "simulated"
+
+// PriceFunc returns the current price of a symbol
+type PriceFunc func(ctx context.Context, symbol string) (decimal.Decimal, error)
+
+// SimulatedBroker fills orders in memory against current prices so order
+// entry can be exercised without a brokerage. Market orders fill at once;
+// limit and stop orders stay open until a later status check finds the price
+// has reached them. Orders are lost when the process restarts.
+type SimulatedBroker struct {
+	prices     PriceFunc
+	commission decimal.Decimal
+
+	mu       sync.Mutex
+	next     int
+	previews map[string]Request
+	orders   map[string]*simulatedOrder
+	placed   map[string]string // client order ID to broker order ID
+}
+
+type simulatedOrder struct {
+	account   string
+	req       Request
+	execution Execution
+}
+
+// NewSimulatedBroker creates a simulated broker that prices orders with
+// prices and charges a flat commission per order
+func NewSimulatedBroker(prices PriceFunc, commission decimal.Decimal) *SimulatedBroker {
+	return &SimulatedBroker{
+		prices:     prices,
+		commission: commission,
+		previews:   make(map[string]Request),
+		orders:     make(map[string]*simulatedOrder),
+		placed:     make(map[string]string),
+	}
+}
+
+// Name implements Broker
+func (b *SimulatedBroker) Name() string {
+	return SimulatedBrokerName
+}
+
+// Preview implements Broker
+func (b *SimulatedBroker) Preview(ctx context.Context, account Account, req Request) (*Preview, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	price, err := b.prices(ctx, req.Symbol)
+	if err != nil {
+		return nil, &BrokerError{Broker: b.Name(), Err: err}
+	}
+
+	// Limit and stop orders are estimated at the price they would trigger at
+	estimate := price
+	switch req.Type {
+	case TypeLimit:
+		estimate = *req.LimitPrice
+	case TypeStop:
+		estimate = *req.StopPrice
+	}
+
+	gross := estimate.Mul(decimal.NewFromFloat(req.Quantity))
+	total := gross.Add(b.commission)
+	if req.Side == SideSell {
+		total = gross.Sub(b.commission)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	id := fmt.Sprintf("SIM-P%d", b.next)
+	b.previews[id] = req
+
+	return &Preview{
+		PreviewID:           id,
+		EstimatedPrice:      &estimate,
+		EstimatedTotal:      total.Round(2),
+		EstimatedCommission: b.commission,
+		Messages:            []string{fmt.Sprintf("Simulated order; %s last traded at %s", req.Symbol, price.StringFixed(2))},
+	}, nil
+}
+
+// Place implements Broker
+func (b *SimulatedBroker) Place(ctx context.Context, account Account, req Request, previewID string) (*Execution, error) {
+	b.mu.Lock()
+	previewed, ok := b.previews[previewID]
+	if ok {
+		delete(b.previews, previewID)
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		return nil, &BrokerError{Broker: b.Name(), Err: fmt.Errorf("preview %s not found", previewID), Rejected: true}
+	}
+	previewed.ClientOrderID = req.ClientOrderID
+	if !previewed.Equal(req) {
+		return nil, &BrokerError{Broker: b.Name(), Err: errors.New("order does not match its preview"), Rejected: true}
+	}
+
+	price, err := b.prices(ctx, req.Symbol)
+	if err != nil {
+		return nil, &BrokerError{Broker: b.Name(), Err: err, Rejected: true}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	order := &simulatedOrder{
+		account: account.ID,
+		req:     req,
+		execution: Execution{
+			BrokerOrderID: fmt.Sprintf("SIM-%d", b.next),
+			Status:        StatusOpen,
+			PlacedAt:      time.Now(),
+		},
+	}
+	order.fill(price)
+	b.orders[order.execution.BrokerOrderID] = order
+	if req.ClientOrderID != "" {
+		b.placed[account.ID+":"+req.ClientOrderID] = order.execution.BrokerOrderID
+	}
+
+	execution := order.execution
+	return &execution, nil
+}
+
+// Cancel implements Broker
+func (b *SimulatedBroker) Cancel(ctx context.Context, account Account, brokerOrderID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	order, ok := b.orders[brokerOrderID]
+	if !ok || order.account != account.ID {
+		return ErrUnknownOrder
+	}
+	if !Cancelable(order.execution.Status) {
+		return &BrokerError{Broker: b.Name(), Err: fmt.Errorf("order is %s", order.execution.Status)}
+	}
+	order.execution.Status = StatusCancelled
+	return nil
+}
+
+// Status implements Broker. Open orders are checked against the current price
+// and filled if it has reached their limit or stop.
+func (b *SimulatedBroker) Status(ctx context.Context, account Account, brokerOrderID string) (*Execution, error) {
+	b.mu.Lock()
+	order, ok := b.orders[brokerOrderID]
+	b.mu.Unlock()
+	if !ok || order.account != account.ID {
+		return nil, ErrUnknownOrder
+	}
+
+	price, err := b.prices(ctx, order.req.Symbol)
+	if err != nil {
+		return nil, &BrokerError{Broker: b.Name(), Err: err}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if order.execution.Status == StatusOpen {
+		order.fill(price)
+	}
+	execution := order.execution
+	return &execution, nil
+}
+
+// Lookup implements Broker
+func (b *SimulatedBroker) Lookup(ctx context.Context, account Account, clientOrderID string) (*Execution, error) {
+	b.mu.Lock()
+	brokerOrderID, ok := b.placed[account.ID+":"+clientOrderID]
+	b.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownOrder
+	}
+	return b.Status(ctx, account, brokerOrderID)
+}
+
+// fill fills the order at price if its type and price allow
+func (o *simulatedOrder) fill(price decimal.Decimal) {
+	buy := o.req.Side == SideBuy
+	switch o.req.Type {
+	case TypeLimit:
+		if (buy && price.GreaterThan(*o.req.LimitPrice)) || (!buy && price.LessThan(*o.req.LimitPrice)) {
+			return
+		}
+	case TypeStop:
+		if (buy && price.LessThan(*o.req.StopPrice)) || (!buy && price.GreaterThan(*o.req.StopPrice)) {
+			return
+		}
+	}
+
+	o.execution.Status = StatusFilled
+	o.execution.FilledQuantity = o.req.Quantity
+	o.execution.AveragePrice = price
+}
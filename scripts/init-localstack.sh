@@ -78,6 +78,12 @@ aws --endpoint-url=http://localhost:4566 sqs create-queue \
     --queue-name kyc-queue \
     || true
 
+# Verify the SES sender used by notification-service
+echo "Verifying SES sender identity..."
+aws --endpoint-url=http://localhost:4566 ses verify-email-identity \
+    --email-address no-reply@trustainvest.com \
+    || true
+
 # Create SNS topics
 echo "Creating SNS topics..."
 aws --endpoint-url=http://localhost:4566 sns create-topic \
@@ -239,9 +239,9 @@ callback_response=$(make_api_call "POST" "/capitalone/auth/callback" "$callback_
 
 # Check if the callback request was successful
 if echo "$callback_response" | jq -e '.success' > /dev/null 2>&1; then
-  access_token=$(echo "$callback_response" | jq -r '.access_token')
+  message=$(echo "$callback_response" | jq -r '.message')
   print_status "success" "OAuth flow completed"
-  echo "Access Token: $access_token"
+  echo "$message"
 else
   print_status "error" "Failed to complete OAuth flow"
   echo "Response: $(echo $callback_response | jq -c '.')"
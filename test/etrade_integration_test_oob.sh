@@ -169,7 +169,7 @@ initiate_response=$(make_api_call "POST" "/etrade/auth/initiate" "$initiate_data
 # Check if the initiate request was successful
 if echo "$initiate_response" | jq -e '.auth_url' > /dev/null 2>&1; then
   auth_url=$(echo "$initiate_response" | jq -r '.auth_url')
-  request_token=$(echo "$initiate_response" | jq -r '.request_token')
+  request_token=$(echo "$initiate_response" | jq -r '.state')
   print_status "success" "OAuth flow initiated"
   echo "Auth URL: $auth_url"
   echo "Request Token: $request_token"
@@ -202,9 +202,9 @@ callback_response=$(make_api_call "POST" "/etrade/auth/callback" "$callback_data
 
 # Check if the callback request was successful
 if echo "$callback_response" | jq -e '.success' > /dev/null 2>&1; then
-  access_token=$(echo "$callback_response" | jq -r '.access_token')
+  message=$(echo "$callback_response" | jq -r '.message')
   print_status "success" "OAuth flow completed"
-  echo "Access Token: $access_token"
+  echo "$message"
 else
   print_status "error" "Failed to complete OAuth flow"
   echo "Response: $callback_response"